@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"middlware/middleware"
+)
+
+// newAuditSink selects where audit events go: AUDIT_HTTP_URL posts them to a
+// collector, AUDIT_LOG_FILE appends them to a file, AUDIT_SYSLOG=1 sends
+// them to the local syslog daemon, and otherwise they are written to stdout.
+// The returned function flushes and closes the sink.
+func newAuditSink() (middleware.AuditSink, func(context.Context) error, error) {
+	if url := os.Getenv("AUDIT_HTTP_URL"); url != "" {
+		sink := middleware.NewHTTPAuditSink(url, middleware.HTTPAuditSinkOptions{})
+		return sink, sink.Close, nil
+	}
+	if path := os.Getenv("AUDIT_LOG_FILE"); path != "" {
+		sink, err := middleware.OpenAuditLog(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return sink, func(context.Context) error { return sink.Close() }, nil
+	}
+	if os.Getenv("AUDIT_SYSLOG") == "1" {
+		sink, err := middleware.NewSyslogAuditSink("", "", "middlware")
+		if err != nil {
+			return nil, nil, err
+		}
+		return sink, func(context.Context) error { return sink.Close() }, nil
+	}
+	return middleware.NewWriterAuditSink(os.Stdout), func(context.Context) error { return nil }, nil
+}
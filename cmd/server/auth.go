@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"middlware/middleware"
+)
+
+// newAuthMiddleware verifies JWTs not revoked in revocations, signed either
+// by the keys published at JWT_JWKS_URL or with the HS256 secret
+// jwt_secret. Without either it falls back to checking X-Auth-Token against
+// token or, if empty, the secret auth_token. Secrets come from secretStore
+// for every request, so rotations apply. It is registered in reg as "auth".
+func newAuthMiddleware(reg *middleware.Registry, logger *slog.Logger, revocations middleware.RevocationStore, secretStore middleware.SecretsProvider, token string) middleware.Middleware {
+	if url := os.Getenv("JWT_JWKS_URL"); url != "" {
+		return reg.Register("auth", middleware.JWT(middleware.JWTOptions{
+			JWKS:        middleware.NewJWKS(context.Background(), url, middleware.JWKSOptions{Logger: logger}),
+			Issuer:      os.Getenv("JWT_ISSUER"),
+			Audience:    os.Getenv("JWT_AUDIENCE"),
+			Revocations: revocations,
+			Logger:      logger,
+		}), "method", "jwks", "url", url)
+	}
+	ctx := context.Background()
+	// Only a missing jwt_secret selects token authentication; a provider
+	// that is down keeps JWT, which answers 503 until it is back
+	_, err := secretStore.Secret(ctx, jwtSecret)
+	switch {
+	case err == nil:
+	case !errors.Is(err, middleware.ErrSecretNotFound):
+		logger.Error("jwt secret lookup failed", "error", err)
+	default:
+		logger.Warn("JWT_JWKS_URL and the jwt_secret secret not set, falling back to static token authentication")
+		opts := middleware.AuthenticationOptions{Token: token, Roles: []string{"admin"}, Logger: logger}
+		if token == "" {
+			opts.Secrets, opts.TokenSecret = secretStore, authTokenSecret
+			if _, err := secretStore.Secret(ctx, authTokenSecret); err != nil {
+				logger.Error("admin token unavailable, admin requests will fail until the auth_token secret is set", "error", err)
+			}
+		}
+		return reg.Register("auth", middleware.Authentication(opts), "method", "token")
+	}
+	return reg.Register("auth", middleware.JWT(middleware.JWTOptions{
+		Secrets:     secretStore,
+		KeySecret:   jwtSecret,
+		Issuer:      os.Getenv("JWT_ISSUER"),
+		Audience:    os.Getenv("JWT_AUDIENCE"),
+		Revocations: revocations,
+		Logger:      logger,
+	}), "method", "jwt")
+}
+
+// newOIDC configures OpenID Connect login from OIDC_* variables and the
+// secrets oidc_client_secret and oidc_cookie_secret, returning nil when
+// OIDC_ISSUER is unset. The callback must point at /account/callback.
+func newOIDC(ctx context.Context, logger *slog.Logger, secretStore middleware.SecretsProvider, client *http.Client) (*middleware.OIDC, error) {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil, nil
+	}
+	// Public clients have no secret
+	clientSecret, err := secretStore.Secret(ctx, oidcClientSecret)
+	if err != nil && !errors.Is(err, middleware.ErrSecretNotFound) {
+		return nil, err
+	}
+	cookieSecret, err := secretStore.Secret(ctx, oidcCookieSecret)
+	if err != nil {
+		return nil, err
+	}
+	return middleware.NewOIDC(ctx, middleware.OIDCOptions{
+		IssuerURL:    issuer,
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: string(clientSecret),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"), // e.g. http://localhost:8080/account/callback
+		LogoutPath:   "/account/logout",
+		CookieSecret: cookieSecret,
+		Client:       client,
+		Logger:       logger,
+	})
+}
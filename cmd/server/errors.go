@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"middlware/middleware"
+)
+
+// newErrorReporter reports panics and 5xx responses to Sentry when
+// SENTRY_DSN is set, tagging them with SENTRY_ENVIRONMENT and
+// SENTRY_RELEASE; otherwise errors are only logged and the reporter is nil.
+// The returned function flushes the events still queued.
+func newErrorReporter() (middleware.ErrorReporter, func(context.Context) error, error) {
+	dsn := os.Getenv("SENTRY_DSN")
+	if dsn == "" {
+		return nil, func(context.Context) error { return nil }, nil
+	}
+	sentry, err := middleware.NewSentry(dsn, middleware.SentryOptions{
+		Environment: os.Getenv("SENTRY_ENVIRONMENT"),
+		Release:     os.Getenv("SENTRY_RELEASE"),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return sentry, sentry.Close, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+
+	"middlware/middleware"
+)
+
+// newGeoIP enriches requests with country and ASN from GEOIP_COUNTRY_DB and
+// GEOIP_ASN_DB, blocking the comma-separated ISO codes in GEOIP_BLOCK. It
+// returns nil when neither database is configured.
+func newGeoIP(logger *slog.Logger) (middleware.Middleware, error) {
+	countryDB, asnDB := os.Getenv("GEOIP_COUNTRY_DB"), os.Getenv("GEOIP_ASN_DB")
+	if countryDB == "" && asnDB == "" {
+		return nil, nil
+	}
+	resolver, err := middleware.OpenMaxMind(countryDB, asnDB)
+	if err != nil {
+		return nil, err
+	}
+	var block []string
+	if s := os.Getenv("GEOIP_BLOCK"); s != "" {
+		block = strings.Split(s, ",")
+	}
+	return middleware.GeoIP(middleware.GeoIPOptions{
+		Resolver:       resolver,
+		BlockCountries: block,
+		Logger:         logger,
+	}), nil
+}
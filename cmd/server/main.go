@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"middlware/config"
+	"middlware/debug"
+	"middlware/flags"
+	"middlware/health"
+	"middlware/middleware"
+	"middlware/render"
+	"middlware/server"
+)
+
+func handleHome(w http.ResponseWriter, r *http.Request) error {
+	// Safely retrieve the value from the context
+	config, ok := middleware.ConfigFromContext(r.Context())
+	if !ok {
+		return errors.New("configuration not found in context")
+	}
+	appName := config.App
+	greeting := "Hello, I'm "
+	if flags.FromContext(r).Enabled("friendly-greeting") {
+		greeting = "Hi there, this is "
+	}
+	select {
+	case <-time.After(2 * time.Second): // Simulate processing
+	case <-r.Context().Done():
+		return nil
+	}
+	w.Write([]byte(greeting + appName))
+	return nil
+}
+
+func handleAdmin(w http.ResponseWriter, r *http.Request) {
+	render.Respond(w, r, struct {
+		XMLName xml.Name `json:"-" xml:"admin"`
+		Admin   bool     `json:"admin" xml:"admin,attr"`
+	}{Admin: true})
+}
+
+func handleAccount(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.OIDCUserFromContext(r.Context())
+	render.Respond(w, r, user)
+}
+
+func handleUpload(w http.ResponseWriter, r *http.Request) error {
+	n, err := io.Copy(io.Discard, r.Body)
+	if err != nil {
+		// MaxBody answers bodies over the limit with 413 itself
+		return &middleware.Error{Status: http.StatusBadRequest, Code: "unreadable_body", Err: err}
+	}
+	render.Respond(w, r, struct {
+		XMLName  xml.Name `json:"-" xml:"upload"`
+		Received int64    `json:"received" xml:"received,attr"`
+	}{Received: n})
+	return nil
+}
+
+func handleWhoami(w http.ResponseWriter, r *http.Request) {
+	id, _ := middleware.IdentityFromContext(r.Context())
+	render.Respond(w, r, id)
+}
+
+func main() {
+	logLevel := new(slog.LevelVar)
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel}))
+	// Settings come from config.Default, overridden by the -config file
+	// (or CONFIG_FILE), APP_* variables and flags, in that order; -h lists
+	// them all
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "YAML or JSON configuration `file` (env CONFIG_FILE)")
+	flags := config.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	cfg, err := loadConfig(*configFile, flags)
+	if err != nil {
+		logger.Error("config load failed", "error", err)
+		os.Exit(1)
+	}
+	logLevel.Set(cfg.LogLevel)
+	dyn := &dynamic{
+		logger:  logger,
+		verbose: &verboseLogging{level: logLevel, base: cfg.LogLevel},
+		maintenance: middleware.NewMaintenance(middleware.MaintenanceOptions{
+			RetryAfter: cfg.Middleware.Maintenance.RetryAfter,
+			// The admin API stays up to switch it off again
+			Allow:   middleware.PathPrefix("/admin/"),
+			Enabled: cfg.Middleware.Maintenance.Enabled,
+		}),
+		cors: newCORS(cfg),
+	}
+	dyn.chaosLatency, dyn.chaosFault = newChaos(cfg, logger)
+	registry := &middleware.Registry{Measure: cfg.Middleware.Timing.Enabled && cfg.Middleware.Timing.Breakdown}
+	registry.RegisterSwitch("verbose_logging", dyn.verbose, nil)
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		logger.Error("tracing setup failed", "error", err)
+		os.Exit(1)
+	}
+	metricsSink, err := newMetricsSink()
+	if err != nil {
+		logger.Error("metrics setup failed", "error", err)
+		os.Exit(1)
+	}
+
+	// Tokens and keys are read from the secrets provider, so they stay out
+	// of the configuration and can be rotated
+	secretStore := newSecrets(context.Background(), cfg.Secrets, logger)
+
+	// Calls to other services pass on the request ID and trace context of
+	// the request they are made for
+	outbound := &http.Client{Timeout: 10 * time.Second, Transport: middleware.Transport(middleware.TransportOptions{})}
+
+	redisClient := newRedisClient()
+	rateLimitStore := newRateLimitStore(redisClient)
+	revocations := newRevocationStore(redisClient)
+	cacheStore := newCacheStore(redisClient)
+	idempotencyStore := newCacheStore(redisClient)
+
+	router := mux.NewRouter()
+
+	// Allow/deny lists come from the configuration, or from IP_FILTER_FILE,
+	// reloaded whenever the file changes
+	var ipFilter *middleware.IPFilter
+	if path := os.Getenv("IP_FILTER_FILE"); path != "" {
+		ipFilter, err = middleware.LoadIPFilter(path)
+		if err == nil {
+			go ipFilter.Watch(context.Background(), 10*time.Second, logger)
+		}
+	} else {
+		ipFilter, err = middleware.NewIPFilter(cfg.Middleware.IPFilter.Allow, cfg.Middleware.IPFilter.Deny)
+		dyn.ipFilter = ipFilter
+	}
+	if err != nil {
+		logger.Error("ip filter setup failed", "error", err)
+		os.Exit(1)
+	}
+
+	auditSink, closeAudit, err := newAuditSink()
+	if err != nil {
+		logger.Error("audit setup failed", "error", err)
+		os.Exit(1)
+	}
+
+	errorReporter, closeErrorReporter, err := newErrorReporter()
+	if err != nil {
+		logger.Error("error reporting setup failed", "error", err)
+		os.Exit(1)
+	}
+
+	geoIP, err := newGeoIP(logger)
+	if err != nil {
+		logger.Error("geoip setup failed", "error", err)
+		os.Exit(1)
+	}
+
+	accessLog, closeAccessLog, err := newAccessLog(cfg.Middleware.AccessLog, logger)
+	if err != nil {
+		logger.Error("access log setup failed", "error", err)
+		os.Exit(1)
+	}
+
+	slo := newSLO(cfg, metricsSink, logger)
+	apdex := newApdex(cfg, metricsSink)
+	var stats *middleware.Stats
+	if cfg.Middleware.Stats.Enabled {
+		stats = middleware.NewStats(middleware.StatsOptions{Logger: logger})
+	}
+	var profiler *middleware.Profiler
+	if cfg.Middleware.Profiling.Enabled {
+		profiler = middleware.NewProfiler(middleware.ProfilerOptions{
+			Secrets:    secretStore,
+			SecretName: profileKeySecret,
+			URL:        "/admin/profiles/",
+			Logger:     logger,
+		})
+	}
+
+	deps := stackDeps{
+		logger:         logger,
+		metrics:        metricsSink,
+		audit:          auditSink,
+		errors:         errorReporter,
+		rateLimitStore: rateLimitStore,
+		cacheStore:     cacheStore,
+		accessLog:      accessLog,
+		slo:            slo,
+		apdex:          apdex,
+		stats:          stats,
+		profiler:       profiler,
+		dynamic:        dyn,
+		registry:       registry,
+	}
+	// Probes and well-known files are answered before the router so they
+	// skip every middleware, and CORS runs in front of it so preflights
+	// reach no route-specific code
+	handler := newEdge(cfg, deps, ipFilter, geoIP, dyn.cors)(router)
+	tlsConfig := newTLSConfig()
+	serverConfig := server.Config{
+		Addr:              cfg.Server.Addr,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		MaxHeaderBytes:    cfg.Server.MaxHeaderBytes,
+		TLS:               tlsConfig,
+		Logger:            logger,
+	}
+	srv, err := server.New(handler, serverConfig)
+	if err != nil {
+		logger.Error("server setup failed", "error", err)
+		os.Exit(1)
+	}
+
+	// The stack shared by every route follows the middleware section of
+	// the configuration
+	stack, err := newStack(cfg, deps)
+	if err != nil {
+		logger.Error("middleware setup failed", "error", err)
+		os.Exit(1)
+	}
+	router.Use(stack.Middleware())
+
+	// "/" is public, everything under /admin requires a token
+	router.Handle("/", middleware.ErrorHandlerFunc(handleHome)).Methods("GET")
+
+	// /events streams the server time to every subscriber
+	events := middleware.NewSSEBroker(middleware.SSEOptions{Retry: 5 * time.Second, Logger: logger})
+	srv.RegisterOnShutdown(events.Close)
+	go func() {
+		for now := range time.Tick(10 * time.Second) {
+			events.Publish("", middleware.Event{Type: "time", Data: now.Format(time.RFC3339)})
+		}
+	}()
+	router.Handle("/events", events).Methods("GET")
+
+	// /ws/echo is a WebSocket echo for authenticated clients
+	ws := router.PathPrefix("/ws").Subrouter()
+	ws.Use(newAuthMiddleware(registry.Scope("/ws"), logger, revocations, secretStore, cfg.Auth.TokenSecret))
+	ws.Handle("/echo", newEchoHandler(logger)).Methods("GET")
+	admin := router.PathPrefix("/admin").Subrouter()
+	adminScope := registry.Scope("/admin")
+	admin.Use(adminScope.Register("auth_throttle", middleware.AuthThrottle(middleware.AuthThrottleOptions{Logger: logger})))
+	admin.Use(newAuthMiddleware(adminScope, logger, revocations, secretStore, cfg.Auth.TokenSecret))
+	admin.Use(adminScope.Register("authorize", middleware.Authorize(middleware.RequireRole("admin")), "role", "admin"))
+	admin.Use(adminScope.Register("idempotency", middleware.Idempotency(middleware.IdempotencyOptions{Store: idempotencyStore, Logger: logger})))
+	admin.HandleFunc("", handleAdmin).Methods("GET")
+	// /admin/middlewares lists and switches middlewares, and
+	// /admin/middlewares/routes shows the chain of every route
+	admin.Handle("/middlewares", registry.Handler(middleware.RegistryHandlerOptions{Logger: logger})).Methods("GET", "POST")
+	admin.Handle("/middlewares/routes", registry.RoutesHandler(router)).Methods("GET")
+	// /admin/slo shows the compliance with the objectives of the routes
+	if slo != nil {
+		admin.Handle("/slo", slo.Handler()).Methods("GET")
+	}
+	// /admin/metrics is scraped by Prometheus, with METRICS_BACKEND=prometheus
+	if prometheus, ok := metricsSink.(*middleware.Prometheus); ok {
+		admin.Handle("/metrics", prometheus.Handler()).Methods("GET")
+	}
+	// /admin/stats is a live dashboard of the last minute of requests,
+	// or its JSON for clients not asking for HTML; DELETE resets it
+	if stats != nil {
+		admin.Handle("/stats", stats.Handler()).Methods("GET", "DELETE")
+	}
+	// /admin/apdex shows the Apdex score of every route
+	if apdex != nil {
+		admin.Handle("/apdex", apdex.Handler()).Methods("GET")
+	}
+	// /admin/profiles/{id} serves the profiles of single requests
+	if profiler != nil {
+		admin.Handle("/profiles/{id}", profiler.Handler()).Methods("GET")
+	}
+	// /admin/log-level reads and sets the log level until the next reload
+	admin.Handle("/log-level", middleware.LogLevelHandler(dyn.verbose, middleware.LogLevelHandlerOptions{Logger: logger})).Methods("GET", "PUT", "POST")
+	admin.Handle("/revocations", middleware.RevocationHandler(revocations, middleware.RevocationHandlerOptions{Logger: logger})).Methods("POST")
+
+	// DEBUG_ENDPOINTS=1 serves pprof and expvar under /debug/ to admins;
+	// DEBUG_ADDR (e.g. "localhost:6060") serves them on a listener of their
+	// own instead, away from the public port and its timeouts
+	debugScope := registry.Scope("/debug/")
+	debugHandler := middleware.Compose(
+		newAuthMiddleware(debugScope, logger, revocations, secretStore, cfg.Auth.TokenSecret),
+		debugScope.Register("authorize", middleware.Authorize(middleware.RequireRole("admin")), "role", "admin"),
+	)(debug.Handler())
+	if os.Getenv("DEBUG_ENDPOINTS") == "1" && os.Getenv("DEBUG_ADDR") == "" {
+		router.PathPrefix("/debug/").Handler(debugHandler)
+	}
+
+	// The larger body limit of uploads is a route override of the
+	// configuration
+	upload := router.PathPrefix("/upload").Subrouter()
+	uploadTypes := []string{"application/octet-stream", "image/*", "application/pdf"}
+	upload.Use(registry.Scope("/upload").Register("content_type", middleware.ContentType(middleware.ContentTypeOptions{
+		Allowed:    uploadTypes,
+		VerifyBody: true,
+		Logger:     logger,
+	}), "allowed", uploadTypes))
+	upload.Handle("", middleware.ErrorHandlerFunc(handleUpload)).Methods("POST")
+
+	// STATIC_DIR is served under /static/, with precompressed variants
+	if dir := os.Getenv("STATIC_DIR"); dir != "" {
+		router.PathPrefix("/static/").Handler(http.StripPrefix("/static", middleware.Static(os.DirFS(dir), middleware.StaticOptions{})))
+	}
+
+	// Browser pages under /account log in through the OIDC provider
+	oidcAuth, err := newOIDC(context.Background(), logger, secretStore, outbound)
+	if err != nil {
+		logger.Error("oidc setup failed", "error", err)
+		os.Exit(1)
+	}
+	if oidcAuth != nil {
+		account := router.PathPrefix("/account").Subrouter()
+		account.Use(registry.Scope("/account").Register("oidc", oidcAuth.Middleware()))
+		account.HandleFunc("", handleAccount).Methods("GET")
+		// The middleware answers these itself; the routes only make mux run it
+		account.HandleFunc("/callback", handleAccount).Methods("GET")
+		account.HandleFunc("/logout", handleAccount).Methods("GET")
+	}
+
+	// Service-to-service endpoints under /internal require a client
+	// certificate when TLS_CLIENT_CA is configured
+	if tlsConfig != nil && tlsConfig.ClientCAFile != "" {
+		internal := router.PathPrefix("/internal").Subrouter()
+		internal.Use(registry.Scope("/internal").Register("client_cert", middleware.ClientCert(middleware.ClientCertOptions{Logger: logger})))
+		internal.HandleFunc("/whoami", handleWhoami).Methods("GET")
+	}
+
+	// SPA_DIR hosts a single-page app on every path not routed above;
+	// registered last so it only sees what nothing else matched
+	if dir := os.Getenv("SPA_DIR"); dir != "" {
+		app := os.DirFS(dir)
+		spa := middleware.SPA(middleware.SPAOptions{FS: app, Exclude: []string{"/admin/", "/debug/", "/internal/", "/upload/"}})
+		router.PathPrefix("/").Handler(spa(middleware.Static(app, middleware.StaticOptions{})))
+	}
+
+	// The effective chain of every route is logged once, so a misordered
+	// stack shows up in the startup logs
+	for _, route := range registry.Routes(router) {
+		logger.Info("middleware chain", "chain", route.String())
+	}
+
+	// The configuration file is reloaded when it changes and on SIGHUP
+	if path := *configFile; path != "" {
+		load := func() (*config.Config, error) { return loadConfig(path, flags) }
+		reloader := config.NewReloader(path, load, dyn.apply, logger)
+		go reloader.Watch(context.Background(), 10*time.Second)
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go reloader.Notify(context.Background(), hup)
+	}
+
+	opts := server.Options{
+		DrainTimeout: cfg.Server.DrainTimeout,
+		Health:       health.Default,
+		Logger:       logger,
+	}
+	if addr := os.Getenv("DEBUG_ADDR"); addr != "" {
+		opts.Companions = append(opts.Companions, &http.Server{Addr: addr, Handler: debugHandler, ReadHeaderTimeout: 5 * time.Second})
+	}
+	// HTTP_REDIRECT_ADDR (e.g. ":80") sends plain-HTTP visitors to HTTPS
+	if addr := os.Getenv("HTTP_REDIRECT_ADDR"); addr != "" && srv.TLSConfig != nil {
+		opts.Companions = append(opts.Companions, server.NewRedirect(addr, serverConfig))
+	}
+	// HTTP3=1 additionally serves HTTP/3 on the same port over UDP
+	if os.Getenv("HTTP3") == "1" && srv.TLSConfig != nil {
+		opts.HTTP3, err = server.NewHTTP3(srv, serverConfig)
+		if err != nil {
+			logger.Error("http3 setup failed", "error", err)
+			os.Exit(1)
+		}
+	}
+	if srv.TLSConfig != nil {
+		err = server.ListenAndServeTLS(context.Background(), srv, "", "", opts)
+	} else {
+		err = server.ListenAndServe(context.Background(), srv, opts)
+	}
+	shutdownTracing(context.Background())
+	closeAudit(context.Background())
+	closeErrorReporter(context.Background())
+	closeAccessLog()
+	if err != nil {
+		logger.Error("server failed", "error", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+
+	"middlware/middleware"
+)
+
+// newMetricsSink selects the metrics backend from the environment:
+// METRICS_BACKEND=statsd or dogstatsd sends to STATSD_ADDR (default
+// 127.0.0.1:8125), prometheus keeps them for scraping at /admin/metrics,
+// with trace exemplars; anything else disables metrics.
+func newMetricsSink() (middleware.MetricsSink, error) {
+	backend := os.Getenv("METRICS_BACKEND")
+	if backend == "prometheus" {
+		return middleware.NewPrometheus(middleware.PrometheusOptions{Namespace: "middlware_"}), nil
+	}
+	if backend != "statsd" && backend != "dogstatsd" {
+		return middleware.NopSink{}, nil
+	}
+	addr := os.Getenv("STATSD_ADDR")
+	if addr == "" {
+		addr = "127.0.0.1:8125"
+	}
+	return middleware.NewStatsD(addr, middleware.StatsDOptions{
+		Namespace: "middlware.",
+		DogStatsD: backend == "dogstatsd",
+	})
+}
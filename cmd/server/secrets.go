@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"middlware/config"
+	"middlware/middleware"
+	"middlware/secrets"
+)
+
+// Names of the secrets the demo reads. The env provider finds them in
+// AUTH_TOKEN, JWT_SECRET, OIDC_CLIENT_SECRET, OIDC_COOKIE_SECRET and
+// PROFILE_KEY.
+const (
+	authTokenSecret  = "auth_token"
+	jwtSecret        = "jwt_secret"
+	oidcClientSecret = "oidc_client_secret"
+	oidcCookieSecret = "oidc_cookie_secret"
+	profileKeySecret = "profile_key"
+)
+
+// newSecrets returns the provider configured by s. Providers other than env
+// are cached and refreshed every s.RefreshInterval until ctx is done.
+func newSecrets(ctx context.Context, s config.Secrets, logger *slog.Logger) middleware.SecretsProvider {
+	var provider middleware.SecretsProvider
+	switch s.Provider {
+	case "dir":
+		provider = secrets.Dir(s.Dir)
+	case "vault":
+		provider = secrets.NewVault(secrets.VaultOptions{
+			Address: s.Vault.Address,
+			Token:   os.Getenv("VAULT_TOKEN"),
+			Mount:   s.Vault.Mount,
+			Path:    s.Vault.Path,
+		})
+	case "aws":
+		provider = prefixed{secrets.NewAWS(secrets.AWSOptions{Region: s.AWS.Region}), s.AWS.Prefix}
+	default:
+		return secrets.Env{}
+	}
+	return secrets.NewCache(ctx, provider, secrets.CacheOptions{RefreshInterval: s.RefreshInterval, Logger: logger})
+}
+
+// prefixed looks secrets up in provider under prefix.
+type prefixed struct {
+	provider middleware.SecretsProvider
+	prefix   string
+}
+
+func (p prefixed) Secret(ctx context.Context, name string) ([]byte, error) {
+	return p.provider.Secret(ctx, p.prefix+name)
+}
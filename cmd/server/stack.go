@@ -0,0 +1,648 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"middlware/config"
+	"middlware/flags"
+	"middlware/health"
+	"middlware/middleware"
+	"middlware/render"
+)
+
+// stackDeps are the stores and sinks the global middleware stack reports
+// to, set up from the environment by main.
+type stackDeps struct {
+	logger  *slog.Logger
+	metrics middleware.MetricsSink
+	audit   middleware.AuditSink
+	// errors receives panics and 5xx responses, if error reporting is set up
+	errors         middleware.ErrorReporter
+	rateLimitStore middleware.RateLimitStore
+	cacheStore     middleware.CacheStore
+	// accessLog receives the access log, if enabled
+	accessLog io.Writer
+	// slo tracks the objectives of the routes, if any
+	slo *middleware.SLO
+	// apdex scores the requests timed, if timing is enabled
+	apdex *middleware.Apdex
+	// stats backs the /admin/stats dashboard, if enabled
+	stats *middleware.Stats
+	// profiler profiles the requests asking for it, if enabled
+	profiler *middleware.Profiler
+	dynamic  *dynamic
+	// registry lists the middlewares installed, for /admin/middlewares
+	registry *middleware.Registry
+}
+
+// dynamic holds the middlewares whose settings follow configuration
+// reloads. Enabling or disabling a middleware takes a restart, so those
+// the configuration disabled at startup are nil.
+type dynamic struct {
+	logger       *slog.Logger
+	verbose      *verboseLogging
+	maintenance  *middleware.Maintenance
+	ipFilter     *middleware.IPFilter
+	cors         *middleware.CORSPolicy
+	rateLimit    *middleware.RateLimiter
+	tenancy      *middleware.Tenancy
+	chaosLatency *middleware.ChaosLatency
+	chaosFault   *middleware.ChaosFault
+}
+
+// apply updates the middlewares to cfg.
+func (d *dynamic) apply(cfg *config.Config) {
+	m := cfg.Middleware
+	d.verbose.setLevel(cfg.LogLevel)
+	if d.ipFilter != nil {
+		// Validate checked the lists
+		d.ipFilter.Set(m.IPFilter.Allow, m.IPFilter.Deny)
+	}
+	if d.cors != nil {
+		if err := d.cors.SetAllowedOrigins(m.CORS.AllowedOrigins); err != nil {
+			d.logger.Error("cors origins not reloaded", "error", err)
+		}
+	}
+	if d.rateLimit != nil {
+		d.rateLimit.SetLimit(m.RateLimit.Rate, m.RateLimit.Burst)
+	}
+	d.tenancy.SetTenants(newTenants(cfg))
+	setEnabled(d.maintenance, m.Maintenance.Enabled)
+	setEnabled(d.chaosLatency, m.ChaosLatency.Enabled)
+	setEnabled(d.chaosFault, m.ChaosFault.Enabled)
+}
+
+// setEnabled switches s on or off.
+func setEnabled(s middleware.Switchable, on bool) {
+	if on {
+		s.Enable()
+	} else {
+		s.Disable()
+	}
+}
+
+// Event streams and WebSockets stay open, so they would hold concurrency
+// slots, skew load shedding and run into the timeout.
+var (
+	streamPaths = []string{"/events", "/ws/echo"}
+	streams     = middleware.Path(streamPaths...)
+)
+
+// Under load, requests with credentials are served before anonymous ones,
+// which are also shed first.
+var priority = middleware.Prioritize(middleware.PriorityRule{
+	Match:    middleware.Any(middleware.Header("Authorization"), middleware.Header("X-Auth-Token")),
+	Priority: 1,
+})
+
+// newStack returns the middleware shared by every route, outermost first,
+// with the sections cfg enables.
+func newStack(cfg *config.Config, d stackDeps) (middleware.Chain, error) {
+	m := cfg.Middleware
+	logger := d.logger
+	stack := middleware.NewChain()
+	// Middlewares that m.Routes can override are wrapped by withRoutes,
+	// whose settings are added to those of the global middleware
+	add := func(name string, mw middleware.Middleware, settings ...any) {
+		stack = stack.Append(d.registry.Register(name, mw, settings...))
+	}
+	addSwitch := func(name string, sw middleware.Switchable, mw middleware.Middleware, settings ...any) {
+		stack = stack.Append(d.registry.RegisterSwitch(name, sw, mw, settings...))
+	}
+
+	add("request_id", middleware.RequestID(middleware.RequestIDOptions{}))
+	// Errors, including the text ones of http.Error, are answered as
+	// application/problem+json
+	add("problems", middleware.Problems(middleware.ProblemOptions{RewriteText: true, Logger: logger}), "rewrite_text", true)
+	if d.errors != nil {
+		// Outside Recovery, so panics are reported once, with their stack;
+		// the 503s of shedding and maintenance are expected under load
+		ignore := []int{http.StatusServiceUnavailable}
+		add("error_reporting", middleware.ErrorReporting(middleware.ErrorReportingOptions{Reporter: d.errors, Ignore: ignore}), "ignore", ignore)
+	}
+	add("recovery", middleware.Recovery(middleware.RecoveryOptions{Problem: true, Reporter: d.errors, Logger: logger}))
+	if a := m.AccessLog; a.Enabled {
+		// Every response is logged, including those the middlewares
+		// below reject
+		add("access_log", middleware.AccessLog(middleware.AccessLogOptions{Format: accessLogFormats[a.Format], Output: d.accessLog}), "format", a.Format, "file", a.File)
+	}
+	// Maintenance mode is installed even when off, like the chaos
+	// middlewares below, so it can be switched on while serving
+	addSwitch("maintenance", d.dynamic.maintenance, d.dynamic.maintenance.Middleware(), "retry_after", m.Maintenance.RetryAfter, "allow", "/admin/")
+	if m.BotFilter.Enabled {
+		add("bot_filter", middleware.BotFilter(middleware.BotFilterOptions{
+			Actions: map[middleware.BotClass]middleware.BotAction{
+				middleware.BotEmpty:    middleware.BotBlock,
+				middleware.BotScripted: middleware.BotRateLimit,
+			},
+			Logger: logger,
+		}))
+	}
+	if m.WAF.Enabled {
+		waf, err := middleware.NewWAF(middleware.DefaultWAFRules)
+		if m.WAF.RulesFile != "" {
+			waf, err = middleware.LoadWAF(m.WAF.RulesFile)
+		}
+		if err != nil {
+			return middleware.Chain{}, err
+		}
+		rules := "built-in"
+		if m.WAF.RulesFile != "" {
+			rules = m.WAF.RulesFile
+		}
+		add("waf", waf.Middleware(middleware.WAFOptions{Logger: logger}), "rules", rules)
+	}
+	if m.Tracing.Enabled {
+		add("tracing", middleware.Tracing(middleware.TracingOptions{}))
+	}
+	if m.Metrics.Enabled {
+		add("metrics", middleware.Metrics(middleware.MetricsOptions{Sink: d.metrics}))
+	}
+	if d.slo != nil {
+		add("slo", d.slo.Middleware())
+	}
+	if d.stats != nil {
+		add("stats", d.stats.Middleware())
+	}
+	if m.Audit.Enabled {
+		add("audit", middleware.Audit(middleware.AuditOptions{Sink: d.audit, Logger: logger}))
+	}
+	if rl := m.RateLimit; rl.Enabled {
+		d.dynamic.rateLimit = middleware.NewRateLimiter(middleware.RateLimitOptions{Rate: rl.Rate, Burst: rl.Burst, Store: d.rateLimitStore, Logger: logger})
+		mw, routes := withRoutes(d.dynamic.rateLimit.Middleware(), m.Routes, func(route config.Route) (middleware.Middleware, any) {
+			if route.RateLimit.Rate == 0 {
+				return nil, nil
+			}
+			// Route buckets are keyed apart from the global ones, which
+			// may be in the same store
+			return middleware.RateLimit(middleware.RateLimitOptions{
+				Rate:    route.RateLimit.Rate,
+				Burst:   route.RateLimit.Burst,
+				KeyFunc: func(r *http.Request) string { return route.Path + " " + middleware.ClientIP(r) },
+				Store:   d.rateLimitStore,
+				Logger:  logger,
+			}), route.RateLimit
+		})
+		add("rate_limit", mw, append([]any{"rate", rl.Rate, "burst", rl.Burst}, routes...)...)
+	}
+	if ls := m.LoadShed; ls.Enabled {
+		shedder := middleware.NewLoadShedder(middleware.LoadShedOptions{
+			MaxInFlight:   ls.MaxInFlight,
+			TargetLatency: ls.TargetLatency,
+			Priority:      priority,
+			Priorities:    2,
+			Logger:        logger,
+		})
+		add("load_shed", middleware.Unless(streams, shedder.Middleware()), "max_in_flight", ls.MaxInFlight, "target_latency", ls.TargetLatency, "skip", streamPaths)
+	}
+	if s := m.Scheduler; s.Enabled {
+		add("scheduler", middleware.Unless(streams, middleware.Scheduler(middleware.SchedulerOptions{
+			Max:          s.Max,
+			Queue:        s.Queue,
+			QueueTimeout: s.QueueTimeout,
+			Priority:     priority,
+			Priorities:   2,
+			Sink:         d.metrics,
+		})), "max", s.Max, "queue", s.Queue, "skip", streamPaths)
+	}
+	if c := m.ConcurrencyLimit; c.Enabled {
+		add("concurrency_limit", middleware.Unless(streams, middleware.ConcurrencyLimit(middleware.ConcurrencyLimitOptions{
+			Max:          c.Max,
+			Queue:        c.Queue,
+			QueueTimeout: c.QueueTimeout,
+			PerRoute:     c.PerRoute,
+			Sink:         d.metrics,
+		})), "max", c.Max, "queue", c.Queue, "per_route", c.PerRoute, "skip", streamPaths)
+	}
+	// Requests for no tenant see the global app
+	d.dynamic.tenancy = middleware.NewTenancy(middleware.TenancyOptions{
+		Tenants: newTenants(cfg),
+		Default: &middleware.Config{App: cfg.App},
+		Logger:  logger,
+	})
+	tenantIDs := make([]string, len(cfg.Tenants))
+	for i, t := range cfg.Tenants {
+		tenantIDs[i] = t.ID
+	}
+	add("tenancy", d.dynamic.tenancy.Middleware(), "app", cfg.App, "tenants", tenantIDs)
+	if m.FeatureFlags.Enabled {
+		provider, err := newFlagProvider(m.FeatureFlags, logger)
+		if err != nil {
+			return middleware.Chain{}, err
+		}
+		add("feature_flags", flags.Middleware(flags.Options{Provider: provider, Logger: logger}))
+	}
+	sample, sampleRates := logSampling(m.Routes)
+	var sampleSettings []any
+	if sampleRates != nil {
+		sampleSettings = []any{"sample", sampleRates}
+	}
+	if m.Logging.Enabled {
+		add("logging", middleware.Logging(middleware.LoggingOptions{Logger: logger, Sample: sample}), sampleSettings...)
+	}
+	if t := m.Timing; t.Enabled {
+		slowRoutes := map[string]time.Duration{}
+		for _, route := range m.Routes {
+			if route.SlowThreshold > 0 {
+				slowRoutes[route.Path] = route.SlowThreshold
+			}
+		}
+		timing := middleware.Timing(middleware.TimingOptions{
+			Logger:                logger,
+			ServerTiming:          t.ServerTiming,
+			ServerTimingBreakdown: t.Breakdown,
+			Sample:                sample,
+			Apdex:                 d.apdex,
+			SlowThreshold:         t.SlowThreshold,
+			SlowRoutes:            slowRoutes,
+			SlowDump:              t.SlowDump,
+		})
+		settings := []any{"apdex_threshold", t.ApdexThreshold, "slow_threshold", t.SlowThreshold, "slow_dump", t.SlowDump, "breakdown", t.Breakdown}
+		if len(slowRoutes) > 0 {
+			settings = append(settings, "slow_routes", slowRoutes)
+		}
+		add("timing", timing, append(settings, sampleSettings...)...)
+	}
+	if d.profiler != nil {
+		add("profiling", d.profiler.Middleware(), "header", "X-Profile", "secret", profileKeySecret)
+	}
+	addSwitch("chaos_latency", d.dynamic.chaosLatency, d.dynamic.chaosLatency.Middleware(), "rate", m.ChaosLatency.Rate, "p50", m.ChaosLatency.P50, "p99", m.ChaosLatency.P99)
+	addSwitch("chaos_fault", d.dynamic.chaosFault, d.dynamic.chaosFault.Middleware(), "rate", m.ChaosFault.Rate, "abort", m.ChaosFault.Abort, "header", m.ChaosFault.Header)
+	if m.MaxBody.Enabled {
+		mw, routes := withRoutes(middleware.MaxBody(m.MaxBody.Limit), m.Routes, func(route config.Route) (middleware.Middleware, any) {
+			if route.MaxBody == 0 {
+				return nil, nil
+			}
+			return middleware.MaxBody(route.MaxBody), route.MaxBody
+		})
+		add("max_body", mw, append([]any{"limit", m.MaxBody.Limit}, routes...)...)
+	}
+	if m.ValidateBody.Enabled {
+		// Inside MaxBody, whose limit it reads bodies up to; like the
+		// route rate limits it runs before the auth of subrouters
+		schemas := map[string]*middleware.JSONSchema{}
+		files := map[string]string{}
+		for _, route := range m.Routes {
+			if route.Schema == "" {
+				continue
+			}
+			schema, err := middleware.LoadJSONSchema(route.Schema)
+			if err != nil {
+				return middleware.Chain{}, err
+			}
+			schemas[route.Path], files[route.Path] = schema, route.Schema
+		}
+		add("validate_body", middleware.ValidateBody(middleware.ValidateBodyOptions{Schemas: schemas, MaxBody: m.MaxBody.Limit, Logger: logger}), "schemas", files)
+	}
+	if m.Compress.Enabled {
+		add("compress", middleware.Compress(middleware.CompressOptions{MinSize: m.Compress.MinSize}), "min_size", m.Compress.MinSize)
+	}
+	if m.ETag.Enabled {
+		add("etag", middleware.ETag(middleware.ETagOptions{}))
+	}
+	if m.Timeout.Enabled {
+		timeout := middleware.Unless(middleware.Any(middleware.Path("/events"), middleware.PathPrefix("/debug/pprof/")), middleware.Timeout(m.Timeout.Duration))
+		mw, routes := withRoutes(timeout, m.Routes, func(route config.Route) (middleware.Middleware, any) {
+			if route.Timeout == 0 {
+				return nil, nil
+			}
+			return middleware.Timeout(route.Timeout), route.Timeout
+		})
+		add("timeout", mw, append([]any{"duration", m.Timeout.Duration, "skip", []string{"/events", "/debug/pprof/"}}, routes...)...)
+	}
+	if c := m.Cache; c.Enabled {
+		cache := func(ttl time.Duration) middleware.Middleware {
+			return middleware.Cache(middleware.CacheOptions{
+				TTL:                  ttl,
+				StaleWhileRevalidate: c.StaleWhileRevalidate,
+				// Tenancy may pick the tenant by header
+				VaryHeaders: []string{"X-Tenant-ID"},
+				Store:       d.cacheStore,
+				Logger:      logger,
+			})
+		}
+		mw, routes := withRoutes(cache(c.TTL), m.Routes, func(route config.Route) (middleware.Middleware, any) {
+			if route.CacheTTL == 0 {
+				return nil, nil
+			}
+			return cache(route.CacheTTL), route.CacheTTL
+		})
+		add("cache", mw, append([]any{"ttl", c.TTL, "stale_while_revalidate", c.StaleWhileRevalidate}, routes...)...)
+	}
+	if m.Coalesce.Enabled {
+		add("coalesce", middleware.Coalesce(middleware.CoalesceOptions{}))
+	}
+	add("cache_control", middleware.CacheControl(
+		middleware.CachePolicy{Authenticated: true, CacheControl: "private, no-store"},
+		middleware.CachePolicy{Match: middleware.Methods("GET", "HEAD"), CacheControl: "public, max-age=30", SurrogateControl: "max-age=300"},
+	))
+	if s := m.SecureHeaders; s.Enabled {
+		add("secure_headers", middleware.SecureHeaders(middleware.SecureHeadersOptions{ContentSecurityPolicy: s.ContentSecurityPolicy}))
+	}
+	if o := m.OpenAPI; o.Enabled {
+		api, err := middleware.LoadOpenAPI(o.Spec)
+		if err != nil {
+			return middleware.Chain{}, err
+		}
+		// Innermost but for render, so responses are validated as the
+		// handlers write them, before Compress and ETag
+		add("openapi", api.Middleware(middleware.OpenAPIOptions{
+			Mode:      middleware.OpenAPIMode(o.Mode),
+			Responses: o.Responses,
+			MaxBody:   m.MaxBody.Limit,
+			Logger:    logger,
+		}), "spec", o.Spec, "mode", o.Mode, "responses", o.Responses)
+	}
+	// Handlers answer with render.Respond, in the format of the Accept
+	// header
+	add("render", render.Middleware(render.Options{Logger: logger}), "types", []string{"application/json", "application/xml", "application/msgpack"})
+	return stack, nil
+}
+
+// withRoutes returns def with the overrides of routes for which override
+// returns a middleware, and the setting of each as a "routes" setting for
+// the registry. Settings of routes take effect on restart.
+func withRoutes(def middleware.Middleware, routes []config.Route, override func(config.Route) (middleware.Middleware, any)) (middleware.Middleware, []any) {
+	mws := map[string]middleware.Middleware{}
+	settings := map[string]any{}
+	for _, route := range routes {
+		if mw, setting := override(route); mw != nil {
+			mws[route.Path], settings[route.Path] = mw, setting
+		}
+	}
+	if len(mws) == 0 {
+		return def, nil
+	}
+	return middleware.PerRoute(def, mws), []any{"routes", settings}
+}
+
+// newTenants returns the tenants of cfg for middleware.Tenancy.
+func newTenants(cfg *config.Config) []middleware.Tenant {
+	tenants := make([]middleware.Tenant, len(cfg.Tenants))
+	for i, t := range cfg.Tenants {
+		app := t.App
+		if app == "" {
+			app = cfg.App
+		}
+		tenants[i] = middleware.Tenant{
+			ID:    t.ID,
+			Hosts: t.Hosts,
+			Config: middleware.Config{
+				App:      app,
+				Branding: middleware.Branding(t.Branding),
+				Limits:   t.Limits,
+			},
+		}
+	}
+	return tenants
+}
+
+// accessLogFormats maps the formats of config.AccessLog.
+var accessLogFormats = map[string]middleware.AccessLogFormat{
+	"json":     middleware.FormatJSON,
+	"common":   middleware.FormatCommon,
+	"combined": middleware.FormatCombined,
+}
+
+// newSLO returns the tracker of the objectives the routes of cfg declare,
+// or nil without any.
+func newSLO(cfg *config.Config, sink middleware.MetricsSink, logger *slog.Logger) *middleware.SLO {
+	var objectives []middleware.Objective
+	for _, route := range cfg.Middleware.Routes {
+		match := middleware.Route(route.Path)
+		if o := route.SLO; o.Availability > 0 {
+			objectives = append(objectives, middleware.Objective{Name: route.Path + " availability", Match: match, Target: o.Availability})
+		}
+		if o := route.SLO; o.Latency > 0 {
+			objectives = append(objectives, middleware.Objective{Name: route.Path + " latency", Match: match, Target: o.Latency, Latency: o.LatencyThreshold})
+		}
+	}
+	if objectives == nil {
+		return nil
+	}
+	opts := middleware.SLOOptions{Objectives: objectives, Sink: sink, Logger: logger}
+	if url := cfg.Middleware.SLO.Webhook; url != "" {
+		opts.OnBurn = middleware.BurnWebhook(url, middleware.BurnWebhookOptions{Logger: logger})
+	}
+	return middleware.NewSLO(opts)
+}
+
+// newApdex returns the Apdex scores of the requests timed, with the
+// thresholds of cfg, or nil with timing disabled.
+func newApdex(cfg *config.Config, sink middleware.MetricsSink) *middleware.Apdex {
+	m := cfg.Middleware
+	if !m.Timing.Enabled {
+		return nil
+	}
+	routes := map[string]time.Duration{}
+	for _, route := range m.Routes {
+		if route.ApdexThreshold > 0 {
+			routes[route.Path] = route.ApdexThreshold
+		}
+	}
+	return middleware.NewApdex(middleware.ApdexOptions{Threshold: m.Timing.ApdexThreshold, Routes: routes, Sink: sink})
+}
+
+// newAccessLog returns where the access log of a goes, and how to close it.
+func newAccessLog(a config.AccessLog, logger *slog.Logger) (io.Writer, func() error, error) {
+	if !a.Enabled || a.File == "" {
+		return os.Stdout, func() error { return nil }, nil
+	}
+	f, err := middleware.OpenLogFile(a.File, middleware.LogFileOptions{
+		MaxSize:    a.MaxSize,
+		Interval:   a.Interval,
+		Compress:   a.Compress,
+		MaxBackups: a.MaxBackups,
+		MaxAge:     a.MaxAge,
+		Logger:     logger,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f.Close, nil
+}
+
+// newFlagProvider returns the source of feature flags configured by f.
+func newFlagProvider(f config.FeatureFlags, logger *slog.Logger) (flags.FlagProvider, error) {
+	switch {
+	case f.File != "":
+		file, err := flags.LoadFile(f.File)
+		if err != nil {
+			return nil, err
+		}
+		go file.Watch(context.Background(), 10*time.Second, logger)
+		return file, nil
+	case f.URL != "":
+		return flags.NewRemote(context.Background(), f.URL, flags.RemoteOptions{Logger: logger}), nil
+	}
+	return flags.Static{}, nil
+}
+
+// logSampling returns the sampling of the routes with a log_sample, which
+// keeps every error, and their rates; both are nil without any.
+func logSampling(routes []config.Route) (func(*http.Request, int) float64, map[string]float64) {
+	rules := []middleware.LogSampleRule{{MinStatus: 400, Rate: 1}}
+	var rates map[string]float64
+	for _, route := range routes {
+		if route.LogSample == 0 {
+			continue
+		}
+		if rates == nil {
+			rates = map[string]float64{}
+		}
+		rates[route.Path] = route.LogSample
+		rules = append(rules, middleware.LogSampleRule{Match: middleware.Route(route.Path), Rate: route.LogSample})
+	}
+	if rates == nil {
+		return nil, nil
+	}
+	return middleware.SampleRules(rules...), rates
+}
+
+// verboseLogging raises the log level to debug while enabled, e.g. to
+// watch a misbehaving instance without restarting it.
+type verboseLogging struct {
+	level *slog.LevelVar
+
+	mu   sync.Mutex
+	base slog.Level // the configured level
+	on   bool
+}
+
+// Enable logs at debug level.
+func (v *verboseLogging) Enable() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.on = true
+	v.level.Set(slog.LevelDebug)
+}
+
+// Disable returns to the configured level.
+func (v *verboseLogging) Disable() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.on = false
+	v.level.Set(v.base)
+}
+
+// Enabled reports whether debug logging is on.
+func (v *verboseLogging) Enabled() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.on
+}
+
+// Level returns the level in effect.
+func (v *verboseLogging) Level() slog.Level { return v.level.Level() }
+
+// Set changes the level until the next configuration reload, switching
+// verbose logging off; it backs the admin log level endpoint.
+func (v *verboseLogging) Set(l slog.Level) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.on = false
+	v.level.Set(l)
+}
+
+// setLevel changes the configured level, which applies once verbose
+// logging is off.
+func (v *verboseLogging) setLevel(l slog.Level) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.base = l
+	if !v.on {
+		v.level.Set(l)
+	}
+}
+
+// newChaos returns the chaos injectors configured by cfg.
+func newChaos(cfg *config.Config, logger *slog.Logger) (*middleware.ChaosLatency, *middleware.ChaosFault) {
+	l, f := cfg.Middleware.ChaosLatency, cfg.Middleware.ChaosFault
+	latency := middleware.NewChaosLatency(middleware.ChaosLatencyOptions{
+		Rate: l.Rate,
+		Quantiles: []middleware.LatencyQuantile{
+			{Quantile: 0.5, Delay: l.P50},
+			{Quantile: 0.99, Delay: l.P99},
+		},
+		Match:   middleware.Not(streams),
+		Enabled: l.Enabled,
+		Logger:  logger,
+	})
+	var match middleware.Matcher
+	if f.Header != "" {
+		match = middleware.Header(f.Header, "fault")
+	}
+	fault := middleware.NewChaosFault(middleware.ChaosFaultOptions{
+		Rate:    f.Rate,
+		Abort:   f.Abort,
+		Match:   match,
+		Enabled: f.Enabled,
+		Logger:  logger,
+	})
+	return latency, fault
+}
+
+// newEdge returns the middleware in front of the router, outermost first.
+// geoIP, set up by main, and cors may be nil.
+func newEdge(cfg *config.Config, d stackDeps, ipFilter *middleware.IPFilter, geoIP middleware.Middleware, cors *middleware.CORSPolicy) middleware.Middleware {
+	var edge []middleware.Middleware
+	add := func(name string, mw middleware.Middleware, settings ...any) {
+		edge = append(edge, d.registry.Register(name, mw, settings...))
+	}
+	add("health", health.Default.Middleware())
+	if hosts := cfg.Middleware.AllowedHosts.Hosts; len(hosts) > 0 {
+		add("allowed_hosts", middleware.AllowedHosts(middleware.AllowedHostsOptions{Hosts: hosts, Logger: d.logger}), "hosts", hosts)
+	}
+	add("well_known", middleware.WellKnown(middleware.WellKnownOptions{
+		SecurityTxt: &middleware.SecurityTxt{Contact: []string{"mailto:security@example.com"}},
+		RobotsTxt:   "User-agent: *\nDisallow: /admin\nDisallow: /internal\n",
+	}))
+	add("ip_filter", ipFilter.Middleware(middleware.IPFilterOptions{Logger: d.logger}))
+	add("honeypot", middleware.Honeypot(middleware.HoneypotOptions{Filter: ipFilter, Logger: d.logger}))
+	if geoIP != nil {
+		add("geoip", geoIP)
+	}
+	if cors != nil {
+		add("cors", cors.Middleware(), "allowed_origins", cfg.Middleware.CORS.AllowedOrigins)
+	}
+	return middleware.Compose(edge...)
+}
+
+// newCORS returns the CORS policy configured by cfg, or nil if it is
+// disabled.
+func newCORS(cfg *config.Config) *middleware.CORSPolicy {
+	c := cfg.Middleware.CORS
+	if !c.Enabled {
+		return nil
+	}
+	return middleware.NewCORSPolicy(middleware.CORSOptions{
+		AllowedOrigins: c.AllowedOrigins,
+		AllowedHeaders: c.AllowedHeaders,
+		ExposedHeaders: c.ExposedHeaders,
+		MaxAge:         c.MaxAge,
+	})
+}
+
+// loadConfig resolves the configuration from the file at path, if any,
+// APP_* variables and flags. ALLOWED_HOSTS (comma-separated) and
+// WAF_RULES_FILE still apply when the other layers leave them unset.
+func loadConfig(path string, flags *config.Flags) (*config.Config, error) {
+	cfg, err := config.Resolve(path, flags)
+	if err != nil {
+		return nil, err
+	}
+	if hosts := os.Getenv("ALLOWED_HOSTS"); hosts != "" && len(cfg.Middleware.AllowedHosts.Hosts) == 0 {
+		cfg.Middleware.AllowedHosts.Hosts = strings.Split(hosts, ",")
+	}
+	if path := os.Getenv("WAF_RULES_FILE"); path != "" && cfg.Middleware.WAF.RulesFile == "" {
+		cfg.Middleware.WAF.RulesFile = path
+	}
+	return cfg, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"middlware/middleware"
+	"middlware/redisstore"
+)
+
+// newRedisClient connects to REDIS_ADDR, or returns nil when it is unset so
+// the in-memory stores are used.
+func newRedisClient() redisstore.Client {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		return nil
+	}
+	return redis.NewClient(&redis.Options{Addr: addr})
+}
+
+// newRateLimitStore shares rate limits through Redis when configured.
+func newRateLimitStore(client redisstore.Client) middleware.RateLimitStore {
+	if client != nil {
+		return redisstore.NewRateLimitStore(client, "")
+	}
+	return middleware.NewMemoryRateLimitStore(0)
+}
+
+// newRevocationStore shares revoked JWTs through Redis when configured.
+func newRevocationStore(client redisstore.Client) middleware.RevocationStore {
+	if client != nil {
+		return redisstore.NewRevocationStore(client, "")
+	}
+	store := middleware.NewMemoryRevocationStore()
+	go store.RunCleanup(context.Background(), time.Hour)
+	return store
+}
+
+// newCacheStore shares cached responses through Redis when configured.
+func newCacheStore(client redisstore.Client) middleware.CacheStore {
+	if client != nil {
+		return redisstore.NewCacheStore(client, "")
+	}
+	return middleware.NewMemoryCacheStore(0, 0)
+}
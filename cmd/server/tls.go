@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"middlware/server"
+)
+
+// newTLSConfig serves HTTPS with TLS_CERT_FILE and TLS_KEY_FILE, or with
+// Let's Encrypt certificates for the comma-separated TLS_AUTOCERT_DOMAINS
+// cached in TLS_AUTOCERT_CACHE. TLS_CLIENT_CA additionally asks clients for
+// certificates. It returns nil, meaning plain HTTP, when none is set.
+func newTLSConfig() *server.TLSConfig {
+	cfg := &server.TLSConfig{
+		CertFile:         os.Getenv("TLS_CERT_FILE"),
+		KeyFile:          os.Getenv("TLS_KEY_FILE"),
+		AutocertCacheDir: os.Getenv("TLS_AUTOCERT_CACHE"),
+		AutocertEmail:    os.Getenv("TLS_AUTOCERT_EMAIL"),
+		ClientCAFile:     os.Getenv("TLS_CLIENT_CA"),
+	}
+	if domains := os.Getenv("TLS_AUTOCERT_DOMAINS"); domains != "" {
+		cfg.AutocertDomains = strings.Split(domains, ",")
+	}
+	if cfg.CertFile == "" && len(cfg.AutocertDomains) == 0 {
+		return nil
+	}
+	return cfg
+}
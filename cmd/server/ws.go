@@ -0,0 +1,60 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"middlware/middleware"
+)
+
+// newEchoHandler upgrades to a WebSocket and sends every message back.
+// The route's authentication only sees the handshake, so the connection is
+// bound to the identity it was opened with and closed with "policy
+// violation" once that token expires; a socket can't outlive its
+// credentials. Handshakes must come from the server's own origin.
+func newEchoHandler(logger *slog.Logger) http.Handler {
+	upgrader := websocket.Upgrader{}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := middleware.IdentityFromContext(r.Context())
+		if !ok {
+			middleware.WriteProblem(w, r, &middleware.Error{Status: http.StatusUnauthorized})
+			return
+		}
+		var expires time.Time
+		if claims, ok := middleware.ClaimsFromContext(r.Context()); ok {
+			if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+				expires = exp.Time
+			}
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// Upgrade has answered the handshake with an error.
+			return
+		}
+		defer conn.Close()
+		if !expires.IsZero() {
+			expiry := time.AfterFunc(time.Until(expires), func() {
+				msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "token expired")
+				conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+				conn.Close()
+			})
+			defer expiry.Stop()
+		}
+
+		logger.Info("websocket opened", "subject", id.Subject, "request_id", middleware.RequestIDFromContext(r.Context()))
+		conn.SetReadLimit(64 << 10)
+		for {
+			kind, msg, err := conn.ReadMessage()
+			if err != nil {
+				break
+			}
+			if err := conn.WriteMessage(kind, msg); err != nil {
+				break
+			}
+		}
+		logger.Info("websocket closed", "subject", id.Subject)
+	})
+}
@@ -0,0 +1,619 @@
+// Package config describes the server and its middleware stack in a YAML
+// or JSON file.
+//
+// A file only needs the settings that differ from Default, e.g.
+//
+//	app: shop
+//	server:
+//	  addr: ":9000"
+//	  write_timeout: 1m
+//	middleware:
+//	  rate_limit:
+//	    rate: 50
+//	    burst: 100
+//	  chaos_latency:
+//	    enabled: true
+//
+// Durations are written as Go durations such as "300ms" or "1m30s".
+//
+// Every setting can also be given as an environment variable and as a
+// command-line flag, named after its key: "APP_" and the upper-cased key,
+// and the lower-cased key with dashes. The server and middleware sections
+// are left out of the names, so server.addr is APP_ADDR or -addr,
+// middleware.rate_limit.rate is APP_RATE_LIMIT_RATE or -rate-limit-rate
+// and auth.token_secret is APP_AUTH_TOKEN_SECRET or -auth-token-secret.
+// The application name is APP_NAME. Lists are comma-separated, e.g.
+// APP_CORS_ALLOWED_ORIGINS="https://a.example.com,https://b.example.com".
+// Resolve layers them over the file. The per-route overrides of
+// middleware.routes and the tenants only come from the file.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the whole configuration.
+type Config struct {
+	// App is the application name handlers see in middleware.Config.
+	App string `yaml:"app" env:"NAME"`
+	// LogLevel is "debug", "info", "warn" or "error".
+	LogLevel   slog.Level `yaml:"log_level"`
+	Server     Server     `yaml:"server" env:""`
+	Auth       Auth       `yaml:"auth"`
+	Secrets    Secrets    `yaml:"secrets"`
+	Middleware Middleware `yaml:"middleware" env:""`
+	// Tenants share the server, each with its own application settings.
+	// They are only read from the file.
+	Tenants []Tenant `yaml:"tenants" env:"-"`
+}
+
+// Tenant configures one tenant of middleware.Tenancy.
+type Tenant struct {
+	ID string `yaml:"id"`
+	// Hosts select the tenant by Host header, exact or "*.example.com";
+	// other requests name it in X-Tenant-ID.
+	Hosts []string `yaml:"hosts"`
+	// App defaults to the global app.
+	App      string   `yaml:"app"`
+	Branding Branding `yaml:"branding"`
+	// Limits are the tenant's quotas by name, e.g. "projects: 10".
+	Limits map[string]int64 `yaml:"limits"`
+}
+
+// Branding is how a tenant presents itself.
+type Branding struct {
+	Name    string `yaml:"name"`
+	LogoURL string `yaml:"logo_url"`
+	Color   string `yaml:"color"`
+}
+
+// Server holds the listener settings; zero values select the defaults of
+// server.Config.
+type Server struct {
+	Addr              string        `yaml:"addr"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	MaxHeaderBytes    int           `yaml:"max_header_bytes"`
+	// DrainTimeout is how long in-flight requests get on shutdown.
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+}
+
+// Auth holds the credentials of the fallback authentication used when no
+// JWT keys are configured.
+type Auth struct {
+	// TokenSecret, when set, is the X-Auth-Token value that authenticates
+	// an admin, in place of the auth_token secret. Only fit for local
+	// demos.
+	TokenSecret string `yaml:"token_secret"`
+}
+
+// Secrets selects where tokens and keys come from.
+type Secrets struct {
+	// Provider is "env" for environment variables named after the secret,
+	// e.g. AUTH_TOKEN, "dir" for the files of Dir, "vault" or "aws".
+	Provider string `yaml:"provider"`
+	Dir      string `yaml:"dir"`
+	// Vault is read with the token in VAULT_TOKEN.
+	Vault VaultSecrets `yaml:"vault"`
+	// AWS is read with the credentials in the usual AWS_* variables.
+	AWS AWSSecrets `yaml:"aws"`
+	// RefreshInterval is how often secrets of the dir, vault and aws
+	// providers are read again, so rotations apply.
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+}
+
+// VaultSecrets locates the secrets in HashiCorp Vault.
+type VaultSecrets struct {
+	Address string `yaml:"address"`
+	Mount   string `yaml:"mount"`
+	Path    string `yaml:"path"`
+}
+
+// AWSSecrets locates the secrets in AWS Secrets Manager. Secrets are named
+// Prefix and the secret name, e.g. "prod/myapp/" for prod/myapp/auth_token.
+type AWSSecrets struct {
+	Region string `yaml:"region"`
+	Prefix string `yaml:"prefix"`
+}
+
+// Middleware selects the middlewares of the global stack and their
+// options. RequestID and Recovery always run.
+type Middleware struct {
+	CORS             CORS             `yaml:"cors"`
+	AllowedHosts     AllowedHosts     `yaml:"allowed_hosts"`
+	IPFilter         IPFilter         `yaml:"ip_filter"`
+	Maintenance      Maintenance      `yaml:"maintenance"`
+	BotFilter        Toggle           `yaml:"bot_filter"`
+	WAF              WAF              `yaml:"waf"`
+	Tracing          Toggle           `yaml:"tracing"`
+	Metrics          Toggle           `yaml:"metrics"`
+	SLO              SLO              `yaml:"slo"`
+	Stats            Toggle           `yaml:"stats"`
+	Audit            Toggle           `yaml:"audit"`
+	RateLimit        RateLimit        `yaml:"rate_limit"`
+	LoadShed         LoadShed         `yaml:"load_shed"`
+	Scheduler        Scheduler        `yaml:"scheduler"`
+	ConcurrencyLimit ConcurrencyLimit `yaml:"concurrency_limit"`
+	FeatureFlags     FeatureFlags     `yaml:"feature_flags"`
+	Logging          Toggle           `yaml:"logging"`
+	AccessLog        AccessLog        `yaml:"access_log"`
+	Timing           Timing           `yaml:"timing"`
+	Profiling        Toggle           `yaml:"profiling"`
+	ChaosLatency     ChaosLatency     `yaml:"chaos_latency"`
+	ChaosFault       ChaosFault       `yaml:"chaos_fault"`
+	MaxBody          MaxBody          `yaml:"max_body"`
+	ValidateBody     Toggle           `yaml:"validate_body"`
+	OpenAPI          OpenAPI          `yaml:"openapi"`
+	Compress         Compress         `yaml:"compress"`
+	ETag             Toggle           `yaml:"etag"`
+	Timeout          Timeout          `yaml:"timeout"`
+	Cache            Cache            `yaml:"cache"`
+	Coalesce         Toggle           `yaml:"coalesce"`
+	SecureHeaders    SecureHeaders    `yaml:"secure_headers"`
+	// Routes override settings of the middlewares above for single
+	// routes. They are only read from the file.
+	Routes []Route `yaml:"routes" env:"-"`
+}
+
+// Toggle enables a middleware that has no options worth configuring.
+type Toggle struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// CORS configures middleware.CORS.
+type CORS struct {
+	Enabled        bool     `yaml:"enabled"`
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	AllowedHeaders []string `yaml:"allowed_headers"`
+	ExposedHeaders []string `yaml:"exposed_headers"`
+	// MaxAge is how long, in seconds, browsers may cache preflights.
+	MaxAge int `yaml:"max_age"`
+}
+
+// AllowedHosts configures middleware.AllowedHosts; it is enabled by
+// listing hosts.
+type AllowedHosts struct {
+	Hosts []string `yaml:"hosts" env:""`
+}
+
+// IPFilter lists the CIDRs or bare IPs middleware.IPFilter allows and
+// denies; it admits everyone with both lists empty.
+type IPFilter struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// Maintenance configures middleware.Maintenance. Disabled, it is still
+// installed so it can be switched on at runtime.
+type Maintenance struct {
+	Enabled    bool          `yaml:"enabled"`
+	RetryAfter time.Duration `yaml:"retry_after"`
+}
+
+// WAF configures the request inspection rules.
+type WAF struct {
+	Enabled bool `yaml:"enabled"`
+	// RulesFile replaces the built-in rules with those of a YAML file.
+	RulesFile string `yaml:"rules_file"`
+}
+
+// RateLimit configures middleware.RateLimit.
+type RateLimit struct {
+	Enabled bool `yaml:"enabled"`
+	// Rate is the number of requests per second and client.
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// LoadShed configures middleware.LoadShedder.
+type LoadShed struct {
+	Enabled       bool          `yaml:"enabled"`
+	MaxInFlight   int           `yaml:"max_in_flight"`
+	TargetLatency time.Duration `yaml:"target_latency"`
+}
+
+// Scheduler configures middleware.Scheduler, with credentials ranking
+// above anonymous requests.
+type Scheduler struct {
+	Enabled      bool          `yaml:"enabled"`
+	Max          int           `yaml:"max"`
+	Queue        int           `yaml:"queue"`
+	QueueTimeout time.Duration `yaml:"queue_timeout"`
+}
+
+// ConcurrencyLimit configures middleware.ConcurrencyLimit.
+type ConcurrencyLimit struct {
+	Enabled      bool          `yaml:"enabled"`
+	Max          int           `yaml:"max"`
+	Queue        int           `yaml:"queue"`
+	QueueTimeout time.Duration `yaml:"queue_timeout"`
+	PerRoute     bool          `yaml:"per_route"`
+}
+
+// FeatureFlags configures flags.Middleware. Definitions are read from File,
+// watched for changes, or fetched from URL; without either every flag is
+// off.
+type FeatureFlags struct {
+	Enabled bool   `yaml:"enabled"`
+	File    string `yaml:"file"`
+	URL     string `yaml:"url"`
+}
+
+// SLO configures middleware.SLO, which is enabled by routes with
+// objectives.
+type SLO struct {
+	// Webhook receives a JSON post whenever an error budget alert starts
+	// or stops firing.
+	Webhook string `yaml:"webhook"`
+}
+
+// AccessLog configures middleware.AccessLog, which writes one line per
+// request apart from the application log.
+type AccessLog struct {
+	Enabled bool `yaml:"enabled"`
+	// Format is "json", "common" or "combined".
+	Format string `yaml:"format"`
+	// File is written with rotation; the log goes to stdout when empty.
+	File string `yaml:"file"`
+	// MaxSize in bytes and Interval rotate the file; zero disables either.
+	MaxSize  int64         `yaml:"max_size"`
+	Interval time.Duration `yaml:"interval"`
+	// Compress gzips rotated files.
+	Compress bool `yaml:"compress"`
+	// MaxBackups and MaxAge limit the rotated files kept; zero keeps
+	// them.
+	MaxBackups int           `yaml:"max_backups"`
+	MaxAge     time.Duration `yaml:"max_age"`
+}
+
+// Timing configures middleware.Timing.
+type Timing struct {
+	Enabled      bool `yaml:"enabled"`
+	ServerTiming bool `yaml:"server_timing"`
+	// ApdexThreshold is the response time users are satisfied within, for
+	// the Apdex scores; routes may override it.
+	ApdexThreshold time.Duration `yaml:"apdex_threshold"`
+	// SlowThreshold singles out the requests taking longer, logged at
+	// warning level with their route and caller; routes may override it
+	// and zero turns it off.
+	SlowThreshold time.Duration `yaml:"slow_threshold"`
+	// SlowDump adds the goroutine stacks of slow requests to their record.
+	SlowDump bool `yaml:"slow_dump"`
+	// Breakdown adds the time every middleware took to the record of each
+	// request and, with ServerTiming, to Server-Timing. Changes take a
+	// restart.
+	Breakdown bool `yaml:"breakdown"`
+}
+
+// ChaosLatency configures middleware.ChaosLatency. Disabled, it is still
+// installed so it can be switched on at runtime.
+type ChaosLatency struct {
+	Enabled bool    `yaml:"enabled"`
+	Rate    float64 `yaml:"rate"`
+	// P50 and P99 shape the delays.
+	P50 time.Duration `yaml:"p50"`
+	P99 time.Duration `yaml:"p99"`
+}
+
+// ChaosFault configures middleware.ChaosFault. Disabled, it is still
+// installed so it can be switched on at runtime.
+type ChaosFault struct {
+	Enabled bool    `yaml:"enabled"`
+	Rate    float64 `yaml:"rate"`
+	Abort   float64 `yaml:"abort"`
+	// Header limits faults to requests sending it with the value "fault";
+	// empty means all requests.
+	Header string `yaml:"header"`
+}
+
+// MaxBody configures middleware.MaxBody.
+type MaxBody struct {
+	Enabled bool  `yaml:"enabled"`
+	Limit   int64 `yaml:"limit"`
+}
+
+// OpenAPI configures the validation of requests, and optionally
+// responses, against an OpenAPI 3 description.
+type OpenAPI struct {
+	Enabled bool `yaml:"enabled"`
+	// Spec is the file of the description, in YAML or JSON.
+	Spec string `yaml:"spec"`
+	// Mode is "log", only logging invalid requests and responses, or
+	// "strict", rejecting them, e.g. in CI.
+	Mode      string `yaml:"mode"`
+	Responses bool   `yaml:"responses"`
+}
+
+// Compress configures middleware.Compress.
+type Compress struct {
+	Enabled bool `yaml:"enabled"`
+	MinSize int  `yaml:"min_size"`
+}
+
+// Timeout configures middleware.Timeout.
+type Timeout struct {
+	Enabled  bool          `yaml:"enabled"`
+	Duration time.Duration `yaml:"duration"`
+}
+
+// Cache configures middleware.Cache.
+type Cache struct {
+	Enabled              bool          `yaml:"enabled"`
+	TTL                  time.Duration `yaml:"ttl"`
+	StaleWhileRevalidate time.Duration `yaml:"stale_while_revalidate"`
+}
+
+// SecureHeaders configures middleware.SecureHeaders.
+type SecureHeaders struct {
+	Enabled               bool   `yaml:"enabled"`
+	ContentSecurityPolicy string `yaml:"content_security_policy"`
+}
+
+// Route overrides middleware settings for the requests of one mux route.
+// Zero values keep the global setting, and a setting only applies while its
+// middleware is enabled.
+type Route struct {
+	// Path is the path template of the route, e.g. "/items/{id}".
+	Path      string         `yaml:"path"`
+	Timeout   time.Duration  `yaml:"timeout"`
+	RateLimit RouteRateLimit `yaml:"rate_limit"`
+	MaxBody   int64          `yaml:"max_body"`
+	CacheTTL  time.Duration  `yaml:"cache_ttl"`
+	// LogSample is the share of the route's successful requests logged,
+	// e.g. 0.01 for a busy probe; responses of 400 and up are all logged.
+	// Zero logs every request.
+	LogSample float64  `yaml:"log_sample"`
+	SLO       RouteSLO `yaml:"slo"`
+	// ApdexThreshold overrides middleware.timing.apdex_threshold.
+	ApdexThreshold time.Duration `yaml:"apdex_threshold"`
+	// SlowThreshold overrides middleware.timing.slow_threshold.
+	SlowThreshold time.Duration `yaml:"slow_threshold"`
+	// Schema is the file of the JSON Schema the POST, PUT and PATCH
+	// bodies of the route must match, loaded at startup.
+	Schema string `yaml:"schema"`
+}
+
+// RouteSLO declares the service level objectives of a route as the share
+// of requests, below 1, answered without a 5xx and within
+// LatencyThreshold; zero leaves either out.
+type RouteSLO struct {
+	Availability     float64       `yaml:"availability"`
+	Latency          float64       `yaml:"latency"`
+	LatencyThreshold time.Duration `yaml:"latency_threshold"`
+}
+
+// RouteRateLimit is the rate limit of a route, counted separately from the
+// global one. Burst defaults as in middleware.RateLimitOptions.
+type RouteRateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// Default returns the configuration used without a file.
+func Default() *Config {
+	return &Config{
+		App: "MyGO",
+		Server: Server{
+			Addr:         ":8080",
+			DrainTimeout: 10 * time.Second,
+		},
+		Secrets: Secrets{Provider: "env", RefreshInterval: 5 * time.Minute},
+		Middleware: Middleware{
+			CORS: CORS{
+				Enabled:        true,
+				AllowedOrigins: []string{"http://localhost:3000", "https://*.example.com"},
+				AllowedHeaders: []string{"Content-Type", "Authorization", "X-Auth-Token", "X-Request-ID"},
+				ExposedHeaders: []string{"X-Request-ID", "Server-Timing"},
+				MaxAge:         600,
+			},
+			Maintenance:      Maintenance{RetryAfter: 5 * time.Minute},
+			BotFilter:        Toggle{Enabled: true},
+			WAF:              WAF{Enabled: true},
+			Tracing:          Toggle{Enabled: true},
+			Stats:            Toggle{Enabled: true},
+			Metrics:          Toggle{Enabled: true},
+			Audit:            Toggle{Enabled: true},
+			RateLimit:        RateLimit{Enabled: true, Rate: 10, Burst: 20},
+			LoadShed:         LoadShed{Enabled: true, MaxInFlight: 80, TargetLatency: 100 * time.Millisecond},
+			Scheduler:        Scheduler{Enabled: true, Max: 200, Queue: 100, QueueTimeout: 3 * time.Second},
+			ConcurrencyLimit: ConcurrencyLimit{Enabled: true, Max: 100, Queue: 50, QueueTimeout: 3 * time.Second, PerRoute: true},
+			FeatureFlags:     FeatureFlags{Enabled: true},
+			Logging:          Toggle{Enabled: true},
+			AccessLog:        AccessLog{Format: "json", MaxSize: 100 << 20, Interval: 24 * time.Hour, Compress: true, MaxBackups: 14},
+			Timing:           Timing{Enabled: true, ServerTiming: true, ApdexThreshold: 500 * time.Millisecond, SlowThreshold: time.Second},
+			ChaosLatency:     ChaosLatency{Rate: 0.1, P50: 50 * time.Millisecond, P99: 2 * time.Second},
+			ChaosFault:       ChaosFault{Rate: 0.02, Abort: 0.2, Header: "X-Chaos"},
+			MaxBody:          MaxBody{Enabled: true, Limit: 1 << 20},
+			OpenAPI:          OpenAPI{Mode: "log"},
+			Compress:         Compress{Enabled: true, MinSize: 1 << 10},
+			ETag:             Toggle{Enabled: true},
+			Timeout:          Timeout{Enabled: true, Duration: 5 * time.Second},
+			Cache:            Cache{Enabled: true, TTL: 30 * time.Second, StaleWhileRevalidate: time.Minute},
+			Coalesce:         Toggle{Enabled: true},
+			SecureHeaders:    SecureHeaders{Enabled: true, ContentSecurityPolicy: "default-src 'none'; frame-ancestors 'none'"},
+			// Uploads get a larger body limit than the 1 MiB applied
+			// everywhere else
+			Routes: []Route{{Path: "/upload", MaxBody: 32 << 20}},
+		},
+	}
+}
+
+// Load reads the file at path over Default and validates the result. JSON
+// files are read as the YAML they also are. Unknown keys are errors, so
+// typos don't silently leave a default in place.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if err := cfg.decodeFile(path); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// decodeFile reads the file at path over c.
+func (c *Config) decodeFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate reports every setting out of range, joined into one error.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, key, problem string) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s %s", key, problem))
+		}
+	}
+	nonNegative := func(d time.Duration, key string) {
+		check(d >= 0, key, "must not be negative")
+	}
+	fraction := func(f float64, key string) {
+		check(f >= 0 && f <= 1, key, "must be between 0 and 1")
+	}
+
+	s := c.Server
+	if _, _, err := net.SplitHostPort(s.Addr); s.Addr != "" && err != nil {
+		errs = append(errs, fmt.Errorf("server.addr: %w", err))
+	}
+	check(s.MaxHeaderBytes >= 0, "server.max_header_bytes", "must not be negative")
+	nonNegative(s.DrainTimeout, "server.drain_timeout")
+
+	switch sec := c.Secrets; sec.Provider {
+	case "env", "aws":
+	case "dir":
+		check(sec.Dir != "", "secrets.dir", "must be set for the dir provider")
+	case "vault":
+		check(sec.Vault.Address != "", "secrets.vault.address", "must be set for the vault provider")
+		check(sec.Vault.Path != "", "secrets.vault.path", "must be set for the vault provider")
+	default:
+		errs = append(errs, fmt.Errorf("secrets.provider must be env, dir, vault or aws, not %q", sec.Provider))
+	}
+	check(c.Secrets.RefreshInterval > 0, "secrets.refresh_interval", "must be positive")
+
+	ids, hosts := map[string]bool{}, map[string]bool{}
+	for i, tenant := range c.Tenants {
+		key := fmt.Sprintf("tenants[%d]", i)
+		check(tenant.ID != "", key+".id", "must not be empty")
+		check(!ids[tenant.ID], key+".id", "repeats "+tenant.ID)
+		ids[tenant.ID] = true
+		for _, host := range tenant.Hosts {
+			host = strings.ToLower(host)
+			check(!hosts[host], key+".hosts", "repeats "+host)
+			hosts[host] = true
+		}
+		for _, name := range slices.Sorted(maps.Keys(tenant.Limits)) {
+			check(tenant.Limits[name] >= 0, key+".limits."+name, "must not be negative")
+		}
+	}
+
+	m := c.Middleware
+	addrs := func(list []string, key string) {
+		for _, entry := range list {
+			var err error
+			if strings.Contains(entry, "/") {
+				_, err = netip.ParsePrefix(entry)
+			} else {
+				_, err = netip.ParseAddr(entry)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			}
+		}
+	}
+	addrs(m.IPFilter.Allow, "middleware.ip_filter.allow")
+	addrs(m.IPFilter.Deny, "middleware.ip_filter.deny")
+	nonNegative(m.Maintenance.RetryAfter, "middleware.maintenance.retry_after")
+	if f := m.FeatureFlags; f.Enabled {
+		check(f.File == "" || f.URL == "", "middleware.feature_flags", "must not set both file and url")
+	}
+	if m.RateLimit.Enabled {
+		check(m.RateLimit.Rate > 0, "middleware.rate_limit.rate", "must be positive")
+		check(m.RateLimit.Burst >= 1, "middleware.rate_limit.burst", "must be at least 1")
+	}
+	if m.LoadShed.Enabled {
+		check(m.LoadShed.MaxInFlight >= 0, "middleware.load_shed.max_in_flight", "must not be negative")
+	}
+	queue := func(key string, max, queue int, timeout time.Duration) {
+		check(max >= 1, key+".max", "must be at least 1")
+		check(queue >= 0, key+".queue", "must not be negative")
+		nonNegative(timeout, key+".queue_timeout")
+	}
+	if q := m.Scheduler; q.Enabled {
+		queue("middleware.scheduler", q.Max, q.Queue, q.QueueTimeout)
+	}
+	if q := m.ConcurrencyLimit; q.Enabled {
+		queue("middleware.concurrency_limit", q.Max, q.Queue, q.QueueTimeout)
+	}
+	if a := m.AccessLog; a.Enabled {
+		check(slices.Contains([]string{"json", "common", "combined"}, a.Format), "middleware.access_log.format", "must be json, common or combined")
+		check(a.MaxSize >= 0, "middleware.access_log.max_size", "must not be negative")
+		nonNegative(a.Interval, "middleware.access_log.interval")
+		check(a.MaxBackups >= 0, "middleware.access_log.max_backups", "must not be negative")
+		nonNegative(a.MaxAge, "middleware.access_log.max_age")
+	}
+	fraction(m.ChaosLatency.Rate, "middleware.chaos_latency.rate")
+	check(m.ChaosLatency.P50 >= 0 && m.ChaosLatency.P50 <= m.ChaosLatency.P99, "middleware.chaos_latency.p50", "must be between 0 and p99")
+	fraction(m.ChaosFault.Rate, "middleware.chaos_fault.rate")
+	fraction(m.ChaosFault.Abort, "middleware.chaos_fault.abort")
+	if m.MaxBody.Enabled {
+		check(m.MaxBody.Limit > 0, "middleware.max_body.limit", "must be positive")
+	}
+	if m.OpenAPI.Enabled {
+		check(m.OpenAPI.Spec != "", "middleware.openapi.spec", "must be set")
+		check(m.OpenAPI.Mode == "strict" || m.OpenAPI.Mode == "log", "middleware.openapi.mode", "must be strict or log")
+	}
+	nonNegative(m.Timing.ApdexThreshold, "middleware.timing.apdex_threshold")
+	nonNegative(m.Timing.SlowThreshold, "middleware.timing.slow_threshold")
+	check(m.Compress.MinSize >= 0, "middleware.compress.min_size", "must not be negative")
+	if m.Timeout.Enabled {
+		check(m.Timeout.Duration > 0, "middleware.timeout.duration", "must be positive")
+	}
+	if m.Cache.Enabled {
+		check(m.Cache.TTL > 0, "middleware.cache.ttl", "must be positive")
+		nonNegative(m.Cache.StaleWhileRevalidate, "middleware.cache.stale_while_revalidate")
+	}
+	paths := map[string]bool{}
+	for i, route := range m.Routes {
+		key := fmt.Sprintf("middleware.routes[%d]", i)
+		check(strings.HasPrefix(route.Path, "/"), key+".path", "must start with /")
+		check(!paths[route.Path], key+".path", "repeats "+route.Path)
+		paths[route.Path] = true
+		nonNegative(route.Timeout, key+".timeout")
+		check(route.RateLimit.Rate >= 0, key+".rate_limit.rate", "must not be negative")
+		check(route.RateLimit.Burst >= 0, key+".rate_limit.burst", "must not be negative")
+		check(route.MaxBody >= 0, key+".max_body", "must not be negative")
+		nonNegative(route.CacheTTL, key+".cache_ttl")
+		fraction(route.LogSample, key+".log_sample")
+		check(route.SLO.Availability >= 0 && route.SLO.Availability < 1, key+".slo.availability", "must be at least 0 and below 1")
+		check(route.SLO.Latency >= 0 && route.SLO.Latency < 1, key+".slo.latency", "must be at least 0 and below 1")
+		check(route.SLO.Latency == 0 || route.SLO.LatencyThreshold > 0, key+".slo.latency_threshold", "must be positive with slo.latency")
+		nonNegative(route.ApdexThreshold, key+".apdex_threshold")
+		nonNegative(route.SlowThreshold, key+".slow_threshold")
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,200 @@
+package config
+
+import (
+	"encoding"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setting is one option reachable by environment variable and flag.
+type setting struct {
+	key   string // e.g. "middleware.rate_limit.rate"
+	env   string // e.g. "APP_RATE_LIMIT_RATE"
+	flag  string // e.g. "rate-limit-rate"
+	index []int
+	kind  reflect.Type
+}
+
+// settings lists every option of Config.
+var settings = collectSettings(reflect.TypeFor[Config](), nil, nil, "APP")
+
+// collectSettings walks the fields of t. A field is named by its yaml tag,
+// and its env tag, if present, replaces that name in the variable; an
+// empty env tag leaves the name out of it, and "-" the whole field.
+func collectSettings(t reflect.Type, index []int, keys []string, env string) []setting {
+	var out []setting
+	seen := map[string]bool{}
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		fieldEnv := env
+		if tag, ok := f.Tag.Lookup("env"); tag == "-" {
+			continue
+		} else if !ok {
+			fieldEnv += "_" + strings.ToUpper(name)
+		} else if tag != "" {
+			fieldEnv += "_" + tag
+		}
+		fieldIndex := append(append([]int(nil), index...), i)
+		fieldKeys := append(append([]string(nil), keys...), name)
+		if f.Type.Kind() == reflect.Struct {
+			out = append(out, collectSettings(f.Type, fieldIndex, fieldKeys, fieldEnv)...)
+			continue
+		}
+		out = append(out, setting{
+			key:   strings.Join(fieldKeys, "."),
+			env:   fieldEnv,
+			flag:  strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(fieldEnv, "APP_"), "_", "-")),
+			index: fieldIndex,
+			kind:  f.Type,
+		})
+	}
+	for _, s := range out {
+		if seen[s.env] {
+			panic("config: two settings named " + s.env)
+		}
+		seen[s.env] = true
+	}
+	return out
+}
+
+// set parses value into the setting's field of c.
+func (s setting) set(c *Config, value string) error {
+	v := reflect.ValueOf(c).Elem().FieldByIndex(s.index)
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(value))
+	}
+	switch {
+	case s.kind == reflect.TypeFor[time.Duration]():
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case s.kind.Kind() == reflect.String:
+		v.SetString(value)
+	case s.kind.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case v.CanInt():
+		n, err := strconv.ParseInt(value, 10, s.kind.Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case v.CanFloat():
+		f, err := strconv.ParseFloat(value, s.kind.Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case s.kind == reflect.TypeFor[[]string]():
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported type %s", s.kind)
+	}
+	return nil
+}
+
+// ApplyEnv sets the options that have an environment variable, looked up
+// with lookup, usually os.LookupEnv.
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	for _, s := range settings {
+		if value, ok := lookup(s.env); ok {
+			if err := s.set(c, value); err != nil {
+				return fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Flags collects the options given on the command line, to be applied on
+// top of the other layers once they are loaded.
+type Flags struct {
+	set []flagValue
+}
+
+type flagValue struct {
+	setting setting
+	value   string
+}
+
+// RegisterFlags defines a flag for every option on fs and returns where
+// their values are collected.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	for _, s := range settings {
+		fs.Var(settingFlag{f, s}, s.flag, fmt.Sprintf("sets %s (env %s)", s.key, s.env))
+	}
+	return f
+}
+
+// Apply sets the options given as flags, in command-line order.
+func (f *Flags) Apply(c *Config) error {
+	for _, fv := range f.set {
+		if err := fv.setting.set(c, fv.value); err != nil {
+			return fmt.Errorf("-%s: %w", fv.setting.flag, err)
+		}
+	}
+	return nil
+}
+
+// settingFlag is the flag.Value of a setting.
+type settingFlag struct {
+	flags   *Flags
+	setting setting
+}
+
+func (sf settingFlag) String() string { return "" }
+
+// Set checks value right away, so flag parsing reports it.
+func (sf settingFlag) Set(value string) error {
+	if err := sf.setting.set(Default(), value); err != nil {
+		return err
+	}
+	sf.flags.set = append(sf.flags.set, flagValue{sf.setting, value})
+	return nil
+}
+
+// IsBoolFlag lets boolean options be given as a bare -name.
+func (sf settingFlag) IsBoolFlag() bool { return sf.setting.kind.Kind() == reflect.Bool }
+
+// Resolve returns the configuration assembled from its layers, each
+// overriding the previous: Default, the file at path if path is set,
+// environment variables and flags, which may be nil. The result is
+// validated.
+func Resolve(path string, flags *Flags) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		if err := cfg.decodeFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.ApplyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if flags != nil {
+		if err := flags.Apply(cfg); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader re-reads a configuration file and hands every valid new version
+// to a callback, so settings the running middlewares support changing, such
+// as rate limits, CORS origins, IP lists and the log level, take effect
+// without a restart. A file that fails to load or validate is logged and
+// the running configuration kept.
+type Reloader struct {
+	path   string
+	load   func() (*Config, error)
+	apply  func(*Config)
+	logger *slog.Logger
+
+	mu  sync.Mutex
+	mod time.Time
+}
+
+// NewReloader returns a reloader of the file at path calling apply with
+// each new configuration. load reads the file with whatever layers go on
+// top of it, e.g. Resolve; it defaults to Load. logger defaults to
+// slog.Default().
+func NewReloader(path string, load func() (*Config, error), apply func(*Config), logger *slog.Logger) *Reloader {
+	if load == nil {
+		load = func() (*Config, error) { return Load(path) }
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	r := &Reloader{path: path, load: load, apply: apply, logger: logger}
+	if info, err := os.Stat(path); err == nil {
+		r.mod = info.ModTime()
+	}
+	return r
+}
+
+// Reload loads the file and applies it. Reloads are serialized, so apply
+// never runs concurrently with itself.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	}
+	cfg, err := r.load()
+	if err != nil {
+		return err
+	}
+	r.mod = info.ModTime()
+	r.apply(cfg)
+	return nil
+}
+
+// Watch polls the file every interval and reloads it when it changes, until
+// ctx is done. Errors are logged and the previous configuration kept.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		info, err := os.Stat(r.path)
+		if err != nil {
+			r.logger.Error("config stat failed", "path", r.path, "error", err)
+			continue
+		}
+		r.mu.Lock()
+		changed := !info.ModTime().Equal(r.mod)
+		r.mu.Unlock()
+		if changed {
+			r.reload()
+		}
+	}
+}
+
+// Notify reloads the file whenever c receives, e.g. on SIGHUP with
+// signal.Notify, until ctx is done.
+func (r *Reloader) Notify(ctx context.Context, c <-chan os.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c:
+			r.reload()
+		}
+	}
+}
+
+func (r *Reloader) reload() {
+	if err := r.Reload(); err != nil {
+		r.logger.Error("config reload failed", "path", r.path, "error", err)
+		return
+	}
+	r.logger.Info("config reloaded", "path", r.path)
+}
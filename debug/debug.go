@@ -0,0 +1,26 @@
+// Package debug serves the runtime's profiling and expvar endpoints.
+package debug
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+)
+
+// Handler returns a handler for the net/http/pprof endpoints under
+// /debug/pprof/ and the expvar variables at /debug/vars. They reveal the
+// command line, memory contents and more, so mount it behind
+// authentication.
+//
+// Profiles and traces run for their seconds parameter, which must stay
+// below the server's WriteTimeout and any Timeout middleware in front.
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
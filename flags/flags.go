@@ -0,0 +1,140 @@
+// Package flags evaluates feature flags per request, with percentage
+// rollouts and targeting of users and roles from the authenticated
+// identity. Definitions come from a FlagProvider: a static map, a watched
+// file or a remote service.
+package flags
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"middlware/middleware"
+)
+
+// Flag defines who gets a feature.
+type Flag struct {
+	// Enabled switches the flag on; when false nobody gets it, whatever
+	// the targeting says.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Users get the flag by identity subject.
+	Users []string `yaml:"users" json:"users"`
+	// Roles give the flag to callers holding any of them.
+	Roles []string `yaml:"roles" json:"roles"`
+	// Rollout is the fraction of callers, from 0 to 1, that get the flag.
+	// A caller keeps its answer across requests, and the callers of a
+	// smaller rollout are among those of a larger one.
+	//
+	// A flag without Users, Roles and Rollout is on for everyone.
+	Rollout float64 `yaml:"rollout" json:"rollout"`
+}
+
+// targeted reports whether f limits who gets it.
+func (f Flag) targeted() bool {
+	return len(f.Users) > 0 || len(f.Roles) > 0 || f.Rollout > 0
+}
+
+// FlagProvider supplies the flag definitions by name. Flags is called for
+// every request, so it should answer from memory.
+type FlagProvider interface {
+	Flags(ctx context.Context) (map[string]Flag, error)
+}
+
+// Options configures Middleware.
+type Options struct {
+	Provider FlagProvider
+	// Key identifies anonymous callers for rollouts; defaults to
+	// middleware.ClientIP. Authenticated callers are identified by their
+	// subject.
+	Key func(r *http.Request) string
+	// Logger receives provider errors; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Middleware takes a snapshot of the flag definitions for every request,
+// so a request sees the same answers throughout, and exposes it through
+// FromContext. Flags are evaluated when asked for, so authentication may
+// run further in. When the provider fails every flag is off.
+func Middleware(opts Options) middleware.Middleware {
+	if opts.Key == nil {
+		opts.Key = middleware.ClientIP
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defs, err := opts.Provider.Flags(r.Context())
+			if err != nil {
+				opts.Logger.ErrorContext(r.Context(), "feature flags unavailable", "error", err)
+				defs = nil
+			}
+			s := &snapshot{defs: defs, key: opts.Key}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, s)))
+		})
+	}
+}
+
+type contextKey struct{}
+
+// snapshot is what Middleware stores in the request context.
+type snapshot struct {
+	defs map[string]Flag
+	key  func(r *http.Request) string
+}
+
+// Set answers flag questions for one request.
+type Set struct {
+	defs map[string]Flag
+	id   *middleware.Identity
+	key  string
+}
+
+// FromContext returns the flags of r, as taken by Middleware. If the
+// middleware did not run every flag is off.
+func FromContext(r *http.Request) *Set {
+	s, _ := r.Context().Value(contextKey{}).(*snapshot)
+	if s == nil {
+		return &Set{}
+	}
+	set := &Set{defs: s.defs}
+	if id, ok := middleware.IdentityFromContext(r.Context()); ok {
+		set.id, set.key = id, id.Subject
+	} else {
+		set.key = s.key(r)
+	}
+	return set
+}
+
+// Enabled reports whether the caller gets the flag name. Unknown flags are
+// off.
+func (s *Set) Enabled(name string) bool {
+	f, ok := s.defs[name]
+	if !ok || !f.Enabled {
+		return false
+	}
+	if !f.targeted() {
+		return true
+	}
+	if s.id != nil {
+		if slices.Contains(f.Users, s.id.Subject) {
+			return true
+		}
+		for _, role := range s.id.Roles {
+			if slices.Contains(f.Roles, role) {
+				return true
+			}
+		}
+	}
+	return f.Rollout > 0 && bucket(name, s.key) < f.Rollout
+}
+
+// bucket places key at a stable point between 0 and 1 for the flag name,
+// so different flags roll out to different callers.
+func bucket(name, key string) float64 {
+	sum := sha256.Sum256([]byte(name + "\x00" + key))
+	return float64(binary.BigEndian.Uint64(sum[:])>>11) / (1 << 53)
+}
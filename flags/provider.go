@@ -0,0 +1,230 @@
+package flags
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Static is a fixed set of flags.
+type Static map[string]Flag
+
+// Flags returns s.
+func (s Static) Flags(context.Context) (map[string]Flag, error) { return s, nil }
+
+// File holds flags read from a YAML or JSON file mapping names to
+// definitions, e.g.
+//
+//	new-checkout:
+//	  enabled: true
+//	  rollout: 0.1
+//	  roles: [beta]
+//
+// It can be reloaded at runtime with Reload or Watch.
+type File struct {
+	path string
+
+	mu    sync.RWMutex
+	flags map[string]Flag
+	mod   time.Time
+}
+
+// LoadFile reads the flags of the file at path.
+func LoadFile(path string) (*File, error) {
+	f := &File{path: path}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Flags returns the flags last read.
+func (f *File) Flags(context.Context) (map[string]Flag, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.flags, nil
+}
+
+// Reload re-reads the file. On error the current flags stay in effect.
+func (f *File) Reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	var flags map[string]Flag
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&flags); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %w", f.path, err)
+	}
+	if err := validate(flags); err != nil {
+		return fmt.Errorf("%s: %w", f.path, err)
+	}
+	f.mu.Lock()
+	f.flags, f.mod = flags, info.ModTime()
+	f.mu.Unlock()
+	return nil
+}
+
+// Watch polls the file every interval and reloads it when it changes, until
+// ctx is done. Reload errors are logged and the previous flags kept.
+func (f *File) Watch(ctx context.Context, interval time.Duration, logger *slog.Logger) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		info, err := os.Stat(f.path)
+		if err != nil {
+			logger.Error("feature flags stat failed", "path", f.path, "error", err)
+			continue
+		}
+		f.mu.RLock()
+		changed := !info.ModTime().Equal(f.mod)
+		f.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := f.Reload(); err != nil {
+			logger.Error("feature flags reload failed", "path", f.path, "error", err)
+			continue
+		}
+		logger.Info("feature flags reloaded", "path", f.path)
+	}
+}
+
+// RemoteOptions configures NewRemote.
+type RemoteOptions struct {
+	// RefreshInterval is how often the flags are re-fetched; defaults to
+	// 30s.
+	RefreshInterval time.Duration
+	// Client fetches the flags; defaults to a client with a 10s timeout.
+	Client *http.Client
+	// Logger receives fetch failures; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Remote holds flags fetched from a service answering GET with the JSON
+// form of the File format, kept fresh in the background. When a fetch fails
+// the previous flags stay in use, so an outage of the service only delays
+// changes.
+type Remote struct {
+	url  string
+	opts RemoteOptions
+
+	mu      sync.RWMutex
+	flags   map[string]Flag
+	fetched time.Time
+}
+
+// NewRemote fetches the flags at url and refreshes them every
+// RefreshInterval until ctx is done. A failed initial fetch is logged
+// rather than returned; until a fetch succeeds every flag is off.
+func NewRemote(ctx context.Context, url string, opts RemoteOptions) *Remote {
+	if opts.RefreshInterval <= 0 {
+		opts.RefreshInterval = 30 * time.Second
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	p := &Remote{url: url, opts: opts}
+	p.refresh(ctx)
+	go p.run(ctx)
+	return p
+}
+
+// Flags returns the flags last fetched.
+func (p *Remote) Flags(context.Context) (map[string]Flag, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.flags, nil
+}
+
+func (p *Remote) run(ctx context.Context) {
+	ticker := time.NewTicker(p.opts.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.refresh(ctx)
+		}
+	}
+}
+
+func (p *Remote) refresh(ctx context.Context) {
+	flags, err := p.fetch(ctx)
+	if err != nil {
+		p.mu.RLock()
+		fetched := p.fetched
+		p.mu.RUnlock()
+		if fetched.IsZero() {
+			p.opts.Logger.Error("feature flags fetch failed, all flags off", "url", p.url, "error", err)
+		} else {
+			p.opts.Logger.Error("feature flags fetch failed, keeping previous flags", "url", p.url, "error", err, "flags_age", time.Since(fetched))
+		}
+		return
+	}
+	p.mu.Lock()
+	p.flags, p.fetched = flags, time.Now()
+	p.mu.Unlock()
+}
+
+func (p *Remote) fetch(ctx context.Context) (map[string]Flag, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var flags map[string]Flag
+	if err := json.NewDecoder(resp.Body).Decode(&flags); err != nil {
+		return nil, err
+	}
+	if err := validate(flags); err != nil {
+		return nil, err
+	}
+	return flags, nil
+}
+
+// validate reports rollouts out of range.
+func validate(flags map[string]Flag) error {
+	var errs []error
+	for name, f := range flags {
+		if f.Rollout < 0 || f.Rollout > 1 {
+			errs = append(errs, fmt.Errorf("%s: rollout must be between 0 and 1", name))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,205 @@
+// Package health provides /healthz and /readyz endpoints backed by a
+// registry of named readiness checks.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Checker reports whether a dependency is usable. It should honour ctx's
+// deadline.
+type Checker func(ctx context.Context) error
+
+// Status values used in reports.
+const (
+	StatusOK       = "ok"
+	StatusFail     = "fail"
+	StatusDraining = "draining"
+)
+
+// CheckResult is the outcome of one check.
+type CheckResult struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Report is the aggregate JSON document served by the handlers.
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// Registry holds readiness checks. The zero value is not usable; create one
+// with NewRegistry.
+type Registry struct {
+	// Timeout bounds every check run; defaults to 5s.
+	Timeout time.Duration
+	// LivenessPath and ReadinessPath are served by Middleware; they default
+	// to /healthz and /readyz.
+	LivenessPath  string
+	ReadinessPath string
+
+	mu       sync.RWMutex
+	checks   map[string]Checker
+	draining atomic.Bool
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{checks: make(map[string]Checker)}
+}
+
+// Default is the registry used by the package-level functions.
+var Default = NewRegistry()
+
+// Register adds a readiness check to Default.
+func Register(name string, fn Checker) {
+	Default.Register(name, fn)
+}
+
+// Register adds or replaces the named readiness check.
+func (reg *Registry) Register(name string, fn Checker) {
+	reg.mu.Lock()
+	reg.checks[name] = fn
+	reg.mu.Unlock()
+}
+
+// Unregister removes the named check.
+func (reg *Registry) Unregister(name string) {
+	reg.mu.Lock()
+	delete(reg.checks, name)
+	reg.mu.Unlock()
+}
+
+// SetDraining marks the process as shutting down (or not). While draining,
+// readiness fails without running any checks so load balancers stop routing
+// new traffic before the listener closes.
+func (reg *Registry) SetDraining(draining bool) {
+	reg.draining.Store(draining)
+}
+
+// Draining reports whether SetDraining(true) is in effect.
+func (reg *Registry) Draining() bool {
+	return reg.draining.Load()
+}
+
+// Check runs all checks concurrently and aggregates their results. The
+// overall status is "fail" if any check fails.
+func (reg *Registry) Check(ctx context.Context) Report {
+	timeout := reg.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	reg.mu.RLock()
+	names := make([]string, 0, len(reg.checks))
+	for name := range reg.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fns := make([]Checker, len(names))
+	for i, name := range names {
+		fns[i] = reg.checks[name]
+	}
+	reg.mu.RUnlock()
+
+	results := make([]CheckResult, len(names))
+	var wg sync.WaitGroup
+	for i, fn := range fns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = run(ctx, fn)
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]CheckResult, len(names))}
+	for i, name := range names {
+		report.Checks[name] = results[i]
+		if results[i].Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
+
+func run(ctx context.Context, fn Checker) (res CheckResult) {
+	start := time.Now()
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			res = CheckResult{Status: StatusFail, Error: "check panicked"}
+		}
+		res.Duration = time.Since(start).String()
+	}()
+	if err := fn(ctx); err != nil {
+		return CheckResult{Status: StatusFail, Error: err.Error()}
+	}
+	return CheckResult{Status: StatusOK}
+}
+
+// LivenessHandler reports that the process is up and serving. It runs no
+// checks so a failing dependency never gets the process restarted.
+func (reg *Registry) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, Report{Status: StatusOK})
+	})
+}
+
+// ReadinessHandler runs the registered checks and responds 200 when all
+// pass or 503 otherwise.
+func (reg *Registry) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reg.Draining() {
+			writeReport(w, Report{Status: StatusDraining})
+			return
+		}
+		writeReport(w, reg.Check(r.Context()))
+	})
+}
+
+// Middleware answers the liveness and readiness paths before the rest of the
+// chain runs, so probes bypass authentication, logging and rate limiting.
+func (reg *Registry) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		live, ready := reg.LivenessHandler(), reg.ReadinessHandler()
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				switch r.URL.Path {
+				case orDefault(reg.LivenessPath, "/healthz"):
+					live.ServeHTTP(w, r)
+					return
+				case orDefault(reg.ReadinessPath, "/readyz"):
+					ready.ServeHTTP(w, r)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func writeReport(w http.ResponseWriter, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != StatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
@@ -0,0 +1,187 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// AccessLogFormat selects how AccessLog renders each line.
+type AccessLogFormat int
+
+const (
+	// FormatJSON writes one JSON object per line.
+	FormatJSON AccessLogFormat = iota
+	// FormatCommon writes the Apache Common Log Format.
+	FormatCommon
+	// FormatCombined writes the Apache Combined Log Format (Common plus
+	// Referer and User-Agent).
+	FormatCombined
+	// FormatTemplate renders AccessLogOptions.Template for each entry.
+	FormatTemplate
+)
+
+// clfTimeLayout is the timestamp layout used by Apache's %t.
+const clfTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// AccessLogEntry describes one completed request. It is the data passed to
+// custom templates, e.g. `{{.Method}} {{.URI}} {{.Status}} {{.Duration}}`.
+type AccessLogEntry struct {
+	Time       time.Time     `json:"time"`
+	RequestID  string        `json:"request_id,omitempty"`
+	RemoteAddr string        `json:"remote_addr"`
+	User       string        `json:"user,omitempty"`
+	Method     string        `json:"method"`
+	URI        string        `json:"uri"`
+	Proto      string        `json:"proto"`
+	Status     int           `json:"status"`
+	Bytes      int64         `json:"bytes"`
+	Duration   time.Duration `json:"-"`
+	DurationMS float64       `json:"duration_ms"`
+	Referer    string        `json:"referer,omitempty"`
+	UserAgent  string        `json:"user_agent,omitempty"`
+	Host       string        `json:"host"`
+}
+
+// AccessLogOptions configures the AccessLog middleware.
+type AccessLogOptions struct {
+	// Format selects the line format; defaults to FormatJSON.
+	Format AccessLogFormat
+	// Template is a text/template over AccessLogEntry, required with
+	// FormatTemplate. A trailing newline is added if missing.
+	Template string
+	// Output receives the log lines; defaults to os.Stdout. Writes are
+	// serialized, so Output need not be safe for concurrent use.
+	Output io.Writer
+}
+
+// AccessLog writes one line per completed request in the configured format
+// so logs can feed existing pipelines without custom parsers. It panics if
+// the custom template does not parse.
+func AccessLog(opts AccessLogOptions) Middleware {
+	out := opts.Output
+	if out == nil {
+		out = os.Stdout
+	}
+	var tmpl *template.Template
+	if opts.Format == FormatTemplate {
+		tmpl = template.Must(template.New("accesslog").Parse(opts.Template))
+	}
+	var mu sync.Mutex
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec, owned := acquireResponseRecorder(w)
+			if owned {
+				defer releaseResponseRecorder(rec)
+			}
+			next.ServeHTTP(rec, r)
+
+			entry := newAccessLogEntry(r, rec, start)
+			var buf bytes.Buffer
+			switch opts.Format {
+			case FormatCommon:
+				writeCommon(&buf, &entry)
+			case FormatCombined:
+				writeCommon(&buf, &entry)
+				buf.WriteString(` "`)
+				writeEscaped(&buf, entry.Referer)
+				buf.WriteString(`" "`)
+				writeEscaped(&buf, entry.UserAgent)
+				buf.WriteByte('"')
+			case FormatTemplate:
+				if err := tmpl.Execute(&buf, &entry); err != nil {
+					buf.Reset()
+					buf.WriteString("access log template error: " + err.Error())
+				}
+			default:
+				json.NewEncoder(&buf).Encode(&entry)
+			}
+			if b := buf.Bytes(); len(b) == 0 || b[len(b)-1] != '\n' {
+				buf.WriteByte('\n')
+			}
+
+			mu.Lock()
+			out.Write(buf.Bytes())
+			mu.Unlock()
+		})
+	}
+}
+
+func newAccessLogEntry(r *http.Request, rec *ResponseRecorder, start time.Time) AccessLogEntry {
+	user, _, _ := r.BasicAuth()
+	status := rec.Status()
+	if status == 0 {
+		status = http.StatusOK
+	}
+	duration := time.Since(start)
+	return AccessLogEntry{
+		Time:       start,
+		RequestID:  RequestIDFromContext(r.Context()),
+		RemoteAddr: ClientIP(r),
+		User:       user,
+		Method:     r.Method,
+		URI:        r.RequestURI,
+		Proto:      r.Proto,
+		Status:     status,
+		Bytes:      rec.BytesWritten(),
+		Duration:   duration,
+		DurationMS: float64(duration) / float64(time.Millisecond),
+		Referer:    r.Referer(),
+		UserAgent:  r.UserAgent(),
+		Host:       r.Host,
+	}
+}
+
+// writeCommon renders `%h %l %u %t "%r" %>s %b`.
+func writeCommon(buf *bytes.Buffer, e *AccessLogEntry) {
+	buf.WriteString(orDash(e.RemoteAddr))
+	buf.WriteString(" - ")
+	buf.WriteString(orDash(e.User))
+	buf.WriteString(" [")
+	buf.WriteString(e.Time.Format(clfTimeLayout))
+	buf.WriteString(`] "`)
+	writeEscaped(buf, e.Method+" "+e.URI+" "+e.Proto)
+	buf.WriteString(`" `)
+	buf.WriteString(strconv.Itoa(e.Status))
+	buf.WriteByte(' ')
+	if e.Bytes == 0 {
+		buf.WriteByte('-')
+	} else {
+		buf.WriteString(strconv.FormatInt(e.Bytes, 10))
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// writeEscaped writes s with quotes, backslashes and non-printable bytes
+// escaped the way Apache does, so client-controlled values can't forge lines.
+func writeEscaped(buf *bytes.Buffer, s string) {
+	const hexDigits = "0123456789abcdef"
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case c < ' ' || c > '~':
+			buf.WriteString(`\x`)
+			buf.WriteByte(hexDigits[c>>4])
+			buf.WriteByte(hexDigits[c&0xf])
+		default:
+			buf.WriteByte(c)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ApdexOptions configures NewApdex.
+type ApdexOptions struct {
+	// Threshold is the response time T users are satisfied within; they
+	// tolerate up to 4T and are frustrated beyond, or by a 5xx. Defaults to
+	// 500ms.
+	Threshold time.Duration
+	// Routes override Threshold by mux path template, e.g. a slower T for
+	// "/reports/{id}".
+	Routes map[string]time.Duration
+	// Window is how far back scores look; defaults to 5m.
+	Window time.Duration
+	// Sink receives the gauge "http.apdex" tagged with the route after
+	// every request; defaults to NopSink.
+	Sink MetricsSink
+	// Prefix is prepended to the metric name; defaults to "http.".
+	Prefix string
+}
+
+const apdexBuckets = 10
+
+type apdexBucket struct {
+	start      time.Time
+	satisfied  int
+	tolerating int
+	frustrated int
+}
+
+type apdexRoute struct {
+	threshold time.Duration
+	buckets   [apdexBuckets]apdexBucket
+}
+
+// Apdex scores the response times of every route as (satisfied +
+// tolerating/2) / requests over a rolling window, from 0 when every user is
+// frustrated to 1 when all are satisfied. It is fed by Timing through
+// TimingOptions.Apdex.
+type Apdex struct {
+	opts   ApdexOptions
+	metric string
+
+	mu     sync.Mutex
+	routes map[string]*apdexRoute
+}
+
+// NewApdex returns an empty Apdex.
+func NewApdex(opts ApdexOptions) *Apdex {
+	if opts.Threshold <= 0 {
+		opts.Threshold = 500 * time.Millisecond
+	}
+	if opts.Window <= 0 {
+		opts.Window = 5 * time.Minute
+	}
+	if opts.Sink == nil {
+		opts.Sink = NopSink{}
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = "http."
+	}
+	return &Apdex{opts: opts, metric: opts.Prefix + "apdex", routes: map[string]*apdexRoute{}}
+}
+
+// Record counts a request of r answered with status after d.
+func (a *Apdex) Record(r *http.Request, status int, d time.Duration) {
+	route := routeTemplate(r)
+	if route == "" {
+		route = "unmatched"
+	}
+	now := time.Now()
+	a.mu.Lock()
+	ar := a.routes[route]
+	if ar == nil {
+		threshold, ok := a.opts.Routes[route]
+		if !ok {
+			threshold = a.opts.Threshold
+		}
+		ar = &apdexRoute{threshold: threshold}
+		a.routes[route] = ar
+	}
+	b := ar.bucket(now, a.opts.Window)
+	switch {
+	case status >= 500 || d > 4*ar.threshold:
+		b.frustrated++
+	case d > ar.threshold:
+		b.tolerating++
+	default:
+		b.satisfied++
+	}
+	score, _ := ar.score(now, a.opts.Window)
+	a.mu.Unlock()
+	a.opts.Sink.Gauge(a.metric, score, []string{"route:" + route})
+}
+
+// bucket returns the rolling-window bucket for now, resetting it if it
+// belongs to an earlier cycle.
+func (ar *apdexRoute) bucket(now time.Time, window time.Duration) *apdexBucket {
+	width := window / apdexBuckets
+	slot := now.Truncate(width)
+	b := &ar.buckets[(slot.UnixNano()/int64(width))%apdexBuckets]
+	if !b.start.Equal(slot) {
+		*b = apdexBucket{start: slot}
+	}
+	return b
+}
+
+// score returns the score of the window ending at now and its counts.
+func (ar *apdexRoute) score(now time.Time, window time.Duration) (float64, apdexBucket) {
+	var sum apdexBucket
+	for _, b := range ar.buckets {
+		if now.Sub(b.start) < window {
+			sum.satisfied += b.satisfied
+			sum.tolerating += b.tolerating
+			sum.frustrated += b.frustrated
+		}
+	}
+	total := sum.satisfied + sum.tolerating + sum.frustrated
+	if total == 0 {
+		return 1, sum
+	}
+	return (float64(sum.satisfied) + float64(sum.tolerating)/2) / float64(total), sum
+}
+
+// ApdexScore is the score of a route, as given by Scores.
+type ApdexScore struct {
+	Route      string  `json:"route"`
+	Threshold  string  `json:"threshold"`
+	Score      float64 `json:"score"`
+	Requests   int     `json:"requests"`
+	Satisfied  int     `json:"satisfied"`
+	Tolerating int     `json:"tolerating"`
+	Frustrated int     `json:"frustrated"`
+}
+
+// Scores returns the score of every route that had requests within the
+// window, by route.
+func (a *Apdex) Scores() []ApdexScore {
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := []ApdexScore{}
+	for route, ar := range a.routes {
+		score, sum := ar.score(now, a.opts.Window)
+		total := sum.satisfied + sum.tolerating + sum.frustrated
+		if total == 0 {
+			continue
+		}
+		out = append(out, ApdexScore{
+			Route:      route,
+			Threshold:  ar.threshold.String(),
+			Score:      round(score),
+			Requests:   total,
+			Satisfied:  sum.satisfied,
+			Tolerating: sum.tolerating,
+			Frustrated: sum.frustrated,
+		})
+	}
+	slices.SortFunc(out, func(x, y ApdexScore) int { return strings.Compare(x.Route, y.Route) })
+	return out
+}
+
+// Handler answers GET with Scores as JSON. Like Registry.Handler it must be
+// mounted behind admin-only middleware.
+func (a *Apdex) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"window": a.opts.Window.String(), "routes": a.Scores()})
+	})
+}
@@ -0,0 +1,225 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ErrKeyNotFound is returned by a KeyStore for unknown keys.
+var ErrKeyNotFound = errors.New("middleware: api key not found")
+
+const apiKeyKey contextKey = "apiKey"
+
+// APIKey is the metadata attached to an API key. The secret itself is never
+// kept here.
+type APIKey struct {
+	ID       string            `json:"id"`
+	Owner    string            `json:"owner"`
+	Scopes   []string          `json:"scopes,omitempty"`
+	RateTier string            `json:"rate_tier,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// KeyStore resolves a presented API key to its metadata, returning
+// ErrKeyNotFound for unknown keys. Stores should look keys up by hash (see
+// HashAPIKey) so that plaintext keys need not be stored.
+type KeyStore interface {
+	Lookup(ctx context.Context, key string) (*APIKey, error)
+}
+
+// HashAPIKey returns the hex SHA-256 of key, the form stores index by.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyOptions configures the APIKeyAuth middleware.
+type APIKeyOptions struct {
+	// Store resolves keys; required.
+	Store KeyStore
+	// Header carries the key; defaults to X-API-Key.
+	Header string
+	// QueryParam, when set, is also checked for the key. Keys in URLs leak
+	// into logs and browser history, so prefer the header.
+	QueryParam string
+	// Logger receives rejected-key and store error records; defaults to
+	// slog.Default().
+	Logger *slog.Logger
+}
+
+// APIKeyAuth authenticates requests by API key. Unknown keys get 401, store
+// failures 503. The key metadata is available through APIKeyFromContext and
+// as an Identity with Method "apikey".
+func APIKeyAuth(opts APIKeyOptions) Middleware {
+	header := opts.Header
+	if header == "" {
+		header = "X-API-Key"
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(header)
+			if key == "" && opts.QueryParam != "" {
+				key = r.URL.Query().Get(opts.QueryParam)
+			}
+			if key == "" {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			info, err := opts.Store.Lookup(r.Context(), key)
+			if err != nil {
+				logger := requestLogger(opts.Logger, r)
+				if errors.Is(err, ErrKeyNotFound) {
+					logger.LogAttrs(r.Context(), slog.LevelWarn, "invalid api key",
+						slog.String("path", r.URL.Path),
+						slog.String("remote_addr", r.RemoteAddr),
+					)
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+				logger.LogAttrs(r.Context(), slog.LevelError, "api key store failed", slog.String("error", err.Error()))
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), apiKeyKey, info)
+			ctx = ContextWithIdentity(ctx, &Identity{Subject: info.Owner, Method: "apikey", Scopes: info.Scopes})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// APIKeyFromContext returns the metadata of the key authenticated by
+// APIKeyAuth.
+func APIKeyFromContext(ctx context.Context) (*APIKey, bool) {
+	info, ok := ctx.Value(apiKeyKey).(*APIKey)
+	return info, ok
+}
+
+// MemoryKeyStore is a KeyStore backed by a map of key hashes.
+type MemoryKeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]*APIKey
+}
+
+// NewMemoryKeyStore returns a store holding keys, indexed by plaintext key.
+func NewMemoryKeyStore(keys map[string]*APIKey) *MemoryKeyStore {
+	s := &MemoryKeyStore{keys: make(map[string]*APIKey, len(keys))}
+	for k, v := range keys {
+		s.keys[HashAPIKey(k)] = v
+	}
+	return s
+}
+
+// Add registers key, replacing any existing entry.
+func (s *MemoryKeyStore) Add(key string, info *APIKey) {
+	s.mu.Lock()
+	s.keys[HashAPIKey(key)] = info
+	s.mu.Unlock()
+}
+
+// Remove revokes key.
+func (s *MemoryKeyStore) Remove(key string) {
+	s.mu.Lock()
+	delete(s.keys, HashAPIKey(key))
+	s.mu.Unlock()
+}
+
+func (s *MemoryKeyStore) Lookup(_ context.Context, key string) (*APIKey, error) {
+	return s.lookupHash(HashAPIKey(key))
+}
+
+func (s *MemoryKeyStore) lookupHash(hash string) (*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	info, ok := s.keys[hash]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return info, nil
+}
+
+// FileKeyStore is a KeyStore loaded from a JSON file containing a list of
+// objects with a "key_sha256" field (the HashAPIKey of the key) plus the
+// APIKey fields. Call Reload to pick up changes.
+type FileKeyStore struct {
+	path string
+	mem  MemoryKeyStore
+}
+
+// NewFileKeyStore loads path.
+func NewFileKeyStore(path string) (*FileKeyStore, error) {
+	s := &FileKeyStore{path: path}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload re-reads the file, atomically replacing the key set. On error the
+// previous keys stay in effect.
+func (s *FileKeyStore) Reload() error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var entries []struct {
+		KeySHA256 string `json:"key_sha256"`
+		APIKey
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	keys := make(map[string]*APIKey, len(entries))
+	for _, e := range entries {
+		info := e.APIKey
+		keys[strings.ToLower(e.KeySHA256)] = &info
+	}
+	s.mem.mu.Lock()
+	s.mem.keys = keys
+	s.mem.mu.Unlock()
+	return nil
+}
+
+func (s *FileKeyStore) Lookup(_ context.Context, key string) (*APIKey, error) {
+	return s.mem.lookupHash(HashAPIKey(key))
+}
+
+// SQLKeyStore is a KeyStore backed by a database table. Query receives the
+// key hash as its only argument and must select id, owner, scopes (space
+// separated) and rate tier, e.g.
+//
+//	SELECT id, owner, scopes, rate_tier FROM api_keys WHERE key_sha256 = $1 AND revoked = false
+type SQLKeyStore struct {
+	db    *sql.DB
+	query string
+}
+
+// NewSQLKeyStore returns a store running query against db.
+func NewSQLKeyStore(db *sql.DB, query string) *SQLKeyStore {
+	return &SQLKeyStore{db: db, query: query}
+}
+
+func (s *SQLKeyStore) Lookup(ctx context.Context, key string) (*APIKey, error) {
+	var info APIKey
+	var scopes, tier sql.NullString
+	err := s.db.QueryRowContext(ctx, s.query, HashAPIKey(key)).Scan(&info.ID, &info.Owner, &scopes, &tier)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	info.Scopes = strings.Fields(scopes.String)
+	info.RateTier = tier.String
+	return &info, nil
+}
@@ -0,0 +1,331 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// AuditEvent records one state-changing request.
+type AuditEvent struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	// Actor is the authenticated subject, empty for anonymous callers.
+	Actor      string `json:"actor,omitempty"`
+	AuthMethod string `json:"auth_method,omitempty"`
+	Action     string `json:"action"`
+	Resource   string `json:"resource"`
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	Status     int    `json:"status"`
+	// Outcome is "success" for 1xx-3xx responses, "denied" for 401 and 403
+	// and "failure" otherwise.
+	Outcome   string `json:"outcome"`
+	ClientIP  string `json:"client_ip"`
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+// AuditSink stores audit events. Implementations must be safe for
+// concurrent use.
+type AuditSink interface {
+	WriteAudit(ctx context.Context, e *AuditEvent) error
+}
+
+// AuditOptions configures the Audit middleware.
+type AuditOptions struct {
+	// Sink receives the events. Required.
+	Sink AuditSink
+	// Methods are the audited request methods; defaults to POST, PUT, PATCH
+	// and DELETE.
+	Methods []string
+	// Action names the operation and the resource it applies to; defaults
+	// to the method and the mux route template (or path).
+	Action func(r *http.Request) (action, resource string)
+	// ClientIP resolves the caller address; defaults to ClientIP.
+	ClientIP func(r *http.Request) string
+	// Logger receives sink errors; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Audit emits an AuditEvent for every request with one of the audited
+// methods once it completes. The actor is taken from the Identity set by
+// whichever authentication middleware ran, even one installed further in on
+// a subrouter.
+func Audit(opts AuditOptions) Middleware {
+	methods := opts.Methods
+	if methods == nil {
+		methods = []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	}
+	action := opts.Action
+	if action == nil {
+		action = func(r *http.Request) (string, string) {
+			resource := routeTemplate(r)
+			if resource == "" {
+				resource = r.URL.Path
+			}
+			return r.Method, resource
+		}
+	}
+	clientIP := opts.ClientIP
+	if clientIP == nil {
+		clientIP = ClientIP
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !slices.Contains(methods, r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			start := time.Now()
+			ctx, slot := captureIdentity(r.Context())
+			r = r.WithContext(ctx)
+			rec, owned := acquireResponseRecorder(w)
+			if owned {
+				defer releaseResponseRecorder(rec)
+			}
+			next.ServeHTTP(rec, r)
+
+			status := rec.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			e := &AuditEvent{
+				Time:      start.UTC(),
+				RequestID: RequestIDFromContext(ctx),
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				Status:    status,
+				Outcome:   auditOutcome(status),
+				ClientIP:  clientIP(r),
+				UserAgent: r.UserAgent(),
+			}
+			e.Action, e.Resource = action(r)
+			if slot.id != nil {
+				e.Actor, e.AuthMethod = slot.id.Subject, slot.id.Method
+			}
+			// The request context may already be cancelled; the event
+			// should still be written.
+			if err := opts.Sink.WriteAudit(context.WithoutCancel(ctx), e); err != nil {
+				requestLogger(opts.Logger, r).LogAttrs(ctx, slog.LevelError, "audit sink failed",
+					slog.String("action", e.Action),
+					slog.String("resource", e.Resource),
+					slog.String("error", err.Error()),
+				)
+			}
+		})
+	}
+}
+
+func auditOutcome(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return "denied"
+	case status < 400:
+		return "success"
+	}
+	return "failure"
+}
+
+// WriterAuditSink writes events as JSON lines to an io.Writer.
+type WriterAuditSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterAuditSink returns a sink writing to w. Writes are serialized, so
+// w need not be safe for concurrent use.
+func NewWriterAuditSink(w io.Writer) *WriterAuditSink {
+	return &WriterAuditSink{w: w}
+}
+
+// OpenAuditLog returns a sink appending to the file at path, creating it
+// readable by the owner only.
+func OpenAuditLog(path string) (*WriterAuditSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriterAuditSink(f), nil
+}
+
+// WriteAudit implements AuditSink.
+func (s *WriterAuditSink) WriteAudit(_ context.Context, e *AuditEvent) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(line)
+	return err
+}
+
+// Close closes the underlying writer if it is an io.Closer.
+func (s *WriterAuditSink) Close() error {
+	if c, ok := s.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// ErrAuditQueueFull is returned by HTTPAuditSink when events arrive faster
+// than the endpoint accepts them.
+var ErrAuditQueueFull = errors.New("audit: queue full")
+
+// HTTPAuditSinkOptions configures NewHTTPAuditSink.
+type HTTPAuditSinkOptions struct {
+	// Client sends the batches; defaults to a client with a 10s timeout.
+	Client *http.Client
+	// Header is added to every request, e.g. for an Authorization token.
+	Header http.Header
+	// BatchSize is the most events sent in one request; defaults to 100.
+	BatchSize int
+	// FlushInterval is how long events may wait for a batch to fill;
+	// defaults to 1s.
+	FlushInterval time.Duration
+	// QueueSize bounds the events waiting to be sent; defaults to 10000.
+	QueueSize int
+	// Logger receives delivery failures; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// HTTPAuditSink posts events in batches, as a JSON array, to an HTTP
+// endpoint from a background goroutine, so slow collectors don't add
+// latency to requests.
+type HTTPAuditSink struct {
+	url    string
+	opts   HTTPAuditSinkOptions
+	queue  chan *AuditEvent
+	closed chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+// NewHTTPAuditSink starts a sink posting to url. Call Close to flush
+// pending events on shutdown.
+func NewHTTPAuditSink(url string, opts HTTPAuditSinkOptions) *HTTPAuditSink {
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 10000
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	s := &HTTPAuditSink{
+		url:    url,
+		opts:   opts,
+		queue:  make(chan *AuditEvent, opts.QueueSize),
+		closed: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// WriteAudit implements AuditSink. It only queues the event.
+func (s *HTTPAuditSink) WriteAudit(_ context.Context, e *AuditEvent) error {
+	select {
+	case <-s.closed:
+		return errors.New("audit: sink closed")
+	default:
+	}
+	select {
+	case s.queue <- e:
+		return nil
+	default:
+		return ErrAuditQueueFull
+	}
+}
+
+// Close stops accepting events and waits until the queued ones were sent
+// or ctx is done.
+func (s *HTTPAuditSink) Close(ctx context.Context) error {
+	s.once.Do(func() { close(s.closed) })
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *HTTPAuditSink) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.opts.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]*AuditEvent, 0, s.opts.BatchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			s.send(batch)
+			batch = batch[:0]
+		}
+	}
+	for {
+		select {
+		case e := <-s.queue:
+			batch = append(batch, e)
+			if len(batch) == s.opts.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-s.closed:
+			for {
+				select {
+				case e := <-s.queue:
+					batch = append(batch, e)
+					if len(batch) == s.opts.BatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *HTTPAuditSink) send(batch []*AuditEvent) {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		s.opts.Logger.Error("audit batch encoding failed", "error", err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		s.opts.Logger.Error("audit delivery failed", "events", len(batch), "error", err)
+		return
+	}
+	for name, values := range s.opts.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.opts.Client.Do(req)
+	if err != nil {
+		s.opts.Logger.Error("audit delivery failed", "events", len(batch), "error", err)
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		s.opts.Logger.Error("audit delivery failed", "events", len(batch), "status", resp.StatusCode)
+	}
+}
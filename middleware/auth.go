@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+)
+
+// AuthenticationOptions configures the Authentication middleware.
+type AuthenticationOptions struct {
+	// Token is the value the X-Auth-Token header must carry.
+	Token string
+	// Secrets, when set, supplies the token as the secret TokenSecret
+	// instead, so it can be rotated.
+	Secrets     SecretsProvider
+	TokenSecret string
+	// Roles are granted to the Identity of authenticated requests.
+	Roles []string
+	// Logger receives the log records; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Authentication rejects requests whose X-Auth-Token header does not match
+// the configured token with 401 Unauthorized; an empty token rejects every
+// request. If the token cannot be looked up the request gets 503 Service
+// Unavailable. Accepted requests carry an Identity with Method "token".
+func Authentication(opts AuthenticationOptions) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := []byte(opts.Token)
+			if opts.Secrets != nil {
+				var err error
+				if token, err = opts.Secrets.Secret(r.Context(), opts.TokenSecret); err != nil {
+					requestLogger(opts.Logger, r).LogAttrs(r.Context(), slog.LevelError, "secret lookup failed",
+						slog.String("secret", opts.TokenSecret),
+						slog.String("error", err.Error()),
+					)
+					http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+					return
+				}
+			}
+			if len(token) == 0 || subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Auth-Token")), token) != 1 {
+				requestLogger(opts.Logger, r).LogAttrs(r.Context(), slog.LevelWarn, "invalid token",
+					slog.String("path", r.URL.Path),
+					slog.String("remote_addr", r.RemoteAddr),
+				)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			requestLogger(opts.Logger, r).LogAttrs(r.Context(), slog.LevelDebug, "verified token")
+			ctx := ContextWithIdentity(r.Context(), &Identity{Subject: "token", Method: "token", Roles: opts.Roles})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
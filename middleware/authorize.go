@@ -0,0 +1,185 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// PolicyError explains why a Policy denied a request. It is rendered as the
+// JSON body of the 403 response.
+type PolicyError struct {
+	Reason  string   `json:"reason"`
+	Missing []string `json:"missing,omitempty"`
+}
+
+func (e *PolicyError) Error() string {
+	if len(e.Missing) == 0 {
+		return e.Reason
+	}
+	return e.Reason + ": " + strings.Join(e.Missing, ", ")
+}
+
+// Policy decides whether the authenticated caller may perform the request.
+// It returns nil to allow, or an error (ideally a *PolicyError) to deny.
+type Policy func(id *Identity, r *http.Request) error
+
+// Authorize enforces policy for the rest of the chain. It must run after an
+// authentication middleware: requests without an Identity get 401, denied
+// ones 403 with a body like {"error":"forbidden","reason":"missing role",
+// "missing":["admin"]}.
+func Authorize(policy Policy) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, ok := IdentityFromContext(r.Context())
+			if !ok {
+				writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+				return
+			}
+			if err := policy(id, r); err != nil {
+				pe, ok := err.(*PolicyError)
+				if !ok {
+					pe = &PolicyError{Reason: err.Error()}
+				}
+				writeJSON(w, http.StatusForbidden, struct {
+					Error string `json:"error"`
+					*PolicyError
+				}{"forbidden", pe})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Satisfies matches requests whose caller, authenticated further out in the
+// chain, passes policy, e.g. Satisfies(RequireRole("premium")) to treat a
+// subscription tier differently. Anonymous requests never match.
+func Satisfies(policy Policy) Matcher {
+	return func(r *http.Request) bool {
+		id, ok := IdentityFromContext(r.Context())
+		return ok && policy(id, r) == nil
+	}
+}
+
+// RequireRole allows callers holding at least one of roles.
+func RequireRole(roles ...string) Policy {
+	return func(id *Identity, _ *http.Request) error {
+		for _, role := range roles {
+			if slices.Contains(id.Roles, role) {
+				return nil
+			}
+		}
+		return &PolicyError{Reason: "missing role", Missing: roles}
+	}
+}
+
+// RequireAllRoles allows callers holding every one of roles.
+func RequireAllRoles(roles ...string) Policy {
+	return func(id *Identity, _ *http.Request) error {
+		if missing := missingFrom(id.Roles, roles); len(missing) > 0 {
+			return &PolicyError{Reason: "missing role", Missing: missing}
+		}
+		return nil
+	}
+}
+
+// RequireAnyScope allows callers granted at least one of scopes.
+func RequireAnyScope(scopes ...string) Policy {
+	return func(id *Identity, _ *http.Request) error {
+		for _, scope := range scopes {
+			if slices.Contains(id.Scopes, scope) {
+				return nil
+			}
+		}
+		return &PolicyError{Reason: "missing scope", Missing: scopes}
+	}
+}
+
+// RequireAllScopes allows callers granted every one of scopes.
+func RequireAllScopes(scopes ...string) Policy {
+	return func(id *Identity, _ *http.Request) error {
+		if missing := missingFrom(id.Scopes, scopes); len(missing) > 0 {
+			return &PolicyError{Reason: "missing scope", Missing: missing}
+		}
+		return nil
+	}
+}
+
+// RequireScopes is the per-route form of RequireAllScopes, e.g.
+//
+//	r.Handle("/orders", RequireScopes("orders:write")(h)).Methods("POST")
+//
+// Denied requests get 403 listing only the scopes the caller lacks.
+func RequireScopes(scopes ...string) Middleware {
+	return Authorize(RequireAllScopes(scopes...))
+}
+
+// RequireClaim allows callers whose verified JWT has claim set to one of
+// values, or containing one of them when the claim is a list. Callers not
+// authenticated by JWT are denied.
+func RequireClaim(claim string, values ...string) Policy {
+	return func(_ *Identity, r *http.Request) error {
+		claims, _ := ClaimsFromContext(r.Context())
+		for _, v := range stringList(claims[claim]) {
+			if slices.Contains(values, v) {
+				return nil
+			}
+		}
+		return &PolicyError{Reason: "missing claim " + claim, Missing: values}
+	}
+}
+
+// RolePermissions maps each role to the permissions it grants, e.g.
+// {"admin": {"orders:read", "orders:write"}, "viewer": {"orders:read"}}.
+type RolePermissions map[string][]string
+
+// RequirePermission allows callers whose roles grant perm.
+func (rp RolePermissions) RequirePermission(perm string) Policy {
+	return func(id *Identity, _ *http.Request) error {
+		for _, role := range id.Roles {
+			if slices.Contains(rp[role], perm) {
+				return nil
+			}
+		}
+		return &PolicyError{Reason: "missing permission", Missing: []string{perm}}
+	}
+}
+
+// AllOf allows a request only if every policy allows it, returning the first
+// denial.
+func AllOf(policies ...Policy) Policy {
+	return func(id *Identity, r *http.Request) error {
+		for _, p := range policies {
+			if err := p(id, r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// AnyOf allows a request if at least one policy allows it, returning the
+// last denial otherwise.
+func AnyOf(policies ...Policy) Policy {
+	return func(id *Identity, r *http.Request) error {
+		var err error
+		for _, p := range policies {
+			if err = p(id, r); err == nil {
+				return nil
+			}
+		}
+		return err
+	}
+}
+
+// missingFrom returns the entries of want not present in have.
+func missingFrom(have, want []string) []string {
+	var missing []string
+	for _, w := range want {
+		if !slices.Contains(have, w) {
+			missing = append(missing, w)
+		}
+	}
+	return missing
+}
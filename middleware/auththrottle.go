@@ -0,0 +1,265 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AuthFailures is the failure history of one throttling key.
+type AuthFailures struct {
+	// Count is the number of consecutive failed attempts.
+	Count int
+	// Last is when the latest one happened.
+	Last time.Time
+	// Pending is the number of attempts reserved but not yet settled.
+	Pending int
+}
+
+// AuthFailureStore tracks authentication attempts per key. Reserve
+// atomically reserves an attempt if allow approves of the history, so
+// parallel attempts can't all pass a check made before any of them failed;
+// the attempt is then settled by exactly one of Fail, which counts it as
+// a failure, Reset, which forgets the failures, or Refund. Histories are
+// forgotten ttl after their last change.
+type AuthFailureStore interface {
+	Reserve(ctx context.Context, key string, ttl time.Duration, allow func(AuthFailures) bool) (f AuthFailures, ok bool, err error)
+	Fail(ctx context.Context, key string, ttl time.Duration) (AuthFailures, error)
+	Reset(ctx context.Context, key string) error
+	Refund(ctx context.Context, key string) error
+}
+
+// AuthThrottleOptions configures the AuthThrottle middleware.
+type AuthThrottleOptions struct {
+	// MaxFailures is how many consecutive failures are tolerated before the
+	// key is locked out; defaults to 5.
+	MaxFailures int
+	// BaseDelay is the first lockout; each further failure doubles it.
+	// Defaults to 1s.
+	BaseDelay time.Duration
+	// MaxDelay caps the lockout; defaults to 15 minutes. Setting BaseDelay
+	// equal to MaxDelay gives a fixed lockout instead of a backoff.
+	MaxDelay time.Duration
+	// Keys returns the keys a request's attempts count against; defaults to
+	// the client IP plus the Basic auth username, if any, so guessing is
+	// throttled both per attacker and per targeted account.
+	Keys func(r *http.Request) []string
+	// Store keeps the histories; defaults to NewMemoryAuthFailureStore().
+	Store AuthFailureStore
+	// Logger receives security events; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// AuthThrottle wraps authentication middlewares and counts their 401
+// responses. Once a key has failed MaxFailures times in a row, requests
+// for it are answered with 429 Too Many Requests and a Retry-After header
+// for an exponentially growing delay, without reaching the authenticator.
+// A successful request resets its keys. Attempts are reserved before the
+// authenticator runs, so no more than the remaining attempts can be in
+// flight at once, and a single one once the key has been locked out.
+func AuthThrottle(opts AuthThrottleOptions) Middleware {
+	maxFailures := opts.MaxFailures
+	if maxFailures <= 0 {
+		maxFailures = 5
+	}
+	base := opts.BaseDelay
+	if base <= 0 {
+		base = time.Second
+	}
+	maxDelay := opts.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = 15 * time.Minute
+	}
+	keys := opts.Keys
+	if keys == nil {
+		keys = func(r *http.Request) []string {
+			k := []string{"ip:" + ClientIP(r)}
+			if user, _, ok := r.BasicAuth(); ok {
+				k = append(k, "user:"+user)
+			}
+			return k
+		}
+	}
+	store := opts.Store
+	if store == nil {
+		store = NewMemoryAuthFailureStore()
+	}
+	lockout := func(count int) time.Duration {
+		if count < maxFailures {
+			return 0
+		}
+		d := base
+		for i := maxFailures; i < count && d < maxDelay; i++ {
+			d *= 2
+		}
+		return min(d, maxDelay)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			logger := requestLogger(opts.Logger, r)
+			// Histories outlive the longest lockout so a patient attacker
+			// doesn't start over from zero after it.
+			ttl := maxDelay + time.Hour
+			now := time.Now()
+			allow := func(f AuthFailures) bool {
+				return !f.Last.Add(lockout(f.Count)).After(now) && f.Count+f.Pending < max(maxFailures, f.Count+1)
+			}
+			var ks []string
+			settle := func(do func(k string) error) {
+				for _, k := range ks {
+					if err := do(k); err != nil {
+						logger.LogAttrs(ctx, slog.LevelError, "auth failure store failed", slog.String("error", err.Error()))
+					}
+				}
+			}
+			for _, k := range keys(r) {
+				f, ok, err := store.Reserve(ctx, k, ttl, allow)
+				if err != nil {
+					logger.LogAttrs(ctx, slog.LevelError, "auth failure store failed", slog.String("error", err.Error()))
+					continue
+				}
+				if !ok {
+					settle(func(k string) error { return store.Refund(ctx, k) })
+					wait := f.Last.Add(lockout(f.Count)).Sub(now)
+					logger.LogAttrs(ctx, slog.LevelWarn, "auth attempt while locked out",
+						slog.String("event", "auth_locked"),
+						slog.String("key", k),
+						slog.Int("failures", f.Count),
+						slog.Int("pending", f.Pending),
+						slog.String("client_ip", ClientIP(r)),
+					)
+					w.Header().Set("Retry-After", retryAfterSeconds(wait))
+					http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+					return
+				}
+				ks = append(ks, k)
+			}
+
+			rec, owned := acquireResponseRecorder(w)
+			if owned {
+				defer releaseResponseRecorder(rec)
+			}
+			// Panics refund the attempts too
+			status := 0
+			defer func() {
+				switch {
+				case status == http.StatusUnauthorized:
+					settle(func(k string) error {
+						f, err := store.Fail(ctx, k, ttl)
+						if err == nil && lockout(f.Count) > 0 {
+							logger.LogAttrs(ctx, slog.LevelWarn, "auth lockout",
+								slog.String("event", "auth_lockout"),
+								slog.String("key", k),
+								slog.Int("failures", f.Count),
+								slog.Duration("lockout", lockout(f.Count)),
+								slog.String("client_ip", ClientIP(r)),
+							)
+						}
+						return err
+					})
+				case status != 0 && status < 400:
+					settle(func(k string) error { return store.Reset(ctx, k) })
+				default:
+					settle(func(k string) error { return store.Refund(ctx, k) })
+				}
+			}()
+			next.ServeHTTP(rec, r)
+			status = rec.Status()
+		})
+	}
+}
+
+// MemoryAuthFailureStore is an AuthFailureStore for a single instance.
+type MemoryAuthFailureStore struct {
+	mu      sync.Mutex
+	entries map[string]authFailureEntry
+	sweep   time.Time
+}
+
+type authFailureEntry struct {
+	AuthFailures
+	expires time.Time
+}
+
+// NewMemoryAuthFailureStore returns an empty in-memory store.
+func NewMemoryAuthFailureStore() *MemoryAuthFailureStore {
+	return &MemoryAuthFailureStore{entries: make(map[string]authFailureEntry)}
+}
+
+// Reserve implements AuthFailureStore.
+func (s *MemoryAuthFailureStore) Reserve(_ context.Context, key string, ttl time.Duration, allow func(AuthFailures) bool) (AuthFailures, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.After(s.sweep) {
+		for k, e := range s.entries {
+			if now.After(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+		s.sweep = now.Add(time.Minute)
+	}
+	e := s.entries[key]
+	if now.After(e.expires) {
+		e = authFailureEntry{}
+	}
+	if !allow(e.AuthFailures) {
+		return e.AuthFailures, false, nil
+	}
+	e.Pending++
+	e.expires = now.Add(ttl)
+	s.entries[key] = e
+	return e.AuthFailures, true, nil
+}
+
+// Fail implements AuthFailureStore.
+func (s *MemoryAuthFailureStore) Fail(_ context.Context, key string, ttl time.Duration) (AuthFailures, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	e := s.entries[key]
+	if now.After(e.expires) {
+		e = authFailureEntry{}
+	}
+	e.Pending = max(e.Pending-1, 0)
+	e.Count++
+	e.Last = now
+	e.expires = now.Add(ttl)
+	s.entries[key] = e
+	return e.AuthFailures, nil
+}
+
+// Reset implements AuthFailureStore.
+func (s *MemoryAuthFailureStore) Reset(_ context.Context, key string) error {
+	s.settle(key, true)
+	return nil
+}
+
+// Refund implements AuthFailureStore.
+func (s *MemoryAuthFailureStore) Refund(_ context.Context, key string) error {
+	s.settle(key, false)
+	return nil
+}
+
+// settle releases a reservation of key, forgetting its failures if reset.
+func (s *MemoryAuthFailureStore) settle(key string, reset bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return
+	}
+	e.Pending = max(e.Pending-1, 0)
+	if reset {
+		e.Count, e.Last = 0, time.Time{}
+	}
+	if e.AuthFailures == (AuthFailures{}) {
+		delete(s.entries, key)
+		return
+	}
+	s.entries[key] = e
+}
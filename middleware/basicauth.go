@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// CredentialsProvider validates a username and password pair.
+type CredentialsProvider interface {
+	Validate(ctx context.Context, username, password string) (bool, error)
+}
+
+// StaticCredentials maps usernames to plaintext passwords. Comparisons are
+// constant-time, including for unknown users.
+type StaticCredentials map[string]string
+
+func (c StaticCredentials) Validate(_ context.Context, username, password string) (bool, error) {
+	want, ok := c[username]
+	// Hashing first makes the comparison independent of the lengths.
+	got, exp := sha256.Sum256([]byte(password)), sha256.Sum256([]byte(want))
+	return subtle.ConstantTimeCompare(got[:], exp[:]) == 1 && ok, nil
+}
+
+// BcryptCredentials maps usernames to bcrypt password hashes, e.g. as
+// produced by `htpasswd -nbB`.
+type BcryptCredentials map[string]string
+
+// dummyBcrypt is compared against for unknown users so that they take as
+// long to reject as wrong passwords.
+var dummyBcrypt = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	return hash
+})
+
+func (c BcryptCredentials) Validate(_ context.Context, username, password string) (bool, error) {
+	hash, ok := c[username]
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyBcrypt(), []byte(password))
+		return false, nil
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil, nil
+}
+
+// BasicAuthOptions configures the BasicAuth middleware.
+type BasicAuthOptions struct {
+	// Provider validates credentials; required.
+	Provider CredentialsProvider
+	// Realm is sent in the WWW-Authenticate challenge; defaults to
+	// "Restricted".
+	Realm string
+	// Logger receives failed-login records; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// BasicAuth authenticates requests with HTTP Basic credentials, answering
+// missing or wrong credentials with 401 and a WWW-Authenticate challenge.
+// The username becomes the Identity subject with Method "basic".
+func BasicAuth(opts BasicAuthOptions) Middleware {
+	realm := opts.Realm
+	if realm == "" {
+		realm = "Restricted"
+	}
+	challenge := "Basic realm=" + strconv.Quote(realm) + `, charset="UTF-8"`
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			username, password, ok := r.BasicAuth()
+			if ok {
+				valid, err := opts.Provider.Validate(r.Context(), username, password)
+				if err != nil {
+					requestLogger(opts.Logger, r).LogAttrs(r.Context(), slog.LevelError, "credentials provider failed",
+						slog.String("error", err.Error()),
+					)
+					http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+					return
+				}
+				if valid {
+					ctx := ContextWithIdentity(r.Context(), &Identity{Subject: username, Method: "basic"})
+					next.ServeHTTP(w, r.WithContext(ctx))
+					return
+				}
+				requestLogger(opts.Logger, r).LogAttrs(r.Context(), slog.LevelWarn, "invalid credentials",
+					slog.String("username", username),
+					slog.String("remote_addr", r.RemoteAddr),
+				)
+			}
+			w.Header().Set("WWW-Authenticate", challenge)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// BodyLogOptions configures the BodyLog middleware.
+type BodyLogOptions struct {
+	// Logger receives the log records; defaults to slog.Default().
+	Logger *slog.Logger
+	// Level of the emitted records; defaults to slog.LevelInfo.
+	Level slog.Level
+	// MaxBytes caps how much of each body is captured; defaults to 4 KiB.
+	MaxBytes int
+	// ContentTypes lists the media types whose bodies are captured; defaults
+	// to JSON, form and text types. Entries ending in "/" match a prefix.
+	ContentTypes []string
+	// RedactFields are JSON keys and form fields whose values are replaced,
+	// matched case-insensitively at any depth; defaults to password, token,
+	// secret, access_token, refresh_token and client_secret.
+	RedactFields []string
+	// RedactHeaders are header names whose values are replaced; defaults to
+	// Authorization, Cookie, Set-Cookie and X-Auth-Token.
+	RedactHeaders []string
+}
+
+// BodyLog captures request and response bodies (size-capped and filtered by
+// content type) and logs them with sensitive fields and headers redacted.
+// It is meant for debugging and should not be enabled on streaming routes.
+func BodyLog(opts BodyLogOptions) Middleware {
+	maxBytes := opts.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = 4 << 10
+	}
+	contentTypes := opts.ContentTypes
+	if len(contentTypes) == 0 {
+		contentTypes = []string{"application/json", "application/x-www-form-urlencoded", "text/"}
+	}
+	fields := opts.RedactFields
+	if len(fields) == 0 {
+		fields = []string{"password", "token", "secret", "access_token", "refresh_token", "client_secret"}
+	}
+	headers := opts.RedactHeaders
+	if len(headers) == 0 {
+		headers = []string{"Authorization", "Cookie", "Set-Cookie", "X-Auth-Token"}
+	}
+	red := newRedactor(fields, headers)
+	capturable := func(contentType string) bool {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return false
+		}
+		for _, ct := range contentTypes {
+			if mediaType == ct || (strings.HasSuffix(ct, "/") && strings.HasPrefix(mediaType, ct)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isUpgrade(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			var reqBody []byte
+			reqType := r.Header.Get("Content-Type")
+			if r.Body != nil && r.Body != http.NoBody && capturable(reqType) {
+				reqBody, _ = io.ReadAll(io.LimitReader(r.Body, int64(maxBytes)))
+				r.Body = readCloser{io.MultiReader(bytes.NewReader(reqBody), r.Body), r.Body}
+			}
+
+			rec, owned := acquireResponseRecorder(w)
+			if owned {
+				defer releaseResponseRecorder(rec)
+			}
+			cw := &captureWriter{ResponseRecorder: rec, max: maxBytes, capturable: capturable}
+			next.ServeHTTP(cw, r)
+
+			respType := cw.Header().Get("Content-Type")
+			requestLogger(opts.Logger, r).LogAttrs(r.Context(), opts.Level, "request body",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Any("request_headers", red.headers(r.Header)),
+				slog.String("request_body", red.body(reqType, reqBody)),
+				slog.Int("status", cw.Status()),
+				slog.Any("response_headers", red.headers(cw.Header())),
+				slog.String("response_body", red.body(respType, cw.buf.Bytes())),
+			)
+		})
+	}
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// captureWriter tees up to max bytes of a capturable response body.
+type captureWriter struct {
+	*ResponseRecorder
+	buf        bytes.Buffer
+	max        int
+	capturable func(string) bool
+	decided    bool
+	capture    bool
+}
+
+func (cw *captureWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		cw.decided = true
+		ct := cw.Header().Get("Content-Type")
+		if ct == "" {
+			ct = http.DetectContentType(b)
+		}
+		cw.capture = cw.capturable(ct)
+	}
+	if cw.capture {
+		if room := cw.max - cw.buf.Len(); room > 0 {
+			cw.buf.Write(b[:min(room, len(b))])
+		}
+	}
+	return cw.ResponseRecorder.Write(b)
+}
+
+// ReadFrom routes io.Copy through Write so copied bodies are captured too.
+func (cw *captureWriter) ReadFrom(src io.Reader) (int64, error) {
+	return io.Copy(writerOnly{cw}, src)
+}
+
+type redactor struct {
+	fields    map[string]struct{}
+	headerSet map[string]struct{}
+	fallback  *regexp.Regexp
+}
+
+func newRedactor(fields, headers []string) *redactor {
+	red := &redactor{fields: map[string]struct{}{}, headerSet: map[string]struct{}{}}
+	quoted := make([]string, 0, len(fields))
+	for _, f := range fields {
+		red.fields[strings.ToLower(f)] = struct{}{}
+		quoted = append(quoted, regexp.QuoteMeta(f))
+	}
+	for _, h := range headers {
+		red.headerSet[http.CanonicalHeaderKey(h)] = struct{}{}
+	}
+	// Truncated JSON can't be parsed, so string values of sensitive keys are
+	// masked textually instead.
+	red.fallback = regexp.MustCompile(`(?i)("(?:` + strings.Join(quoted, "|") + `)"\s*:\s*)"(?:[^"\\]|\\.)*"?`)
+	return red
+}
+
+func (red *redactor) headers(h http.Header) map[string]string {
+	out := make(map[string]string, len(h))
+	for k, v := range h {
+		if _, ok := red.headerSet[k]; ok {
+			out[k] = redacted
+			continue
+		}
+		out[k] = strings.Join(v, ", ")
+	}
+	return out
+}
+
+func (red *redactor) body(contentType string, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var v any
+		if err := json.Unmarshal(body, &v); err != nil {
+			return red.fallback.ReplaceAllString(string(body), `${1}"`+redacted+`"`)
+		}
+		out, _ := json.Marshal(red.value(v))
+		return string(out)
+	case mediaType == "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return string(body)
+		}
+		for k := range values {
+			if _, ok := red.fields[strings.ToLower(k)]; ok {
+				values[k] = []string{redacted}
+			}
+		}
+		return values.Encode()
+	}
+	return string(body)
+}
+
+func (red *redactor) value(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, val := range v {
+			if _, ok := red.fields[strings.ToLower(k)]; ok {
+				v[k] = redacted
+				continue
+			}
+			v[k] = red.value(val)
+		}
+	case []any:
+		for i, val := range v {
+			v[i] = red.value(val)
+		}
+	}
+	return v
+}
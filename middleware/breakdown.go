@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const breakdownKey contextKey = "breakdown"
+
+// breakdown collects the time each measured middleware of a request took
+// itself, apart from the rest of the chain it called.
+type breakdown struct {
+	mu     sync.Mutex
+	layers []layerTiming // outermost first
+}
+
+type layerTiming struct {
+	name string
+	// entered and left bound the middleware; passed and returned its call
+	// of the next handler, zero if it answered the request itself.
+	entered, passed, returned, left time.Time
+}
+
+// self returns the time the layer took itself until now.
+func (l *layerTiming) self(now time.Time) time.Duration {
+	if l.passed.IsZero() {
+		if !l.left.IsZero() {
+			now = l.left
+		}
+		return now.Sub(l.entered)
+	}
+	d := l.passed.Sub(l.entered)
+	if !l.left.IsZero() {
+		d += l.left.Sub(l.returned)
+	}
+	return d
+}
+
+func (b *breakdown) enter(name string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.layers = append(b.layers, layerTiming{name: name, entered: time.Now()})
+	return len(b.layers) - 1
+}
+
+// pass records the call of the next handler by the innermost layer named
+// name that has not made it yet.
+func (b *breakdown) pass(name string) int {
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := len(b.layers) - 1; i >= 0; i-- {
+		if l := &b.layers[i]; l.name == name && l.passed.IsZero() {
+			l.passed = now
+			return i
+		}
+	}
+	return -1
+}
+
+func (b *breakdown) returned(i int) {
+	if i < 0 {
+		return
+	}
+	now := time.Now()
+	b.mu.Lock()
+	b.layers[i].returned = now
+	b.mu.Unlock()
+}
+
+func (b *breakdown) leave(i int) {
+	now := time.Now()
+	b.mu.Lock()
+	b.layers[i].left = now
+	b.mu.Unlock()
+}
+
+// attrs returns the time of every layer so far, as a "middlewares" group.
+func (b *breakdown) attrs() slog.Attr {
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	attrs := make([]any, 0, len(b.layers))
+	for i := range b.layers {
+		attrs = append(attrs, slog.Duration(b.layers[i].name, b.layers[i].self(now)))
+	}
+	return slog.Group("middlewares", attrs...)
+}
+
+// metrics returns the time of every layer so far as Server-Timing metrics.
+func (b *breakdown) metrics() []ServerTimingMetric {
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	metrics := make([]ServerTimingMetric, 0, len(b.layers))
+	for i := range b.layers {
+		metrics = append(metrics, ServerTimingMetric{Name: b.layers[i].name, Desc: "middleware", Duration: b.layers[i].self(now)})
+	}
+	return metrics
+}
+
+func breakdownFromContext(ctx context.Context) *breakdown {
+	b, _ := ctx.Value(breakdownKey).(*breakdown)
+	return b
+}
+
+// Measure wraps mw so it reports the time it takes itself, without the
+// rest of the chain it calls, to Timing, which logs the times of the
+// measured middlewares of every request and can send them as Server-Timing
+// metrics. The outermost measured middleware starts the breakdown of the
+// request. Time spent in unmeasured middlewares counts to the measured one
+// around them. See also Registry.Measure.
+func Measure(name string, mw Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b := breakdownFromContext(r.Context())
+			if b == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			i := b.pass(name)
+			defer b.returned(i)
+			next.ServeHTTP(w, r)
+		})
+		h := mw(inner)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b := breakdownFromContext(r.Context())
+			if b == nil {
+				b = &breakdown{}
+				r = r.WithContext(context.WithValue(r.Context(), breakdownKey, b))
+			}
+			defer b.leave(b.enter(name))
+			h.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,319 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by CircuitBreaker.Allow while the breaker is
+// rejecting calls.
+var ErrCircuitOpen = errors.New("middleware: circuit breaker is open")
+
+// BreakerState is the state of a CircuitBreaker.
+type BreakerState int
+
+const (
+	// BreakerClosed lets every call through while tracking failures.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen rejects every call until the cooldown expires.
+	BreakerOpen
+	// BreakerHalfOpen lets a limited number of probe calls through; their
+	// outcome decides whether the breaker closes or opens again.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerOptions configures a CircuitBreaker.
+type BreakerOptions struct {
+	// Name identifies the dependency, e.g. for BreakerFromContext.
+	Name string
+	// FailureRate in (0, 1] trips the breaker once reached; defaults to 0.5.
+	FailureRate float64
+	// MinRequests is the minimum number of calls in the window before the
+	// failure rate is considered; defaults to 10.
+	MinRequests int
+	// Window is the rolling period over which calls are counted; defaults
+	// to 10s.
+	Window time.Duration
+	// Cooldown is how long the breaker stays open before probing; defaults
+	// to 30s.
+	Cooldown time.Duration
+	// HalfOpenRequests is the number of probe calls allowed while half-open;
+	// defaults to 1.
+	HalfOpenRequests int
+	// OnStateChange, if set, is called after every transition, in order and
+	// outside the breaker's lock.
+	OnStateChange func(name string, from, to BreakerState)
+}
+
+const breakerBuckets = 10
+
+type breakerBucket struct {
+	start     time.Time
+	successes int
+	failures  int
+}
+
+// CircuitBreaker tracks the failure rate of calls to a dependency and fails
+// fast while it is unhealthy.
+type CircuitBreaker struct {
+	opts BreakerOptions
+
+	mu       sync.Mutex
+	state    BreakerState
+	openedAt time.Time
+	probes   int
+	buckets  [breakerBuckets]breakerBucket
+	// generation counts transitions, so calls admitted before one don't
+	// count towards the state after it
+	generation uint64
+	// changes are the transitions OnStateChange is yet to be called with,
+	// and notifying is set while a caller delivers them
+	changes   []breakerChange
+	notifying bool
+}
+
+type breakerChange struct {
+	from, to BreakerState
+}
+
+// NewCircuitBreaker returns a closed breaker.
+func NewCircuitBreaker(opts BreakerOptions) *CircuitBreaker {
+	if opts.FailureRate <= 0 || opts.FailureRate > 1 {
+		opts.FailureRate = 0.5
+	}
+	if opts.MinRequests <= 0 {
+		opts.MinRequests = 10
+	}
+	if opts.Window <= 0 {
+		opts.Window = 10 * time.Second
+	}
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = 30 * time.Second
+	}
+	if opts.HalfOpenRequests <= 0 {
+		opts.HalfOpenRequests = 1
+	}
+	return &CircuitBreaker{opts: opts}
+}
+
+// Name returns the configured name.
+func (cb *CircuitBreaker) Name() string {
+	return cb.opts.Name
+}
+
+// State returns the current state, moving from open to half-open if the
+// cooldown has elapsed.
+func (cb *CircuitBreaker) State() BreakerState {
+	defer cb.notify()
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.maybeHalfOpen(time.Now())
+	return cb.state
+}
+
+// Allow asks to make one call. On success the caller must invoke done with
+// the outcome of the call; while the breaker is open it returns
+// ErrCircuitOpen.
+func (cb *CircuitBreaker) Allow() (done func(success bool), err error) {
+	defer cb.notify()
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	now := time.Now()
+	cb.maybeHalfOpen(now)
+
+	switch cb.state {
+	case BreakerOpen:
+		return nil, ErrCircuitOpen
+	case BreakerHalfOpen:
+		if cb.probes >= cb.opts.HalfOpenRequests {
+			return nil, ErrCircuitOpen
+		}
+		cb.probes++
+	}
+	generation := cb.generation
+	var once sync.Once
+	return func(success bool) {
+		once.Do(func() { cb.record(generation, success) })
+	}, nil
+}
+
+// Do runs fn if the breaker allows it and records whether it returned an
+// error.
+func (cb *CircuitBreaker) Do(fn func() error) error {
+	done, err := cb.Allow()
+	if err != nil {
+		return err
+	}
+	err = fn()
+	done(err == nil)
+	return err
+}
+
+// record counts the outcome of a call admitted in generation.
+func (cb *CircuitBreaker) record(generation uint64, success bool) {
+	defer cb.notify()
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	now := time.Now()
+
+	if generation != cb.generation {
+		return
+	}
+	if cb.state == BreakerHalfOpen {
+		// The first probe to finish decides for the others
+		if success {
+			cb.setState(BreakerClosed, now)
+		} else {
+			cb.setState(BreakerOpen, now)
+		}
+		return
+	}
+	if cb.state != BreakerClosed {
+		return
+	}
+
+	b := cb.bucket(now)
+	if success {
+		b.successes++
+	} else {
+		b.failures++
+	}
+	var total, failures int
+	for _, b := range cb.buckets {
+		if now.Sub(b.start) < cb.opts.Window {
+			total += b.successes + b.failures
+			failures += b.failures
+		}
+	}
+	if total >= cb.opts.MinRequests && float64(failures)/float64(total) >= cb.opts.FailureRate {
+		cb.setState(BreakerOpen, now)
+	}
+}
+
+// bucket returns the rolling-window bucket for now, resetting it if it
+// belongs to an earlier cycle.
+func (cb *CircuitBreaker) bucket(now time.Time) *breakerBucket {
+	width := cb.opts.Window / breakerBuckets
+	if width <= 0 {
+		width = 1
+	}
+	slot := now.Truncate(width)
+	b := &cb.buckets[(slot.UnixNano()/int64(width))%breakerBuckets]
+	if !b.start.Equal(slot) {
+		*b = breakerBucket{start: slot}
+	}
+	return b
+}
+
+func (cb *CircuitBreaker) maybeHalfOpen(now time.Time) {
+	if cb.state == BreakerOpen && now.Sub(cb.openedAt) >= cb.opts.Cooldown {
+		cb.setState(BreakerHalfOpen, now)
+	}
+}
+
+func (cb *CircuitBreaker) setState(to BreakerState, now time.Time) {
+	from := cb.state
+	if from == to {
+		return
+	}
+	cb.state = to
+	cb.generation++
+	cb.probes = 0
+	switch to {
+	case BreakerOpen:
+		cb.openedAt = now
+	case BreakerClosed:
+		cb.buckets = [breakerBuckets]breakerBucket{}
+	}
+	if cb.opts.OnStateChange != nil {
+		cb.changes = append(cb.changes, breakerChange{from, to})
+	}
+}
+
+// notify calls OnStateChange with the pending transitions once cb.mu is
+// released. Only one caller delivers at a time, so listeners see them in
+// order, and transitions caused by a listener are delivered after it
+// returns.
+func (cb *CircuitBreaker) notify() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.notifying {
+		return
+	}
+	cb.notifying = true
+	defer func() { cb.notifying = false }()
+	for len(cb.changes) > 0 {
+		changes := cb.changes
+		cb.changes = nil
+		func() {
+			cb.mu.Unlock()
+			defer cb.mu.Lock()
+			for _, c := range changes {
+				cb.opts.OnStateChange(cb.opts.Name, c.from, c.to)
+			}
+		}()
+	}
+}
+
+const breakersKey contextKey = "breakers"
+
+// Breakers makes bs available to handlers through BreakerFromContext, so a
+// handler calling a dependency can check the breaker before doing so.
+func Breakers(bs ...*CircuitBreaker) Middleware {
+	byName := make(map[string]*CircuitBreaker, len(bs))
+	for _, b := range bs {
+		byName[b.Name()] = b
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), breakersKey, byName)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// BreakerFromContext returns the named breaker registered with Breakers.
+func BreakerFromContext(ctx context.Context, name string) (*CircuitBreaker, bool) {
+	byName, _ := ctx.Value(breakersKey).(map[string]*CircuitBreaker)
+	cb, ok := byName[name]
+	return cb, ok
+}
+
+// CircuitBreak guards the rest of the chain with cb: while the breaker is
+// open requests fail fast with 503, and 5xx responses count as failures.
+// Wrapping an httputil.ReverseProxy with it gives a breaker per upstream.
+func CircuitBreak(cb *CircuitBreaker) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			done, err := cb.Allow()
+			if err != nil {
+				w.Header().Set("Retry-After", retryAfterSeconds(cb.opts.Cooldown))
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			}
+			rec, owned := acquireResponseRecorder(w)
+			if owned {
+				defer releaseResponseRecorder(rec)
+			}
+			success := false
+			defer func() { done(success) }()
+			next.ServeHTTP(rec, r)
+			success = rec.Status() < 500
+		})
+	}
+}
@@ -0,0 +1,723 @@
+package middleware
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CachedResponse is a response kept by a CacheStore.
+//
+// A Status of 0 marks a key whose responses turned out not to be cacheable,
+// so that requests for it skip the fill lock until the marker expires.
+type CachedResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	// Stored is when the response was produced and Expires when it stops
+	// being fresh. Until StaleUntil, if later, it may still be served
+	// while it is being refreshed.
+	Stored     time.Time
+	Expires    time.Time
+	StaleUntil time.Time
+}
+
+// KeepUntil returns when a store may drop resp.
+func (resp *CachedResponse) KeepUntil() time.Time {
+	if resp.StaleUntil.After(resp.Expires) {
+		return resp.StaleUntil
+	}
+	return resp.Expires
+}
+
+// errMalformedCachedResponse is returned by UnmarshalBinary for data it
+// did not produce.
+var errMalformedCachedResponse = errors.New("middleware: malformed cached response")
+
+// MarshalBinary encodes resp compactly for stores that keep bytes, such as
+// Redis: a version byte, varint status and times, the header and the body.
+func (resp *CachedResponse) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 0, len(resp.Body)+256)
+	data = append(data, 2)
+	data = binary.AppendUvarint(data, uint64(resp.Status))
+	data = binary.AppendVarint(data, resp.Stored.UnixNano())
+	data = binary.AppendVarint(data, resp.Expires.UnixNano())
+	data = binary.AppendVarint(data, resp.StaleUntil.UnixNano())
+	appendString := func(s string) {
+		data = binary.AppendUvarint(data, uint64(len(s)))
+		data = append(data, s...)
+	}
+	data = binary.AppendUvarint(data, uint64(len(resp.Header)))
+	for k, vs := range resp.Header {
+		appendString(k)
+		data = binary.AppendUvarint(data, uint64(len(vs)))
+		for _, v := range vs {
+			appendString(v)
+		}
+	}
+	return append(data, resp.Body...), nil
+}
+
+// UnmarshalBinary decodes data produced by MarshalBinary, including the
+// version 1 encoding without StaleUntil.
+func (resp *CachedResponse) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 || data[0] < 1 || data[0] > 2 {
+		return errMalformedCachedResponse
+	}
+	version := data[0]
+	data = data[1:]
+	bad := false
+	uvarint := func() uint64 {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			bad = true
+			return 0
+		}
+		data = data[n:]
+		return v
+	}
+	varint := func() int64 {
+		v, n := binary.Varint(data)
+		if n <= 0 {
+			bad = true
+			return 0
+		}
+		data = data[n:]
+		return v
+	}
+	str := func() string {
+		n := uvarint()
+		if bad || n > uint64(len(data)) {
+			bad = true
+			return ""
+		}
+		s := string(data[:n])
+		data = data[n:]
+		return s
+	}
+	status := uvarint()
+	stored, expires := varint(), varint()
+	staleUntil := time.Time{}
+	if version >= 2 {
+		staleUntil = time.Unix(0, varint())
+	}
+	count := uvarint()
+	if bad || count > uint64(len(data)) {
+		return errMalformedCachedResponse
+	}
+	header := make(http.Header, count)
+	for range count {
+		k := str()
+		n := uvarint()
+		if bad || n > uint64(len(data)) {
+			return errMalformedCachedResponse
+		}
+		vs := make([]string, n)
+		for i := range vs {
+			vs[i] = str()
+		}
+		header[k] = vs
+	}
+	if bad {
+		return errMalformedCachedResponse
+	}
+	*resp = CachedResponse{
+		Status:     int(status),
+		Header:     header,
+		Body:       slices.Clone(data),
+		Stored:     time.Unix(0, stored),
+		Expires:    time.Unix(0, expires),
+		StaleUntil: staleUntil,
+	}
+	return nil
+}
+
+// CacheStore keeps responses for Cache. Get returns nil without error on a
+// miss or for an entry past its KeepUntil time. Lock claims the right to
+// fill key for ttl on behalf of the holder of token and reports whether it
+// was granted, so that when a popular entry expires one request regenerates
+// it while the others wait; Unlock releases the claim early if token still
+// holds it, so a holder whose claim expired can't release someone else's.
+// Shared implementations (see package redisstore) let replicas serve each
+// other's responses.
+type CacheStore interface {
+	Get(ctx context.Context, key string) (*CachedResponse, error)
+	Set(ctx context.Context, key string, resp *CachedResponse) error
+	Lock(ctx context.Context, key, token string, ttl time.Duration) (bool, error)
+	Unlock(ctx context.Context, key, token string) error
+}
+
+// CacheOptions configures the Cache middleware.
+type CacheOptions struct {
+	// TTL is how long responses are kept when they carry no max-age or
+	// s-maxage of their own; defaults to 1 minute.
+	TTL time.Duration
+	// StaleWhileRevalidate is how long after expiring a response is still
+	// served, while a single background request refreshes it; responses
+	// may set their own with a stale-while-revalidate directive. When the
+	// refresh fails the stale response keeps being served until the window
+	// closes. Defaults to 0, i.e. expired responses are regenerated in the
+	// foreground.
+	StaleWhileRevalidate time.Duration
+	// VaryHeaders are request headers whose values are part of the cache
+	// key, e.g. Accept or Accept-Language. They must cover every header
+	// the cached responses vary on.
+	VaryHeaders []string
+	// BypassHeaders are request headers whose presence skips the cache in
+	// both directions; defaults to Authorization and Cookie, so
+	// personalised responses are never shared.
+	BypassHeaders []string
+	// Store keeps the responses; defaults to
+	// NewMemoryCacheStore(MaxEntries, MaxBytes).
+	Store CacheStore
+	// MaxEntries bounds the number of responses in the default store;
+	// defaults to 1000.
+	MaxEntries int
+	// MaxBytes bounds the total size of bodies and headers in the default
+	// store; defaults to 64 MiB.
+	MaxBytes int64
+	// MaxEntrySize is the largest response that is cached; defaults to
+	// 1 MiB. Larger responses are streamed through untouched.
+	MaxEntrySize int64
+	// FillTimeout is how long a request regenerating a missing entry holds
+	// off others asking for the same key. They poll the store for up to
+	// this long and then run the handler themselves; defaults to 5s.
+	FillTimeout time.Duration
+	// Logger receives store error records; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Cache serves repeated GET and HEAD requests from a CacheStore. Responses
+// with status 200, 301, 404 or 410 are stored unless they set a cookie,
+// carry Cache-Control no-store, no-cache or private, vary on "*" or on a
+// request header outside VaryHeaders, or are streamed, i.e. flushed by the handler. Requests sending Cache-Control
+// no-cache are passed on and refresh the entry; no-store skips the cache
+// entirely. Each response carries X-Cache: HIT, STALE, MISS or BYPASS, and
+// hits and stale responses an Age header. Store failures are logged and
+// treated as misses.
+//
+// Only headers set by handlers inside Cache are stored, so it belongs after
+// per-request middleware such as RequestID, and after Compress, so the
+// cached body is the uncompressed one.
+func Cache(opts CacheOptions) Middleware {
+	ttl := opts.TTL
+	if ttl <= 0 {
+		ttl = time.Minute
+	}
+	bypass := opts.BypassHeaders
+	if bypass == nil {
+		bypass = []string{"Authorization", "Cookie"}
+	}
+	maxEntrySize := opts.MaxEntrySize
+	if maxEntrySize <= 0 {
+		maxEntrySize = 1 << 20
+	}
+	fillTimeout := opts.FillTimeout
+	if fillTimeout <= 0 {
+		fillTimeout = 5 * time.Second
+	}
+	store := opts.Store
+	if store == nil {
+		store = NewMemoryCacheStore(opts.MaxEntries, opts.MaxBytes)
+	}
+
+	// revalidate regenerates key in the background for a request that was
+	// served a stale response, then releases the fill lock.
+	revalidate := func(next http.Handler, r *http.Request, key, token string) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), fillTimeout)
+		defer cancel()
+		defer store.Unlock(ctx, key, token)
+		defer func() {
+			if p := recover(); p != nil {
+				requestLogger(opts.Logger, r).LogAttrs(ctx, slog.LevelError, "cache revalidation panicked",
+					slog.Any("panic", p),
+					slog.String("path", r.URL.Path),
+				)
+			}
+		}()
+		r = r.Clone(ctx)
+		r.Method = http.MethodGet
+		cw := &cacheWriter{
+			ResponseWriter: &discardWriter{header: make(http.Header)},
+			before:         make(http.Header),
+			vary:           opts.VaryHeaders,
+			limit:          maxEntrySize,
+		}
+		next.ServeHTTP(cw, r)
+		// A failed refresh leaves the stale copy in place.
+		if resp, ok := cw.response(ttl, opts.StaleWhileRevalidate); ok {
+			if err := store.Set(ctx, key, resp); err != nil {
+				requestLogger(opts.Logger, r).LogAttrs(ctx, slog.LevelError, "cache store failed",
+					slog.String("op", "set"),
+					slog.String("error", err.Error()),
+				)
+			}
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead || isUpgrade(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			reqCC := parseCacheControl(r.Header.Get("Cache-Control"))
+			_, noStore := reqCC["no-store"]
+			for _, h := range bypass {
+				noStore = noStore || r.Header.Get(h) != ""
+			}
+			if noStore {
+				w.Header().Set("X-Cache", "BYPASS")
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := r.Context()
+			storeFailed := func(op string, err error) {
+				requestLogger(opts.Logger, r).LogAttrs(ctx, slog.LevelError, "cache store failed",
+					slog.String("op", op),
+					slog.String("error", err.Error()),
+				)
+			}
+			key := cacheKey(r, opts.VaryHeaders)
+			token := NewUUID()
+			pass := false
+			release := func() {}
+			if _, refresh := reqCC["no-cache"]; !refresh && r.Header.Get("Pragma") != "no-cache" {
+				resp, err := store.Get(ctx, key)
+				if err != nil {
+					storeFailed("get", err)
+				}
+				if resp == nil && err == nil {
+					locked, err := store.Lock(ctx, key, token, fillTimeout)
+					if err != nil {
+						storeFailed("lock", err)
+					} else if !locked {
+						resp, locked = waitForFill(ctx, store, key, token, fillTimeout)
+					}
+					if locked {
+						release = sync.OnceFunc(func() { store.Unlock(context.WithoutCancel(ctx), key, token) })
+						defer release()
+					}
+				}
+				switch {
+				case resp == nil:
+				case resp.Status == 0:
+					pass = true
+				case time.Now().After(resp.Expires):
+					// Whoever gets the lock refreshes; everyone is served
+					// the stale copy meanwhile.
+					if locked, err := store.Lock(ctx, key, token, fillTimeout); err != nil {
+						storeFailed("lock", err)
+					} else if locked {
+						go revalidate(next, r, key, token)
+					}
+					serveCached(w, r, resp, "STALE")
+					return
+				default:
+					serveCached(w, r, resp, "HIT")
+					return
+				}
+			}
+
+			// A HEAD response has no body to replay to later GETs.
+			save := func(resp *CachedResponse) {
+				if r.Method != http.MethodGet {
+					return
+				}
+				if err := store.Set(context.WithoutCancel(ctx), key, resp); err != nil {
+					storeFailed("set", err)
+				}
+			}
+			// Remember the key as uncacheable so that requests for it stop
+			// queueing on the fill lock.
+			markUncacheable := func() {
+				if !pass {
+					now := time.Now()
+					save(&CachedResponse{Stored: now, Expires: now.Add(ttl)})
+				}
+			}
+			w.Header().Set("X-Cache", "MISS")
+			cw := &cacheWriter{
+				ResponseWriter: w,
+				before:         w.Header().Clone(),
+				vary:           opts.VaryHeaders,
+				limit:          maxEntrySize,
+				// Streams are never stored, and requests waiting for this
+				// one had better not wait for the end of it.
+				onFlush: func() {
+					markUncacheable()
+					release()
+				},
+			}
+			next.ServeHTTP(cw, r)
+			if resp, ok := cw.response(ttl, opts.StaleWhileRevalidate); ok {
+				save(resp)
+			} else if !cw.streamed {
+				markUncacheable()
+			}
+		})
+	}
+}
+
+// waitForFill polls store until another request has filled key, or has
+// given up on it so that the lock could be taken over, for up to timeout.
+func waitForFill(ctx context.Context, store CacheStore, key, token string, timeout time.Duration) (*CachedResponse, bool) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-ticker.C:
+		}
+		resp, err := store.Get(ctx, key)
+		if resp != nil || err != nil {
+			return resp, false
+		}
+		if locked, err := store.Lock(ctx, key, token, timeout); locked || err != nil {
+			return nil, locked
+		}
+	}
+}
+
+// cacheKey identifies a response by method, host, request target and the
+// values of the vary headers. HEAD shares the GET entry.
+func cacheKey(r *http.Request, vary []string) string {
+	var b strings.Builder
+	b.WriteString("GET ")
+	b.WriteString(r.Host)
+	b.WriteString(r.URL.RequestURI())
+	for _, h := range vary {
+		b.WriteByte('\n')
+		b.WriteString(strings.Join(r.Header.Values(h), ","))
+	}
+	return b.String()
+}
+
+// parseCacheControl splits a Cache-Control header into lower-cased
+// directives and their (unquoted) values.
+func parseCacheControl(v string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(v, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name == "" {
+			continue
+		}
+		directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+	}
+	return directives
+}
+
+// cachedSize approximates the memory held by resp.
+func cachedSize(resp *CachedResponse) int64 {
+	n := int64(len(resp.Body))
+	for k, vs := range resp.Header {
+		n += int64(len(k))
+		for _, v := range vs {
+			n += int64(len(v))
+		}
+	}
+	return n
+}
+
+func serveCached(w http.ResponseWriter, r *http.Request, resp *CachedResponse, state string) {
+	w.Header().Set("X-Cache", state)
+	w.Header().Set("Age", strconv.Itoa(int(max(0, time.Since(resp.Stored).Seconds()))))
+	writeRecorded(w, r, resp)
+}
+
+// writeRecorded replays the status, headers and body of resp on w.
+func writeRecorded(w http.ResponseWriter, r *http.Request, resp *CachedResponse) {
+	h := w.Header()
+	for k, v := range resp.Header {
+		h[k] = slices.Clone(v)
+	}
+	h.Set("Content-Length", strconv.Itoa(len(resp.Body)))
+	w.WriteHeader(resp.Status)
+	if r.Method != http.MethodHead {
+		w.Write(resp.Body)
+	}
+}
+
+// cacheWriter passes the response through while keeping a copy of it.
+type cacheWriter struct {
+	http.ResponseWriter
+	before http.Header
+	// vary are the request headers the cache key covers.
+	vary  []string
+	limit int64
+
+	// onFlush, if set, runs after the first flush.
+	onFlush func()
+
+	status   int
+	header   http.Header
+	body     []byte
+	overflow bool
+	streamed bool
+}
+
+func (cw *cacheWriter) WriteHeader(code int) {
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if cw.status != 0 {
+		return
+	}
+	cw.status = code
+	// Keep only what the inner handlers added or changed.
+	cw.header = make(http.Header)
+	for k, v := range cw.ResponseWriter.Header() {
+		if k != "X-Cache" && !slices.Equal(v, cw.before[k]) {
+			cw.header[k] = slices.Clone(v)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *cacheWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.overflow {
+		if int64(len(cw.body)+len(p)) > cw.limit {
+			cw.overflow, cw.body = true, nil
+		} else {
+			cw.body = append(cw.body, p...)
+		}
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+func (cw *cacheWriter) Flush() {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+	if !cw.streamed {
+		cw.streamed = true
+		if cw.onFlush != nil {
+			cw.onFlush()
+		}
+	}
+}
+
+func (cw *cacheWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	cw.overflow = true
+	return http.NewResponseController(cw.ResponseWriter).Hijack()
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController.
+func (cw *cacheWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// keyedVary reports whether the cache key covers every header the inner
+// handlers made the response vary on, e.g. not the Accept-Encoding of a
+// precompressed file unless it is one of VaryHeaders. Values added further
+// out, like the Accept-Encoding of Compress, don't count, as the stored
+// response is the same for every value of them, but the same header added
+// again inside does.
+func (cw *cacheWriter) keyedVary() bool {
+	added := cw.ResponseWriter.Header().Values("Vary")
+	if before := cw.before.Values("Vary"); len(added) >= len(before) && slices.Equal(added[:len(before)], before) {
+		added = added[len(before):]
+	}
+	for _, name := range varyNames(added) {
+		if !slices.ContainsFunc(cw.vary, func(h string) bool { return strings.EqualFold(h, name) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// varyNames returns the canonical header names listed by Vary values.
+func varyNames(values []string) []string {
+	var names []string
+	for _, v := range values {
+		for name := range strings.SplitSeq(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
+
+// response returns the recorded response if it may be stored, fresh for
+// ttl and servable stale for swr after that unless it says otherwise.
+func (cw *cacheWriter) response(ttl, swr time.Duration) (*CachedResponse, bool) {
+	switch cw.status {
+	case http.StatusOK, http.StatusMovedPermanently, http.StatusNotFound, http.StatusGone:
+	default:
+		return nil, false
+	}
+	h := cw.ResponseWriter.Header()
+	if cw.overflow || cw.streamed || h.Get("Set-Cookie") != "" || slices.Contains(h.Values("Vary"), "*") || !cw.keyedVary() {
+		return nil, false
+	}
+	cc := parseCacheControl(h.Get("Cache-Control"))
+	for _, d := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := cc[d]; ok {
+			return nil, false
+		}
+	}
+	for _, d := range []string{"s-maxage", "max-age"} {
+		if v, ok := cc[d]; ok {
+			secs, err := strconv.Atoi(v)
+			if err != nil || secs <= 0 {
+				return nil, false
+			}
+			ttl = time.Duration(secs) * time.Second
+			break
+		}
+	}
+	if v, ok := cc["stale-while-revalidate"]; ok {
+		if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+			swr = time.Duration(secs) * time.Second
+		}
+	}
+	// The hit path sets its own length; a handler's may be stale once
+	// the body is replayed through outer middleware.
+	cw.header.Del("Content-Length")
+	now := time.Now()
+	return &CachedResponse{
+		Status:     cw.status,
+		Header:     cw.header,
+		Body:       cw.body,
+		Stored:     now,
+		Expires:    now.Add(ttl),
+		StaleUntil: now.Add(ttl + swr),
+	}, true
+}
+
+// discardWriter is the ResponseWriter of background revalidations, whose
+// response only goes to the store.
+type discardWriter struct {
+	header http.Header
+}
+
+func (dw *discardWriter) Header() http.Header         { return dw.header }
+func (dw *discardWriter) WriteHeader(int)             {}
+func (dw *discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+
+// MemoryCacheStore is a CacheStore for a single instance: an LRU bounded
+// by entry count and size.
+type MemoryCacheStore struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
+	lru        *list.List
+	entries    map[string]*list.Element
+	locks      map[string]memoryCacheLock
+}
+
+type memoryCacheLock struct {
+	token string
+	until time.Time
+}
+
+type memoryCacheItem struct {
+	key  string
+	resp *CachedResponse
+}
+
+var _ CacheStore = (*MemoryCacheStore)(nil)
+
+// NewMemoryCacheStore returns an empty store holding at most maxEntries
+// responses (default 1000) totalling maxBytes (default 64 MiB).
+func NewMemoryCacheStore(maxEntries int, maxBytes int64) *MemoryCacheStore {
+	if maxEntries <= 0 {
+		maxEntries = 1000
+	}
+	if maxBytes <= 0 {
+		maxBytes = 64 << 20
+	}
+	return &MemoryCacheStore{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		lru:        list.New(),
+		entries:    make(map[string]*list.Element),
+		locks:      make(map[string]memoryCacheLock),
+	}
+}
+
+func (s *MemoryCacheStore) Get(_ context.Context, key string) (*CachedResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	item := el.Value.(*memoryCacheItem)
+	if time.Now().After(item.resp.KeepUntil()) {
+		s.remove(el)
+		return nil, nil
+	}
+	s.lru.MoveToFront(el)
+	return item.resp, nil
+}
+
+func (s *MemoryCacheStore) Set(_ context.Context, key string, resp *CachedResponse) error {
+	size := cachedSize(resp)
+	if size > s.maxBytes {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[key]; ok {
+		s.remove(el)
+	}
+	for s.lru.Len() >= s.maxEntries || s.bytes+size > s.maxBytes {
+		s.remove(s.lru.Back())
+	}
+	s.entries[key] = s.lru.PushFront(&memoryCacheItem{key: key, resp: resp})
+	s.bytes += size
+	return nil
+}
+
+func (s *MemoryCacheStore) Lock(_ context.Context, key, token string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l, ok := s.locks[key]; ok && now.Before(l.until) {
+		return false, nil
+	}
+	for k, l := range s.locks {
+		if now.After(l.until) {
+			delete(s.locks, k)
+		}
+	}
+	s.locks[key] = memoryCacheLock{token: token, until: now.Add(ttl)}
+	return true, nil
+}
+
+func (s *MemoryCacheStore) Unlock(_ context.Context, key, token string) error {
+	s.mu.Lock()
+	if s.locks[key].token == token {
+		delete(s.locks, key)
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemoryCacheStore) remove(el *list.Element) {
+	item := s.lru.Remove(el).(*memoryCacheItem)
+	delete(s.entries, item.key)
+	s.bytes -= cachedSize(item.resp)
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+// TestCachePrecompressedVariants checks that the brotli variant Static
+// serves to one client isn't replayed to clients that can't decode it, with
+// or without Accept-Encoding among the vary headers.
+func TestCachePrecompressedVariants(t *testing.T) {
+	files := fstest.MapFS{
+		"app.3f9a2c1d.js":    {Data: []byte("console.log('plain')")},
+		"app.3f9a2c1d.js.br": {Data: []byte("brotli bytes")},
+	}
+	for _, vary := range [][]string{nil, {"Accept-Encoding"}} {
+		h := Compress(CompressOptions{})(Cache(CacheOptions{VaryHeaders: vary})(Static(files, StaticOptions{})))
+		get := func(acceptEncoding string) *httptest.ResponseRecorder {
+			r := httptest.NewRequest(http.MethodGet, "/app.3f9a2c1d.js", nil)
+			r.Header.Set("Accept-Encoding", acceptEncoding)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			return w
+		}
+		if w := get("br"); w.Header().Get("Content-Encoding") != "br" {
+			t.Fatalf("vary %v: brotli client got Content-Encoding %q", vary, w.Header().Get("Content-Encoding"))
+		}
+		w := get("identity")
+		if enc := w.Header().Get("Content-Encoding"); enc != "" {
+			t.Fatalf("vary %v: identity client got Content-Encoding %q, X-Cache %s", vary, enc, w.Header().Get("X-Cache"))
+		}
+		if body := w.Body.String(); body != "console.log('plain')" {
+			t.Fatalf("vary %v: identity client got %q", vary, body)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CachePolicy sets the caching headers of the responses it matches. Its
+// conditions combine; a policy without any matches every response with a
+// status below 400.
+type CachePolicy struct {
+	// Match selects requests, e.g. PathPrefix("/static/").
+	Match Matcher
+	// Authenticated restricts the policy to callers identified by an
+	// authentication middleware anywhere in the chain.
+	Authenticated bool
+	// ContentTypes restricts the policy to responses of these media types;
+	// "image/*" matches any subtype.
+	ContentTypes []string
+	// Statuses restricts the policy to these response codes; defaults to
+	// any below 400, so errors aren't cached for as long as content.
+	Statuses []int
+
+	// CacheControl is the Cache-Control value, e.g. "no-store" or
+	// "public, max-age=31536000, immutable". An Expires header is derived
+	// from its max-age for HTTP/1.0 caches.
+	CacheControl string
+	// SurrogateControl is sent to CDNs that honour Surrogate-Control, e.g.
+	// "max-age=86400", letting the edge keep content longer than browsers.
+	SurrogateControl string
+	// Override replaces caching headers the handler set itself. By default
+	// they are left alone, so a handler can still opt out of its policy.
+	Override bool
+}
+
+// CacheControl applies the first matching policy to each response just
+// before its header is written, e.g.
+//
+//	middleware.CacheControl(
+//		middleware.CachePolicy{Authenticated: true, CacheControl: "private, no-store"},
+//		middleware.CachePolicy{Match: middleware.PathPrefix("/static/"), CacheControl: "public, max-age=31536000, immutable"},
+//		middleware.CachePolicy{ContentTypes: []string{"application/json"}, CacheControl: "no-cache"},
+//	)
+//
+// Responses matching no policy are left as the handler wrote them.
+func CacheControl(policies ...CachePolicy) Middleware {
+	type compiled struct {
+		CachePolicy
+		maxAge int
+	}
+	ps := make([]compiled, len(policies))
+	needIdentity := false
+	for i, p := range policies {
+		p.ContentTypes = slices.Clone(p.ContentTypes)
+		for j, t := range p.ContentTypes {
+			p.ContentTypes[j] = strings.ToLower(t)
+		}
+		ps[i] = compiled{CachePolicy: p, maxAge: -1}
+		if v, ok := parseCacheControl(p.CacheControl)["max-age"]; ok {
+			if n, err := strconv.Atoi(v); err == nil {
+				ps[i].maxAge = n
+			}
+		}
+		needIdentity = needIdentity || p.Authenticated
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Only request conditions can be checked up front.
+			var candidates []*compiled
+			for i := range ps {
+				if ps[i].Match == nil || ps[i].Match(r) {
+					candidates = append(candidates, &ps[i])
+				}
+			}
+			if len(candidates) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			var slot *identitySlot
+			if needIdentity {
+				var ctx context.Context
+				ctx, slot = captureIdentity(r.Context())
+				r = r.WithContext(ctx)
+			}
+			rec, owned := acquireResponseRecorder(w)
+			if owned {
+				defer releaseResponseRecorder(rec)
+			}
+			rec.BeforeWriteHeader(func(code int) {
+				h := rec.Header()
+				mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+				for _, p := range candidates {
+					switch {
+					case p.Authenticated && slot.id == nil,
+						len(p.ContentTypes) > 0 && !mediaTypeIn(p.ContentTypes, mediaType),
+						len(p.Statuses) > 0 && !slices.Contains(p.Statuses, code),
+						len(p.Statuses) == 0 && code >= 400:
+						continue
+					}
+					if h.Get("Cache-Control") != "" && !p.Override {
+						return
+					}
+					if p.CacheControl != "" {
+						h.Set("Cache-Control", p.CacheControl)
+						if p.maxAge >= 0 {
+							h.Set("Expires", time.Now().Add(time.Duration(p.maxAge)*time.Second).UTC().Format(http.TimeFormat))
+						} else {
+							h.Del("Expires")
+						}
+					}
+					if p.SurrogateControl != "" {
+						h.Set("Surrogate-Control", p.SurrogateControl)
+					}
+					return
+				}
+			})
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import "net/http"
+
+// Compose folds mw into a single Middleware. The first middleware is the
+// outermost one, so Compose(a, b)(h) serves requests as a(b(h)).
+func Compose(mw ...Middleware) Middleware {
+	return NewChain(mw...).Middleware()
+}
+
+// Chain is an immutable, ordered stack of middlewares. Chains can be built
+// once and reused across routers and sub-routers; Append and Extend always
+// return a new Chain and never modify the receiver.
+type Chain struct {
+	middlewares []Middleware
+}
+
+// NewChain returns a Chain running mw in the given order.
+func NewChain(mw ...Middleware) Chain {
+	return Chain{middlewares: append([]Middleware(nil), mw...)}
+}
+
+// Append returns a new Chain with mw added after the existing middlewares.
+func (c Chain) Append(mw ...Middleware) Chain {
+	middlewares := make([]Middleware, 0, len(c.middlewares)+len(mw))
+	middlewares = append(middlewares, c.middlewares...)
+	middlewares = append(middlewares, mw...)
+	return Chain{middlewares: middlewares}
+}
+
+// Extend returns a new Chain with the middlewares of other added after the
+// existing ones.
+func (c Chain) Extend(other Chain) Chain {
+	return c.Append(other.middlewares...)
+}
+
+// Then wraps h with every middleware in the chain. A nil h is treated as
+// http.DefaultServeMux.
+func (c Chain) Then(h http.Handler) http.Handler {
+	if h == nil {
+		h = http.DefaultServeMux
+	}
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		h = c.middlewares[i](h)
+	}
+	return h
+}
+
+// ThenFunc is like Then but takes a handler function.
+func (c Chain) ThenFunc(fn http.HandlerFunc) http.Handler {
+	if fn == nil {
+		return c.Then(nil)
+	}
+	return c.Then(fn)
+}
+
+// Middleware returns the chain as a single Middleware, e.g. for Router.Use.
+func (c Chain) Middleware() Middleware {
+	return c.Then
+}
+
+// Len reports the number of middlewares in the chain.
+func (c Chain) Len() int {
+	return len(c.middlewares)
+}
+
+// Wrap attaches mw to a single handler, leaving the rest of the router
+// untouched. It is the per-route counterpart of Router.Use:
+//
+//	router.Handle("/admin", middleware.Wrap(adminHandler, middleware.Authentication(token)))
+func Wrap(h http.Handler, mw ...Middleware) http.Handler {
+	return NewChain(mw...).Then(h)
+}
+
+// WrapFunc is like Wrap but takes a handler function.
+func WrapFunc(fn http.HandlerFunc, mw ...Middleware) http.Handler {
+	return NewChain(mw...).ThenFunc(fn)
+}
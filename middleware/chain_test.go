@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// benchmarkLayer is a middleware configured as a production stack would,
+// with logs and metrics discarded.
+type benchmarkLayer struct {
+	name string
+	mw   Middleware
+}
+
+// benchmarkStack returns the global stack of cmd/server, outermost first,
+// with limits high enough never to reject the benchmark's requests.
+func benchmarkStack(b *testing.B) []benchmarkLayer {
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	waf, err := NewWAF(DefaultWAFRules)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return []benchmarkLayer{
+		{"RequestID", RequestID(RequestIDOptions{})},
+		{"Recovery", Recovery(RecoveryOptions{JSON: true, Logger: logger})},
+		{"BotFilter", BotFilter(BotFilterOptions{
+			Actions: map[BotClass]BotAction{BotEmpty: BotBlock, BotScripted: BotRateLimit},
+			Logger:  logger,
+		})},
+		{"WAF", waf.Middleware(WAFOptions{Logger: logger})},
+		{"Tracing", Tracing(TracingOptions{})},
+		{"Metrics", Metrics(MetricsOptions{Sink: NopSink{}})},
+		{"Audit", Audit(AuditOptions{Sink: NewWriterAuditSink(io.Discard), Logger: logger})},
+		{"RateLimit", RateLimit(RateLimitOptions{Rate: 1e9, Burst: 1 << 30, Logger: logger})},
+		{"LoadShed", NewLoadShedder(LoadShedOptions{MaxInFlight: 80, Logger: logger}).Middleware()},
+		{"ConcurrencyLimit", ConcurrencyLimit(ConcurrencyLimitOptions{Max: 100, Queue: 50, QueueTimeout: 3 * time.Second, PerRoute: true})},
+		{"WithConfig", WithConfig(&Config{App: "benchmark"})},
+		{"Logging", Logging(LoggingOptions{Logger: logger})},
+		{"Timing", Timing(TimingOptions{Logger: logger, ServerTiming: true})},
+		{"MaxBody", MaxBody(1 << 20)},
+		{"Compress", Compress(CompressOptions{})},
+		{"ETag", ETag(ETagOptions{})},
+		{"Timeout", Timeout(5 * time.Second)},
+		{"Cache", Cache(CacheOptions{TTL: 30 * time.Second, StaleWhileRevalidate: time.Minute, Logger: logger})},
+		{"Coalesce", Coalesce(CoalesceOptions{})},
+		{"CacheControl", CacheControl(
+			CachePolicy{Authenticated: true, CacheControl: "private, no-store"},
+			CachePolicy{Match: Methods("GET", "HEAD"), CacheControl: "public, max-age=30"},
+		)},
+		{"SecureHeaders", SecureHeaders(SecureHeadersOptions{ContentSecurityPolicy: "default-src 'none'"})},
+		{"RESTHeaders", RESTHeaders()},
+	}
+}
+
+// compose stacks layers, skipping the one named skip.
+func compose(layers []benchmarkLayer, skip string) Middleware {
+	var mw []Middleware
+	for _, l := range layers {
+		if l.name != skip {
+			mw = append(mw, l.mw)
+		}
+	}
+	return Compose(mw...)
+}
+
+// BenchmarkMiddleware reports the cost of each middleware on its own,
+// next to the bare handler under "none". Cache is measured serving hits,
+// since the benchmark repeats one request.
+func BenchmarkMiddleware(b *testing.B) {
+	b.Run("none", func(b *testing.B) {
+		benchmarkServe(b, benchmarkHandler)
+	})
+	for _, l := range benchmarkStack(b) {
+		b.Run(l.name, func(b *testing.B) {
+			benchmarkServe(b, l.mw(benchmarkHandler))
+		})
+	}
+}
+
+// BenchmarkChain reports the cost of the whole stack, both for requests
+// reaching the handler ("full", which asks Cache to refresh) and for cache
+// hits, and of the stack without each middleware in turn; the difference
+// to "full" is what that middleware adds in context.
+func BenchmarkChain(b *testing.B) {
+	layers := benchmarkStack(b)
+	refresh := func() *http.Request {
+		r := benchmarkRequest()
+		r.Header.Set("Cache-Control", "no-cache")
+		return r
+	}
+	b.Run("full", func(b *testing.B) {
+		benchmarkServeRequest(b, compose(layers, "")(benchmarkHandler), refresh())
+	})
+	b.Run("hit", func(b *testing.B) {
+		benchmarkServe(b, compose(layers, "")(benchmarkHandler))
+	})
+	for _, l := range layers {
+		b.Run("without/"+l.name, func(b *testing.B) {
+			benchmarkServeRequest(b, compose(layers, l.name)(benchmarkHandler), refresh())
+		})
+	}
+}
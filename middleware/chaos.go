@@ -0,0 +1,189 @@
+package middleware
+
+import (
+	"cmp"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// LatencyQuantile is a point of a latency distribution: a fraction
+// Quantile of the injected delays are at most Delay.
+type LatencyQuantile struct {
+	Quantile float64
+	Delay    time.Duration
+}
+
+// ChaosLatencyOptions configures a ChaosLatency.
+type ChaosLatencyOptions struct {
+	// Rate is the fraction of requests delayed, from 0 to 1.
+	Rate float64
+	// Delay is added to every delayed request.
+	Delay time.Duration
+	// Jitter adds a further random delay of up to Jitter.
+	Jitter time.Duration
+	// Quantiles, if set, shape the delays like a measured latency
+	// distribution instead of Delay and Jitter, e.g. p50 of 20ms and p99
+	// of 800ms. Delays are interpolated between the points, starting from
+	// zero, and capped at the last one.
+	Quantiles []LatencyQuantile
+	// Match limits injection to matching requests; defaults to all.
+	Match Matcher
+	// Enabled starts injecting right away; otherwise it waits for Enable.
+	Enabled bool
+	// Logger receives a debug record for every delayed request; defaults
+	// to slog.Default().
+	Logger *slog.Logger
+}
+
+// ChaosLatency delays a sample of requests before passing them on, to
+// exercise the timeouts and retries of clients and of middleware further
+// out. The delay is reported in the Server-Timing header as "chaos" when
+// Timing adds one, and cut short if the client goes away. It can be
+// switched on and off while serving.
+type ChaosLatency struct {
+	runtimeSwitch
+	opts ChaosLatencyOptions
+}
+
+// NewChaosLatency returns a latency injector.
+func NewChaosLatency(opts ChaosLatencyOptions) *ChaosLatency {
+	if opts.Match == nil {
+		opts.Match = func(*http.Request) bool { return true }
+	}
+	opts.Quantiles = slices.Clone(opts.Quantiles)
+	slices.SortFunc(opts.Quantiles, func(a, b LatencyQuantile) int {
+		return cmp.Compare(a.Quantile, b.Quantile)
+	})
+	c := &ChaosLatency{opts: opts}
+	c.enabled.Store(opts.Enabled)
+	return c
+}
+
+// Middleware returns the middleware applying c.
+func (c *ChaosLatency) Middleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !c.Enabled() || !sampled(c.opts.Rate) || !c.opts.Match(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			d := c.delay()
+			requestLogger(c.opts.Logger, r).LogAttrs(r.Context(), slog.LevelDebug, "chaos latency injected",
+				slog.String("path", r.URL.Path),
+				slog.Duration("delay", d),
+			)
+			timer := time.NewTimer(d)
+			select {
+			case <-timer.C:
+			case <-r.Context().Done():
+				timer.Stop()
+				return
+			}
+			AddServerTiming(r.Context(), "chaos", "", d)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// delay draws the delay of one request.
+func (c *ChaosLatency) delay() time.Duration {
+	if q := c.opts.Quantiles; len(q) > 0 {
+		u := rand.Float64()
+		lo := LatencyQuantile{}
+		for _, hi := range q {
+			if u <= hi.Quantile {
+				if hi.Quantile == lo.Quantile {
+					return hi.Delay
+				}
+				f := (u - lo.Quantile) / (hi.Quantile - lo.Quantile)
+				return lo.Delay + time.Duration(f*float64(hi.Delay-lo.Delay))
+			}
+			lo = hi
+		}
+		return lo.Delay
+	}
+	d := c.opts.Delay
+	if c.opts.Jitter > 0 {
+		d += rand.N(c.opts.Jitter)
+	}
+	return d
+}
+
+// ChaosFaultOptions configures a ChaosFault.
+type ChaosFaultOptions struct {
+	// Rate is the fraction of requests failed, from 0 to 1.
+	Rate float64
+	// Statuses are the error statuses failed requests get, picked at
+	// random; defaults to 500, 502 and 503.
+	Statuses []int
+	// Abort is the fraction of failed requests, from 0 to 1, whose
+	// connection is dropped without any response instead, as if the server
+	// had crashed.
+	Abort float64
+	// Match limits injection to matching requests, e.g. a Route or Header;
+	// defaults to all.
+	Match Matcher
+	// Enabled starts injecting right away; otherwise it waits for Enable.
+	Enabled bool
+	// Logger receives a debug record for every failed request; defaults to
+	// slog.Default().
+	Logger *slog.Logger
+}
+
+// ChaosFault fails a sample of requests without running the handler, with
+// an error status or by aborting the connection, to check that clients
+// retry or degrade gracefully and that alerts fire. It is the companion of
+// ChaosLatency and is switched on and off the same way.
+type ChaosFault struct {
+	runtimeSwitch
+	opts ChaosFaultOptions
+}
+
+// NewChaosFault returns a fault injector.
+func NewChaosFault(opts ChaosFaultOptions) *ChaosFault {
+	if len(opts.Statuses) == 0 {
+		opts.Statuses = []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable}
+	}
+	if opts.Match == nil {
+		opts.Match = func(*http.Request) bool { return true }
+	}
+	c := &ChaosFault{opts: opts}
+	c.enabled.Store(opts.Enabled)
+	return c
+}
+
+// Middleware returns the middleware applying c.
+func (c *ChaosFault) Middleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !c.Enabled() || !sampled(c.opts.Rate) || !c.opts.Match(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			logger := requestLogger(c.opts.Logger, r)
+			if sampled(c.opts.Abort) {
+				logger.LogAttrs(r.Context(), slog.LevelDebug, "chaos fault injected",
+					slog.String("path", r.URL.Path),
+					slog.Bool("abort", true),
+				)
+				// The server drops the connection without logging a panic,
+				// and Recovery lets it through.
+				panic(http.ErrAbortHandler)
+			}
+			status := c.opts.Statuses[rand.IntN(len(c.opts.Statuses))]
+			logger.LogAttrs(r.Context(), slog.LevelDebug, "chaos fault injected",
+				slog.String("path", r.URL.Path),
+				slog.Int("status", status),
+			)
+			http.Error(w, http.StatusText(status), status)
+		})
+	}
+}
+
+// sampled reports whether a request falls into a sample of rate.
+func sampled(rate float64) bool {
+	return rate >= 1 || rate > 0 && rand.Float64() < rate
+}
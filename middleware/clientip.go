@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ClientIP returns the IP address of the peer that sent r, taken from
+// RemoteAddr. Headers such as X-Forwarded-For are ignored since any client
+// can set them; use ClientIPFromProxies behind a reverse proxy.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ClientIPFromProxies returns a function resolving the effective client IP
+// behind trusted reverse proxies. The X-Forwarded-For chain (or header, if
+// set, e.g. "X-Real-IP") is walked from the right, skipping addresses inside
+// trusted, so a client can't spoof its address by prepending entries. It
+// falls back to ClientIP when the peer itself is not trusted. It panics on
+// an invalid CIDR.
+func ClientIPFromProxies(trusted []string, header string) func(r *http.Request) string {
+	prefixes := mustParsePrefixes(trusted)
+	if header == "" {
+		header = "X-Forwarded-For"
+	}
+	isTrusted := func(s string) bool {
+		addr, err := netip.ParseAddr(strings.TrimSpace(s))
+		if err != nil {
+			return false
+		}
+		return prefixesContain(prefixes, addr.Unmap())
+	}
+	return func(r *http.Request) string {
+		peer := ClientIP(r)
+		if !isTrusted(peer) {
+			return peer
+		}
+		values := r.Header.Values(header)
+		for i := len(values) - 1; i >= 0; i-- {
+			parts := strings.Split(values[i], ",")
+			for j := len(parts) - 1; j >= 0; j-- {
+				ip := strings.TrimSpace(parts[j])
+				if ip == "" {
+					continue
+				}
+				if !isTrusted(ip) {
+					return ip
+				}
+			}
+		}
+		return peer
+	}
+}
+
+// parsePrefix accepts a CIDR or a bare IP address (treated as a single-host
+// prefix).
+func parsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func parsePrefixes(list []string) ([]netip.Prefix, error) {
+	out := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		p, err := parsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+func mustParsePrefixes(list []string) []netip.Prefix {
+	out, err := parsePrefixes(list)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"net/http"
+	"sync"
+)
+
+// CoalesceOptions configures the Coalesce middleware.
+type CoalesceOptions struct {
+	// VaryHeaders are request headers whose values must also match for
+	// two requests to be considered identical, as for CacheOptions.
+	VaryHeaders []string
+	// BypassHeaders are request headers whose presence keeps a request
+	// out of coalescing; defaults to Authorization and Cookie, so callers
+	// never receive each other's personalised responses.
+	BypassHeaders []string
+	// MaxSize is the largest response shared with waiters; defaults to
+	// 1 MiB. When it is exceeded they run the handler themselves.
+	MaxSize int64
+}
+
+// Coalesce deduplicates concurrent identical GET requests: the first one
+// runs the handler while the others wait and then receive a copy of its
+// response. It suits slow endpoints hit by bursts, and complements Cache for
+// responses that may not be stored. Waiters run the handler themselves when
+// the response can't be shared: it set a cookie, was too large or streamed,
+// or the first request was cancelled or panicked before finishing.
+func Coalesce(opts CoalesceOptions) Middleware {
+	bypass := opts.BypassHeaders
+	if bypass == nil {
+		bypass = []string{"Authorization", "Cookie"}
+	}
+	maxSize := opts.MaxSize
+	if maxSize <= 0 {
+		maxSize = 1 << 20
+	}
+	type call struct {
+		done chan struct{}
+		resp *CachedResponse
+	}
+	var mu sync.Mutex
+	calls := make(map[string]*call)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet || isUpgrade(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			for _, h := range bypass {
+				if r.Header.Get(h) != "" {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			key := cacheKey(r, opts.VaryHeaders)
+			mu.Lock()
+			if c, ok := calls[key]; ok {
+				mu.Unlock()
+				select {
+				case <-c.done:
+				case <-r.Context().Done():
+					return
+				}
+				if c.resp != nil {
+					writeRecorded(w, r, c.resp)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			c := &call{done: make(chan struct{})}
+			calls[key] = c
+			mu.Unlock()
+			finish := sync.OnceFunc(func() {
+				mu.Lock()
+				delete(calls, key)
+				mu.Unlock()
+				close(c.done)
+			})
+			defer finish()
+
+			cw := &cacheWriter{
+				ResponseWriter: w,
+				before:         w.Header().Clone(),
+				limit:          maxSize,
+				// A stream can't be shared; the waiters start their own
+				// instead of waiting for it to end.
+				onFlush: finish,
+			}
+			next.ServeHTTP(cw, r)
+			if cw.status == 0 {
+				cw.WriteHeader(http.StatusOK)
+			}
+			if !cw.overflow && !cw.streamed && r.Context().Err() == nil && w.Header().Get("Set-Cookie") == "" {
+				c.resp = &CachedResponse{Status: cw.status, Header: cw.header, Body: cw.body}
+			}
+		})
+	}
+}
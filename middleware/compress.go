@@ -0,0 +1,350 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultCompressibleTypes are the media types Compress compresses unless
+// told otherwise. A trailing "*" matches any subtype suffix, so
+// "application/*+json" covers "application/problem+json".
+var DefaultCompressibleTypes = []string{
+	"text/html", "text/css", "text/plain", "text/javascript", "text/xml", "text/csv", "text/markdown",
+	"application/json", "application/javascript", "application/xml", "application/wasm",
+	"application/*+json", "application/*+xml", "image/svg+xml",
+}
+
+// CompressOptions configures the Compress middleware.
+type CompressOptions struct {
+	// Encodings are the content codings offered, from "zstd", "br" and
+	// "gzip"; defaults to all three in that order. The client's q-values
+	// decide, with ties going to the earlier entry.
+	Encodings []string
+	// GzipLevel defaults to gzip.DefaultCompression.
+	GzipLevel int
+	// BrotliLevel (1-11) defaults to 5, which suits dynamic content; the
+	// higher levels are meant for precompressed assets.
+	BrotliLevel int
+	// ZstdLevel (1-22, mapped onto the encoder's speed presets) defaults
+	// to 3.
+	ZstdLevel int
+	// MinSize is the smallest body worth compressing; defaults to 1 KiB.
+	// Smaller bodies are sent as they are, since compression would save
+	// little and may even add bytes.
+	MinSize int
+	// ContentTypes are the media types to compress; defaults to
+	// DefaultCompressibleTypes.
+	ContentTypes []string
+}
+
+// Compress compresses responses with the best encoding the client accepts
+// according to its Accept-Encoding header. The decision is made
+// once MinSize bytes were written or the handler flushes or returns, so the
+// Content-Type set by the handler (or sniffed from the first bytes) is
+// known; compressed responses drop Content-Length and get a weak ETag.
+// Responses that are already encoded, partial or have no body are never
+// compressed.
+func Compress(opts CompressOptions) Middleware {
+	encodings := opts.Encodings
+	if len(encodings) == 0 {
+		encodings = []string{"zstd", "br", "gzip"}
+	}
+	pools := make(map[string]*sync.Pool, len(encodings))
+	for _, enc := range encodings {
+		pools[enc] = newEncoderPool(enc, opts)
+	}
+	minSize := opts.MinSize
+	if minSize <= 0 {
+		minSize = 1 << 10
+	}
+	types := opts.ContentTypes
+	if types == nil {
+		types = DefaultCompressibleTypes
+	}
+	var exact, suffixes []string
+	for _, t := range types {
+		if prefix, suffix, ok := strings.Cut(t, "*"); ok {
+			suffixes = append(suffixes, prefix, suffix)
+		} else {
+			exact = append(exact, t)
+		}
+	}
+	compressible := func(contentType string) bool {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return false
+		}
+		for _, t := range exact {
+			if mediaType == t {
+				return true
+			}
+		}
+		for i := 0; i < len(suffixes); i += 2 {
+			if strings.HasPrefix(mediaType, suffixes[i]) && strings.HasSuffix(mediaType, suffixes[i+1]) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			enc := negotiateEncoding(r.Header.Get("Accept-Encoding"), encodings)
+			if enc == "" || r.Method == http.MethodHead || isUpgrade(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{
+				ResponseWriter: w,
+				minSize:        minSize,
+				compressible:   compressible,
+				encoding:       enc,
+				pool:           pools[enc],
+			}
+			next.ServeHTTP(cw, r)
+			cw.close()
+		})
+	}
+}
+
+// encoder is the part of the gzip, brotli and zstd writers Compress uses.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// newEncoderPool returns a pool of encoders for enc. It panics on an
+// unknown encoding.
+func newEncoderPool(enc string, opts CompressOptions) *sync.Pool {
+	var newEncoder func() encoder
+	switch enc {
+	case "gzip":
+		level := opts.GzipLevel
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		newEncoder = func() encoder {
+			gw, _ := gzip.NewWriterLevel(nil, level)
+			return gw
+		}
+	case "br":
+		level := opts.BrotliLevel
+		if level == 0 {
+			level = 5
+		}
+		newEncoder = func() encoder { return brotli.NewWriterLevel(nil, level) }
+	case "zstd":
+		level := opts.ZstdLevel
+		if level == 0 {
+			level = 3
+		}
+		newEncoder = func() encoder {
+			// One goroutine per encoder, and a window browsers are
+			// required to support.
+			zw, _ := zstd.NewWriter(nil,
+				zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)),
+				zstd.WithEncoderConcurrency(1),
+				zstd.WithWindowSize(1<<20),
+			)
+			return zw
+		}
+	default:
+		panic("middleware: unsupported compression encoding " + strconv.Quote(enc))
+	}
+	return &sync.Pool{New: func() any { return newEncoder() }}
+}
+
+// negotiateEncoding picks the supported content coding the client prefers
+// according to the q-values of its Accept-Encoding header, ties going to
+// the order of supported. It returns "" if none is acceptable.
+func negotiateEncoding(header string, supported []string) string {
+	if header == "" {
+		return ""
+	}
+	best, bestQ := "", 0.0
+	wildcard := -1.0
+	quality := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if name == "*" {
+			wildcard = q
+			continue
+		}
+		quality[name] = q
+	}
+	for _, enc := range supported {
+		q, ok := quality[enc]
+		if !ok {
+			q = max(wildcard, 0)
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// compressWriter buffers the start of a response until it can decide
+// whether to compress it.
+type compressWriter struct {
+	http.ResponseWriter
+	minSize      int
+	compressible func(contentType string) bool
+	encoding     string
+	pool         *sync.Pool
+
+	status  int
+	buf     []byte
+	decided bool
+	enc     encoder
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if cw.status != 0 {
+		return
+	}
+	cw.status = code
+	h := cw.Header()
+	// Nothing to gain, or nothing we may touch: decide right away.
+	if code == http.StatusNoContent || code == http.StatusNotModified || code == http.StatusPartialContent ||
+		code == http.StatusSwitchingProtocols || h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		cw.decide(false)
+		return
+	}
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < cw.minSize {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.decided {
+		if cw.enc != nil {
+			return cw.enc.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= cw.minSize {
+		if err := cw.decideFromContent(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// decideFromContent compresses if the content type qualifies, sniffing it
+// from the buffered bytes when the handler didn't set one.
+func (cw *compressWriter) decideFromContent() error {
+	h := cw.Header()
+	ct := h.Get("Content-Type")
+	if ct == "" && len(cw.buf) > 0 {
+		// Set it now: sniffing compressed bytes later would be wrong.
+		ct = http.DetectContentType(cw.buf)
+		h.Set("Content-Type", ct)
+	}
+	return cw.decide(len(cw.buf) > 0 && cw.compressible(ct))
+}
+
+// decide sends the header, compressed or not, and the buffered bytes.
+func (cw *compressWriter) decide(compress bool) error {
+	cw.decided = true
+	h := cw.Header()
+	if compress {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.encoding)
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		cw.enc = cw.pool.Get().(encoder)
+		cw.enc.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Flush sends what was buffered, compressing it if the type qualifies
+// regardless of MinSize, since streamed responses rarely reach it at once.
+func (cw *compressWriter) Flush() {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.decideFromContent()
+	}
+	if cw.enc != nil {
+		cw.enc.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	cw.decided = true
+	return http.NewResponseController(cw.ResponseWriter).Hijack()
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// close finishes the response once the handler returned.
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		if cw.status == 0 {
+			// Nothing written at all: let the server send its default 200.
+			if len(cw.buf) == 0 {
+				return
+			}
+			cw.status = http.StatusOK
+		}
+		if len(cw.buf) < cw.minSize {
+			cw.decide(false)
+		} else {
+			cw.decideFromContent()
+		}
+	}
+	if cw.enc != nil {
+		cw.enc.Close()
+		cw.enc.Reset(nil)
+		cw.pool.Put(cw.enc)
+		cw.enc = nil
+	}
+}
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// benchmarkPayload is a JSON document typical of an API listing response.
+func benchmarkPayload() []byte {
+	type item struct {
+		ID          int      `json:"id"`
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Tags        []string `json:"tags"`
+		Price       float64  `json:"price"`
+	}
+	items := make([]item, 500)
+	for i := range items {
+		items[i] = item{
+			ID:          i,
+			Name:        fmt.Sprintf("product-%d", i),
+			Description: fmt.Sprintf("Item %d of the catalogue, shipped within %d days.", i, i%7+1),
+			Tags:        []string{"catalogue", fmt.Sprintf("group-%d", i%12)},
+			Price:       float64(i%100) + 0.99,
+		}
+	}
+	data, _ := json.Marshal(items)
+	return data
+}
+
+// BenchmarkCompress reports the throughput and compression ratio of each
+// encoding at a low, the default and a high level.
+func BenchmarkCompress(b *testing.B) {
+	payload := benchmarkPayload()
+	cases := []struct {
+		encoding string
+		opts     CompressOptions
+	}{
+		{"gzip", CompressOptions{GzipLevel: 1}},
+		{"gzip", CompressOptions{}},
+		{"gzip", CompressOptions{GzipLevel: 9}},
+		{"br", CompressOptions{BrotliLevel: 1}},
+		{"br", CompressOptions{}},
+		{"br", CompressOptions{BrotliLevel: 11}},
+		{"zstd", CompressOptions{ZstdLevel: 1}},
+		{"zstd", CompressOptions{}},
+		{"zstd", CompressOptions{ZstdLevel: 19}},
+	}
+	for _, c := range cases {
+		level := c.opts.GzipLevel + c.opts.BrotliLevel + c.opts.ZstdLevel
+		name := fmt.Sprintf("%s/level=%d", c.encoding, level)
+		if level == 0 {
+			name = c.encoding + "/level=default"
+		}
+		b.Run(name, func(b *testing.B) {
+			c.opts.Encodings = []string{c.encoding}
+			h := Compress(c.opts)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write(payload)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", c.encoding)
+
+			var size int
+			b.SetBytes(int64(len(payload)))
+			b.ReportAllocs()
+			for b.Loop() {
+				rec := &countingWriter{header: http.Header{}}
+				h.ServeHTTP(rec, req)
+				size = rec.n
+			}
+			check := httptest.NewRecorder()
+			h.ServeHTTP(check, req)
+			if got := check.Header().Get("Content-Encoding"); got != c.encoding {
+				b.Fatalf("Content-Encoding = %q, want %q", got, c.encoding)
+			}
+			b.ReportMetric(float64(len(payload))/float64(size), "ratio")
+		})
+	}
+}
+
+func BenchmarkCompressNegotiation(b *testing.B) {
+	header := "gzip;q=0.8, deflate, br;q=0.9, zstd;q=0.9, *;q=0.1"
+	supported := []string{"zstd", "br", "gzip"}
+	for b.Loop() {
+		if negotiateEncoding(header, supported) != "zstd" {
+			b.Fatal("unexpected encoding")
+		}
+	}
+}
+
+// countingWriter is a ResponseWriter that only counts body bytes, keeping
+// the benchmark free of buffer growth.
+type countingWriter struct {
+	header http.Header
+	n      int
+}
+
+func (cw *countingWriter) Header() http.Header { return cw.header }
+func (cw *countingWriter) WriteHeader(int)     {}
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.n += len(p)
+	return len(p), nil
+}
@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConcurrencyLimitOptions configures the ConcurrencyLimit middleware.
+type ConcurrencyLimitOptions struct {
+	// Max is the number of requests allowed to execute at the same time.
+	Max int
+	// Queue is how many further requests may wait for a slot; 0 rejects
+	// immediately once Max is reached.
+	Queue int
+	// QueueTimeout bounds how long a queued request waits; defaults to 1s.
+	QueueTimeout time.Duration
+	// PerRoute gives every mux route template its own bulkhead of the same
+	// size instead of one shared by all routes.
+	PerRoute bool
+	// Status is the status of rejected requests, e.g. 429 Too Many
+	// Requests for clients that back off on it; defaults to 503 Service
+	// Unavailable.
+	Status int
+	// Sink receives the number of queued requests as the gauge
+	// "http.concurrency.queued" and rejections as the count
+	// "http.concurrency.rejected", tagged with the route when PerRoute is
+	// set; defaults to NopSink.
+	Sink MetricsSink
+}
+
+// ConcurrencyLimit caps the number of concurrently executing requests,
+// queueing up to Queue waiters for up to QueueTimeout and rejecting the rest
+// with Status. It protects slow handlers from exhausting goroutines and
+// downstream resources. Rejections carry a Retry-After estimating when the
+// requests ahead will have drained, from the recent time requests took
+// to serve.
+func ConcurrencyLimit(opts ConcurrencyLimitOptions) Middleware {
+	timeout := opts.QueueTimeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	status := opts.Status
+	if status == 0 {
+		status = http.StatusServiceUnavailable
+	}
+	sink := opts.Sink
+	if sink == nil {
+		sink = NopSink{}
+	}
+	newBulkhead := func(tags []string) *bulkhead {
+		return &bulkhead{slots: make(chan struct{}, max(1, opts.Max)), queue: int64(opts.Queue), sink: sink, tags: tags}
+	}
+	global := newBulkhead(nil)
+	var routes sync.Map // route template -> *bulkhead
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b := global
+			if opts.PerRoute {
+				route := routeTemplate(r)
+				if v, ok := routes.Load(route); ok {
+					b = v.(*bulkhead)
+				} else {
+					v, _ = routes.LoadOrStore(route, newBulkhead([]string{"route:" + route}))
+					b = v.(*bulkhead)
+				}
+			}
+			if !b.acquire(r, timeout) {
+				sink.Count("http.concurrency.rejected", 1, b.tags)
+				w.Header().Set("Retry-After", retryAfterSeconds(b.drainTime(timeout)))
+				http.Error(w, http.StatusText(status), status)
+				return
+			}
+			start := time.Now()
+			defer func() { b.release(time.Since(start)) }()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type bulkhead struct {
+	slots   chan struct{}
+	queue   int64
+	waiting atomic.Int64
+	// served is a moving average of how long requests held a slot, in
+	// nanoseconds.
+	served atomic.Int64
+
+	sink MetricsSink
+	tags []string
+}
+
+func (b *bulkhead) acquire(r *http.Request, timeout time.Duration) bool {
+	select {
+	case b.slots <- struct{}{}:
+		return true
+	default:
+	}
+	n := b.waiting.Add(1)
+	if n > b.queue {
+		b.waiting.Add(-1)
+		return false
+	}
+	b.sink.Gauge("http.concurrency.queued", float64(n), b.tags)
+	defer func() {
+		b.sink.Gauge("http.concurrency.queued", float64(b.waiting.Add(-1)), b.tags)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case b.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// release frees the slot of a request that took d to serve.
+func (b *bulkhead) release(d time.Duration) {
+	<-b.slots
+	// Concurrent updates may drop a sample, which an estimate can afford.
+	if avg := b.served.Load(); avg == 0 {
+		b.served.Store(int64(d))
+	} else {
+		b.served.Store(avg + (int64(d)-avg)/8)
+	}
+}
+
+// drainTime estimates how long the requests queued and executing take to
+// finish, served Max at a time. It falls back to timeout until a request
+// has completed.
+func (b *bulkhead) drainTime(timeout time.Duration) time.Duration {
+	avg := time.Duration(b.served.Load())
+	if avg == 0 {
+		return timeout
+	}
+	return avg * time.Duration(b.waiting.Load()+int64(len(b.slots))) / time.Duration(cap(b.slots))
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+)
+
+// type contextKey, it won't conflict with other keys, even if they have the same string value.
+const configKey contextKey = "config"
+
+// Config is the application configuration made available to handlers
+// through the request context.
+type Config struct {
+	App string
+	// Tenant is the ID of the tenant the request is for, as resolved by
+	// Tenancy; it is empty outside multi-tenant deployments.
+	Tenant   string
+	Branding Branding
+	// Limits are quotas that handlers enforce, by name, e.g. "projects":
+	// 10. Missing quotas are unlimited.
+	Limits map[string]int64
+}
+
+// Branding is how the application presents itself, e.g. to the users of
+// one tenant.
+type Branding struct {
+	Name    string
+	LogoURL string
+	Color   string
+}
+
+// WithConfig stores config in the request context. It could be used to load
+// configuration from a file or a database and apply it to every request.
+func WithConfig(config *Config) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), configKey, config)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ConfigFromContext returns the Config stored by WithConfig, if any.
+func ConfigFromContext(ctx context.Context) (*Config, bool) {
+	config, ok := ctx.Value(configKey).(*Config)
+	return config, ok && config != nil
+}
@@ -0,0 +1,219 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// CORSOptions configures the CORS middleware.
+type CORSOptions struct {
+	// AllowedOrigins lists origins allowed to make cross-origin requests,
+	// e.g. "https://app.example.com". "*" allows any origin and a single
+	// wildcard label matches subdomains ("https://*.example.com"). Defaults
+	// to "*" when AllowedOriginPatterns and AllowOriginFunc are unset too.
+	AllowedOrigins []string
+	// AllowedOriginPatterns are regular expressions matched against the
+	// whole Origin header.
+	AllowedOriginPatterns []string
+	// AllowOriginFunc, if set, is consulted for origins not matched above.
+	AllowOriginFunc func(r *http.Request, origin string) bool
+	// AllowedMethods defaults to GET, POST, PUT, DELETE, OPTIONS. HEAD is
+	// always allowed.
+	AllowedMethods []string
+	// AllowedHeaders defaults to Content-Type, Authorization. "*" allows any
+	// requested header.
+	AllowedHeaders []string
+	// ExposedHeaders lists response headers readable by scripts.
+	ExposedHeaders []string
+	// MaxAge in seconds lets browsers cache preflight results.
+	MaxAge int
+	// AllowCredentials permits cookies and HTTP auth. It requires origins
+	// to be listed, matched by pattern or by AllowOriginFunc rather than
+	// allowed with "*", as every site could then act for the user.
+	AllowCredentials bool
+	// PassthroughPreflight forwards preflight requests to the next handler
+	// instead of answering them with 204.
+	PassthroughPreflight bool
+}
+
+// CORS implements cross-origin resource sharing: it answers preflight
+// requests and adds the Access-Control-* headers to actual requests from
+// allowed origins. Responses vary on Origin whenever the result depends on
+// it. Install it in front of the router so preflights for routes without an
+// OPTIONS method are still answered.
+func CORS(opts CORSOptions) Middleware {
+	return NewCORSPolicy(opts).Middleware()
+}
+
+// CORSPolicy is the CORS middleware with allowed origins that can be
+// replaced while serving, e.g. on a configuration reload.
+type CORSPolicy struct {
+	opts    CORSOptions
+	origins atomic.Pointer[corsOrigins]
+}
+
+type corsOrigins struct {
+	allowAll bool
+	exact    []string
+	patterns []*regexp.Regexp
+}
+
+// NewCORSPolicy returns a policy applying opts. It panics if an
+// AllowedOriginPatterns entry is not a valid regular expression, or if
+// AllowCredentials is set while any origin is allowed.
+func NewCORSPolicy(opts CORSOptions) *CORSPolicy {
+	p := &CORSPolicy{opts: opts}
+	if err := p.SetAllowedOrigins(opts.AllowedOrigins); err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// errCORSCredentialsAnyOrigin rejects AllowCredentials for any origin.
+var errCORSCredentialsAnyOrigin = errors.New("middleware: CORS AllowCredentials requires an explicit list of allowed origins")
+
+// SetAllowedOrigins replaces AllowedOrigins for the requests that follow.
+// With AllowCredentials set it returns an error and keeps the previous
+// origins if origins would allow any origin.
+func (p *CORSPolicy) SetAllowedOrigins(origins []string) error {
+	if len(origins) == 0 && len(p.opts.AllowedOriginPatterns) == 0 && p.opts.AllowOriginFunc == nil {
+		origins = []string{"*"}
+	}
+	o := &corsOrigins{allowAll: slices.Contains(origins, "*")}
+	if o.allowAll && p.opts.AllowCredentials {
+		return errCORSCredentialsAnyOrigin
+	}
+	for _, origin := range origins {
+		if origin == "*" {
+			continue
+		}
+		if strings.Contains(origin, "*") {
+			o.patterns = append(o.patterns, regexp.MustCompile("^"+strings.ReplaceAll(regexp.QuoteMeta(origin), `\*`, `[a-zA-Z0-9-]+`)+"$"))
+			continue
+		}
+		o.exact = append(o.exact, strings.ToLower(origin))
+	}
+	for _, pattern := range p.opts.AllowedOriginPatterns {
+		o.patterns = append(o.patterns, regexp.MustCompile("^(?:"+pattern+")$"))
+	}
+	p.origins.Store(o)
+	return nil
+}
+
+// allowed reports whether origin may make cross-origin requests.
+func (o *corsOrigins) allowed(r *http.Request, origin string, allowFunc func(*http.Request, string) bool) bool {
+	if o.allowAll || slices.Contains(o.exact, strings.ToLower(origin)) {
+		return true
+	}
+	for _, p := range o.patterns {
+		if p.MatchString(origin) {
+			return true
+		}
+	}
+	return allowFunc != nil && allowFunc(r, origin)
+}
+
+// Middleware returns the middleware applying p.
+func (p *CORSPolicy) Middleware() Middleware {
+	opts := p.opts
+	methods := opts.AllowedMethods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodOptions}
+	}
+	headers := opts.AllowedHeaders
+	if len(headers) == 0 {
+		headers = []string{"Content-Type", "Authorization"}
+	}
+	allowAnyHeader := slices.Contains(headers, "*")
+	allowMethods := strings.Join(methods, ", ")
+	allowHeaders := strings.Join(headers, ", ")
+	exposeHeaders := strings.Join(opts.ExposedHeaders, ", ")
+	maxAge := ""
+	if opts.MaxAge > 0 {
+		maxAge = strconv.Itoa(opts.MaxAge)
+	}
+	lowerHeaders := make([]string, len(headers))
+	for i, h := range headers {
+		lowerHeaders[i] = strings.ToLower(h)
+	}
+
+	methodAllowed := func(m string) bool {
+		return m == http.MethodHead || slices.Contains(methods, m)
+	}
+	headersAllowed := func(requested string) bool {
+		if allowAnyHeader || requested == "" {
+			return true
+		}
+		for _, h := range strings.Split(requested, ",") {
+			if h = strings.ToLower(strings.TrimSpace(h)); h != "" && !slices.Contains(lowerHeaders, h) {
+				return false
+			}
+		}
+		return true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origins := p.origins.Load()
+			// Only a fixed "*" response is independent of the Origin header.
+			varyOrigin := !origins.allowAll
+			h := w.Header()
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			if preflight {
+				h.Add("Vary", "Origin")
+				h.Add("Vary", "Access-Control-Request-Method")
+				h.Add("Vary", "Access-Control-Request-Headers")
+			} else if varyOrigin {
+				h.Add("Vary", "Origin")
+			}
+
+			allowed := origin != "" && origins.allowed(r, origin, opts.AllowOriginFunc)
+			if allowed {
+				if origins.allowAll {
+					h.Set("Access-Control-Allow-Origin", "*")
+				} else {
+					h.Set("Access-Control-Allow-Origin", origin)
+				}
+				if opts.AllowCredentials {
+					h.Set("Access-Control-Allow-Credentials", "true")
+				}
+			}
+
+			if !preflight {
+				if allowed && exposeHeaders != "" {
+					h.Set("Access-Control-Expose-Headers", exposeHeaders)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			reqHeaders := r.Header.Get("Access-Control-Request-Headers")
+			if allowed && methodAllowed(r.Header.Get("Access-Control-Request-Method")) && headersAllowed(reqHeaders) {
+				h.Set("Access-Control-Allow-Methods", allowMethods)
+				if allowAnyHeader && reqHeaders != "" {
+					h.Set("Access-Control-Allow-Headers", reqHeaders)
+				} else {
+					h.Set("Access-Control-Allow-Headers", allowHeaders)
+				}
+				if maxAge != "" {
+					h.Set("Access-Control-Max-Age", maxAge)
+				}
+			} else {
+				// Without the allow headers the browser blocks the request.
+				h.Del("Access-Control-Allow-Origin")
+				h.Del("Access-Control-Allow-Credentials")
+			}
+			if opts.PassthroughPreflight {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+)
+
+// WriteDeadline replaces the server's write timeout for the routes it wraps,
+// giving them d from the start of the handler to finish the response. A
+// zero d removes the deadline, for long-lived streams that do their own
+// keep-alive. Writers that cannot reach the connection, such as the
+// buffering one installed by Timeout, leave the server's deadline in place,
+// so WriteDeadline belongs outside Timeout in a chain.
+func WriteDeadline(d time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var deadline time.Time
+			if d > 0 {
+				deadline = time.Now().Add(d)
+			}
+			// ErrNotSupported just means the server default still applies.
+			_ = http.NewResponseController(w).SetWriteDeadline(deadline)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
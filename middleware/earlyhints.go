@@ -0,0 +1,38 @@
+package middleware
+
+import "net/http"
+
+// SendEarlyHints adds links, e.g. "</static/app.css>; rel=preload; as=style",
+// as Link headers and sends them in a 103 Early Hints response, so the
+// browser can start fetching while the handler is still working on the
+// page. The links stay in the header map and are repeated on the final
+// response. Nothing is sent to HTTP/1.0 clients, which don't understand
+// informational responses.
+func SendEarlyHints(w http.ResponseWriter, r *http.Request, links ...string) {
+	if len(links) == 0 || !r.ProtoAtLeast(1, 1) {
+		return
+	}
+	for _, l := range links {
+		w.Header().Add("Link", l)
+	}
+	w.WriteHeader(http.StatusEarlyHints)
+}
+
+// EarlyHints sends links as 103 Early Hints ahead of every GET request
+// asking for HTML, before running the next handler. Attach it to the
+// routes rendering pages, e.g.
+//
+//	pages.Use(middleware.EarlyHints(
+//		"</static/app.css>; rel=preload; as=style",
+//		"</static/app.js>; rel=modulepreload",
+//	))
+func EarlyHints(links ...string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet && acceptsHTML(r) {
+				SendEarlyHints(w, r, links...)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
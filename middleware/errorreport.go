@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrorReport describes a failure worth the attention of an error tracker:
+// a panic recovered by Recovery or a 5xx response seen by ErrorReporting.
+type ErrorReport struct {
+	Time time.Time
+	// Panic is the recovered value, nil for 5xx responses.
+	Panic any
+	// Frames are the call stack of the panic, innermost first.
+	Frames []runtime.Frame
+	// Status is the response status, 500 for panics.
+	Status int
+	// Route is the mux path template of the request, if it matched one.
+	Route string
+	// Identity is the authenticated caller, if any, even when an
+	// authentication middleware further in set it.
+	Identity *Identity
+	// Breadcrumbs are the events recorded while serving the request,
+	// oldest first.
+	Breadcrumbs []Breadcrumb
+}
+
+// ErrorReporter sends reports to an error tracker. ReportError is called
+// before the response is complete and must not block it, so
+// implementations should queue the reports; they must be safe for
+// concurrent use.
+type ErrorReporter interface {
+	ReportError(r *http.Request, e *ErrorReport)
+}
+
+// Breadcrumb is an event leading up to an error, e.g. a call to another
+// service or a query.
+type Breadcrumb struct {
+	Time time.Time
+	// Category groups breadcrumbs, e.g. "http" for those of Transport.
+	Category string
+	Message  string
+	Level    slog.Level
+	Data     map[string]any
+}
+
+// maxBreadcrumbs bounds the breadcrumbs kept per request; the oldest are
+// dropped first.
+const maxBreadcrumbs = 100
+
+// AddBreadcrumb records b in the request of ctx, to be sent with its error
+// report if it fails. It does nothing when neither Recovery reports panics
+// nor ErrorReporting is installed. Time defaults to now.
+func AddBreadcrumb(ctx context.Context, b Breadcrumb) {
+	trail, _ := ctx.Value(errorTrailKey).(*errorTrail)
+	if trail == nil {
+		return
+	}
+	if b.Time.IsZero() {
+		b.Time = time.Now()
+	}
+	trail.mu.Lock()
+	defer trail.mu.Unlock()
+	if len(trail.breadcrumbs) == maxBreadcrumbs {
+		trail.breadcrumbs = slices.Delete(trail.breadcrumbs, 0, 1)
+	}
+	trail.breadcrumbs = append(trail.breadcrumbs, b)
+}
+
+const errorTrailKey contextKey = "error_trail"
+
+// errorTrail collects what error reports carry besides the request: the
+// breadcrumbs and the identity set further in. It is shared by Recovery and
+// ErrorReporting so a panic is reported once, by Recovery, with its stack.
+type errorTrail struct {
+	identity *identitySlot
+
+	mu          sync.Mutex
+	breadcrumbs []Breadcrumb
+	reported    bool
+}
+
+// withErrorTrail returns r with a trail in its context, reusing that of a
+// middleware further out.
+func withErrorTrail(r *http.Request) (*http.Request, *errorTrail) {
+	if trail, ok := r.Context().Value(errorTrailKey).(*errorTrail); ok {
+		return r, trail
+	}
+	ctx, slot := captureIdentity(r.Context())
+	trail := &errorTrail{identity: slot}
+	return r.WithContext(context.WithValue(ctx, errorTrailKey, trail)), trail
+}
+
+// report sends e, completed from the trail, to reporter unless the failure
+// was reported already.
+func (t *errorTrail) report(reporter ErrorReporter, r *http.Request, e *ErrorReport) {
+	t.mu.Lock()
+	if t.reported {
+		t.mu.Unlock()
+		return
+	}
+	t.reported = true
+	e.Breadcrumbs = slices.Clone(t.breadcrumbs)
+	t.mu.Unlock()
+	e.Time = time.Now()
+	e.Route = routeTemplate(r)
+	e.Identity = t.identity.id
+	reporter.ReportError(r, e)
+}
+
+// ErrorReportingOptions configures the ErrorReporting middleware.
+type ErrorReportingOptions struct {
+	// Reporter receives the reports. Required.
+	Reporter ErrorReporter
+	// Ignore lists 5xx statuses not worth reporting, e.g. the 503 of load
+	// shedding.
+	Ignore []int
+}
+
+// ErrorReporting reports every 5xx response to opts.Reporter, with the
+// breadcrumbs recorded by AddBreadcrumb while serving it. Installed outside
+// Recovery, it lets Recovery report panics with their stack instead of the
+// 500 it answers them with.
+func ErrorReporting(opts ErrorReportingOptions) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r, trail := withErrorTrail(r)
+			rec, owned := acquireResponseRecorder(w)
+			if owned {
+				defer releaseResponseRecorder(rec)
+			}
+			next.ServeHTTP(rec, r)
+			if status := rec.Status(); status >= 500 && !slices.Contains(opts.Ignore, status) {
+				trail.report(opts.Reporter, r, &ErrorReport{Status: status})
+			}
+		})
+	}
+}
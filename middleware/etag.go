@@ -0,0 +1,215 @@
+package middleware
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ETagOptions configures the ETag middleware.
+type ETagOptions struct {
+	// Weak makes generated tags weak (W/"..."), so caches won't combine
+	// them with range requests.
+	Weak bool
+	// MaxSize is the largest body buffered to compute a tag; defaults to
+	// 1 MiB. Larger responses are streamed without one.
+	MaxSize int64
+}
+
+// ETag adds validators to GET and HEAD responses and answers conditional
+// requests with 304 Not Modified. When the handler sets ETag or
+// Last-Modified itself, If-None-Match and If-Modified-Since are evaluated
+// as soon as it writes the header and the body writes of a 304 are dropped.
+// Otherwise 200 responses to GET are buffered and tagged with a hash of the
+// body.
+//
+// Installed after Compress, tags identify the uncompressed body and Compress
+// marks them weak on compressed responses; If-None-Match uses the weak
+// comparison, so revalidation works for every encoding. Installed in front
+// of Compress, each encoding gets its own strong tag instead.
+func ETag(opts ETagOptions) Middleware {
+	maxSize := opts.MaxSize
+	if maxSize <= 0 {
+		maxSize = 1 << 20
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead || isUpgrade(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ew := &etagWriter{ResponseWriter: w, r: r, weak: opts.Weak, limit: maxSize}
+			next.ServeHTTP(ew, r)
+			ew.finish()
+		})
+	}
+}
+
+// etagWriter holds back a 200 response until its tag is known.
+type etagWriter struct {
+	http.ResponseWriter
+	r     *http.Request
+	weak  bool
+	limit int64
+
+	status      int
+	buffering   bool
+	notModified bool
+	buf         []byte
+}
+
+func (ew *etagWriter) WriteHeader(code int) {
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		ew.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if ew.status != 0 {
+		return
+	}
+	ew.status = code
+	h := ew.Header()
+	if code != http.StatusOK {
+		ew.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if h.Get("ETag") != "" || h.Get("Last-Modified") != "" {
+		if ew.precondition() {
+			ew.writeNotModified()
+		} else {
+			ew.ResponseWriter.WriteHeader(code)
+		}
+		return
+	}
+	// Handlers often skip the body of a HEAD response, which would be
+	// tagged as empty.
+	if ew.r.Method == http.MethodHead {
+		ew.ResponseWriter.WriteHeader(code)
+		return
+	}
+	ew.buffering = true
+}
+
+func (ew *etagWriter) Write(p []byte) (int, error) {
+	if ew.status == 0 {
+		ew.WriteHeader(http.StatusOK)
+	}
+	switch {
+	case ew.notModified:
+		return len(p), nil
+	case !ew.buffering:
+		return ew.ResponseWriter.Write(p)
+	case int64(len(ew.buf)+len(p)) > ew.limit:
+		if err := ew.release(); err != nil {
+			return 0, err
+		}
+		return ew.ResponseWriter.Write(p)
+	}
+	ew.buf = append(ew.buf, p...)
+	return len(p), nil
+}
+
+// release gives up on tagging and sends what was buffered.
+func (ew *etagWriter) release() error {
+	ew.buffering = false
+	ew.ResponseWriter.WriteHeader(ew.status)
+	buf := ew.buf
+	ew.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := ew.ResponseWriter.Write(buf)
+	return err
+}
+
+// finish tags and sends a buffered response.
+func (ew *etagWriter) finish() {
+	if !ew.buffering {
+		return
+	}
+	ew.buffering = false
+	sum := sha256.Sum256(ew.buf)
+	tag := `"` + base64.RawURLEncoding.EncodeToString(sum[:12]) + `"`
+	if ew.weak {
+		tag = "W/" + tag
+	}
+	ew.Header().Set("ETag", tag)
+	if ew.precondition() {
+		ew.writeNotModified()
+		return
+	}
+	ew.ResponseWriter.WriteHeader(ew.status)
+	if len(ew.buf) > 0 {
+		ew.ResponseWriter.Write(ew.buf)
+	}
+}
+
+// precondition reports whether the request's validators match the
+// response's, i.e. whether 304 is the right answer. If-None-Match takes
+// precedence over If-Modified-Since.
+func (ew *etagWriter) precondition() bool {
+	h := ew.Header()
+	if inm := ew.r.Header.Get("If-None-Match"); inm != "" {
+		etag := h.Get("ETag")
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				// Answer with the tag as the client knows it, which may
+				// have been weakened on the way out by Compress.
+				if candidate != "*" {
+					h.Set("ETag", candidate)
+				}
+				return true
+			}
+		}
+		return false
+	}
+	ims, err := http.ParseTime(ew.r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(h.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	return !lastModified.Truncate(time.Second).After(ims)
+}
+
+// writeNotModified sends 304 with the headers that describe the body
+// removed, as http.ServeContent does.
+func (ew *etagWriter) writeNotModified() {
+	ew.notModified = true
+	h := ew.Header()
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	h.Del("Content-Encoding")
+	if h.Get("ETag") != "" {
+		h.Del("Last-Modified")
+	}
+	ew.ResponseWriter.WriteHeader(http.StatusNotModified)
+}
+
+func (ew *etagWriter) Flush() {
+	if ew.status == 0 {
+		ew.WriteHeader(http.StatusOK)
+	}
+	if ew.buffering {
+		ew.release()
+	}
+	http.NewResponseController(ew.ResponseWriter).Flush()
+}
+
+func (ew *etagWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(ew.ResponseWriter).Hijack()
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController.
+func (ew *etagWriter) Unwrap() http.ResponseWriter {
+	return ew.ResponseWriter
+}
@@ -0,0 +1,16 @@
+package middleware
+
+import "sync/atomic"
+
+// gauge is a concurrency-safe counter of things currently in progress.
+type gauge struct {
+	n atomic.Int64
+}
+
+func (g *gauge) add(delta int64) int64 {
+	return g.n.Add(delta)
+}
+
+func (g *gauge) value() int64 {
+	return g.n.Load()
+}
@@ -0,0 +1,184 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+// GeoInfo is what a GeoResolver knows about an address. Fields are empty
+// when the address is not in the database, e.g. private ranges.
+type GeoInfo struct {
+	// Country is the ISO 3166-1 alpha-2 code, e.g. "DE".
+	Country string `json:"country,omitempty"`
+	// ASN is the autonomous system number announcing the address.
+	ASN uint `json:"asn,omitempty"`
+	// ASOrg is the organisation owning ASN.
+	ASOrg string `json:"as_org,omitempty"`
+}
+
+// GeoResolver looks up addresses in a geolocation database.
+type GeoResolver interface {
+	Lookup(addr netip.Addr) (GeoInfo, error)
+}
+
+// MaxMindResolver resolves addresses with MaxMind (or compatible) MMDB
+// files, e.g. GeoLite2-Country and GeoLite2-ASN.
+type MaxMindResolver struct {
+	country *maxminddb.Reader
+	asn     *maxminddb.Reader
+}
+
+// OpenMaxMind opens a country (or city) database and an ASN database.
+// Either path may be empty to skip that lookup.
+func OpenMaxMind(countryDB, asnDB string) (*MaxMindResolver, error) {
+	m := &MaxMindResolver{}
+	var err error
+	if countryDB != "" {
+		if m.country, err = maxminddb.Open(countryDB); err != nil {
+			return nil, err
+		}
+	}
+	if asnDB != "" {
+		if m.asn, err = maxminddb.Open(asnDB); err != nil {
+			m.Close()
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Lookup implements GeoResolver.
+func (m *MaxMindResolver) Lookup(addr netip.Addr) (GeoInfo, error) {
+	var info GeoInfo
+	if m.country != nil {
+		var rec struct {
+			Country struct {
+				ISOCode string `maxminddb:"iso_code"`
+			} `maxminddb:"country"`
+		}
+		if err := m.country.Lookup(addr).Decode(&rec); err != nil {
+			return info, err
+		}
+		info.Country = rec.Country.ISOCode
+	}
+	if m.asn != nil {
+		var rec struct {
+			Number uint   `maxminddb:"autonomous_system_number"`
+			Org    string `maxminddb:"autonomous_system_organization"`
+		}
+		if err := m.asn.Lookup(addr).Decode(&rec); err != nil {
+			return info, err
+		}
+		info.ASN, info.ASOrg = rec.Number, rec.Org
+	}
+	return info, nil
+}
+
+// Close releases the database files.
+func (m *MaxMindResolver) Close() error {
+	var errs []error
+	if m.country != nil {
+		errs = append(errs, m.country.Close())
+	}
+	if m.asn != nil {
+		errs = append(errs, m.asn.Close())
+	}
+	return errors.Join(errs...)
+}
+
+const geoKey contextKey = "geo"
+
+// GeoFromContext returns the lookup result stored by GeoIP.
+func GeoFromContext(ctx context.Context) (GeoInfo, bool) {
+	info, ok := ctx.Value(geoKey).(GeoInfo)
+	return info, ok
+}
+
+// GeoIPOptions configures the GeoIP middleware.
+type GeoIPOptions struct {
+	// Resolver looks up client addresses. Required.
+	Resolver GeoResolver
+	// ClientIP resolves the address to look up; defaults to ClientIP. Use
+	// ClientIPFromProxies behind a load balancer.
+	ClientIP func(r *http.Request) string
+	// BlockCountries are ISO codes answered with 403 Forbidden.
+	BlockCountries []string
+	// ChallengeCountries are ISO codes whose requests go through Challenge
+	// instead of straight to the handler.
+	ChallengeCountries []string
+	// Challenge wraps the handler for ChallengeCountries, e.g. to serve a
+	// CAPTCHA unless the client already solved one. If nil those requests
+	// are blocked like BlockCountries.
+	Challenge Middleware
+	// Logger receives blocked-request and lookup-failure records; defaults
+	// to slog.Default().
+	Logger *slog.Logger
+}
+
+// GeoIP looks up each client's country and ASN, stores the result in the
+// request context for GeoFromContext and log records, and blocks or
+// challenges the configured countries. Failed lookups are logged and the
+// request is let through.
+func GeoIP(opts GeoIPOptions) Middleware {
+	clientIP := opts.ClientIP
+	if clientIP == nil {
+		clientIP = ClientIP
+	}
+	block := upperAll(opts.BlockCountries)
+	challenge := upperAll(opts.ChallengeCountries)
+	if opts.Challenge == nil {
+		block = append(block, challenge...)
+		challenge = nil
+	}
+	return func(next http.Handler) http.Handler {
+		challenged := next
+		if opts.Challenge != nil {
+			challenged = opts.Challenge(next)
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := clientIP(r)
+			addr, err := netip.ParseAddr(ip)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			info, err := opts.Resolver.Lookup(addr.Unmap())
+			if err != nil {
+				requestLogger(opts.Logger, r).LogAttrs(r.Context(), slog.LevelError, "geoip lookup failed",
+					slog.String("client_ip", ip),
+					slog.String("error", err.Error()),
+				)
+				next.ServeHTTP(w, r)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), geoKey, info))
+			switch {
+			case info.Country != "" && slices.Contains(block, info.Country):
+				requestLogger(opts.Logger, r).LogAttrs(r.Context(), slog.LevelWarn, "country blocked",
+					slog.String("client_ip", ip),
+					slog.String("path", r.URL.Path),
+				)
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			case info.Country != "" && slices.Contains(challenge, info.Country):
+				challenged.ServeHTTP(w, r)
+			default:
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+func upperAll(ss []string) []string {
+	out := make([]string, len(ss))
+	for i, s := range ss {
+		out[i] = strings.ToUpper(s)
+	}
+	return out
+}
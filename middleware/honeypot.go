@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultHoneypotPaths are probed by scanners but never served by this
+// kind of API. A trailing "*" matches any path with that prefix.
+var DefaultHoneypotPaths = []string{
+	"/wp-admin*",
+	"/wp-login.php",
+	"/xmlrpc.php",
+	"/.env*",
+	"/.git/*",
+	"/.aws/*",
+	"/phpmyadmin*",
+	"/config.php",
+	"/server-status",
+	"/actuator/*",
+	"/cgi-bin/*",
+}
+
+// HoneypotOptions configures the Honeypot middleware.
+type HoneypotOptions struct {
+	// Paths are the decoys, matched case-insensitively; defaults to
+	// DefaultHoneypotPaths.
+	Paths []string
+	// Filter, when set, bans the client for BanDuration on a hit.
+	Filter *IPFilter
+	// BanDuration defaults to 24 hours.
+	BanDuration time.Duration
+	// ClientIP resolves the address to flag; defaults to ClientIP. Use
+	// ClientIPFromProxies behind a load balancer.
+	ClientIP func(r *http.Request) string
+	// Logger receives the alerts; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Honeypot answers requests for decoy paths with a plain 404, as if they
+// didn't exist, and raises an alert naming the client. With a Filter the
+// client is banned, so its next requests anywhere are refused by
+// Filter.Middleware. It must wrap the router, since decoys have no routes
+// of their own.
+func Honeypot(opts HoneypotOptions) Middleware {
+	paths := opts.Paths
+	if paths == nil {
+		paths = DefaultHoneypotPaths
+	}
+	var exact, prefixes []string
+	for _, p := range paths {
+		p = strings.ToLower(p)
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			prefixes = append(prefixes, prefix)
+		} else {
+			exact = append(exact, p)
+		}
+	}
+	banFor := opts.BanDuration
+	if banFor <= 0 {
+		banFor = 24 * time.Hour
+	}
+	clientIP := opts.ClientIP
+	if clientIP == nil {
+		clientIP = ClientIP
+	}
+	isDecoy := func(path string) bool {
+		path = strings.ToLower(path)
+		for _, p := range exact {
+			if path == p {
+				return true
+			}
+		}
+		for _, p := range prefixes {
+			if strings.HasPrefix(path, p) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !isDecoy(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ip := clientIP(r)
+			attrs := []slog.Attr{
+				slog.String("event", "honeypot_hit"),
+				slog.String("client_ip", ip),
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.String("user_agent", r.UserAgent()),
+			}
+			if opts.Filter != nil {
+				if err := opts.Filter.Ban(ip, banFor); err == nil {
+					attrs = append(attrs, slog.Duration("banned_for", banFor))
+				}
+			}
+			requestLogger(opts.Logger, r).LogAttrs(r.Context(), slog.LevelWarn, "honeypot triggered", attrs...)
+			http.NotFound(w, r)
+		})
+	}
+}
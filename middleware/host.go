@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// requestHost returns r.Host lower-cased, without port or trailing dot.
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// hostPattern matches a host name exactly or, written as "*.example.com",
+// any subdomain of example.com (but not example.com itself).
+type hostPattern struct {
+	exact  string
+	suffix string
+}
+
+func newHostPattern(p string) hostPattern {
+	p = strings.TrimSuffix(strings.ToLower(p), ".")
+	if suffix, ok := strings.CutPrefix(p, "*"); ok {
+		return hostPattern{suffix: suffix}
+	}
+	return hostPattern{exact: p}
+}
+
+func (p hostPattern) match(host string) bool {
+	if p.suffix != "" {
+		return len(host) > len(p.suffix) && strings.HasSuffix(host, p.suffix)
+	}
+	return host == p.exact
+}
+
+// AllowedHostsOptions configures the AllowedHosts middleware.
+type AllowedHostsOptions struct {
+	// Hosts are the accepted names, exact or "*.example.com" for any
+	// subdomain; ports are ignored.
+	Hosts []string
+	// Logger receives rejected-request records; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// AllowedHosts rejects requests whose Host header is not one of the
+// configured names: missing hosts get 400 Bad Request and unknown ones 421
+// Misdirected Request. This stops DNS rebinding and Host header injection
+// into generated links.
+func AllowedHosts(opts AllowedHostsOptions) Middleware {
+	patterns := make([]hostPattern, len(opts.Hosts))
+	for i, h := range opts.Hosts {
+		patterns[i] = newHostPattern(h)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host := requestHost(r)
+			if host == "" {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			for _, p := range patterns {
+				if p.match(host) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			requestLogger(opts.Logger, r).LogAttrs(r.Context(), slog.LevelWarn, "host rejected",
+				slog.String("host", r.Host),
+				slog.String("remote_addr", r.RemoteAddr),
+			)
+			http.Error(w, http.StatusText(http.StatusMisdirectedRequest), http.StatusMisdirectedRequest)
+		})
+	}
+}
+
+// VirtualHosts dispatches requests to the handler (typically a router with
+// its own chain) registered for their Host, so several sites can share one
+// listener. Keys follow AllowedHosts; exact names win over wildcards, and
+// longer wildcards over shorter ones. Requests for other hosts go to
+// fallback, or get 421 Misdirected Request if it is nil.
+func VirtualHosts(sites map[string]http.Handler, fallback http.Handler) http.Handler {
+	exact := make(map[string]http.Handler)
+	type wildcard struct {
+		pattern hostPattern
+		handler http.Handler
+	}
+	var wildcards []wildcard
+	for name, h := range sites {
+		p := newHostPattern(name)
+		if p.suffix == "" {
+			exact[p.exact] = h
+			continue
+		}
+		wildcards = append(wildcards, wildcard{p, h})
+	}
+	// Most specific first.
+	slices.SortFunc(wildcards, func(a, b wildcard) int {
+		return len(b.pattern.suffix) - len(a.pattern.suffix)
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := requestHost(r)
+		if h, ok := exact[host]; ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		for _, wc := range wildcards {
+			if wc.pattern.match(host) {
+				wc.handler.ServeHTTP(w, r)
+				return
+			}
+		}
+		if fallback != nil {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusMisdirectedRequest), http.StatusMisdirectedRequest)
+	})
+}
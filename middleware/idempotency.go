@@ -0,0 +1,214 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// idempotencyFingerprintHeader carries the request fingerprint inside stored
+// responses; it is removed before replaying them.
+const idempotencyFingerprintHeader = "X-Idempotency-Fingerprint"
+
+// IdempotencyOptions configures the Idempotency middleware.
+type IdempotencyOptions struct {
+	// Header carries the client's key; defaults to Idempotency-Key.
+	Header string
+	// Methods are the methods keys apply to; defaults to POST and PATCH.
+	Methods []string
+	// Required rejects requests using those methods without a key with 400.
+	Required bool
+	// TTL is how long a response is replayed for retries; defaults to 24h.
+	TTL time.Duration
+	// LockTimeout bounds how long a key stays claimed by a request that
+	// never finishes, e.g. because the instance crashed; defaults to 1m.
+	LockTimeout time.Duration
+	// Store keeps responses and in-flight claims; defaults to an in-memory
+	// store. Use a shared one, such as redisstore.CacheStore, so retries
+	// landing on another replica are recognised.
+	Store CacheStore
+	// Scope namespaces keys per client; defaults to the Subject of the
+	// Identity set by an authentication middleware, so callers can't
+	// replay each other's responses, or one namespace for everyone when
+	// there is none.
+	Scope func(r *http.Request) string
+	// MaxBody is the largest request body fingerprinted, as it is read
+	// whole; defaults to 1 MiB. Larger bodies get 413 Request Entity Too
+	// Large.
+	MaxBody int64
+	// MaxResponseSize is the largest response stored; defaults to 1 MiB.
+	// Larger responses are not replayed and a retry runs the handler again.
+	MaxResponseSize int64
+	// Logger receives store error records; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Idempotency makes retried requests safe: the first response for each
+// Idempotency-Key is stored and replayed, with Idempotent-Replayed: true,
+// for retries within the TTL. A retry arriving while the first request is
+// still running gets 409 Conflict, and reusing a key for a different method,
+// URL or body gets 422 Unprocessable Entity. Server errors (5xx) are not
+// stored, so the client can retry them. Store failures are answered with
+// 503 rather than risking a second execution.
+func Idempotency(opts IdempotencyOptions) Middleware {
+	header := opts.Header
+	if header == "" {
+		header = "Idempotency-Key"
+	}
+	methods := opts.Methods
+	if len(methods) == 0 {
+		methods = []string{http.MethodPost, http.MethodPatch}
+	}
+	ttl := opts.TTL
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	lockTimeout := opts.LockTimeout
+	if lockTimeout <= 0 {
+		lockTimeout = time.Minute
+	}
+	store := opts.Store
+	if store == nil {
+		store = NewMemoryCacheStore(10000, 0)
+	}
+	scope := opts.Scope
+	if scope == nil {
+		scope = func(r *http.Request) string {
+			if id, ok := IdentityFromContext(r.Context()); ok {
+				return id.Subject
+			}
+			return ""
+		}
+	}
+	maxBody := opts.MaxBody
+	if maxBody <= 0 {
+		maxBody = 1 << 20
+	}
+	maxSize := opts.MaxResponseSize
+	if maxSize <= 0 {
+		maxSize = 1 << 20
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !slices.Contains(methods, r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key := r.Header.Get(header)
+			if key == "" {
+				if opts.Required {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing " + header + " header"})
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !validRequestID(key) {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid " + header + " header"})
+				return
+			}
+
+			ctx := r.Context()
+			storeFailed := func(op string, err error) {
+				requestLogger(opts.Logger, r).LogAttrs(ctx, slog.LevelError, "idempotency store failed",
+					slog.String("op", op),
+					slog.String("error", err.Error()),
+				)
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			}
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxBody+1))
+			var tooLarge *http.MaxBytesError
+			switch {
+			case errors.As(err, &tooLarge) || int64(len(body)) > maxBody:
+				writeJSON(w, http.StatusRequestEntityTooLarge, map[string]any{"error": "request body too large", "limit": maxBody})
+				return
+			case err != nil:
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			r.Body = readCloser{bytes.NewReader(body), r.Body}
+			sum := sha256.New()
+			io.WriteString(sum, r.Method+" "+r.URL.RequestURI()+"\n")
+			sum.Write(body)
+			fingerprint := hex.EncodeToString(sum.Sum(nil))
+			storeKey := "idempotency " + scope(r) + "\n" + key
+
+			replay := func(resp *CachedResponse) {
+				if resp.Header.Get(idempotencyFingerprintHeader) != fingerprint {
+					writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": header + " reused with a different request"})
+					return
+				}
+				replayed := *resp
+				replayed.Header = resp.Header.Clone()
+				replayed.Header.Del(idempotencyFingerprintHeader)
+				w.Header().Set("Idempotent-Replayed", "true")
+				writeRecorded(w, r, &replayed)
+			}
+			resp, err := store.Get(ctx, storeKey)
+			if err != nil {
+				storeFailed("get", err)
+				return
+			}
+			if resp != nil {
+				replay(resp)
+				return
+			}
+			token := NewUUID()
+			locked, err := store.Lock(ctx, storeKey, token, lockTimeout)
+			if err != nil {
+				storeFailed("lock", err)
+				return
+			}
+			if !locked {
+				writeJSON(w, http.StatusConflict, map[string]string{"error": "a request with this " + header + " is in progress"})
+				return
+			}
+			defer store.Unlock(context.WithoutCancel(ctx), storeKey, token)
+			// The first request may have finished between Get and Lock.
+			if resp, err := store.Get(ctx, storeKey); err != nil {
+				storeFailed("get", err)
+				return
+			} else if resp != nil {
+				replay(resp)
+				return
+			}
+
+			cw := &cacheWriter{
+				ResponseWriter: w,
+				before:         w.Header().Clone(),
+				limit:          maxSize,
+			}
+			next.ServeHTTP(cw, r)
+			if cw.status == 0 {
+				cw.WriteHeader(http.StatusOK)
+			}
+			if cw.status >= 500 {
+				return
+			}
+			if cw.overflow {
+				requestLogger(opts.Logger, r).LogAttrs(ctx, slog.LevelWarn, "idempotent response too large to store",
+					slog.String("path", r.URL.Path),
+					slog.Int64("limit", maxSize),
+				)
+				return
+			}
+			cw.header.Set(idempotencyFingerprintHeader, fingerprint)
+			now := time.Now()
+			resp = &CachedResponse{Status: cw.status, Header: cw.header, Body: cw.body, Stored: now, Expires: now.Add(ttl)}
+			if err := store.Set(context.WithoutCancel(ctx), storeKey, resp); err != nil {
+				requestLogger(opts.Logger, r).LogAttrs(ctx, slog.LevelError, "idempotency store failed",
+					slog.String("op", "set"),
+					slog.String("error", err.Error()),
+				)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import "context"
+
+const identityKey contextKey = "identity"
+
+// Identity describes the authenticated caller. Authentication middlewares
+// store it in the request context so authorization, auditing and handlers
+// don't depend on which mechanism was used.
+type Identity struct {
+	// Subject uniquely identifies the caller (JWT "sub", key owner, user).
+	Subject string
+	// Method names the mechanism that authenticated the caller, e.g. "jwt".
+	Method string
+	Roles  []string
+	Scopes []string
+}
+
+// ContextWithIdentity returns a copy of ctx carrying id. It also reports id
+// to middlewares further out that asked for it with captureIdentity, since
+// they can't see contexts derived further in.
+func ContextWithIdentity(ctx context.Context, id *Identity) context.Context {
+	slot, _ := ctx.Value(identitySlotKey).(*identitySlot)
+	for ; slot != nil; slot = slot.parent {
+		slot.id = id
+	}
+	return context.WithValue(ctx, identityKey, id)
+}
+
+const identitySlotKey contextKey = "identity_slot"
+
+// identitySlot receives the identity set by an authentication middleware
+// running inside the one that installed it. Slots installed further out
+// are linked through parent so each of them is filled.
+type identitySlot struct {
+	id     *Identity
+	parent *identitySlot
+}
+
+// captureIdentity returns a context whose descendants report their identity
+// to the returned slot.
+func captureIdentity(ctx context.Context) (context.Context, *identitySlot) {
+	parent, _ := ctx.Value(identitySlotKey).(*identitySlot)
+	slot := &identitySlot{parent: parent}
+	if id, ok := IdentityFromContext(ctx); ok {
+		slot.id = id
+	}
+	return context.WithValue(ctx, identitySlotKey, slot), slot
+}
+
+// IdentityFromContext returns the caller stored by an authentication
+// middleware, if any.
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey).(*Identity)
+	return id, ok && id != nil
+}
@@ -0,0 +1,227 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IPFilter holds CIDR allow and deny lists. Deny entries win; when the allow
+// list is non-empty only addresses inside it are admitted. Lists can be
+// replaced at runtime with Set or Reload, and single addresses banned
+// temporarily with Ban. The zero value admits everyone.
+type IPFilter struct {
+	mu    sync.RWMutex
+	allow []netip.Prefix
+	deny  []netip.Prefix
+	bans  map[netip.Addr]time.Time
+	// sweep is when Ban next drops expired bans
+	sweep time.Time
+	path  string
+	mod   time.Time
+}
+
+// NewIPFilter returns a filter from CIDRs or bare IPs.
+func NewIPFilter(allow, deny []string) (*IPFilter, error) {
+	f := &IPFilter{}
+	if err := f.Set(allow, deny); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// LoadIPFilter reads lists from a file with one "allow <cidr>" or
+// "deny <cidr>" entry per line; blank lines and "#" comments are ignored.
+func LoadIPFilter(path string) (*IPFilter, error) {
+	f := &IPFilter{path: path}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Set atomically replaces both lists.
+func (f *IPFilter) Set(allow, deny []string) error {
+	a, err := parsePrefixes(allow)
+	if err != nil {
+		return err
+	}
+	d, err := parsePrefixes(deny)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.allow, f.deny = a, d
+	f.mu.Unlock()
+	return nil
+}
+
+// Reload re-reads the file given to LoadIPFilter. On error the current lists
+// stay in effect.
+func (f *IPFilter) Reload() error {
+	if f.path == "" {
+		return nil
+	}
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	var allow, deny []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return fmt.Errorf("%s:%d: want \"allow|deny <cidr>\"", f.path, n)
+		}
+		switch fields[0] {
+		case "allow":
+			allow = append(allow, fields[1])
+		case "deny":
+			deny = append(deny, fields[1])
+		default:
+			return fmt.Errorf("%s:%d: unknown action %q", f.path, n, fields[0])
+		}
+	}
+	if err := f.Set(allow, deny); err != nil {
+		return fmt.Errorf("%s: %w", f.path, err)
+	}
+	f.mu.Lock()
+	f.mod = info.ModTime()
+	f.mu.Unlock()
+	return nil
+}
+
+// Watch polls the file every interval and reloads it when it changes, until
+// ctx is done. Reload errors are logged and the previous lists kept.
+func (f *IPFilter) Watch(ctx context.Context, interval time.Duration, logger *slog.Logger) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		info, err := os.Stat(f.path)
+		if err != nil {
+			logger.Error("ip filter stat failed", "path", f.path, "error", err)
+			continue
+		}
+		f.mu.RLock()
+		changed := !info.ModTime().Equal(f.mod)
+		f.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := f.Reload(); err != nil {
+			logger.Error("ip filter reload failed", "path", f.path, "error", err)
+			continue
+		}
+		logger.Info("ip filter reloaded", "path", f.path)
+	}
+}
+
+// Ban denies ip for d, on top of the lists; bans survive Set and Reload.
+func (f *IPFilter) Ban(ip string, d time.Duration) error {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.bans == nil {
+		f.bans = make(map[netip.Addr]time.Time)
+	}
+	if now.After(f.sweep) {
+		for a, until := range f.bans {
+			if now.After(until) {
+				delete(f.bans, a)
+			}
+		}
+		f.sweep = now.Add(time.Minute)
+	}
+	f.bans[addr.Unmap()] = now.Add(d)
+	return nil
+}
+
+// Unban lifts a ban placed with Ban.
+func (f *IPFilter) Unban(ip string) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return
+	}
+	f.mu.Lock()
+	delete(f.bans, addr.Unmap())
+	f.mu.Unlock()
+}
+
+// Allowed reports whether ip passes the filter. Unparseable addresses are
+// rejected.
+func (f *IPFilter) Allowed(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if until, ok := f.bans[addr]; ok && time.Now().Before(until) {
+		return false
+	}
+	if prefixesContain(f.deny, addr) {
+		return false
+	}
+	return len(f.allow) == 0 || prefixesContain(f.allow, addr)
+}
+
+// IPFilterOptions configures IPFilter.Middleware.
+type IPFilterOptions struct {
+	// ClientIP resolves the address to check; defaults to ClientIP. Use
+	// ClientIPFromProxies behind a load balancer.
+	ClientIP func(r *http.Request) string
+	// Logger receives blocked-request records; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Middleware rejects requests from addresses not passing the filter with
+// 403 Forbidden.
+func (f *IPFilter) Middleware(opts IPFilterOptions) Middleware {
+	clientIP := opts.ClientIP
+	if clientIP == nil {
+		clientIP = ClientIP
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := clientIP(r)
+			if !f.Allowed(ip) {
+				requestLogger(opts.Logger, r).LogAttrs(r.Context(), slog.LevelWarn, "ip blocked",
+					slog.String("client_ip", ip),
+					slog.String("path", r.URL.Path),
+				)
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
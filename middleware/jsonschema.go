@@ -0,0 +1,709 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math/big"
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// JSONSchema is a compiled JSON Schema. It supports the validation
+// keywords of draft 2020-12 that JSON documents written by hand use:
+// type, enum, const, the numeric, string, array and object bounds,
+// properties, required, additionalProperties, patternProperties, items,
+// prefixItems, allOf, anyOf, oneOf, not, format (date-time, date, email,
+// uuid, uri, ipv4, ipv6) and $ref to the same document, e.g. "#/$defs/tag".
+// Annotations and unknown keywords are ignored. Patterns are Go regular
+// expressions.
+type JSONSchema struct {
+	root *schemaNode
+}
+
+// FieldError is a violation of a schema. Field is the JSON Pointer of the
+// offending value, e.g. "/items/0/name", "" for the document itself. In
+// names the part of the request or response it is in when that is not the
+// body alone, e.g. "query" for the parameter limit, whose Field is "limit".
+type FieldError struct {
+	In      string `json:"in,omitempty"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// CompileJSONSchema compiles the schema in data.
+func CompileJSONSchema(data []byte) (*JSONSchema, error) {
+	doc, err := decodeJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("jsonschema: %w", err)
+	}
+	c := &schemaCompiler{doc: doc, nodes: map[string]*schemaNode{}}
+	root, err := c.compile(doc, "#")
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkLoops(); err != nil {
+		return nil, err
+	}
+	return &JSONSchema{root: root}, nil
+}
+
+// LoadJSONSchema compiles the schema in the file at path.
+func LoadJSONSchema(path string) (*JSONSchema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := CompileJSONSchema(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Validate returns the violations of the schema by v, a value decoded from
+// JSON with json.Decoder.UseNumber, so numbers are compared exactly, or
+// nil if it is valid.
+func (s *JSONSchema) Validate(v any) []FieldError {
+	return s.root.validateAll(v)
+}
+
+// decodeJSON decodes the single JSON value in data, keeping numbers as
+// json.Number.
+func decodeJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("data after the JSON value")
+	}
+	return v, nil
+}
+
+type schemaNode struct {
+	// always is set for the boolean schemas true and false.
+	always *bool
+	ref    *schemaNode
+
+	types    []string
+	enum     []any
+	constant any
+	hasConst bool
+
+	minLength, maxLength int // -1 when unset
+	pattern              *regexp.Regexp
+	format               string
+
+	minimum, maximum, exclusiveMinimum, exclusiveMaximum, multipleOf *big.Rat
+
+	properties        map[string]*schemaNode
+	required          []string
+	additional        *schemaNode // nil allows any
+	patternProperties []patternProperty
+	minProperties     int
+	maxProperties     int // -1 when unset
+
+	prefixItems []*schemaNode
+	items       *schemaNode
+	minItems    int
+	maxItems    int // -1 when unset
+	uniqueItems bool
+
+	allOf, anyOf, oneOf []*schemaNode
+	not                 *schemaNode
+}
+
+type patternProperty struct {
+	re     *regexp.Regexp
+	schema *schemaNode
+}
+
+// schemaCompiler compiles the schemas of one document, each once, so
+// references may be recursive.
+type schemaCompiler struct {
+	doc   any
+	nodes map[string]*schemaNode // by JSON Pointer fragment, e.g. "#/$defs/tag"
+	// openAPI30 reads the schema dialect of OpenAPI 3.0, which has
+	// nullable and boolean exclusiveMinimum and exclusiveMaximum.
+	openAPI30 bool
+}
+
+var schemaTypes = []string{"null", "boolean", "object", "array", "number", "integer", "string"}
+
+func (c *schemaCompiler) compile(v any, at string) (*schemaNode, error) {
+	if n, ok := c.nodes[at]; ok {
+		return n, nil
+	}
+	n := &schemaNode{minLength: -1, maxLength: -1, maxProperties: -1, maxItems: -1}
+	c.nodes[at] = n
+	fail := func(keyword, format string, args ...any) (*schemaNode, error) {
+		return nil, fmt.Errorf("jsonschema: %s/%s: %s", at, keyword, fmt.Sprintf(format, args...))
+	}
+	if b, ok := v.(bool); ok {
+		n.always = &b
+		return n, nil
+	}
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("jsonschema: %s: schema must be an object or boolean", at)
+	}
+	sub := func(keyword string) (*schemaNode, error) {
+		return c.compile(obj[keyword], at+"/"+escapePointer(keyword))
+	}
+	list := func(keyword string) ([]*schemaNode, error) {
+		items, ok := obj[keyword].([]any)
+		if !ok || len(items) == 0 {
+			_, err := fail(keyword, "must be a non-empty array")
+			return nil, err
+		}
+		nodes := make([]*schemaNode, len(items))
+		for i, item := range items {
+			var err error
+			if nodes[i], err = c.compile(item, at+"/"+keyword+"/"+strconv.Itoa(i)); err != nil {
+				return nil, err
+			}
+		}
+		return nodes, nil
+	}
+	count := func(keyword string, dst *int) error {
+		num, _ := obj[keyword].(json.Number)
+		i, err := num.Int64()
+		if err != nil || i < 0 {
+			_, err := fail(keyword, "must be a non-negative integer")
+			return err
+		}
+		*dst = int(i)
+		return nil
+	}
+	number := func(keyword string, dst **big.Rat) error {
+		num, _ := obj[keyword].(json.Number)
+		r, ok := new(big.Rat).SetString(string(num))
+		if !ok {
+			_, err := fail(keyword, "must be a number")
+			return err
+		}
+		*dst = r
+		return nil
+	}
+
+	var nullable, exclusiveMinimum, exclusiveMaximum bool // of OpenAPI 3.0
+	for _, keyword := range slices.Sorted(maps.Keys(obj)) {
+		var err error
+		switch keyword {
+		case "$ref":
+			ref, _ := obj[keyword].(string)
+			if !strings.HasPrefix(ref, "#") {
+				return fail(keyword, "only references within the document are supported, not %q", ref)
+			}
+			target, ok := resolvePointer(c.doc, ref)
+			if !ok {
+				return fail(keyword, "%q does not exist", ref)
+			}
+			n.ref, err = c.compile(target, ref)
+		case "type":
+			switch t := obj[keyword].(type) {
+			case string:
+				n.types = []string{t}
+			case []any:
+				for _, item := range t {
+					s, _ := item.(string)
+					n.types = append(n.types, s)
+				}
+			}
+			if len(n.types) == 0 {
+				return fail(keyword, "must be a type name or an array of them")
+			}
+			for _, t := range n.types {
+				if !slices.Contains(schemaTypes, t) {
+					return fail(keyword, "unknown type %q", t)
+				}
+			}
+		case "enum":
+			items, ok := obj[keyword].([]any)
+			if !ok {
+				return fail(keyword, "must be an array")
+			}
+			n.enum = items
+		case "const":
+			n.constant, n.hasConst = obj[keyword], true
+		case "minLength":
+			err = count(keyword, &n.minLength)
+		case "maxLength":
+			err = count(keyword, &n.maxLength)
+		case "pattern":
+			s, _ := obj[keyword].(string)
+			if n.pattern, err = regexp.Compile(s); err != nil {
+				return fail(keyword, "%v", err)
+			}
+		case "format":
+			n.format, _ = obj[keyword].(string)
+		case "minimum":
+			err = number(keyword, &n.minimum)
+		case "maximum":
+			err = number(keyword, &n.maximum)
+		case "exclusiveMinimum":
+			if exclusive, ok := obj[keyword].(bool); ok && c.openAPI30 {
+				exclusiveMinimum = exclusive
+				break
+			}
+			err = number(keyword, &n.exclusiveMinimum)
+		case "exclusiveMaximum":
+			if exclusive, ok := obj[keyword].(bool); ok && c.openAPI30 {
+				exclusiveMaximum = exclusive
+				break
+			}
+			err = number(keyword, &n.exclusiveMaximum)
+		case "nullable":
+			nullable, _ = obj[keyword].(bool)
+		case "multipleOf":
+			if err = number(keyword, &n.multipleOf); err == nil && n.multipleOf.Sign() <= 0 {
+				return fail(keyword, "must be positive")
+			}
+		case "properties":
+			props, ok := obj[keyword].(map[string]any)
+			if !ok {
+				return fail(keyword, "must be an object")
+			}
+			n.properties = make(map[string]*schemaNode, len(props))
+			for name, prop := range props {
+				if n.properties[name], err = c.compile(prop, at+"/properties/"+escapePointer(name)); err != nil {
+					return nil, err
+				}
+			}
+		case "required":
+			items, ok := obj[keyword].([]any)
+			if !ok {
+				return fail(keyword, "must be an array of property names")
+			}
+			for _, item := range items {
+				name, ok := item.(string)
+				if !ok {
+					return fail(keyword, "must be an array of property names")
+				}
+				n.required = append(n.required, name)
+			}
+		case "additionalProperties":
+			n.additional, err = sub(keyword)
+		case "patternProperties":
+			props, ok := obj[keyword].(map[string]any)
+			if !ok {
+				return fail(keyword, "must be an object")
+			}
+			for _, expr := range slices.Sorted(maps.Keys(props)) {
+				re, err := regexp.Compile(expr)
+				if err != nil {
+					return fail(keyword, "%v", err)
+				}
+				node, err := c.compile(props[expr], at+"/patternProperties/"+escapePointer(expr))
+				if err != nil {
+					return nil, err
+				}
+				n.patternProperties = append(n.patternProperties, patternProperty{re, node})
+			}
+		case "minProperties":
+			err = count(keyword, &n.minProperties)
+		case "maxProperties":
+			err = count(keyword, &n.maxProperties)
+		case "prefixItems":
+			n.prefixItems, err = list(keyword)
+		case "items":
+			n.items, err = sub(keyword)
+		case "minItems":
+			err = count(keyword, &n.minItems)
+		case "maxItems":
+			err = count(keyword, &n.maxItems)
+		case "uniqueItems":
+			n.uniqueItems, _ = obj[keyword].(bool)
+		case "allOf":
+			n.allOf, err = list(keyword)
+		case "anyOf":
+			n.anyOf, err = list(keyword)
+		case "oneOf":
+			n.oneOf, err = list(keyword)
+		case "not":
+			n.not, err = sub(keyword)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.openAPI30 {
+		if nullable && n.types != nil {
+			n.types = append(n.types, "null")
+		}
+		if exclusiveMinimum {
+			n.minimum, n.exclusiveMinimum = nil, n.minimum
+		}
+		if exclusiveMaximum {
+			n.maximum, n.exclusiveMaximum = nil, n.maximum
+		}
+	}
+	return n, nil
+}
+
+// checkLoops returns an error if a schema applies itself to the value it
+// validates through $ref, allOf, anyOf, oneOf and not, without moving into
+// a part of the value, e.g. {"$ref": "#"}, as validating would recurse
+// forever.
+func (c *schemaCompiler) checkLoops() error {
+	at := make(map[*schemaNode]string, len(c.nodes))
+	for pointer, n := range c.nodes {
+		at[n] = pointer
+	}
+	const visiting, done = 1, 2
+	state := make(map[*schemaNode]int, len(c.nodes))
+	var loop func(n *schemaNode) *schemaNode
+	loop = func(n *schemaNode) *schemaNode {
+		switch state[n] {
+		case visiting:
+			return n
+		case done:
+			return nil
+		}
+		state[n] = visiting
+		for _, next := range n.inPlace() {
+			if l := loop(next); l != nil {
+				return l
+			}
+		}
+		state[n] = done
+		return nil
+	}
+	for _, pointer := range slices.Sorted(maps.Keys(c.nodes)) {
+		if l := loop(c.nodes[pointer]); l != nil {
+			return fmt.Errorf("jsonschema: %s: refers back to itself without descending into the value", at[l])
+		}
+	}
+	return nil
+}
+
+// inPlace returns the subschemas n applies to the value itself.
+func (n *schemaNode) inPlace() []*schemaNode {
+	nodes := slices.Concat(n.allOf, n.anyOf, n.oneOf)
+	if n.ref != nil {
+		nodes = append(nodes, n.ref)
+	}
+	if n.not != nil {
+		nodes = append(nodes, n.not)
+	}
+	return nodes
+}
+
+// resolvePointer returns the value at the JSON Pointer fragment ref, e.g.
+// "#/$defs/tag", of doc.
+func resolvePointer(doc any, ref string) (any, bool) {
+	ref = strings.TrimPrefix(ref, "#")
+	if ref == "" {
+		return doc, true
+	}
+	if !strings.HasPrefix(ref, "/") {
+		return nil, false
+	}
+	v := doc
+	for token := range strings.SplitSeq(ref[1:], "/") {
+		if unescaped, err := url.PathUnescape(token); err == nil {
+			token = unescaped
+		}
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch node := v.(type) {
+		case map[string]any:
+			var ok bool
+			if v, ok = node[token]; !ok {
+				return nil, false
+			}
+		case []any:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// escapePointer escapes a property name as a JSON Pointer token.
+func escapePointer(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
+
+func (n *schemaNode) validate(v any, at string, errs *[]FieldError) {
+	fail := func(format string, args ...any) {
+		*errs = append(*errs, FieldError{Field: at, Message: fmt.Sprintf(format, args...)})
+	}
+	if n.always != nil {
+		if !*n.always {
+			fail("is not allowed")
+		}
+		return
+	}
+	if n.ref != nil {
+		n.ref.validate(v, at, errs)
+	}
+	if n.types != nil && !slices.ContainsFunc(n.types, func(t string) bool { return hasJSONType(v, t) }) {
+		fail("must be %s", joinOr(n.types))
+		return
+	}
+	if n.hasConst && !jsonEqual(v, n.constant) {
+		fail("must be %s", jsonText(n.constant))
+	}
+	if n.enum != nil && !slices.ContainsFunc(n.enum, func(e any) bool { return jsonEqual(v, e) }) {
+		values := make([]string, len(n.enum))
+		for i, e := range n.enum {
+			values[i] = jsonText(e)
+		}
+		fail("must be one of %s", strings.Join(values, ", "))
+	}
+
+	switch v := v.(type) {
+	case string:
+		length := utf8.RuneCountInString(v)
+		if n.minLength >= 0 && length < n.minLength {
+			fail("must be at least %d characters long", n.minLength)
+		}
+		if n.maxLength >= 0 && length > n.maxLength {
+			fail("must be at most %d characters long", n.maxLength)
+		}
+		if n.pattern != nil && !n.pattern.MatchString(v) {
+			fail("must match %s", n.pattern)
+		}
+		if n.format != "" && !validFormat(n.format, v) {
+			fail("must be a valid %s", n.format)
+		}
+	case json.Number:
+		r, ok := new(big.Rat).SetString(string(v))
+		if !ok {
+			fail("must be a number")
+			return
+		}
+		if n.minimum != nil && r.Cmp(n.minimum) < 0 {
+			fail("must be at least %s", n.minimum.RatString())
+		}
+		if n.maximum != nil && r.Cmp(n.maximum) > 0 {
+			fail("must be at most %s", n.maximum.RatString())
+		}
+		if n.exclusiveMinimum != nil && r.Cmp(n.exclusiveMinimum) <= 0 {
+			fail("must be greater than %s", n.exclusiveMinimum.RatString())
+		}
+		if n.exclusiveMaximum != nil && r.Cmp(n.exclusiveMaximum) >= 0 {
+			fail("must be less than %s", n.exclusiveMaximum.RatString())
+		}
+		if n.multipleOf != nil && !new(big.Rat).Quo(r, n.multipleOf).IsInt() {
+			fail("must be a multiple of %s", n.multipleOf.RatString())
+		}
+	case map[string]any:
+		for _, name := range n.required {
+			if _, ok := v[name]; !ok {
+				*errs = append(*errs, FieldError{Field: at + "/" + escapePointer(name), Message: "is required"})
+			}
+		}
+		if n.minProperties > 0 && len(v) < n.minProperties {
+			fail("must have at least %d properties", n.minProperties)
+		}
+		if n.maxProperties >= 0 && len(v) > n.maxProperties {
+			fail("must have at most %d properties", n.maxProperties)
+		}
+		for _, name := range slices.Sorted(maps.Keys(v)) {
+			field := at + "/" + escapePointer(name)
+			matched := false
+			if prop, ok := n.properties[name]; ok {
+				prop.validate(v[name], field, errs)
+				matched = true
+			}
+			for _, pp := range n.patternProperties {
+				if pp.re.MatchString(name) {
+					pp.schema.validate(v[name], field, errs)
+					matched = true
+				}
+			}
+			if !matched && n.additional != nil {
+				n.additional.validate(v[name], field, errs)
+			}
+		}
+	case []any:
+		if len(v) < n.minItems {
+			fail("must have at least %d items", n.minItems)
+		}
+		if n.maxItems >= 0 && len(v) > n.maxItems {
+			fail("must have at most %d items", n.maxItems)
+		}
+		for i, item := range v {
+			field := at + "/" + strconv.Itoa(i)
+			switch {
+			case i < len(n.prefixItems):
+				n.prefixItems[i].validate(item, field, errs)
+			case n.items != nil:
+				n.items.validate(item, field, errs)
+			}
+		}
+		if n.uniqueItems {
+		unique:
+			for i := range v {
+				for j := range i {
+					if jsonEqual(v[i], v[j]) {
+						fail("must not repeat items, but %d repeats %d", i, j)
+						break unique
+					}
+				}
+			}
+		}
+	}
+
+	for _, s := range n.allOf {
+		s.validate(v, at, errs)
+	}
+	if n.anyOf != nil && !slices.ContainsFunc(n.anyOf, func(s *schemaNode) bool { return s.matches(v) }) {
+		fail("must match at least one of the allowed schemas")
+	}
+	if n.oneOf != nil {
+		matching := 0
+		for _, s := range n.oneOf {
+			if s.matches(v) {
+				matching++
+			}
+		}
+		if matching != 1 {
+			fail("must match exactly one of the allowed schemas, but matches %d", matching)
+		}
+	}
+	if n.not != nil && n.not.matches(v) {
+		fail("must not match the disallowed schema")
+	}
+}
+
+// validateAll returns the violations of n by v.
+func (n *schemaNode) validateAll(v any) []FieldError {
+	var errs []FieldError
+	n.validate(v, "", &errs)
+	return errs
+}
+
+// matches reports whether v is valid against n.
+func (n *schemaNode) matches(v any) bool {
+	var errs []FieldError
+	n.validate(v, "", &errs)
+	return len(errs) == 0
+}
+
+// hasJSONType reports whether v, decoded from JSON, is of the schema type
+// t. Integers are numbers without a fractional part, like 1.0.
+func hasJSONType(v any, t string) bool {
+	switch v := v.(type) {
+	case nil:
+		return t == "null"
+	case bool:
+		return t == "boolean"
+	case string:
+		return t == "string"
+	case map[string]any:
+		return t == "object"
+	case []any:
+		return t == "array"
+	case json.Number:
+		if t == "number" {
+			return true
+		}
+		r, ok := new(big.Rat).SetString(string(v))
+		return t == "integer" && ok && r.IsInt()
+	}
+	return false
+}
+
+// jsonEqual reports whether the decoded JSON values a and b are equal,
+// comparing numbers by value.
+func jsonEqual(a, b any) bool {
+	switch a := a.(type) {
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		x, okA := new(big.Rat).SetString(string(a))
+		y, okB := new(big.Rat).SetString(string(b))
+		return okA && okB && x.Cmp(y) == 0
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, va := range a {
+			if vb, ok := b[k]; !ok || !jsonEqual(va, vb) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		b, ok := b.([]any)
+		return ok && slices.EqualFunc(a, b, jsonEqual)
+	}
+	return a == b
+}
+
+// jsonText returns v as JSON, for messages.
+func jsonText(v any) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// joinOr names the types "a string", "a string or null" and so on.
+func joinOr(types []string) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		switch t {
+		case "null":
+			names[i] = "null"
+		case "array", "object", "integer":
+			names[i] = "an " + t
+		default:
+			names[i] = "a " + t
+		}
+	}
+	if len(names) == 1 {
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// validFormat reports whether s has format; unknown formats are only
+// annotations.
+func validFormat(format, s string) bool {
+	switch format {
+	case "date-time":
+		_, err := time.Parse(time.RFC3339, s)
+		return err == nil
+	case "date":
+		_, err := time.Parse(time.DateOnly, s)
+		return err == nil
+	case "email":
+		addr, err := mail.ParseAddress(s)
+		return err == nil && addr.Address == s
+	case "uuid":
+		return uuidPattern.MatchString(s)
+	case "uri":
+		u, err := url.Parse(s)
+		return err == nil && u.Scheme != ""
+	case "ipv4":
+		addr, err := netip.ParseAddr(s)
+		return err == nil && addr.Is4()
+	case "ipv6":
+		addr, err := netip.ParseAddr(s)
+		return err == nil && addr.Is6()
+	}
+	return true
+}
@@ -0,0 +1,278 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWKSOptions configures NewJWKS.
+type JWKSOptions struct {
+	// RefreshInterval is how often the key set is re-fetched; defaults to
+	// one hour.
+	RefreshInterval time.Duration
+	// MinRefreshInterval rate-limits the extra fetches triggered by tokens
+	// with an unknown "kid", so forged tokens can't hammer the provider;
+	// defaults to one minute.
+	MinRefreshInterval time.Duration
+	// Client fetches the key set; defaults to a client with a 10s timeout.
+	Client *http.Client
+	// FetchTimeout bounds each fetch, whatever the Client, so a slow
+	// provider can't hold up the requests waiting for the keys; defaults
+	// to 10s.
+	FetchTimeout time.Duration
+	// Logger receives fetch failures; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// JWKS is a JSON Web Key Set fetched from a URL, typically an identity
+// provider's jwks_uri, and kept fresh in the background. When a fetch
+// fails the previous keys stay in use, so a provider outage only matters
+// for keys it rotated in during the outage.
+type JWKS struct {
+	url  string
+	opts JWKSOptions
+
+	mu      sync.RWMutex
+	keys    map[string]jwk
+	fetched time.Time
+
+	fetchMu   sync.Mutex
+	lastFetch time.Time
+}
+
+// jwk is a parsed key with the algorithm it is restricted to, if any.
+type jwk struct {
+	key any
+	alg string
+}
+
+// NewJWKS fetches the key set at url and refreshes it every
+// RefreshInterval until ctx is done. A failed initial fetch is logged
+// rather than returned, and retried when the first token arrives.
+func NewJWKS(ctx context.Context, url string, opts JWKSOptions) *JWKS {
+	if opts.RefreshInterval <= 0 {
+		opts.RefreshInterval = time.Hour
+	}
+	if opts.MinRefreshInterval <= 0 {
+		opts.MinRefreshInterval = time.Minute
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if opts.FetchTimeout <= 0 {
+		opts.FetchTimeout = 10 * time.Second
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	k := &JWKS{url: url, opts: opts}
+	k.refresh(ctx, false)
+	go k.run(ctx)
+	return k
+}
+
+// Keyfunc resolves a token's key by its "kid" header, re-fetching the set
+// once when the kid is unknown. It can be used as JWTOptions.Keyfunc, but
+// setting JWTOptions.JWKS also restricts the algorithms accordingly.
+func (k *JWKS) Keyfunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := k.lookup(kid)
+	if !ok {
+		k.refresh(context.Background(), true)
+		if key, ok = k.lookup(kid); !ok {
+			return nil, fmt.Errorf("jwks: unknown key %q", kid)
+		}
+	}
+	if key.alg != "" && key.alg != token.Method.Alg() {
+		return nil, fmt.Errorf("jwks: key %q is for %s, not %s", kid, key.alg, token.Method.Alg())
+	}
+	return key.key, nil
+}
+
+// lookup finds kid, or the only key when the token names none.
+func (k *JWKS) lookup(kid string) (jwk, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if kid == "" && len(k.keys) == 1 {
+		for _, key := range k.keys {
+			return key, true
+		}
+	}
+	key, ok := k.keys[kid]
+	return key, ok
+}
+
+func (k *JWKS) run(ctx context.Context) {
+	ticker := time.NewTicker(k.opts.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			k.refresh(ctx, false)
+		}
+	}
+}
+
+// refresh fetches the key set. With onDemand set it does nothing if a fetch
+// happened less than MinRefreshInterval ago. Concurrent callers wait for a
+// single fetch.
+func (k *JWKS) refresh(ctx context.Context, onDemand bool) {
+	k.fetchMu.Lock()
+	defer k.fetchMu.Unlock()
+	if onDemand && time.Since(k.lastFetch) < k.opts.MinRefreshInterval {
+		return
+	}
+	k.lastFetch = time.Now()
+	ctx, cancel := context.WithTimeout(ctx, k.opts.FetchTimeout)
+	defer cancel()
+	keys, err := k.fetch(ctx)
+	if err != nil {
+		k.mu.RLock()
+		fetched := k.fetched
+		k.mu.RUnlock()
+		if fetched.IsZero() {
+			k.opts.Logger.Error("jwks fetch failed, no keys available", "url", k.url, "error", err)
+		} else {
+			k.opts.Logger.Error("jwks fetch failed, keeping previous keys", "url", k.url, "error", err, "keys_age", time.Since(fetched))
+		}
+		return
+	}
+	k.mu.Lock()
+	k.keys, k.fetched = keys, time.Now()
+	k.mu.Unlock()
+}
+
+// maxJWKSSize bounds the key sets read; real ones are a few kilobytes.
+const maxJWKSSize = 1 << 20
+
+func (k *JWKS) fetch(ctx context.Context) (map[string]jwk, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := k.opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxJWKSSize {
+		return nil, fmt.Errorf("key set larger than %d bytes", maxJWKSSize)
+	}
+	var set struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]jwk, len(set.Keys))
+	for _, raw := range set.Keys {
+		kid, key, err := parseJWK(raw)
+		if err != nil {
+			// One unsupported key (e.g. an encryption key) shouldn't hide
+			// the others.
+			k.opts.Logger.Warn("jwks key skipped", "url", k.url, "kid", kid, "error", err)
+			continue
+		}
+		keys[kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no usable keys")
+	}
+	return keys, nil
+}
+
+func parseJWK(raw json.RawMessage) (string, jwk, error) {
+	var j struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		Alg string `json:"alg"`
+		Crv string `json:"crv"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+	if err := json.Unmarshal(raw, &j); err != nil {
+		return "", jwk{}, err
+	}
+	if j.Use != "" && j.Use != "sig" {
+		return j.Kid, jwk{}, fmt.Errorf("key use %q", j.Use)
+	}
+	b64 := base64.RawURLEncoding
+	switch j.Kty {
+	case "RSA":
+		n, err1 := b64.DecodeString(j.N)
+		e, err2 := b64.DecodeString(j.E)
+		if err := errors.Join(err1, err2); err != nil || len(e) > 4 {
+			return j.Kid, jwk{}, errors.New("malformed RSA key")
+		}
+		return j.Kid, jwk{alg: j.Alg, key: &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return j.Kid, jwk{}, fmt.Errorf("unsupported curve %q", j.Crv)
+		}
+		x, err1 := b64.DecodeString(j.X)
+		y, err2 := b64.DecodeString(j.Y)
+		if err := errors.Join(err1, err2); err != nil {
+			return j.Kid, jwk{}, errors.New("malformed EC key")
+		}
+		// Parse the uncompressed point so off-curve keys are rejected.
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x) > size || len(y) > size {
+			return j.Kid, jwk{}, errors.New("malformed EC key")
+		}
+		point := make([]byte, 1+2*size)
+		point[0] = 4
+		copy(point[1+size-len(x):1+size], x)
+		copy(point[1+2*size-len(y):], y)
+		key, err := ecdsa.ParseUncompressedPublicKey(curve, point)
+		if err != nil {
+			return j.Kid, jwk{}, err
+		}
+		return j.Kid, jwk{alg: j.Alg, key: key}, nil
+	case "OKP":
+		x, err := b64.DecodeString(j.X)
+		if j.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return j.Kid, jwk{}, errors.New("unsupported or malformed OKP key")
+		}
+		return j.Kid, jwk{alg: j.Alg, key: ed25519.PublicKey(x)}, nil
+	}
+	return j.Kid, jwk{}, fmt.Errorf("unsupported key type %q", j.Kty)
+}
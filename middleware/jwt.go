@@ -0,0 +1,264 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const claimsKey contextKey = "claims"
+
+// JWTOptions configures the JWT middleware. One of Key, Secrets, Keyfunc
+// or JWKS must be set.
+type JWTOptions struct {
+	// Key verifies signatures: a []byte secret for HS256/384/512, an
+	// *rsa.PublicKey for RS*/PS* or an *ecdsa.PublicKey for ES*.
+	Key any
+	// Secrets, when set, overrides Key with the HS256/384/512 secret
+	// KeySecret, looked up for every request so it can be rotated. Keyfunc
+	// and JWKS still take precedence.
+	Secrets   SecretsProvider
+	KeySecret string
+	// Keyfunc, when set, overrides Key and picks the key per token (e.g. by
+	// "kid").
+	Keyfunc jwt.Keyfunc
+	// JWKS, when set, overrides Key and Keyfunc and verifies tokens with
+	// the identity provider's published keys, following its rotations.
+	JWKS *JWKS
+	// Algorithms restricts the accepted "alg" values; defaults to the family
+	// matching Key's type, or every asymmetric algorithm with JWKS. It must
+	// be set when Keyfunc is used.
+	Algorithms []string
+	// Issuer and Audience, when set, must match the "iss" and "aud" claims.
+	Issuer   string
+	Audience string
+	// Leeway tolerates clock skew when checking exp, nbf and iat.
+	Leeway time.Duration
+	// AllowMissingExpiry accepts tokens without an "exp" claim.
+	AllowMissingExpiry bool
+	// TokenLookup extracts the raw token; defaults to the bearer token of
+	// the Authorization header.
+	TokenLookup func(r *http.Request) string
+	// Revocations, when set, is consulted for every valid token; revoked
+	// tokens are rejected and store failures answered with 503.
+	Revocations RevocationStore
+	// Logger receives rejected-token log records; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// JWT authenticates requests carrying a signed JSON Web Token. Signature,
+// algorithm, exp/nbf and the configured issuer and audience are verified;
+// failures get 401 with a WWW-Authenticate: Bearer challenge. The parsed
+// claims and derived Identity are stored in the request context. It panics
+// if the accepted algorithms are left to default with Keyfunc, or with a
+// Key of an unsupported type, as every "alg" would be accepted, and if Key
+// is the only key and empty, as anyone could sign with it.
+func JWT(opts JWTOptions) Middleware {
+	if opts.Secrets == nil && opts.Keyfunc == nil && opts.JWKS == nil {
+		if secret, ok := opts.Key.([]byte); opts.Key == nil || ok && len(secret) == 0 {
+			panic("middleware: JWT Key is empty")
+		}
+	}
+	keyfunc := opts.Keyfunc
+	if keyfunc == nil {
+		key := opts.Key
+		keyfunc = func(*jwt.Token) (any, error) { return key, nil }
+	}
+	algs := opts.Algorithms
+	if len(algs) == 0 {
+		algs = algorithmsFor(opts.Key)
+		if opts.Secrets != nil {
+			algs = []string{"HS256", "HS384", "HS512"}
+		}
+	}
+	if opts.JWKS != nil {
+		keyfunc = opts.JWKS.Keyfunc
+		if len(opts.Algorithms) == 0 {
+			algs = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+		}
+	}
+	if len(algs) == 0 {
+		if opts.Keyfunc != nil {
+			panic("middleware: JWT Algorithms must be set with Keyfunc")
+		}
+		panic(fmt.Sprintf("middleware: JWT can't verify with a Key of type %T", opts.Key))
+	}
+	parserOpts := []jwt.ParserOption{jwt.WithValidMethods(algs), jwt.WithLeeway(opts.Leeway)}
+	if opts.Issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(opts.Issuer))
+	}
+	if opts.Audience != "" {
+		parserOpts = append(parserOpts, jwt.WithAudience(opts.Audience))
+	}
+	if !opts.AllowMissingExpiry {
+		parserOpts = append(parserOpts, jwt.WithExpirationRequired())
+	}
+	parser := jwt.NewParser(parserOpts...)
+	lookup := opts.TokenLookup
+	if lookup == nil {
+		lookup = BearerToken
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			raw := lookup(r)
+			if raw == "" {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			kf := keyfunc
+			if opts.Secrets != nil && opts.JWKS == nil && opts.Keyfunc == nil {
+				key, err := opts.Secrets.Secret(r.Context(), opts.KeySecret)
+				if err == nil && len(key) == 0 {
+					// An empty HMAC key verifies tokens anyone can sign
+					err = errors.New("secret is empty")
+				}
+				if err != nil {
+					requestLogger(opts.Logger, r).LogAttrs(r.Context(), slog.LevelError, "secret lookup failed",
+						slog.String("secret", opts.KeySecret),
+						slog.String("error", err.Error()),
+					)
+					http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+					return
+				}
+				kf = func(*jwt.Token) (any, error) { return key, nil }
+			}
+			claims := jwt.MapClaims{}
+			if _, err := parser.ParseWithClaims(raw, claims, kf); err != nil {
+				requestLogger(opts.Logger, r).LogAttrs(r.Context(), slog.LevelWarn, "invalid token",
+					slog.String("path", r.URL.Path),
+					slog.String("remote_addr", r.RemoteAddr),
+					slog.String("error", err.Error()),
+				)
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="`+tokenError(err)+`"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if opts.Revocations != nil {
+				jti, _ := claims["jti"].(string)
+				sub, _ := claims["sub"].(string)
+				var issuedAt time.Time
+				if iat, err := claims.GetIssuedAt(); err == nil && iat != nil {
+					issuedAt = iat.Time
+				}
+				revoked, err := opts.Revocations.IsRevoked(r.Context(), jti, sub, issuedAt)
+				if err != nil {
+					requestLogger(opts.Logger, r).LogAttrs(r.Context(), slog.LevelError, "revocation store failed",
+						slog.String("error", err.Error()),
+					)
+					http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+					return
+				}
+				if revoked {
+					requestLogger(opts.Logger, r).LogAttrs(r.Context(), slog.LevelWarn, "revoked token",
+						slog.String("jti", jti),
+						slog.String("subject", sub),
+						slog.String("remote_addr", r.RemoteAddr),
+					)
+					w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="token revoked"`)
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+			}
+
+			ctx := context.WithValue(r.Context(), claimsKey, claims)
+			ctx = ContextWithIdentity(ctx, identityFromClaims(claims))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ClaimsFromContext returns the claims of the token verified by JWT.
+func ClaimsFromContext(ctx context.Context) (jwt.MapClaims, bool) {
+	claims, ok := ctx.Value(claimsKey).(jwt.MapClaims)
+	return claims, ok
+}
+
+// BearerToken returns the token of an "Authorization: Bearer <token>"
+// header, or "".
+func BearerToken(r *http.Request) string {
+	const prefix = "bearer "
+	h := r.Header.Get("Authorization")
+	if len(h) > len(prefix) && strings.EqualFold(h[:len(prefix)], prefix) {
+		return strings.TrimSpace(h[len(prefix):])
+	}
+	return ""
+}
+
+func algorithmsFor(key any) []string {
+	switch key.(type) {
+	case []byte:
+		return []string{"HS256", "HS384", "HS512"}
+	case *rsa.PublicKey:
+		return []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}
+	case *ecdsa.PublicKey:
+		return []string{"ES256", "ES384", "ES512"}
+	case ed25519.PublicKey:
+		return []string{"EdDSA"}
+	}
+	return nil
+}
+
+// tokenError gives a short, client-safe reason for a rejected token.
+func tokenError(err error) string {
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return "token expired"
+	case errors.Is(err, jwt.ErrTokenNotValidYet):
+		return "token not yet valid"
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		return "invalid audience"
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		return "invalid issuer"
+	case errors.Is(err, jwt.ErrTokenRequiredClaimMissing):
+		return "required claim missing"
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		return "invalid signature"
+	}
+	return "malformed token"
+}
+
+// identityFromClaims maps the conventional claims onto an Identity: "sub",
+// space-separated "scope" or list "scp", and "roles" or "role".
+func identityFromClaims(claims jwt.MapClaims) *Identity {
+	id := &Identity{Method: "jwt"}
+	id.Subject, _ = claims["sub"].(string)
+	if scope, ok := claims["scope"].(string); ok {
+		id.Scopes = strings.Fields(scope)
+	} else {
+		id.Scopes = stringList(claims["scp"])
+	}
+	id.Roles = stringList(claims["roles"])
+	if len(id.Roles) == 0 {
+		id.Roles = stringList(claims["role"])
+	}
+	return id
+}
+
+// stringList converts a claim holding a string or a list of strings.
+func stringList(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Each saturated interval sheds another tenth of a priority class; each
+// healthy one admits back half as much, so recovery doesn't overshoot.
+const (
+	loadShedIncrease = 0.1
+	loadShedDecrease = 0.05
+)
+
+// LoadShedOptions configures a LoadShedder.
+type LoadShedOptions struct {
+	// MaxInFlight is the number of concurrent requests above which the
+	// server counts as saturated; 0 disables this signal.
+	MaxInFlight int
+	// TargetLatency is the latency above which the server counts as
+	// saturated, compared with the fastest request of each Interval: when
+	// even that one is slow, requests are waiting for something shared such
+	// as CPU or a connection pool rather than being slow by nature. Defaults
+	// to 100ms; negative disables this signal.
+	TargetLatency time.Duration
+	// Interval is how often the shed fraction is adjusted; defaults to 1s.
+	Interval time.Duration
+	// Priority classifies a request from 0, shed first, to Priorities-1,
+	// shed last; defaults to 0 for every request.
+	Priority func(r *http.Request) int
+	// Priorities is the number of classes Priority returns; defaults to 1.
+	Priorities int
+	// Logger receives records when shedding starts and stops; defaults to
+	// slog.Default().
+	Logger *slog.Logger
+}
+
+// LoadShedder rejects a growing fraction of requests with 503 Service
+// Unavailable while the server is saturated, and lets them back in once it
+// recovers. Shedding starts with the lowest priority class and only moves
+// on to the next class once that one is rejected entirely. Failing some
+// requests fast keeps latency bounded for the rest, where queueing them all
+// would let it grow until every request times out.
+//
+// Long-lived requests such as event streams skew both signals, so leave
+// them out with Unless.
+type LoadShedder struct {
+	opts LoadShedOptions
+
+	mu          sync.Mutex
+	level       float64 // classes below floor(level) are shed, the next one in part
+	inFlight    int
+	windowStart time.Time
+	peak        int
+	completed   int
+	fastest     time.Duration
+}
+
+// NewLoadShedder returns a shedder admitting every request.
+func NewLoadShedder(opts LoadShedOptions) *LoadShedder {
+	if opts.TargetLatency == 0 {
+		opts.TargetLatency = 100 * time.Millisecond
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	if opts.Priority == nil {
+		opts.Priority = func(*http.Request) int { return 0 }
+	}
+	if opts.Priorities <= 0 {
+		opts.Priorities = 1
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	return &LoadShedder{opts: opts, windowStart: time.Now()}
+}
+
+// Level returns how much is being shed: the number of priority classes
+// rejected, 1.5 meaning all of class 0 and half of class 1.
+func (s *LoadShedder) Level() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.level
+}
+
+// Middleware returns the middleware applying s.
+func (s *LoadShedder) Middleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !s.admit(r) {
+				w.Header().Set("Retry-After", retryAfterSeconds(s.opts.Interval))
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			}
+			start := time.Now()
+			defer func() { s.done(time.Since(start)) }()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// admit decides whether r may proceed, counting it as in flight if so.
+func (s *LoadShedder) admit(r *http.Request) bool {
+	class := min(max(s.opts.Priority(r), 0), s.opts.Priorities-1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.adjust(r, time.Now())
+	if p := s.level - float64(class); p >= 1 || p > 0 && rand.Float64() < p {
+		return false
+	}
+	s.inFlight++
+	s.peak = max(s.peak, s.inFlight)
+	return true
+}
+
+func (s *LoadShedder) done(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inFlight--
+	if s.completed == 0 || d < s.fastest {
+		s.fastest = d
+	}
+	s.completed++
+}
+
+// adjust moves the shed level once an interval has passed. Intervals
+// without any request count as healthy. s.mu must be held.
+func (s *LoadShedder) adjust(r *http.Request, now time.Time) {
+	elapsed := now.Sub(s.windowStart)
+	if elapsed < s.opts.Interval {
+		return
+	}
+	saturated := s.opts.MaxInFlight > 0 && s.peak > s.opts.MaxInFlight ||
+		s.opts.TargetLatency > 0 && s.completed > 0 && s.fastest > s.opts.TargetLatency
+	from := s.level
+	if saturated {
+		s.level = min(s.level+loadShedIncrease, float64(s.opts.Priorities))
+	} else {
+		intervals := float64(elapsed / s.opts.Interval)
+		s.level = max(s.level-loadShedDecrease*intervals, 0)
+	}
+	// Keep repeated steps from leaving a tiny remainder behind.
+	s.level = math.Round(s.level*1000) / 1000
+	switch {
+	case from == 0 && s.level > 0:
+		s.opts.Logger.LogAttrs(r.Context(), slog.LevelWarn, "load shedding started",
+			slog.Int("in_flight", s.peak),
+			slog.Duration("fastest", s.fastest),
+		)
+	case from > 0 && s.level == 0:
+		s.opts.Logger.LogAttrs(r.Context(), slog.LevelInfo, "load shedding stopped")
+	}
+	s.windowStart = now
+	s.peak = s.inFlight
+	s.completed = 0
+	s.fastest = 0
+}
@@ -0,0 +1,224 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logFileTimeLayout stamps rotated files; it sorts chronologically and has
+// no characters file systems object to.
+const logFileTimeLayout = "2006-01-02T15-04-05.000"
+
+// LogFileOptions configures OpenLogFile.
+type LogFileOptions struct {
+	// MaxSize rotates the file before a write takes it past this many
+	// bytes; 0 means no limit.
+	MaxSize int64
+	// Interval rotates the file on the first write of every period,
+	// aligned to UTC, so 24h rotates at midnight UTC; 0 disables it.
+	Interval time.Duration
+	// Compress gzips rotated files.
+	Compress bool
+	// MaxBackups is how many rotated files are kept; 0 keeps them all.
+	MaxBackups int
+	// MaxAge removes rotated files older than this; 0 keeps them.
+	MaxAge time.Duration
+	// Logger receives compression and cleanup failures; defaults to
+	// slog.Default().
+	Logger *slog.Logger
+}
+
+// LogFile is an io.WriteCloser appending to a file that is rotated by size
+// and time, e.g. for AccessLogOptions.Output. Rotation renames the file
+// after the time it happened, so access.log becomes
+// access-2026-10-14T15-30-00.000.log, and starts a new one; rotated files
+// are compressed and pruned in the background.
+type LogFile struct {
+	path string
+	opts LogFileOptions
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+	next time.Time // when Interval rotates the file
+
+	cleanupMu sync.Mutex
+	cleanups  sync.WaitGroup
+}
+
+// OpenLogFile opens the file at path for appending, creating it if needed.
+func OpenLogFile(path string, opts LogFileOptions) (*LogFile, error) {
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	f := &LogFile{path: path, opts: opts}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open starts writing to f.path. A file left from an earlier period gets
+// rotated on the first write.
+func (f *LogFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	if f.opts.Interval > 0 {
+		start := time.Now()
+		if f.size > 0 {
+			start = info.ModTime()
+		}
+		f.next = start.Truncate(f.opts.Interval).Add(f.opts.Interval)
+	}
+	return nil
+}
+
+// Write appends p, rotating the file first if it is due.
+func (f *LogFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	full := f.opts.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.opts.MaxSize
+	if full || (!f.next.IsZero() && !time.Now().Before(f.next)) {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Rotate rotates the file now, e.g. on a signal.
+func (f *LogFile) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	return f.rotate()
+}
+
+func (f *LogFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	ext := filepath.Ext(f.path)
+	rotated := strings.TrimSuffix(f.path, ext) + "-" + time.Now().UTC().Format(logFileTimeLayout) + ext
+	// If the file was moved away, e.g. by logrotate, there is nothing to
+	// rename and a new file is started all the same
+	renamed := os.Rename(f.path, rotated) == nil
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.cleanups.Add(1)
+	go func() {
+		defer f.cleanups.Done()
+		f.cleanup(rotated, renamed)
+	}()
+	return nil
+}
+
+// cleanup compresses the file just rotated and removes the rotated files
+// beyond MaxBackups and MaxAge.
+func (f *LogFile) cleanup(rotated string, renamed bool) {
+	f.cleanupMu.Lock()
+	defer f.cleanupMu.Unlock()
+	if renamed && f.opts.Compress {
+		if err := gzipFile(rotated); err != nil {
+			f.opts.Logger.Error("log file compression failed", "path", rotated, "error", err)
+		}
+	}
+	if f.opts.MaxBackups <= 0 && f.opts.MaxAge <= 0 {
+		return
+	}
+	dir, name := filepath.Split(f.path)
+	ext := filepath.Ext(name)
+	prefix := strings.TrimSuffix(name, ext) + "-"
+	entries, err := os.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		f.opts.Logger.Error("log file cleanup failed", "path", f.path, "error", err)
+		return
+	}
+	type backup struct {
+		path string
+		time time.Time
+	}
+	var backups []backup
+	for _, e := range entries {
+		stamp, ok := strings.CutPrefix(e.Name(), prefix)
+		if !ok {
+			continue
+		}
+		stamp = strings.TrimSuffix(strings.TrimSuffix(stamp, ".gz"), ext)
+		if t, err := time.Parse(logFileTimeLayout, stamp); err == nil {
+			backups = append(backups, backup{filepath.Join(dir, e.Name()), t})
+		}
+	}
+	// Newest first
+	slices.SortFunc(backups, func(a, b backup) int { return b.time.Compare(a.time) })
+	for i, b := range backups {
+		if (f.opts.MaxBackups > 0 && i >= f.opts.MaxBackups) || (f.opts.MaxAge > 0 && time.Since(b.time) > f.opts.MaxAge) {
+			if err := os.Remove(b.path); err != nil {
+				f.opts.Logger.Error("log file removal failed", "path", b.path, "error", err)
+			}
+		}
+	}
+}
+
+// Close closes the file and waits for background compression and pruning.
+func (f *LogFile) Close() error {
+	f.mu.Lock()
+	var err error
+	if f.file != nil {
+		err = f.file.Close()
+		f.file = nil
+	}
+	f.mu.Unlock()
+	f.cleanups.Wait()
+	return err
+}
+
+// gzipFile replaces the file at path with path.gz.
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+// requestLogger returns logger (or slog.Default when nil) annotated with the
+// request ID and GeoIP country/ASN, if present in the request context.
+// Applications using zap, zerolog or similar can pass a *slog.Logger backed
+// by their own slog.Handler.
+func requestLogger(logger *slog.Logger, r *http.Request) *slog.Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	attrs := requestAttrs(nil, r)
+	if len(attrs) == 0 {
+		return logger
+	}
+	return slog.New(logger.Handler().WithAttrs(attrs))
+}
+
+// requestAttrs appends the request ID and GeoIP attributes of r to attrs.
+func requestAttrs(attrs []slog.Attr, r *http.Request) []slog.Attr {
+	if id := RequestIDFromContext(r.Context()); id != "" {
+		attrs = append(attrs, slog.String("request_id", id))
+	}
+	if geo, ok := GeoFromContext(r.Context()); ok {
+		if geo.Country != "" {
+			attrs = append(attrs, slog.String("country", geo.Country))
+		}
+		if geo.ASN != 0 {
+			attrs = append(attrs, slog.Uint64("asn", uint64(geo.ASN)))
+		}
+	}
+	return attrs
+}
+
+// attrPool recycles the attribute slices of logRequest.
+var attrPool = sync.Pool{New: func() any {
+	attrs := make([]slog.Attr, 0, 16)
+	return &attrs
+}}
+
+// logRequest is requestLogger(logger, r).LogAttrs(...) for middleware
+// logging every request: instead of deriving a logger, whose handler copies
+// and preformats its attributes, the request attributes are put in the
+// record itself from a pooled slice, and nothing is done when level is
+// disabled.
+func logRequest(logger *slog.Logger, r *http.Request, level slog.Level, msg string, attrs ...slog.Attr) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	ctx := r.Context()
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+	buf := attrPool.Get().(*[]slog.Attr)
+	all := append(requestAttrs((*buf)[:0], r), attrs...)
+	logger.LogAttrs(ctx, level, msg, all...)
+	clear(all)
+	*buf = all[:0]
+	attrPool.Put(buf)
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// LoggingOptions configures the Logging middleware.
+type LoggingOptions struct {
+	// Logger receives the log records; defaults to slog.Default().
+	Logger *slog.Logger
+	// Sample, when set, logs requests as they complete instead, with their
+	// status and duration, and only the share of them it returns for the
+	// request and status, from 0 for none to 1 for all. SampleRules builds
+	// one.
+	Sample func(r *http.Request, status int) float64
+}
+
+// Logging logs the method, path and remote address of every request as it
+// is received, or of a sample of completed requests with Sample. Sampled
+// records carry the rate they were kept at as sample_rate, to scale counts
+// back up.
+func Logging(opts LoggingOptions) Middleware {
+	return func(next http.Handler) http.Handler {
+		if opts.Sample != nil {
+			return sampledLogging(opts, next)
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logRequest(opts.Logger, r, slog.LevelInfo, "request received",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.String("remote_addr", r.RemoteAddr),
+			)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func sampledLogging(opts LoggingOptions, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec, owned := acquireResponseRecorder(w)
+		if owned {
+			defer releaseResponseRecorder(rec)
+		}
+		next.ServeHTTP(rec, r)
+
+		status := rec.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		rate, ok := logSampled(opts.Sample, r, status)
+		if !ok {
+			return
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.Int("status", status),
+			slog.Duration("duration", time.Since(start)),
+		}
+		if rate < 1 {
+			attrs = append(attrs, slog.Float64("sample_rate", rate))
+		}
+		logRequest(opts.Logger, r, slog.LevelInfo, "request served", attrs...)
+	})
+}
+
+// logSampled reports whether sample keeps the record of a request
+// answered with status, and the rate it was kept at. A nil sample keeps
+// them all.
+func logSampled(sample func(*http.Request, int) float64, r *http.Request, status int) (float64, bool) {
+	if sample == nil {
+		return 1, true
+	}
+	rate := sample(r, status)
+	return rate, sampled(rate)
+}
+
+// LogSampleRule sets the share of requests Logging keeps for those it
+// matches.
+type LogSampleRule struct {
+	// Match selects the requests, e.g. with Route; nil matches all.
+	Match Matcher
+	// MinStatus and MaxStatus bound the matched statuses, inclusive; zero
+	// leaves a bound open.
+	MinStatus int
+	MaxStatus int
+	// Rate is the share kept, from 0 to 1.
+	Rate float64
+}
+
+// SampleRules returns a LoggingOptions or TimingOptions Sample applying the first rule
+// matching a request, and keeping every request no rule matches. List the
+// rules keeping all errors first, e.g.
+//
+//	SampleRules(
+//		LogSampleRule{MinStatus: 400, Rate: 1},
+//		LogSampleRule{Match: Route("/healthz"), Rate: 0.01},
+//	)
+func SampleRules(rules ...LogSampleRule) func(r *http.Request, status int) float64 {
+	return func(r *http.Request, status int) float64 {
+		for _, rule := range rules {
+			if rule.MinStatus > 0 && status < rule.MinStatus || rule.MaxStatus > 0 && status > rule.MaxStatus {
+				continue
+			}
+			if rule.Match == nil || rule.Match(r) {
+				return rule.Rate
+			}
+		}
+		return 1
+	}
+}
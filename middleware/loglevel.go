@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// LogLevel is a log level that can be changed while serving, such as
+// *slog.LevelVar.
+type LogLevel interface {
+	Level() slog.Level
+	Set(slog.Level)
+}
+
+// LogLevelHandlerOptions configures LogLevelHandler.
+type LogLevelHandlerOptions struct {
+	// Logger receives a record of every change; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// LogLevelHandler is an admin endpoint for level. GET answers with the
+// level as JSON, e.g. {"level": "INFO"}, and PUT or POST sets it from a body such
+// as {"level": "debug"}, answering with the new level. Like
+// Registry.Handler it must be mounted behind admin-only middleware.
+func LogLevelHandler(level LogLevel, opts LogLevelHandlerOptions) http.Handler {
+	type body struct {
+		Level *slog.Level `json:"level"`
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			current := level.Level()
+			writeJSON(w, http.StatusOK, body{&current})
+			return
+		case http.MethodPut, http.MethodPost:
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT, POST")
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		var req body
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Level == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": `body must give a "level" such as "debug" or "warn"`})
+			return
+		}
+		previous := level.Level()
+		level.Set(*req.Level)
+		attrs := []slog.Attr{
+			slog.String("from", previous.String()),
+			slog.String("to", req.Level.String()),
+		}
+		if id, ok := IdentityFromContext(r.Context()); ok {
+			attrs = append(attrs, slog.String("changed_by", id.Subject))
+		}
+		requestLogger(opts.Logger, r).LogAttrs(r.Context(), slog.LevelInfo, "log level changed", attrs...)
+		current := level.Level()
+		writeJSON(w, http.StatusOK, body{&current})
+	})
+}
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+)
+
+// MaintenanceOptions configures a Maintenance.
+type MaintenanceOptions struct {
+	// RetryAfter tells clients when to come back; defaults to 5m.
+	RetryAfter time.Duration
+	// Allow lets matching requests through, e.g. the admin API, so
+	// maintenance mode can be turned off again.
+	Allow Matcher
+	// Enabled starts in maintenance mode; otherwise it waits for Enable.
+	Enabled bool
+}
+
+// Maintenance answers requests with 503 Service Unavailable and a
+// Retry-After while switched on, e.g. during a data migration, instead of
+// letting them fail in unpredictable ways. Health probes answered in front
+// of it keep the instance in rotation.
+type Maintenance struct {
+	runtimeSwitch
+	opts MaintenanceOptions
+}
+
+// NewMaintenance returns a maintenance mode switch.
+func NewMaintenance(opts MaintenanceOptions) *Maintenance {
+	if opts.RetryAfter <= 0 {
+		opts.RetryAfter = 5 * time.Minute
+	}
+	if opts.Allow == nil {
+		opts.Allow = func(*http.Request) bool { return false }
+	}
+	m := &Maintenance{opts: opts}
+	m.enabled.Store(opts.Enabled)
+	return m
+}
+
+// Middleware returns the middleware applying m.
+func (m *Maintenance) Middleware() Middleware {
+	retryAfter := retryAfterSeconds(m.opts.RetryAfter)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !m.Enabled() || m.opts.Allow(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Retry-After", retryAfter)
+			http.Error(w, "Down for maintenance", http.StatusServiceUnavailable)
+		})
+	}
+}
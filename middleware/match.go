@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Matcher reports whether a request should be handled by a conditional
+// middleware. Any func(*http.Request) bool can be used as a Matcher.
+type Matcher func(r *http.Request) bool
+
+// When runs mw only for requests matching m; all other requests go straight
+// to the next handler.
+func When(m Matcher, mw Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		wrapped := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if m(r) {
+				wrapped.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Unless runs mw for every request except those matching m, e.g.
+//
+//	middleware.Unless(middleware.Path("/healthz", "/login"), middleware.Authentication(token))
+func Unless(m Matcher, mw Middleware) Middleware {
+	return When(Not(m), mw)
+}
+
+// Path matches requests whose URL path is exactly one of paths.
+func Path(paths ...string) Matcher {
+	set := make(map[string]struct{}, len(paths))
+	for _, p := range paths {
+		set[p] = struct{}{}
+	}
+	return func(r *http.Request) bool {
+		_, ok := set[r.URL.Path]
+		return ok
+	}
+}
+
+// PathPrefix matches requests whose URL path starts with any of prefixes.
+func PathPrefix(prefixes ...string) Matcher {
+	return func(r *http.Request) bool {
+		for _, p := range prefixes {
+			if strings.HasPrefix(r.URL.Path, p) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// PathRegexp matches requests whose URL path matches expr. It panics if expr
+// does not compile, so it is meant to be called while building the chain.
+func PathRegexp(expr string) Matcher {
+	re := regexp.MustCompile(expr)
+	return func(r *http.Request) bool {
+		return re.MatchString(r.URL.Path)
+	}
+}
+
+// Route matches requests whose mux route has one of the path templates,
+// e.g. "/items/{id}". It only matches inside the router, i.e. for
+// middleware added with Router.Use.
+func Route(templates ...string) Matcher {
+	return func(r *http.Request) bool {
+		return slices.Contains(templates, routeTemplate(r))
+	}
+}
+
+// Methods matches requests using any of methods (case-insensitive).
+func Methods(methods ...string) Matcher {
+	return func(r *http.Request) bool {
+		for _, m := range methods {
+			if strings.EqualFold(r.Method, m) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Header matches requests carrying header name, with one of values if any
+// are given.
+func Header(name string, values ...string) Matcher {
+	return func(r *http.Request) bool {
+		v := r.Header.Get(name)
+		if len(values) == 0 {
+			return v != ""
+		}
+		return slices.Contains(values, v)
+	}
+}
+
+// Not inverts m.
+func Not(m Matcher) Matcher {
+	return func(r *http.Request) bool {
+		return !m(r)
+	}
+}
+
+// Any matches when at least one of ms matches.
+func Any(ms ...Matcher) Matcher {
+	return func(r *http.Request) bool {
+		for _, m := range ms {
+			if m(r) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// All matches when every one of ms matches.
+func All(ms ...Matcher) Matcher {
+	return func(r *http.Request) bool {
+		for _, m := range ms {
+			if !m(r) {
+				return false
+			}
+		}
+		return true
+	}
+}
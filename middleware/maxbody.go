@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+)
+
+// MaxBody limits request bodies to n bytes. Reads beyond the limit fail with
+// *http.MaxBytesError, and if the handler had not started its response yet
+// the client gets 413 Request Entity Too Large with a JSON error instead of
+// whatever the handler writes afterwards.
+//
+// MaxBody may be nested: an inner MaxBody, e.g. on an upload route, replaces
+// the limit of an outer one instead of stacking, so it can raise it.
+func MaxBody(n int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if lb, ok := r.Body.(*limitedBody); ok {
+				lb.limit = n
+				next.ServeHTTP(w, r)
+				return
+			}
+			if r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+			lb := &limitedBody{rc: r.Body, limit: n, contentLength: r.ContentLength}
+			r.Body = lb
+			next.ServeHTTP(&limitedBodyWriter{ResponseWriter: w, body: lb}, r)
+		})
+	}
+}
+
+// limitedBody is like http.MaxBytesReader but with an adjustable limit and
+// a record of whether it was exceeded.
+type limitedBody struct {
+	rc            io.ReadCloser
+	limit         int64
+	read          int64
+	contentLength int64
+	exceeded      bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.exceeded || b.contentLength > b.limit {
+		b.exceeded = true
+		return 0, &http.MaxBytesError{Limit: b.limit}
+	}
+	// Read one byte past the limit to tell "exactly n" from "more than n".
+	if room := b.limit - b.read + 1; int64(len(p)) > room {
+		p = p[:room]
+	}
+	n, err := b.rc.Read(p)
+	b.read += int64(n)
+	if b.read > b.limit {
+		b.exceeded = true
+		return n - int(b.read-b.limit), &http.MaxBytesError{Limit: b.limit}
+	}
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	return b.rc.Close()
+}
+
+// limitedBodyWriter replaces the handler's response with a 413 when the
+// body limit was hit before the response started.
+type limitedBodyWriter struct {
+	http.ResponseWriter
+	body     *limitedBody
+	started  bool
+	replaced bool
+}
+
+func (w *limitedBodyWriter) WriteHeader(code int) {
+	if w.started || (code >= 100 && code < 200) {
+		if !w.replaced {
+			w.ResponseWriter.WriteHeader(code)
+		}
+		return
+	}
+	w.started = true
+	if w.body.exceeded {
+		w.replaced = true
+		w.Header().Del("Content-Length")
+		w.Header().Set("Connection", "close")
+		writeJSON(w.ResponseWriter, http.StatusRequestEntityTooLarge, map[string]any{
+			"error": "request body too large",
+			"limit": w.body.limit,
+		})
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *limitedBodyWriter) Write(p []byte) (int, error) {
+	if !w.started {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.replaced {
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *limitedBodyWriter) Flush() {
+	if !w.started {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.replaced {
+		f.Flush()
+	}
+}
+
+func (w *limitedBodyWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (w *limitedBodyWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MetricsSink receives the metrics emitted by the Metrics middleware. Tags
+// are "key:value" pairs; backends without tag support may drop them.
+type MetricsSink interface {
+	Count(name string, value int64, tags []string)
+	Timing(name string, d time.Duration, tags []string)
+	Gauge(name string, value float64, tags []string)
+}
+
+// NopSink discards all metrics.
+type NopSink struct{}
+
+func (NopSink) Count(string, int64, []string)          {}
+func (NopSink) Timing(string, time.Duration, []string) {}
+func (NopSink) Gauge(string, float64, []string)        {}
+
+// MetricsOptions configures the Metrics middleware.
+type MetricsOptions struct {
+	// Sink receives the metrics; defaults to NopSink.
+	Sink MetricsSink
+	// Prefix is prepended to every metric name; defaults to "http.".
+	Prefix string
+}
+
+// Metrics emits a request counter, a latency timing and an in-flight gauge
+// for every request, tagged with method, route and status. The route is the
+// path template of the mux route (e.g. "/users/{id}"), so every user shares
+// one series; requests not routed by mux, as when the middleware is not
+// added with Router.Use, are tagged "route:unmatched" rather than by their
+// path. With an ExemplarSink the timings of requests traced by Tracing,
+// which must run first, carry their trace as an exemplar.
+func Metrics(opts MetricsOptions) Middleware {
+	sink := opts.Sink
+	if sink == nil {
+		sink = NopSink{}
+	}
+	prefix := opts.Prefix
+	if prefix == "" {
+		prefix = "http."
+	}
+	requests := prefix + "requests"
+	duration := prefix + "request.duration"
+	inFlight := prefix + "requests.in_flight"
+	var active gauge
+	exemplars, _ := sink.(ExemplarSink)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sink.Gauge(inFlight, float64(active.add(1)), nil)
+			rec, owned := acquireResponseRecorder(w)
+			if owned {
+				defer releaseResponseRecorder(rec)
+			}
+			defer func() {
+				sink.Gauge(inFlight, float64(active.add(-1)), nil)
+				status := rec.Status()
+				if status == 0 {
+					status = http.StatusOK
+				}
+				route := routeTemplate(r)
+				if route == "" {
+					route = "unmatched"
+				}
+				tags := []string{
+					"method:" + r.Method,
+					"route:" + route,
+					"status:" + strconv.Itoa(status),
+					"status_class:" + strconv.Itoa(status/100) + "xx",
+				}
+				sink.Count(requests, 1, tags)
+				if sc := trace.SpanContextFromContext(r.Context()); exemplars != nil && sc.IsSampled() {
+					exemplars.TimingExemplar(duration, time.Since(start), tags, sc.TraceID().String(), sc.SpanID().String())
+				} else {
+					sink.Timing(duration, time.Since(start), tags)
+				}
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
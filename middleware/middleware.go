@@ -0,0 +1,12 @@
+// Package middleware provides reusable net/http middlewares that can be
+// plugged into any router accepting func(http.Handler) http.Handler,
+// including gorilla/mux's Router.Use.
+package middleware
+
+import "net/http"
+
+// Middleware wraps an http.Handler with additional behaviour. It is an alias
+// so values can be passed straight to mux.Router.Use.
+type Middleware = func(http.Handler) http.Handler
+
+type contextKey string
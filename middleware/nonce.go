@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// NonceStore remembers nonces for a while. Add records nonce for ttl and
+// reports whether it was not seen before; it must be atomic so concurrent
+// replays on different replicas can't both succeed (see package redisstore).
+type NonceStore interface {
+	Add(ctx context.Context, nonce string, ttl time.Duration) (bool, error)
+}
+
+// MemoryNonceStore is a NonceStore for a single instance.
+type MemoryNonceStore struct {
+	cache *replayCache
+}
+
+// NewMemoryNonceStore returns an empty in-memory store.
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{cache: newReplayCache()}
+}
+
+// Add implements NonceStore.
+func (s *MemoryNonceStore) Add(_ context.Context, nonce string, ttl time.Duration) (bool, error) {
+	return s.cache.add(nonce, time.Now().Add(ttl)), nil
+}
+
+// NonceOptions configures the Nonce middleware.
+type NonceOptions struct {
+	// Header carries the nonce; defaults to X-Nonce.
+	Header string
+	// Window is how long a nonce is remembered; defaults to 5 minutes. When
+	// requests are also timestamped, as for Signature, it should be at least
+	// twice the accepted skew, which applies on either side of now, so a
+	// nonce can't be replayed once forgotten.
+	Window time.Duration
+	// Store keeps seen nonces; defaults to NewMemoryNonceStore().
+	Store NonceStore
+	// Scope namespaces nonces, e.g. per API key, so clients can't collide
+	// with each other; defaults to one namespace for everyone.
+	Scope func(r *http.Request) string
+	// FailOpen lets requests through when the store fails. By default they
+	// are rejected with 503.
+	FailOpen bool
+	// Logger receives rejection records; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Nonce requires every request to carry a unique nonce of 16 to 128
+// printable characters and rejects repeats within the window with 401. On
+// signed calls the signature must cover the nonce, or a captured request
+// could be replayed with a fresh one.
+func Nonce(opts NonceOptions) Middleware {
+	header := opts.Header
+	if header == "" {
+		header = "X-Nonce"
+	}
+	window := opts.Window
+	if window <= 0 {
+		window = 5 * time.Minute
+	}
+	store := opts.Store
+	if store == nil {
+		store = NewMemoryNonceStore()
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reject := func(reason string) {
+				requestLogger(opts.Logger, r).LogAttrs(r.Context(), slog.LevelWarn, "invalid nonce",
+					slog.String("reason", reason),
+					slog.String("path", r.URL.Path),
+					slog.String("remote_addr", r.RemoteAddr),
+				)
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			}
+
+			nonce := r.Header.Get(header)
+			if len(nonce) < 16 || !validRequestID(nonce) {
+				reject("missing or malformed nonce")
+				return
+			}
+			key := nonce
+			if opts.Scope != nil {
+				key = opts.Scope(r) + ":" + nonce
+			}
+			fresh, err := store.Add(r.Context(), key, window)
+			if err != nil {
+				requestLogger(opts.Logger, r).LogAttrs(r.Context(), slog.LevelError, "nonce store failed",
+					slog.String("error", err.Error()),
+				)
+				if !opts.FailOpen {
+					http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+					return
+				}
+			} else if !fresh {
+				reject("replayed nonce")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// OIDCOptions configures an OpenID Connect relying party.
+type OIDCOptions struct {
+	// IssuerURL is the provider's issuer, e.g. https://accounts.google.com
+	// or https://keycloak.example.com/realms/main. Endpoints and signing
+	// keys are discovered from it.
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the absolute callback URL registered with the
+	// provider; its path is served by the middleware.
+	RedirectURL string
+	// Scopes requested in addition to "openid"; defaults to profile, email.
+	Scopes []string
+	// LogoutPath clears the session; defaults to /auth/logout.
+	LogoutPath string
+	// CookieSecret signs the session and login-state cookies; at least 32
+	// random bytes.
+	CookieSecret []byte
+	// CookieName of the session cookie; defaults to "oidc_session".
+	CookieName string
+	// SessionTTL is the session lifetime; defaults to 8h.
+	SessionTTL time.Duration
+	// InsecureCookies drops the Secure attribute, for local HTTP testing.
+	InsecureCookies bool
+	// Client makes the discovery, key and token requests; defaults to
+	// http.DefaultClient. With a Transport the code exchange carries the
+	// ID of the callback request.
+	Client *http.Client
+	// Logger receives login failure records; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// OIDCUser is the authenticated user kept in the session cookie.
+type OIDCUser struct {
+	Subject string    `json:"sub"`
+	Email   string    `json:"email,omitempty"`
+	Name    string    `json:"name,omitempty"`
+	Expires time.Time `json:"exp"`
+}
+
+type oidcLoginState struct {
+	State    string `json:"state"`
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+	ReturnTo string `json:"return_to"`
+}
+
+const (
+	oidcUserKey        contextKey = "oidcUser"
+	oidcStateCookie               = "oidc_state"
+	oidcStateCookieTTL            = 10 * time.Minute
+)
+
+// OIDC implements the authorization-code flow with state, nonce and PKCE.
+type OIDC struct {
+	opts         OIDCOptions
+	oauth        oauth2.Config
+	verifier     *oidc.IDTokenVerifier
+	callbackPath string
+}
+
+// NewOIDC discovers the provider configuration from opts.IssuerURL.
+func NewOIDC(ctx context.Context, opts OIDCOptions) (*OIDC, error) {
+	if len(opts.CookieSecret) < 32 {
+		return nil, errors.New("middleware: OIDC cookie secret must be at least 32 bytes")
+	}
+	redirect, err := url.Parse(opts.RedirectURL)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Client != nil {
+		ctx = oidc.ClientContext(ctx, opts.Client)
+	}
+	provider, err := oidc.NewProvider(ctx, opts.IssuerURL)
+	if err != nil {
+		return nil, err
+	}
+	if opts.LogoutPath == "" {
+		opts.LogoutPath = "/auth/logout"
+	}
+	if opts.CookieName == "" {
+		opts.CookieName = "oidc_session"
+	}
+	if opts.SessionTTL <= 0 {
+		opts.SessionTTL = 8 * time.Hour
+	}
+	scopes := opts.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"profile", "email"}
+	}
+	return &OIDC{
+		opts: opts,
+		oauth: oauth2.Config{
+			ClientID:     opts.ClientID,
+			ClientSecret: opts.ClientSecret,
+			RedirectURL:  opts.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       append([]string{oidc.ScopeOpenID}, scopes...),
+		},
+		verifier:     provider.Verifier(&oidc.Config{ClientID: opts.ClientID}),
+		callbackPath: redirect.Path,
+	}, nil
+}
+
+// Middleware serves the callback and logout paths and requires a session
+// for everything else. Unauthenticated browser navigations (GET/HEAD) are
+// redirected to the provider; other requests get 401. The user is available
+// through OIDCUserFromContext and as an Identity with Method "oidc".
+func (o *OIDC) Middleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case o.callbackPath:
+				o.callback(w, r)
+				return
+			case o.opts.LogoutPath:
+				o.setCookie(w, o.opts.CookieName, "", -1)
+				http.Redirect(w, r, "/", http.StatusFound)
+				return
+			}
+
+			if user, ok := o.session(r); ok {
+				ctx := context.WithValue(r.Context(), oidcUserKey, user)
+				ctx = ContextWithIdentity(ctx, &Identity{Subject: user.Subject, Method: "oidc"})
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			o.login(w, r)
+		})
+	}
+}
+
+// OIDCUserFromContext returns the user authenticated by OIDC.Middleware.
+func OIDCUserFromContext(ctx context.Context) (*OIDCUser, bool) {
+	user, ok := ctx.Value(oidcUserKey).(*OIDCUser)
+	return user, ok
+}
+
+func (o *OIDC) session(r *http.Request) (*OIDCUser, bool) {
+	c, err := r.Cookie(o.opts.CookieName)
+	if err != nil {
+		return nil, false
+	}
+	var user OIDCUser
+	if verifyValue(o.opts.CookieSecret, o.opts.CookieName, c.Value, &user) != nil || time.Now().After(user.Expires) {
+		return nil, false
+	}
+	return &user, true
+}
+
+func (o *OIDC) login(w http.ResponseWriter, r *http.Request) {
+	st := oidcLoginState{
+		State:    randomToken(),
+		Verifier: oauth2.GenerateVerifier(),
+		Nonce:    randomToken(),
+		ReturnTo: r.URL.RequestURI(),
+	}
+	value, err := signValue(o.opts.CookieSecret, oidcStateCookie, st)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	o.setCookie(w, oidcStateCookie, value, int(oidcStateCookieTTL.Seconds()))
+	authURL := o.oauth.AuthCodeURL(st.State, oauth2.S256ChallengeOption(st.Verifier), oidc.Nonce(st.Nonce))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (o *OIDC) callback(w http.ResponseWriter, r *http.Request) {
+	fail := func(msg string, err error) {
+		attrs := []slog.Attr{slog.String("reason", msg)}
+		if err != nil {
+			attrs = append(attrs, slog.String("error", err.Error()))
+		}
+		requestLogger(o.opts.Logger, r).LogAttrs(r.Context(), slog.LevelWarn, "oidc login failed", attrs...)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	}
+
+	c, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		fail("missing state cookie", err)
+		return
+	}
+	o.setCookie(w, oidcStateCookie, "", -1)
+	var st oidcLoginState
+	if err := verifyValue(o.opts.CookieSecret, oidcStateCookie, c.Value, &st); err != nil {
+		fail("invalid state cookie", err)
+		return
+	}
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		fail("provider error: "+e, nil)
+		return
+	}
+	if q.Get("state") == "" || q.Get("state") != st.State {
+		fail("state mismatch", nil)
+		return
+	}
+
+	ctx := r.Context()
+	if o.opts.Client != nil {
+		ctx = oidc.ClientContext(ctx, o.opts.Client)
+	}
+	token, err := o.oauth.Exchange(ctx, q.Get("code"), oauth2.VerifierOption(st.Verifier))
+	if err != nil {
+		fail("code exchange", err)
+		return
+	}
+	rawID, ok := token.Extra("id_token").(string)
+	if !ok {
+		fail("no id_token in token response", nil)
+		return
+	}
+	idToken, err := o.verifier.Verify(ctx, rawID)
+	if err != nil {
+		fail("id token verification", err)
+		return
+	}
+	if idToken.Nonce != st.Nonce {
+		fail("nonce mismatch", nil)
+		return
+	}
+	var claims struct {
+		Email string `json:"email"`
+		Name  string `json:"name"`
+	}
+	idToken.Claims(&claims)
+
+	user := OIDCUser{Subject: idToken.Subject, Email: claims.Email, Name: claims.Name, Expires: time.Now().Add(o.opts.SessionTTL)}
+	value, err := signValue(o.opts.CookieSecret, o.opts.CookieName, user)
+	if err != nil {
+		fail("session encoding", err)
+		return
+	}
+	o.setCookie(w, o.opts.CookieName, value, int(o.opts.SessionTTL.Seconds()))
+
+	// Only ever redirect to a local path to avoid an open redirect.
+	returnTo := st.ReturnTo
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") {
+		returnTo = "/"
+	}
+	http.Redirect(w, r, returnTo, http.StatusFound)
+}
+
+func (o *OIDC) setCookie(w http.ResponseWriter, name, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   !o.opts.InsecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// randomToken returns 32 random bytes, base64url encoded.
+func randomToken() string {
+	var b [32]byte
+	rand.Read(b[:])
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
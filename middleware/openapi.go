@@ -0,0 +1,718 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// OpenAPIMode selects what happens to requests and responses that don't
+// match the description.
+type OpenAPIMode string
+
+const (
+	// OpenAPIStrict rejects invalid requests and replaces invalid responses
+	// with 500 Internal Server Error, for tests and CI.
+	OpenAPIStrict OpenAPIMode = "strict"
+	// OpenAPILogOnly logs violations and serves requests and responses as
+	// they are, for rolling a description out against real traffic.
+	OpenAPILogOnly OpenAPIMode = "log"
+)
+
+// OpenAPI is a compiled OpenAPI 3.0 or 3.1 description. Schemas are
+// compiled as by CompileJSONSchema, in the dialect of the version, and may
+// refer to anything in the description, e.g. "#/components/schemas/Item";
+// parameters, request bodies, responses and path items may be references
+// too. Paths are relative to the path of the first server URL, if any.
+type OpenAPI struct {
+	base  string
+	paths []*apiPath // concrete paths before templated ones
+}
+
+type apiPath struct {
+	template   string
+	re         *regexp.Regexp
+	names      []string // of the path parameters, in order
+	operations map[string]*apiOperation
+}
+
+type apiOperation struct {
+	name      string // operationId, or method and path template
+	params    []apiParam
+	body      *apiBody
+	responses map[string]*apiResponse // by status code, range like "2XX" or "default"
+}
+
+type apiParam struct {
+	name, in string
+	required bool
+	schema   *schemaNode // nil accepts any value
+}
+
+type apiBody struct {
+	required bool
+	content  map[string]*schemaNode // by media type or range, nil accepting any body
+}
+
+type apiResponse struct {
+	content map[string]*schemaNode // as apiBody.content, nil for no body
+}
+
+// NewOpenAPI compiles the description in data, in YAML or JSON.
+func NewOpenAPI(data []byte) (*OpenAPI, error) {
+	var raw any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("openapi: %w", err)
+	}
+	// Through JSON, so numbers are json.Number as Validate expects and
+	// response codes, which YAML reads as integers, strings
+	normalized, err := json.Marshal(stringKeys(raw))
+	if err != nil {
+		return nil, fmt.Errorf("openapi: %w", err)
+	}
+	doc, err := decodeJSON(normalized)
+	if err != nil {
+		return nil, fmt.Errorf("openapi: %w", err)
+	}
+	root, _ := doc.(map[string]any)
+	version, _ := root["openapi"].(string)
+	if !strings.HasPrefix(version, "3.") {
+		return nil, fmt.Errorf("openapi: unsupported version %q", version)
+	}
+	c := &schemaCompiler{doc: doc, nodes: map[string]*schemaNode{}, openAPI30: strings.HasPrefix(version, "3.0.")}
+	api := &OpenAPI{}
+	if servers, _ := root["servers"].([]any); len(servers) > 0 {
+		server, _ := servers[0].(map[string]any)
+		rawURL, _ := server["url"].(string)
+		if u, err := url.Parse(rawURL); err == nil {
+			api.base = strings.TrimSuffix(u.Path, "/")
+		}
+	}
+	paths, _ := root["paths"].(map[string]any)
+	for _, template := range slices.Sorted(maps.Keys(paths)) {
+		p, err := c.apiPath(template, paths[template])
+		if err != nil {
+			return nil, err
+		}
+		api.paths = append(api.paths, p)
+	}
+	if err := c.checkLoops(); err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(api.paths, func(a, b *apiPath) int { return len(a.names) - len(b.names) })
+	return api, nil
+}
+
+// LoadOpenAPI compiles the description in the file at path.
+func LoadOpenAPI(path string) (*OpenAPI, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	api, err := NewOpenAPI(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return api, nil
+}
+
+// stringKeys converts the maps YAML decodes with keys other than strings
+// to maps with string keys.
+func stringKeys(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, item := range v {
+			v[k] = stringKeys(item)
+		}
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, item := range v {
+			m[fmt.Sprint(k)] = stringKeys(item)
+		}
+		return m
+	case []any:
+		for i, item := range v {
+			v[i] = stringKeys(item)
+		}
+	}
+	return v
+}
+
+// deref follows the references of the object v at at, returning it and
+// where it is.
+func (c *schemaCompiler) deref(v any, at string) (map[string]any, string, error) {
+	for range 10 {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil, at, fmt.Errorf("openapi: %s: must be an object", at)
+		}
+		ref, ok := obj["$ref"].(string)
+		if !ok {
+			return obj, at, nil
+		}
+		if v, ok = resolvePointer(c.doc, ref); !ok {
+			return nil, at, fmt.Errorf("openapi: %s: %q does not exist", at, ref)
+		}
+		at = ref
+	}
+	return nil, at, fmt.Errorf("openapi: %s: too many references", at)
+}
+
+var apiMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+func (c *schemaCompiler) apiPath(template string, v any) (*apiPath, error) {
+	item, at, err := c.deref(v, "#/paths/"+escapePointer(template))
+	if err != nil {
+		return nil, err
+	}
+	p := &apiPath{template: template, operations: map[string]*apiOperation{}}
+	expr, rest := "^", template
+	for {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			break
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("openapi: path %s: unclosed parameter", template)
+		}
+		expr += regexp.QuoteMeta(rest[:open]) + "([^/]+)"
+		p.names = append(p.names, rest[open+1:open+end])
+		rest = rest[open+end+1:]
+	}
+	p.re = regexp.MustCompile(expr + regexp.QuoteMeta(rest) + "$")
+	shared, err := c.apiParams(item["parameters"], at+"/parameters")
+	if err != nil {
+		return nil, err
+	}
+	for _, method := range apiMethods {
+		if _, ok := item[method]; !ok {
+			continue
+		}
+		op, err := c.apiOperation(strings.ToUpper(method)+" "+template, item[method], at+"/"+method, shared)
+		if err != nil {
+			return nil, err
+		}
+		p.operations[strings.ToUpper(method)] = op
+	}
+	return p, nil
+}
+
+func (c *schemaCompiler) apiOperation(name string, v any, at string, shared []apiParam) (*apiOperation, error) {
+	obj, at, err := c.deref(v, at)
+	if err != nil {
+		return nil, err
+	}
+	op := &apiOperation{name: name}
+	if id, ok := obj["operationId"].(string); ok {
+		op.name = id
+	}
+	own, err := c.apiParams(obj["parameters"], at+"/parameters")
+	if err != nil {
+		return nil, err
+	}
+	// Parameters of the operation override those of the path
+	for _, p := range shared {
+		if !slices.ContainsFunc(own, func(o apiParam) bool { return o.name == p.name && o.in == p.in }) {
+			op.params = append(op.params, p)
+		}
+	}
+	op.params = append(op.params, own...)
+	if body, ok := obj["requestBody"]; ok {
+		bodyObj, bodyAt, err := c.deref(body, at+"/requestBody")
+		if err != nil {
+			return nil, err
+		}
+		op.body = &apiBody{}
+		op.body.required, _ = bodyObj["required"].(bool)
+		if op.body.content, err = c.apiContent(bodyObj["content"], bodyAt+"/content"); err != nil {
+			return nil, err
+		}
+	}
+	if responses, ok := obj["responses"].(map[string]any); ok {
+		op.responses = map[string]*apiResponse{}
+		for code, resp := range responses {
+			respObj, respAt, err := c.deref(resp, at+"/responses/"+code)
+			if err != nil {
+				return nil, err
+			}
+			content, err := c.apiContent(respObj["content"], respAt+"/content")
+			if err != nil {
+				return nil, err
+			}
+			if code != "default" {
+				code = strings.ToUpper(code) // of ranges like "2xx"
+			}
+			op.responses[code] = &apiResponse{content: content}
+		}
+	}
+	return op, nil
+}
+
+func (c *schemaCompiler) apiParams(v any, at string) ([]apiParam, error) {
+	items, _ := v.([]any)
+	var params []apiParam
+	for i, item := range items {
+		obj, paramAt, err := c.deref(item, at+"/"+strconv.Itoa(i))
+		if err != nil {
+			return nil, err
+		}
+		p := apiParam{}
+		p.name, _ = obj["name"].(string)
+		p.in, _ = obj["in"].(string)
+		p.required, _ = obj["required"].(bool)
+		switch {
+		case p.name == "":
+			return nil, fmt.Errorf("openapi: %s: parameter without name", paramAt)
+		case !slices.Contains([]string{"path", "query", "header", "cookie"}, p.in):
+			return nil, fmt.Errorf("openapi: %s: unknown location %q", paramAt, p.in)
+		}
+		if schema, ok := obj["schema"]; ok {
+			if p.schema, err = c.compile(schema, paramAt+"/schema"); err != nil {
+				return nil, err
+			}
+		}
+		params = append(params, p)
+	}
+	return params, nil
+}
+
+func (c *schemaCompiler) apiContent(v any, at string) (map[string]*schemaNode, error) {
+	media, ok := v.(map[string]any)
+	if !ok {
+		return nil, nil
+	}
+	content := make(map[string]*schemaNode, len(media))
+	for mediaType, item := range media {
+		obj, _ := item.(map[string]any)
+		content[strings.ToLower(mediaType)] = nil
+		if schema, ok := obj["schema"]; ok {
+			node, err := c.compile(schema, at+"/"+escapePointer(mediaType)+"/schema")
+			if err != nil {
+				return nil, err
+			}
+			content[strings.ToLower(mediaType)] = node
+		}
+	}
+	return content, nil
+}
+
+// OpenAPIOptions configures OpenAPI.Middleware.
+type OpenAPIOptions struct {
+	// Mode defaults to OpenAPIStrict.
+	Mode OpenAPIMode
+	// Responses validates responses too, which are buffered for it.
+	Responses bool
+	// MaxBody is the largest request body validated, as it is read whole;
+	// defaults to 1 MiB. Larger ones are passed on unvalidated.
+	MaxBody int64
+	// MaxResponseSize is the largest response body validated; defaults to
+	// 1 MiB. Of larger and flushed responses only the status and
+	// Content-Type are.
+	MaxResponseSize int64
+	// Logger receives a record of every invalid request and response;
+	// defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Middleware validates the path, query, header and cookie parameters and
+// the body of the requests for paths of the description, and with
+// Responses set the status, Content-Type and body of their responses.
+// Requests for other paths pass. Bodies are validated if they are JSON
+// (application/json or +json), and of other types only the media type.
+//
+// In strict mode a request for an undocumented method gets 405 Method Not
+// Allowed, one with an undocumented Content-Type 415 Unsupported Media
+// Type, invalid parameters or a body that doesn't parse 400 Bad Request
+// and a body violating its schema 422 Unprocessable Entity, each with
+// every violation:
+//
+//	{"error": "request does not match the API description", "errors": [{"in": "query", "field": "limit", "message": "must be an integer"}]}
+//
+// Install it after Compress, so it sees the responses of the handlers.
+func (api *OpenAPI) Middleware(opts OpenAPIOptions) Middleware {
+	if opts.Mode == "" {
+		opts.Mode = OpenAPIStrict
+	}
+	if opts.MaxBody <= 0 {
+		opts.MaxBody = 1 << 20
+	}
+	if opts.MaxResponseSize <= 0 {
+		opts.MaxResponseSize = 1 << 20
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path, vars := api.match(r)
+			if path == nil || isUpgrade(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			op := path.operations[r.Method]
+			if op == nil && r.Method == http.MethodHead {
+				op = path.operations[http.MethodGet]
+			}
+			if op == nil {
+				allow := slices.Sorted(maps.Keys(path.operations))
+				errs := []FieldError{{In: "method", Message: r.Method + " " + path.template + " is not documented"}}
+				if rejectRequest(r, opts, path.template, http.StatusMethodNotAllowed, errs) {
+					w.Header().Set("Allow", strings.Join(allow, ", "))
+					writeJSON(w, http.StatusMethodNotAllowed, map[string]any{"error": "request does not match the API description", "errors": errs})
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			status, errs := op.checkRequest(r, vars, opts.MaxBody)
+			if errs != nil && rejectRequest(r, opts, op.name, status, errs) {
+				writeJSON(w, status, map[string]any{"error": "request does not match the API description", "errors": errs})
+				return
+			}
+			if !opts.Responses || op.responses == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			aw := &openAPIWriter{ResponseWriter: w, r: r, op: op, opts: &opts}
+			next.ServeHTTP(aw, r)
+			aw.finish()
+		})
+	}
+}
+
+// rejectRequest logs the violations of a request and reports whether it is
+// to be rejected.
+func rejectRequest(r *http.Request, opts OpenAPIOptions, operation string, status int, errs []FieldError) bool {
+	requestLogger(opts.Logger, r).LogAttrs(r.Context(), slog.LevelWarn, "openapi request invalid",
+		slog.String("operation", operation),
+		slog.String("mode", string(opts.Mode)),
+		slog.Int("status", status),
+		slog.Any("errors", errs),
+	)
+	return opts.Mode == OpenAPIStrict
+}
+
+// match returns the path of the description r is for, with the values of
+// its parameters, or nil.
+func (api *OpenAPI) match(r *http.Request) (*apiPath, map[string]string) {
+	p := r.URL.EscapedPath()
+	if api.base != "" {
+		if !strings.HasPrefix(p, api.base+"/") {
+			return nil, nil
+		}
+		p = p[len(api.base):]
+	}
+	for _, path := range api.paths {
+		m := path.re.FindStringSubmatch(p)
+		if m == nil {
+			continue
+		}
+		vars := make(map[string]string, len(path.names))
+		for i, name := range path.names {
+			vars[name] = m[i+1]
+			if v, err := url.PathUnescape(m[i+1]); err == nil {
+				vars[name] = v
+			}
+		}
+		return path, vars
+	}
+	return nil, nil
+}
+
+// checkRequest returns the violations of the operation by r, if any, and
+// the status rejecting them; the body is left for the handler to read.
+func (op *apiOperation) checkRequest(r *http.Request, vars map[string]string, maxBody int64) (int, []FieldError) {
+	var errs []FieldError
+	statuses := map[int]bool{}
+	for _, p := range op.params {
+		var values []string
+		switch p.in {
+		case "path":
+			values = []string{vars[p.name]}
+		case "query":
+			values = r.URL.Query()[p.name]
+		case "header":
+			values = r.Header.Values(p.name)
+		case "cookie":
+			if c, err := r.Cookie(p.name); err == nil {
+				values = []string{c.Value}
+			}
+		}
+		if len(values) == 0 {
+			if p.required {
+				errs = append(errs, FieldError{In: p.in, Field: p.name, Message: "is required"})
+				statuses[http.StatusBadRequest] = true
+			}
+			continue
+		}
+		if p.schema == nil {
+			continue
+		}
+		var paramErrs []FieldError
+		p.schema.validate(paramValue(values, p.schema), "", &paramErrs)
+		for _, e := range paramErrs {
+			errs = append(errs, FieldError{In: p.in, Field: p.name + e.Field, Message: e.Message})
+			statuses[http.StatusBadRequest] = true
+		}
+	}
+
+	if body := op.body; body != nil && (r.Body == nil || r.Body == http.NoBody) {
+		if body.required {
+			errs = append(errs, FieldError{In: "body", Message: "is required"})
+			statuses[http.StatusBadRequest] = true
+		}
+	} else if body != nil {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		schema, ok := contentSchema(body.content, mediaType)
+		switch {
+		case !ok:
+			errs = append(errs, FieldError{In: "header", Field: "Content-Type", Message: "must be one of " + strings.Join(slices.Sorted(maps.Keys(body.content)), ", ")})
+			statuses[http.StatusUnsupportedMediaType] = true
+		case schema != nil && jsonMediaType(mediaType):
+			data, _ := io.ReadAll(io.LimitReader(r.Body, maxBody+1))
+			r.Body = readCloser{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+			if int64(len(data)) > maxBody {
+				break
+			}
+			v, err := decodeJSON(data)
+			if err != nil {
+				errs = append(errs, FieldError{In: "body", Message: "is not valid JSON"})
+				statuses[http.StatusBadRequest] = true
+				break
+			}
+			for _, e := range schema.validateAll(v) {
+				e.In = "body"
+				errs = append(errs, e)
+				statuses[http.StatusUnprocessableEntity] = true
+			}
+		}
+	}
+	for _, status := range []int{http.StatusUnsupportedMediaType, http.StatusBadRequest, http.StatusUnprocessableEntity} {
+		if statuses[status] {
+			return status, errs
+		}
+	}
+	return 0, nil
+}
+
+// contentSchema returns the schema of the body of mediaType in content,
+// documented by its type or a range like "text/*", and whether there is
+// one.
+func contentSchema(content map[string]*schemaNode, mediaType string) (*schemaNode, bool) {
+	typ, _, _ := strings.Cut(mediaType, "/")
+	for _, key := range []string{mediaType, typ + "/*", "*/*"} {
+		if schema, ok := content[key]; ok {
+			return schema, true
+		}
+	}
+	return nil, false
+}
+
+// jsonMediaType reports whether mediaType is JSON.
+func jsonMediaType(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+var jsonNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// paramValue converts the values of a parameter to the JSON types its
+// schema expects: numbers, booleans and, for arrays, the values repeated or
+// separated by commas.
+func paramValue(values []string, schema *schemaNode) any {
+	if slices.Contains(schema.typeNames(), "array") {
+		if len(values) == 1 {
+			values = strings.Split(values[0], ",")
+		}
+		var itemTypes []string
+		for n := schema; n != nil; n = n.ref {
+			if n.items != nil {
+				itemTypes = n.items.typeNames()
+				break
+			}
+		}
+		items := make([]any, len(values))
+		for i, v := range values {
+			items[i] = scalarValue(v, itemTypes)
+		}
+		return items
+	}
+	return scalarValue(values[0], schema.typeNames())
+}
+
+func scalarValue(s string, types []string) any {
+	for _, t := range types {
+		switch {
+		case (t == "integer" || t == "number") && jsonNumber.MatchString(s):
+			return json.Number(s)
+		case t == "boolean" && (s == "true" || s == "false"):
+			return s == "true"
+		}
+	}
+	return s
+}
+
+// typeNames returns the types n allows, following references.
+func (n *schemaNode) typeNames() []string {
+	for n != nil && n.types == nil {
+		n = n.ref
+	}
+	if n == nil {
+		return nil
+	}
+	return n.types
+}
+
+// openAPIWriter holds back a response until it is validated.
+type openAPIWriter struct {
+	http.ResponseWriter
+	r    *http.Request
+	op   *apiOperation
+	opts *OpenAPIOptions
+
+	status    int
+	buf       []byte
+	streaming bool // sent, being validated or not
+	replaced  bool
+}
+
+func (aw *openAPIWriter) WriteHeader(code int) {
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		aw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if aw.status == 0 {
+		aw.status = code
+	}
+}
+
+func (aw *openAPIWriter) Write(p []byte) (int, error) {
+	if aw.status == 0 {
+		aw.WriteHeader(http.StatusOK)
+	}
+	switch {
+	case aw.replaced:
+		return len(p), nil
+	case aw.streaming:
+		return aw.ResponseWriter.Write(p)
+	case int64(len(aw.buf)+len(p)) > aw.opts.MaxResponseSize:
+		if aw.send(false); aw.replaced {
+			return len(p), nil
+		}
+		return aw.ResponseWriter.Write(p)
+	}
+	aw.buf = append(aw.buf, p...)
+	return len(p), nil
+}
+
+// finish validates and sends a buffered response.
+func (aw *openAPIWriter) finish() {
+	if aw.status == 0 {
+		aw.status = http.StatusOK
+	}
+	if !aw.streaming && !aw.replaced {
+		aw.send(true)
+	}
+}
+
+// send validates the response, its body too if complete, and sends what
+// was buffered, or replaces it in strict mode if it is invalid.
+func (aw *openAPIWriter) send(complete bool) {
+	errs := aw.check(complete)
+	if errs != nil {
+		requestLogger(aw.opts.Logger, aw.r).LogAttrs(aw.r.Context(), slog.LevelWarn, "openapi response invalid",
+			slog.String("operation", aw.op.name),
+			slog.String("mode", string(aw.opts.Mode)),
+			slog.Int("status", aw.status),
+			slog.Any("errors", errs),
+		)
+	}
+	if errs != nil && aw.opts.Mode == OpenAPIStrict {
+		aw.replaced = true
+		aw.buf = nil
+		for _, key := range []string{"Content-Length", "Content-Encoding", "ETag", "Last-Modified"} {
+			aw.Header().Del(key)
+		}
+		writeJSON(aw.ResponseWriter, http.StatusInternalServerError, map[string]any{"error": "response does not match the API description", "errors": errs})
+		return
+	}
+	aw.streaming = true
+	aw.ResponseWriter.WriteHeader(aw.status)
+	if len(aw.buf) > 0 {
+		aw.ResponseWriter.Write(aw.buf)
+	}
+	aw.buf = nil
+}
+
+// check returns the violations of the operation by the response.
+func (aw *openAPIWriter) check(complete bool) []FieldError {
+	code := strconv.Itoa(aw.status)
+	resp, ok := aw.op.responses[code]
+	if !ok {
+		resp, ok = aw.op.responses[code[:1]+"XX"]
+	}
+	if !ok {
+		resp, ok = aw.op.responses["default"]
+	}
+	if !ok {
+		return []FieldError{{In: "status", Message: "status " + code + " is not documented"}}
+	}
+	if resp.content == nil || complete && len(aw.buf) == 0 {
+		return nil
+	}
+	contentType := aw.Header().Get("Content-Type")
+	if _, set := aw.Header()["Content-Type"]; !set && len(aw.buf) > 0 {
+		// As net/http will send it
+		contentType = http.DetectContentType(aw.buf)
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	schema, ok := contentSchema(resp.content, mediaType)
+	if !ok {
+		return []FieldError{{In: "header", Field: "Content-Type", Message: "must be one of " + strings.Join(slices.Sorted(maps.Keys(resp.content)), ", ")}}
+	}
+	if !complete || schema == nil || !jsonMediaType(mediaType) || aw.Header().Get("Content-Encoding") != "" {
+		return nil
+	}
+	v, err := decodeJSON(aw.buf)
+	if err != nil {
+		return []FieldError{{In: "body", Message: "is not valid JSON"}}
+	}
+	errs := schema.validateAll(v)
+	for i := range errs {
+		errs[i].In = "body"
+	}
+	return errs
+}
+
+func (aw *openAPIWriter) Flush() {
+	if aw.status == 0 {
+		aw.WriteHeader(http.StatusOK)
+	}
+	if !aw.streaming && !aw.replaced {
+		aw.send(false)
+	}
+	if !aw.replaced {
+		http.NewResponseController(aw.ResponseWriter).Flush()
+	}
+}
+
+func (aw *openAPIWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(aw.ResponseWriter).Hijack()
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController.
+func (aw *openAPIWriter) Unwrap() http.ResponseWriter {
+	return aw.ResponseWriter
+}
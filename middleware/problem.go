@@ -0,0 +1,279 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const problemsKey contextKey = "problems"
+
+// Error is a domain error with the response it maps to, written as an RFC
+// 7807 problem by WriteProblem. Handlers return it from an
+// ErrorHandlerFunc or panic with it, e.g.
+//
+//	return &middleware.Error{Status: http.StatusNotFound, Code: "item_not_found", Detail: "no item " + id}
+type Error struct {
+	// Status is the HTTP status of the response; defaults to 500.
+	Status int
+	// Code identifies the kind of problem to clients, e.g.
+	// "item_not_found"; defaults to the status text in snake case, e.g.
+	// "not_found".
+	Code string
+	// Title summarises the kind of problem; defaults to the status text.
+	Title string
+	// Detail explains this occurrence to the client.
+	Detail string
+	// Err is the cause, logged for statuses of 500 and up but never sent.
+	Err error
+	// Extensions are further members of the problem, e.g. the fields of a
+	// validation error.
+	Extensions map[string]any
+}
+
+func (e *Error) Error() string {
+	msg := e.code()
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+func (e *Error) status() int {
+	if e.Status == 0 {
+		return http.StatusInternalServerError
+	}
+	return e.Status
+}
+
+func (e *Error) code() string {
+	if e.Code != "" {
+		return e.Code
+	}
+	return strings.ReplaceAll(strings.ToLower(strings.ReplaceAll(http.StatusText(e.status()), "-", " ")), " ", "_")
+}
+
+// ProblemOptions configures the Problems middleware.
+type ProblemOptions struct {
+	// TypeBase is the URI the codes of problems are appended to for their
+	// type, e.g. "https://example.com/problems/" for
+	// "https://example.com/problems/item_not_found"; without it problems
+	// are of type "about:blank".
+	TypeBase string
+	// Map, if set, maps the errors that are not an *Error, e.g. the
+	// sentinel errors of a store, returning nil for those it doesn't know.
+	Map func(err error) *Error
+	// RewriteText answers the plain text errors of http.Error written
+	// further in, e.g. by other middlewares, with problems too, the text
+	// becoming their detail unless it is just the status text.
+	RewriteText bool
+	// Logger receives the errors of responses of 500 and up; defaults to
+	// slog.Default().
+	Logger *slog.Logger
+}
+
+// Problems applies opts to the problems written further in by
+// WriteProblem, ErrorHandlerFunc and Recovery, which write them with the
+// defaults without it.
+func Problems(opts ProblemOptions) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r = r.WithContext(context.WithValue(r.Context(), problemsKey, &opts))
+			if !opts.RewriteText || isUpgrade(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			pw := &problemWriter{ResponseWriter: w, r: r}
+			next.ServeHTTP(pw, r)
+			pw.finish()
+		})
+	}
+}
+
+// WriteProblem answers r with the problem err maps to, as
+// application/problem+json:
+//
+//	{"type": "about:blank", "title": "Not Found", "status": 404, "detail": "no item 7", "code": "item_not_found", "instance": "/items/7", "request_id": "..."}
+//
+// Errors wrapping an *Error map to it, and the rest, in turn, by
+// ProblemOptions.Map, to 403 for a *PolicyError, 413 for an
+// *http.MaxBytesError, 504 for context.DeadlineExceeded and 500 Internal
+// Server Error, whose details are not sent. Errors mapping to 500 and up
+// are logged, but for an *Error without Err.
+func WriteProblem(w http.ResponseWriter, r *http.Request, err error) {
+	opts, _ := r.Context().Value(problemsKey).(*ProblemOptions)
+	if opts == nil {
+		opts = &ProblemOptions{}
+	}
+	e := mapProblem(err, opts)
+	status := e.status()
+	if status >= 500 && (e.Err != nil || e != err) {
+		requestLogger(opts.Logger, r).LogAttrs(r.Context(), slog.LevelError, "request failed",
+			slog.Int("status", status),
+			slog.String("code", e.code()),
+			slog.String("error", err.Error()),
+		)
+	}
+	problem := make(map[string]any, len(e.Extensions)+7)
+	for k, v := range e.Extensions {
+		problem[k] = v
+	}
+	problem["type"] = "about:blank"
+	if opts.TypeBase != "" {
+		problem["type"] = opts.TypeBase + e.code()
+	}
+	problem["title"] = e.Title
+	if e.Title == "" {
+		problem["title"] = http.StatusText(status)
+	}
+	problem["status"] = status
+	if e.Detail != "" {
+		problem["detail"] = e.Detail
+	}
+	problem["code"] = e.code()
+	problem["instance"] = r.URL.Path
+	if id := RequestIDFromContext(r.Context()); id != "" {
+		problem["request_id"] = id
+	}
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/problem+json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+// mapProblem returns the *Error err maps to.
+func mapProblem(err error, opts *ProblemOptions) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	if opts.Map != nil {
+		if e := opts.Map(err); e != nil {
+			return e
+		}
+	}
+	var policy *PolicyError
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &policy):
+		ext := map[string]any{"reason": policy.Reason}
+		if policy.Missing != nil {
+			ext["missing"] = policy.Missing
+		}
+		return &Error{Status: http.StatusForbidden, Detail: policy.Error(), Err: err, Extensions: ext}
+	case errors.As(err, &tooLarge):
+		return &Error{Status: http.StatusRequestEntityTooLarge, Code: "request_body_too_large", Err: err, Extensions: map[string]any{"limit": tooLarge.Limit}}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &Error{Status: http.StatusGatewayTimeout, Err: err}
+	}
+	return &Error{Status: http.StatusInternalServerError, Err: err}
+}
+
+// ErrorHandlerFunc is a handler returning its error, which is answered
+// with WriteProblem unless the handler had started its response, when it
+// is only logged.
+type ErrorHandlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+func (f ErrorHandlerFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec, owned := acquireResponseRecorder(w)
+	if owned {
+		defer releaseResponseRecorder(rec)
+	}
+	err := f(rec, r)
+	switch {
+	case err == nil:
+	case rec.Written() || rec.Hijacked():
+		opts, _ := r.Context().Value(problemsKey).(*ProblemOptions)
+		if opts == nil {
+			opts = &ProblemOptions{}
+		}
+		requestLogger(opts.Logger, r).LogAttrs(r.Context(), slog.LevelError, "request failed after responding",
+			slog.Int("status", rec.Status()),
+			slog.String("error", err.Error()),
+		)
+	default:
+		WriteProblem(rec, r, err)
+	}
+}
+
+// problemWriter holds back the text errors of http.Error, recognised by
+// its headers, to answer them with problems.
+type problemWriter struct {
+	http.ResponseWriter
+	r *http.Request
+
+	wroteHeader bool
+	rewriting   bool
+	status      int
+	text        []byte
+}
+
+func (pw *problemWriter) WriteHeader(code int) {
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		pw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if pw.wroteHeader {
+		return
+	}
+	pw.wroteHeader = true
+	h := pw.Header()
+	if code >= 400 && h.Get("Content-Type") == "text/plain; charset=utf-8" && h.Get("X-Content-Type-Options") == "nosniff" {
+		pw.rewriting, pw.status = true, code
+		return
+	}
+	pw.ResponseWriter.WriteHeader(code)
+}
+
+func (pw *problemWriter) Write(p []byte) (int, error) {
+	if !pw.wroteHeader {
+		pw.WriteHeader(http.StatusOK)
+	}
+	if !pw.rewriting {
+		return pw.ResponseWriter.Write(p)
+	}
+	if room := 1<<10 - len(pw.text); room > 0 {
+		pw.text = append(pw.text, p[:min(len(p), room)]...)
+	}
+	return len(p), nil
+}
+
+// finish writes the problem of a text error.
+func (pw *problemWriter) finish() {
+	if !pw.rewriting {
+		return
+	}
+	pw.rewriting = false
+	e := &Error{Status: pw.status}
+	if text := strings.TrimSpace(string(pw.text)); text != http.StatusText(pw.status) {
+		e.Detail = text
+	}
+	WriteProblem(pw.ResponseWriter, pw.r, e)
+}
+
+func (pw *problemWriter) Flush() {
+	if !pw.rewriting {
+		http.NewResponseController(pw.ResponseWriter).Flush()
+	}
+}
+
+func (pw *problemWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(pw.ResponseWriter).Hijack()
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController.
+func (pw *problemWriter) Unwrap() http.ResponseWriter {
+	return pw.ResponseWriter
+}
@@ -0,0 +1,280 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"path"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProfilerOptions configures NewProfiler.
+type ProfilerOptions struct {
+	// Secret is the HMAC key the profile headers are signed with.
+	Secret []byte
+	// Secrets, when set, overrides Secret with the secret SecretName,
+	// looked up for every request asking for a profile so it can be
+	// rotated.
+	Secrets    SecretsProvider
+	SecretName string
+	// Header asks for a profile; defaults to X-Profile.
+	Header string
+	// URL is the path Handler is mounted at, which the fetch URLs of the
+	// profiles start with; defaults to "/debug/profiles/".
+	URL string
+	// Keep is the number of profiles kept for fetching, the oldest being
+	// dropped first; defaults to 10.
+	Keep int
+	// TTL is how long profiles are kept; defaults to 10m.
+	TTL time.Duration
+	// Logger receives a record of every profile and rejected header;
+	// defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Profiler profiles single requests on demand. A request whose header (see
+// SignProfile) carries a valid signature is served while the process
+// records a CPU profile, with the request's goroutines labelled with its
+// request ID and route, or an execution trace, with the request as a task.
+// The response carries the fetch URL of the result in X-Profile-URL.
+//
+// The runtime records one CPU profile and one trace at a time, of the
+// whole process, so a request asking for a profile while another is being
+// recorded is served without one, and the labels or task tell its samples
+// apart from those of concurrent requests. Invalid headers are ignored.
+type Profiler struct {
+	opts ProfilerOptions
+
+	// cpu and trace are held while a profile of their kind is recorded.
+	cpu   sync.Mutex
+	trace sync.Mutex
+
+	mu       sync.Mutex
+	profiles []*profile // oldest first
+}
+
+type profile struct {
+	id      string
+	kind    string
+	data    []byte
+	created time.Time
+}
+
+// NewProfiler returns a profiler keeping no profiles yet.
+func NewProfiler(opts ProfilerOptions) *Profiler {
+	if opts.Header == "" {
+		opts.Header = "X-Profile"
+	}
+	if opts.URL == "" {
+		opts.URL = "/debug/profiles/"
+	}
+	if opts.Keep <= 0 {
+		opts.Keep = 10
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = 10 * time.Minute
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	return &Profiler{opts: opts}
+}
+
+// SignProfile returns the value of the header asking for a profile of kind,
+// "cpu" or "trace", of a request with method and path, valid for ttl:
+//
+//	kind; exp=<unix seconds>; sig=<hex(hmac-sha256(secret, kind \n exp \n METHOD /path))>
+func SignProfile(secret []byte, kind, method, path string, ttl time.Duration) string {
+	exp := time.Now().Add(ttl).Unix()
+	return kind + "; exp=" + strconv.FormatInt(exp, 10) + "; sig=" + hex.EncodeToString(profileMAC(secret, kind, exp, method, path))
+}
+
+func profileMAC(secret []byte, kind string, exp int64, method, path string) []byte {
+	return signatureMAC(secret, kind+"\n"+strconv.FormatInt(exp, 10)+"\n"+method+" "+path)
+}
+
+// requested returns the kind of profile the header of r asks for, or ""
+// when it has none or it is not validly signed.
+func (p *Profiler) requested(r *http.Request) string {
+	value := r.Header.Get(p.opts.Header)
+	if value == "" {
+		return ""
+	}
+	reject := func(reason string) string {
+		requestLogger(p.opts.Logger, r).LogAttrs(r.Context(), slog.LevelWarn, "profile header rejected",
+			slog.String("reason", reason),
+			slog.String("path", r.URL.Path),
+		)
+		return ""
+	}
+	kind, params, _ := strings.Cut(value, ";")
+	kind = strings.TrimSpace(kind)
+	if kind != "cpu" && kind != "trace" {
+		return reject("unknown profile kind")
+	}
+	var exp int64
+	var mac []byte
+	for param := range strings.SplitSeq(params, ";") {
+		key, val, _ := strings.Cut(strings.TrimSpace(param), "=")
+		switch key {
+		case "exp":
+			exp, _ = strconv.ParseInt(val, 10, 64)
+		case "sig":
+			mac, _ = hex.DecodeString(val)
+		}
+	}
+	if exp == 0 || mac == nil {
+		return reject("missing expiry or signature")
+	}
+	if time.Now().Unix() > exp {
+		return reject("expired")
+	}
+	secret := p.opts.Secret
+	if p.opts.Secrets != nil {
+		var err error
+		if secret, err = p.opts.Secrets.Secret(r.Context(), p.opts.SecretName); err != nil {
+			return reject("no secret")
+		}
+	}
+	if len(secret) == 0 || !hmac.Equal(mac, profileMAC(secret, kind, exp, r.Method, r.URL.Path)) {
+		return reject("signature mismatch")
+	}
+	return kind
+}
+
+// Middleware profiles the requests asking for it. Protocol upgrades are
+// never profiled, since they last as long as the connection.
+func (p *Profiler) Middleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			kind := p.requested(r)
+			if kind == "" || isUpgrade(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			lock := &p.cpu
+			if kind == "trace" {
+				lock = &p.trace
+			}
+			if !lock.TryLock() {
+				requestLogger(p.opts.Logger, r).LogAttrs(r.Context(), slog.LevelInfo, "profile skipped",
+					slog.String("kind", kind),
+					slog.String("reason", "another one is being recorded"),
+				)
+				next.ServeHTTP(w, r)
+				return
+			}
+			defer lock.Unlock()
+
+			var buf bytes.Buffer
+			var err error
+			stop := pprof.StopCPUProfile
+			if kind == "cpu" {
+				err = pprof.StartCPUProfile(&buf)
+			} else {
+				err, stop = trace.Start(&buf), trace.Stop
+			}
+			if err != nil {
+				// Someone else, e.g. /debug/pprof/profile, is recording
+				requestLogger(p.opts.Logger, r).LogAttrs(r.Context(), slog.LevelInfo, "profile skipped",
+					slog.String("kind", kind),
+					slog.String("reason", err.Error()),
+				)
+				next.ServeHTTP(w, r)
+				return
+			}
+			id := make([]byte, 16)
+			rand.Read(id)
+			prof := &profile{id: hex.EncodeToString(id), kind: kind}
+			w.Header().Set("X-Profile-URL", p.opts.URL+prof.id)
+			start := time.Now()
+			// Also when the handler panics, so recording stops
+			defer func() {
+				stop()
+				prof.data, prof.created = buf.Bytes(), time.Now()
+				p.keep(prof)
+				requestLogger(p.opts.Logger, r).LogAttrs(r.Context(), slog.LevelInfo, "request profiled",
+					slog.String("kind", kind),
+					slog.String("profile", prof.id),
+					slog.Int("bytes", len(prof.data)),
+					slog.Duration("duration", time.Since(start)),
+				)
+			}()
+
+			route := routeTemplate(r)
+			if route == "" {
+				route = "unmatched"
+			}
+			if kind == "cpu" {
+				labels := pprof.Labels("request_id", RequestIDFromContext(r.Context()), "route", route)
+				pprof.Do(r.Context(), labels, func(ctx context.Context) {
+					next.ServeHTTP(w, r.WithContext(ctx))
+				})
+				return
+			}
+			ctx, task := trace.NewTask(r.Context(), r.Method+" "+route)
+			defer task.End()
+			trace.Log(ctx, "request_id", RequestIDFromContext(ctx))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// keep stores prof, dropping expired profiles and the oldest beyond Keep.
+func (p *Profiler) keep(prof *profile) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.expire()
+	p.profiles = append(p.profiles, prof)
+	if len(p.profiles) > p.opts.Keep {
+		p.profiles = p.profiles[len(p.profiles)-p.opts.Keep:]
+	}
+}
+
+// expire drops the profiles older than TTL. p.mu must be held.
+func (p *Profiler) expire() {
+	cutoff := time.Now().Add(-p.opts.TTL)
+	for len(p.profiles) > 0 && p.profiles[0].created.Before(cutoff) {
+		p.profiles = p.profiles[1:]
+	}
+}
+
+// Handler serves the profile whose ID ends the request path to GET, once
+// the request profiled has completed: CPU profiles for go tool pprof and
+// traces for go tool trace. Like Registry.Handler it must be mounted behind
+// admin-only middleware.
+func (p *Profiler) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := path.Base(r.URL.Path)
+		p.mu.Lock()
+		p.expire()
+		var prof *profile
+		for _, candidate := range p.profiles {
+			if candidate.id == id {
+				prof = candidate
+			}
+		}
+		p.mu.Unlock()
+		if prof == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "profile not found"})
+			return
+		}
+		ext := ".pprof"
+		if prof.kind == "trace" {
+			ext = ".trace"
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+prof.id+ext+`"`)
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(prof.data)
+	})
+}
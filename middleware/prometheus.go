@@ -0,0 +1,242 @@
+package middleware
+
+import (
+	"bytes"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExemplarSink is a MetricsSink that can link a timing to the trace it was
+// observed in. Metrics hands it the IDs of the request's span when tracing
+// sampled it, so a latency spike on a dashboard leads to a trace of it.
+type ExemplarSink interface {
+	MetricsSink
+	TimingExemplar(name string, d time.Duration, tags []string, traceID, spanID string)
+}
+
+// PrometheusOptions configures NewPrometheus.
+type PrometheusOptions struct {
+	// Namespace is prepended to every metric name, e.g. "myapp_".
+	Namespace string
+	// Buckets are the upper bounds, in seconds, of the histograms timings
+	// are observed into; defaults to DefaultBuckets.
+	Buckets []float64
+}
+
+// DefaultBuckets suit request latencies from 5ms to 10s.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Prometheus is an ExemplarSink kept in memory and served to Prometheus by
+// Handler. Metric names have their dots replaced, counters get a "_total"
+// suffix and timings become histograms in seconds with a "_seconds"
+// suffix; "key:value" tags become labels.
+type Prometheus struct {
+	opts PrometheusOptions
+
+	mu       sync.Mutex
+	families map[string]*promFamily
+}
+
+type promFamily struct {
+	kind   string // "counter", "gauge" or "histogram"
+	series map[string]*promSeries
+}
+
+type promSeries struct {
+	labels string // rendered, sorted by key
+	value  float64
+	// Histograms count the observations of each bucket alone, the last
+	// being +Inf, and keep the latest exemplar of each.
+	buckets   []uint64
+	exemplars []*promExemplar
+	sum       float64
+}
+
+type promExemplar struct {
+	traceID, spanID string
+	value           float64
+	at              time.Time
+}
+
+// NewPrometheus returns an empty sink.
+func NewPrometheus(opts PrometheusOptions) *Prometheus {
+	if opts.Buckets == nil {
+		opts.Buckets = DefaultBuckets
+	}
+	return &Prometheus{opts: opts, families: map[string]*promFamily{}}
+}
+
+// Count adds value to the counter name.
+func (p *Prometheus) Count(name string, value int64, tags []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.series(p.opts.Namespace+promName(name), "counter", tags).value += float64(value)
+}
+
+// Gauge sets the gauge name.
+func (p *Prometheus) Gauge(name string, value float64, tags []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.series(p.opts.Namespace+promName(name), "gauge", tags).value = value
+}
+
+// Timing observes d into the histogram name.
+func (p *Prometheus) Timing(name string, d time.Duration, tags []string) {
+	p.TimingExemplar(name, d, tags, "", "")
+}
+
+// TimingExemplar observes d into the histogram name, recording the trace
+// and span IDs as the exemplar of its bucket when traceID is set.
+func (p *Prometheus) TimingExemplar(name string, d time.Duration, tags []string, traceID, spanID string) {
+	seconds := d.Seconds()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.series(p.opts.Namespace+promName(name)+"_seconds", "histogram", tags)
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(p.opts.Buckets)+1)
+		s.exemplars = make([]*promExemplar, len(p.opts.Buckets)+1)
+	}
+	i, _ := slices.BinarySearch(p.opts.Buckets, seconds)
+	s.buckets[i]++
+	s.sum += seconds
+	if traceID != "" {
+		s.exemplars[i] = &promExemplar{traceID: traceID, spanID: spanID, value: seconds, at: time.Now()}
+	}
+}
+
+// series returns the series of the family name with tags, creating both
+// as needed. p.mu must be held.
+func (p *Prometheus) series(name, kind string, tags []string) *promSeries {
+	f := p.families[name]
+	if f == nil {
+		f = &promFamily{kind: kind, series: map[string]*promSeries{}}
+		p.families[name] = f
+	}
+	labels := promLabels(tags)
+	s := f.series[labels]
+	if s == nil {
+		s = &promSeries{labels: labels}
+		f.series[labels] = s
+	}
+	return s
+}
+
+// Handler serves the metrics in the OpenMetrics format to scrapers asking
+// for it, with exemplars, and otherwise in the Prometheus text format,
+// which has no room for them.
+func (p *Prometheus) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+		var buf bytes.Buffer
+		p.write(&buf, openMetrics)
+		if openMetrics {
+			w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		}
+		w.Write(buf.Bytes())
+	})
+}
+
+func (p *Prometheus) write(buf *bytes.Buffer, openMetrics bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, name := range slices.Sorted(maps.Keys(p.families)) {
+		f := p.families[name]
+		typeName := name
+		if f.kind == "counter" && !openMetrics {
+			typeName += "_total"
+		}
+		buf.WriteString("# TYPE " + typeName + " " + f.kind + "\n")
+		for _, labels := range slices.Sorted(maps.Keys(f.series)) {
+			s := f.series[labels]
+			switch f.kind {
+			case "counter":
+				writeSample(buf, name+"_total", labels, "", s.value)
+			case "gauge":
+				writeSample(buf, name, labels, "", s.value)
+			case "histogram":
+				var count uint64
+				for i, n := range s.buckets {
+					count += n
+					le := "+Inf"
+					if i < len(p.opts.Buckets) {
+						le = formatFloat(p.opts.Buckets[i])
+					}
+					writeSample(buf, name+"_bucket", labels, `le="`+le+`"`, float64(count))
+					if e := s.exemplars[i]; openMetrics && e != nil {
+						buf.Truncate(buf.Len() - 1)
+						buf.WriteString(` # {trace_id="` + e.traceID + `",span_id="` + e.spanID + `"} ` +
+							formatFloat(e.value) + " " + formatFloat(float64(e.at.UnixMilli())/1000) + "\n")
+					}
+				}
+				writeSample(buf, name+"_sum", labels, "", s.sum)
+				writeSample(buf, name+"_count", labels, "", float64(count))
+			}
+		}
+	}
+	if openMetrics {
+		buf.WriteString("# EOF\n")
+	}
+}
+
+func writeSample(buf *bytes.Buffer, name, labels, extra string, value float64) {
+	buf.WriteString(name)
+	if labels != "" && extra != "" {
+		labels += ","
+	}
+	if labels += extra; labels != "" {
+		buf.WriteString("{" + labels + "}")
+	}
+	buf.WriteString(" " + formatFloat(value) + "\n")
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// promName replaces the characters Prometheus does not allow in names.
+func promName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == ':' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// promLabels renders "key:value" tags as labels sorted by key; a tag
+// without a colon becomes a label named "tag".
+func promLabels(tags []string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	pairs := make([][2]string, 0, len(tags))
+	for _, t := range tags {
+		key, value, ok := strings.Cut(t, ":")
+		if !ok {
+			key, value = "tag", t
+		}
+		key = strings.ReplaceAll(promName(key), ":", "_")
+		if key == "" || key[0] >= '0' && key[0] <= '9' {
+			key = "_" + key
+		}
+		pairs = append(pairs, [2]string{key, value})
+	}
+	slices.SortStableFunc(pairs, func(a, b [2]string) int { return strings.Compare(a[0], b[0]) })
+	var b strings.Builder
+	for i, kv := range pairs {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(kv[0] + `="` + labelEscaper.Replace(kv[1]) + `"`)
+	}
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,191 @@
+package middleware
+
+import (
+	"container/list"
+	"context"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimitResult is a store's decision for one request.
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+// RateLimitStore decides whether one more request for key fits within rate
+// requests per second with the given burst. Implementations shared between
+// replicas (see package redisstore) make limits global across instances.
+type RateLimitStore interface {
+	Allow(ctx context.Context, key string, rate float64, burst int) (RateLimitResult, error)
+}
+
+// RateLimitOptions configures the RateLimit middleware.
+type RateLimitOptions struct {
+	// Rate is the sustained number of requests per second allowed per key.
+	Rate float64
+	// Burst is the bucket size, i.e. how many requests may arrive at once;
+	// defaults to max(1, Rate).
+	Burst int
+	// KeyFunc selects the bucket for a request; defaults to ClientIP.
+	KeyFunc func(r *http.Request) string
+	// Store keeps the limiter state; defaults to an in-memory store from
+	// NewMemoryRateLimitStore(MaxKeys).
+	Store RateLimitStore
+	// MaxKeys bounds the number of buckets tracked by the default in-memory
+	// store; defaults to 10000.
+	MaxKeys int
+	// FailClosed rejects requests with 503 when the store returns an error.
+	// By default such requests are let through.
+	FailClosed bool
+	// Logger receives store errors; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// RateLimit enforces a token bucket per client (by default per IP) and
+// rejects requests exceeding it with 429 Too Many Requests and a Retry-After
+// header.
+func RateLimit(opts RateLimitOptions) Middleware {
+	return NewRateLimiter(opts).Middleware()
+}
+
+// RateLimiter is the RateLimit middleware with a limit that can be changed
+// while serving, e.g. on a configuration reload.
+type RateLimiter struct {
+	opts  RateLimitOptions
+	limit atomic.Pointer[rateLimit]
+}
+
+type rateLimit struct {
+	rate   float64
+	burst  int
+	header string // burst as X-RateLimit-Limit
+}
+
+// NewRateLimiter returns a limiter applying opts.
+func NewRateLimiter(opts RateLimitOptions) *RateLimiter {
+	if opts.KeyFunc == nil {
+		opts.KeyFunc = ClientIP
+	}
+	if opts.Store == nil {
+		opts.Store = NewMemoryRateLimitStore(opts.MaxKeys)
+	}
+	l := &RateLimiter{opts: opts}
+	l.SetLimit(opts.Rate, opts.Burst)
+	return l
+}
+
+// SetLimit replaces Rate and Burst for the requests that follow; a Burst of
+// 0 defaults as in RateLimitOptions. Buckets keep the tokens they hold.
+func (l *RateLimiter) SetLimit(rate float64, burst int) {
+	if burst <= 0 {
+		burst = max(1, int(rate))
+	}
+	l.limit.Store(&rateLimit{rate: rate, burst: burst, header: strconv.Itoa(burst)})
+}
+
+// Middleware returns the middleware applying l.
+func (l *RateLimiter) Middleware() Middleware {
+	opts := l.opts
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit := l.limit.Load()
+			res, err := opts.Store.Allow(r.Context(), opts.KeyFunc(r), limit.rate, limit.burst)
+			if err != nil {
+				requestLogger(opts.Logger, r).LogAttrs(r.Context(), slog.LevelError, "rate limit store failed",
+					slog.String("error", err.Error()),
+				)
+				if opts.FailClosed {
+					http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("X-RateLimit-Limit", limit.header)
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			if !res.Allowed {
+				w.Header().Set("Retry-After", retryAfterSeconds(res.RetryAfter))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// retryAfterSeconds renders d as whole seconds, rounded up, for Retry-After.
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(max(1, int(math.Ceil(d.Seconds()))))
+}
+
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// MemoryRateLimitStore is a process-local RateLimitStore holding one token
+// bucket per key in an LRU list, so idle clients are evicted once the key
+// limit is reached. An evicted client simply starts over with a full bucket.
+type MemoryRateLimitStore struct {
+	maxKeys int
+
+	mu      sync.Mutex
+	lru     *list.List
+	buckets map[string]*list.Element
+}
+
+// NewMemoryRateLimitStore returns a store tracking at most maxKeys buckets;
+// maxKeys <= 0 means 10000.
+func NewMemoryRateLimitStore(maxKeys int) *MemoryRateLimitStore {
+	if maxKeys <= 0 {
+		maxKeys = 10000
+	}
+	return &MemoryRateLimitStore{
+		maxKeys: maxKeys,
+		lru:     list.New(),
+		buckets: make(map[string]*list.Element),
+	}
+}
+
+// Allow takes one token from key's bucket. It never returns an error.
+func (s *MemoryRateLimitStore) Allow(_ context.Context, key string, rate float64, burst int) (RateLimitResult, error) {
+	return s.allow(key, rate, float64(burst), time.Now()), nil
+}
+
+func (s *MemoryRateLimitStore) allow(key string, rate, burst float64, now time.Time) RateLimitResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var b *bucket
+	if el, ok := s.buckets[key]; ok {
+		s.lru.MoveToFront(el)
+		b = el.Value.(*bucket)
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+		b.last = now
+	} else {
+		if s.lru.Len() >= s.maxKeys {
+			oldest := s.lru.Back()
+			s.lru.Remove(oldest)
+			delete(s.buckets, oldest.Value.(*bucket).key)
+		}
+		b = &bucket{key: key, tokens: burst, last: now}
+		s.buckets[key] = s.lru.PushFront(b)
+	}
+
+	if b.tokens < 1 {
+		if rate <= 0 {
+			return RateLimitResult{RetryAfter: time.Hour}
+		}
+		return RateLimitResult{RetryAfter: time.Duration((1 - b.tokens) / rate * float64(time.Second))}
+	}
+	b.tokens--
+	return RateLimitResult{Allowed: true, Remaining: int(b.tokens)}
+}
@@ -0,0 +1,180 @@
+package middleware
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// ResponseRecorder wraps an http.ResponseWriter and records the status code,
+// the number of body bytes written and the time of the first write, so that
+// middlewares can report on the response after the handler returns.
+//
+// It implements http.Flusher, http.Hijacker and io.ReaderFrom by delegating
+// to the underlying writer; when that writer lacks the capability Flush is a
+// no-op, Hijack returns an error and ReadFrom falls back to io.Copy. Unwrap
+// exposes the underlying writer to http.ResponseController.
+type ResponseRecorder struct {
+	http.ResponseWriter
+
+	status     int
+	bytes      int64
+	firstWrite time.Time
+	hijacked   bool
+	beforeHdr  []func(code int)
+}
+
+// NewResponseRecorder wraps w. If w already is a *ResponseRecorder it is
+// returned as is, so stacked middlewares share one recorder.
+func NewResponseRecorder(w http.ResponseWriter) *ResponseRecorder {
+	if rec, ok := w.(*ResponseRecorder); ok {
+		return rec
+	}
+	return &ResponseRecorder{ResponseWriter: w}
+}
+
+// recorderPool recycles the recorders of acquireResponseRecorder.
+var recorderPool = sync.Pool{New: func() any { return new(ResponseRecorder) }}
+
+// acquireResponseRecorder is NewResponseRecorder for the middlewares in
+// this package, which wrap every request: a recorder it creates comes from
+// a pool, and owned reports that the caller must hand it back with
+// releaseResponseRecorder once the rest of the chain has returned.
+func acquireResponseRecorder(w http.ResponseWriter) (rec *ResponseRecorder, owned bool) {
+	if rec, ok := w.(*ResponseRecorder); ok {
+		return rec, false
+	}
+	rec = recorderPool.Get().(*ResponseRecorder)
+	rec.ResponseWriter = w
+	return rec, true
+}
+
+// releaseResponseRecorder resets rec and returns it to the pool.
+func releaseResponseRecorder(rec *ResponseRecorder) {
+	clear(rec.beforeHdr)
+	*rec = ResponseRecorder{beforeHdr: rec.beforeHdr[:0]}
+	recorderPool.Put(rec)
+}
+
+// Status returns the response status code. It is 200 if the handler wrote a
+// body without calling WriteHeader and 0 if nothing has been written yet.
+func (rec *ResponseRecorder) Status() int {
+	return rec.status
+}
+
+// BytesWritten returns the number of body bytes written so far.
+func (rec *ResponseRecorder) BytesWritten() int64 {
+	return rec.bytes
+}
+
+// FirstWrite returns when the header or first body byte was written, or the
+// zero time if the handler has not written anything.
+func (rec *ResponseRecorder) FirstWrite() time.Time {
+	return rec.firstWrite
+}
+
+// Written reports whether the response header has been sent.
+func (rec *ResponseRecorder) Written() bool {
+	return rec.status != 0
+}
+
+// Hijacked reports whether the connection was taken over via Hijack.
+func (rec *ResponseRecorder) Hijacked() bool {
+	return rec.hijacked
+}
+
+// BeforeWriteHeader registers fn to run just before the final status line is
+// sent, while headers can still be modified. Hooks run in registration order.
+func (rec *ResponseRecorder) BeforeWriteHeader(fn func(code int)) {
+	rec.beforeHdr = append(rec.beforeHdr, fn)
+}
+
+func (rec *ResponseRecorder) WriteHeader(code int) {
+	// Informational responses (e.g. 103 Early Hints) may precede the real
+	// status, so they are passed through without being recorded.
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		rec.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if rec.status != 0 {
+		return
+	}
+	rec.status = code
+	for _, fn := range rec.beforeHdr {
+		fn(code)
+	}
+	rec.firstWrite = time.Now()
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *ResponseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// ReadFrom lets io.Copy use the underlying writer's ReadFrom (for example
+// sendfile on a *net.TCPConn) while still counting bytes.
+func (rec *ResponseRecorder) ReadFrom(src io.Reader) (int64, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	var n int64
+	var err error
+	if rf, ok := rec.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(src)
+	} else {
+		n, err = io.Copy(writerOnly{rec.ResponseWriter}, src)
+	}
+	rec.bytes += n
+	return n, err
+}
+
+func (rec *ResponseRecorder) Flush() {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rec *ResponseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	// The controller looks past wrappers that only offer Unwrap.
+	conn, brw, err := http.NewResponseController(rec.ResponseWriter).Hijack()
+	if err == nil {
+		rec.hijacked = true
+		if rec.status == 0 {
+			rec.status = http.StatusSwitchingProtocols
+			rec.firstWrite = time.Now()
+		}
+	}
+	return conn, brw, err
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController.
+func (rec *ResponseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// isUpgrade reports whether r asks to switch protocols, as WebSocket
+// handshakes do. The handler then hijacks the connection, so middleware
+// working on the response has nothing to act on and passes such requests
+// straight through.
+func isUpgrade(r *http.Request) bool {
+	return r.Header.Get("Upgrade") != "" && httpguts.HeaderValuesContainsToken(r.Header["Connection"], "upgrade")
+}
+
+// writerOnly hides any ReadFrom method of the wrapped writer so io.Copy does
+// not recurse back into ResponseRecorder.ReadFrom.
+type writerOnly struct {
+	io.Writer
+}
@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// RecoveryOptions configures the Recovery middleware.
+type RecoveryOptions struct {
+	// JSON makes the default body {"error":"Internal Server Error"} with an
+	// application/json content type, for REST APIs.
+	JSON bool
+	// Problem makes the default body a problem written by WriteProblem,
+	// with the request ID, instead.
+	Problem bool
+	// Body, when set, replaces the default response body.
+	Body string
+	// ContentType is sent with Body; defaults to text/plain or
+	// application/json depending on JSON.
+	ContentType string
+	// OnPanic, if set, is called with the recovered value and stack trace,
+	// e.g. to report the panic to an error tracker.
+	OnPanic func(r *http.Request, recovered any, stack []byte)
+	// Reporter, if set, receives an ErrorReport of every panic, with its
+	// stack frames and the breadcrumbs of the request.
+	Reporter ErrorReporter
+	// Logger receives the log records; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Recovery recovers from panics in the rest of the chain, logs the stack
+// trace and responds with 500 Internal Server Error if nothing was written
+// yet. http.ErrAbortHandler is re-panicked so net/http can abort the
+// connection as intended. Panics with an error wrapping an *Error are
+// domain errors, answered with their problem by WriteProblem without being
+// logged or reported as panics.
+func Recovery(opts RecoveryOptions) Middleware {
+	contentType := opts.ContentType
+	body := opts.Body
+	if body == "" {
+		if opts.JSON {
+			body = `{"error":"Internal Server Error"}`
+		} else {
+			body = http.StatusText(http.StatusInternalServerError)
+		}
+	}
+	if contentType == "" {
+		if opts.JSON {
+			contentType = "application/json"
+		} else {
+			contentType = "text/plain; charset=utf-8"
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var trail *errorTrail
+			if opts.Reporter != nil {
+				r, trail = withErrorTrail(r)
+			}
+			rec, owned := acquireResponseRecorder(w)
+			if owned {
+				defer releaseResponseRecorder(rec)
+			}
+			defer func() {
+				recovered := recover()
+				if recovered == nil {
+					return
+				}
+				if recovered == http.ErrAbortHandler {
+					panic(recovered)
+				}
+				var domain *Error
+				if err, ok := recovered.(error); ok && errors.As(err, &domain) {
+					if !rec.Written() && !rec.Hijacked() {
+						WriteProblem(rec, r, err)
+					}
+					return
+				}
+				stack := debug.Stack()
+				requestLogger(opts.Logger, r).LogAttrs(r.Context(), slog.LevelError, "panic recovered",
+					slog.String("method", r.Method),
+					slog.String("path", r.URL.Path),
+					slog.String("error", fmt.Sprint(recovered)),
+					slog.String("stack", string(stack)),
+				)
+				if opts.OnPanic != nil {
+					opts.OnPanic(r, recovered, stack)
+				}
+				if trail != nil {
+					trail.report(opts.Reporter, r, &ErrorReport{Panic: recovered, Frames: panicFrames(), Status: http.StatusInternalServerError})
+				}
+				if rec.Written() || rec.Hijacked() {
+					return
+				}
+				if opts.Problem && opts.Body == "" {
+					WriteProblem(rec, r, &Error{Status: http.StatusInternalServerError})
+					return
+				}
+				rec.Header().Set("Content-Type", contentType)
+				rec.Header().Set("X-Content-Type-Options", "nosniff")
+				rec.Header().Del("Content-Length")
+				rec.WriteHeader(http.StatusInternalServerError)
+				rec.Write([]byte(body))
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
+
+// panicFrames returns the stack of the panic being recovered, innermost
+// first, from the function that panicked out. It must be called by the
+// deferred function that recovered.
+func panicFrames() []runtime.Frame {
+	pcs := make([]uintptr, 100)
+	// Skip runtime.Callers, panicFrames and the deferred function
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	var out []runtime.Frame
+	for {
+		f, more := frames.Next()
+		// Then runtime.gopanic and, for runtime errors, the functions
+		// raising them
+		if out != nil || !strings.HasPrefix(f.Function, "runtime.") {
+			out = append(out, f)
+		}
+		if !more {
+			return out
+		}
+	}
+}
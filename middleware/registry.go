@@ -0,0 +1,316 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/mux"
+)
+
+// Switchable is a middleware that can be turned on and off while serving,
+// such as ChaosLatency, ChaosFault and Maintenance.
+type Switchable interface {
+	Enable()
+	Disable()
+	Enabled() bool
+}
+
+// runtimeSwitch implements Switchable for the middlewares embedding it.
+type runtimeSwitch struct {
+	enabled atomic.Bool
+}
+
+// Enable turns the middleware on.
+func (s *runtimeSwitch) Enable() { s.enabled.Store(true) }
+
+// Disable turns the middleware off for new requests.
+func (s *runtimeSwitch) Disable() { s.enabled.Store(false) }
+
+// Enabled reports whether the middleware is on.
+func (s *runtimeSwitch) Enabled() bool { return s.enabled.Load() }
+
+// MiddlewareInfo describes a middleware in a Registry.
+type MiddlewareInfo struct {
+	Name string `json:"name"`
+	// Scope is the path prefix the middleware is limited to, if any.
+	Scope string `json:"scope,omitempty"`
+	// Enabled is always true for middlewares that can't be switched.
+	Enabled bool `json:"enabled"`
+	// Dynamic reports whether the middleware can be switched at runtime.
+	Dynamic bool `json:"dynamic"`
+	// Settings are its key options as registered.
+	Settings map[string]any `json:"settings,omitempty"`
+}
+
+// Registry lists the middlewares a server runs, in the order they were
+// registered, so operators can inspect and switch them through Handler
+// and check the chain every route runs through with RoutesHandler. The
+// zero value is an empty registry.
+type Registry struct {
+	// Measure, set before registering, wraps every middleware registered
+	// with Measure under its name, for the per-middleware times of Timing.
+	// Scopes created afterwards inherit it.
+	Measure bool
+
+	prefix string
+
+	mu      sync.RWMutex
+	entries []registryEntry
+	scopes  []*Registry
+}
+
+type registryEntry struct {
+	name     string
+	sw       Switchable
+	settings map[string]any
+	// middleware is false for a switch that isn't a middleware of its own.
+	middleware bool
+}
+
+// Register records a middleware under name with its key settings as
+// alternating keys and values, e.g. "rate", 10, "burst", 20, and returns
+// mw, so a stack can be registered as it is built.
+func (reg *Registry) Register(name string, mw Middleware, settings ...any) Middleware {
+	return reg.RegisterSwitch(name, nil, mw, settings...)
+}
+
+// RegisterSwitch records a middleware that sw switches on and off, and
+// returns mw. mw may be nil for a switch that isn't a middleware of its
+// own, e.g. one raising the log level.
+func (reg *Registry) RegisterSwitch(name string, sw Switchable, mw Middleware, settings ...any) Middleware {
+	e := registryEntry{name: name, sw: sw, middleware: mw != nil}
+	if len(settings) > 0 {
+		e.settings = make(map[string]any, len(settings)/2)
+		for i := 0; i+1 < len(settings); i += 2 {
+			e.settings[fmt.Sprint(settings[i])] = settings[i+1]
+		}
+	}
+	reg.mu.Lock()
+	reg.entries = append(reg.entries, e)
+	reg.mu.Unlock()
+	if reg.Measure && mw != nil {
+		return Measure(name, mw)
+	}
+	return mw
+}
+
+// Scope returns a registry for the middlewares that only run for paths
+// under prefix, such as those of a mux subrouter.
+func (reg *Registry) Scope(prefix string) *Registry {
+	child := &Registry{prefix: prefix, Measure: reg.Measure}
+	reg.mu.Lock()
+	reg.scopes = append(reg.scopes, child)
+	reg.mu.Unlock()
+	return child
+}
+
+// List describes every registered middleware, those of scopes after the
+// others.
+func (reg *Registry) List() []MiddlewareInfo {
+	var list []MiddlewareInfo
+	reg.walk(func(scope *Registry) bool {
+		for _, e := range scope.entries {
+			list = append(list, e.info(scope.prefix))
+		}
+		return true
+	})
+	return list
+}
+
+// Chain describes the middlewares a request for path runs through,
+// outermost first: the unscoped ones, then those of the scopes covering
+// path.
+func (reg *Registry) Chain(path string) []MiddlewareInfo {
+	var chain []MiddlewareInfo
+	reg.walk(func(scope *Registry) bool {
+		if !pathUnder(path, scope.prefix) {
+			return false
+		}
+		for _, e := range scope.entries {
+			if e.middleware {
+				chain = append(chain, e.info(scope.prefix))
+			}
+		}
+		return true
+	})
+	return chain
+}
+
+// walk calls fn for reg and then its scopes, depth first, skipping the
+// scopes of those for which fn returns false. fn runs with the scope's
+// lock held.
+func (reg *Registry) walk(fn func(*Registry) bool) {
+	reg.mu.RLock()
+	descend := fn(reg)
+	scopes := reg.scopes
+	reg.mu.RUnlock()
+	if !descend {
+		return
+	}
+	for _, scope := range scopes {
+		scope.walk(fn)
+	}
+}
+
+func (e registryEntry) info(scope string) MiddlewareInfo {
+	return MiddlewareInfo{
+		Name:     e.name,
+		Scope:    scope,
+		Enabled:  e.sw == nil || e.sw.Enabled(),
+		Dynamic:  e.sw != nil,
+		Settings: e.settings,
+	}
+}
+
+// pathUnder reports whether path is prefix or below it.
+func pathUnder(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// lookup returns the switch registered under name, or nil.
+func (reg *Registry) lookup(name string) Switchable {
+	var sw Switchable
+	reg.walk(func(scope *Registry) bool {
+		for _, e := range scope.entries {
+			if sw == nil && e.name == name && e.sw != nil {
+				sw = e.sw
+			}
+		}
+		return sw == nil
+	})
+	return sw
+}
+
+// RouteChain is the chain of middlewares of a mux route.
+type RouteChain struct {
+	Route       string           `json:"route"`
+	Methods     []string         `json:"methods,omitempty"`
+	Middlewares []MiddlewareInfo `json:"middlewares"`
+}
+
+// String renders c on one line, e.g.
+// "GET /admin: request_id > rate_limit(burst=20 rate=10) > auth(method=jwt)".
+func (c RouteChain) String() string {
+	var b strings.Builder
+	if len(c.Methods) > 0 {
+		b.WriteString(strings.Join(c.Methods, ","))
+		b.WriteByte(' ')
+	}
+	b.WriteString(c.Route)
+	b.WriteString(":")
+	for i, mw := range c.Middlewares {
+		if i > 0 {
+			b.WriteString(" >")
+		}
+		b.WriteByte(' ')
+		b.WriteString(mw.Name)
+		if len(mw.Settings) == 0 {
+			continue
+		}
+		b.WriteByte('(')
+		for j, key := range slices.Sorted(maps.Keys(mw.Settings)) {
+			if j > 0 {
+				b.WriteByte(' ')
+			}
+			fmt.Fprintf(&b, "%s=%v", key, mw.Settings[key])
+		}
+		b.WriteByte(')')
+	}
+	return b.String()
+}
+
+// Routes returns the chain of every route of router that has a handler,
+// judged by its path template. Middlewares that mux only runs for some
+// requests of a route, such as those wrapped in Unless, are listed with
+// the rest.
+func (reg *Registry) Routes(router *mux.Router) []RouteChain {
+	var routes []RouteChain
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		tmpl, err := route.GetPathTemplate()
+		if err != nil || route.GetHandler() == nil {
+			return nil
+		}
+		methods, _ := route.GetMethods()
+		routes = append(routes, RouteChain{Route: tmpl, Methods: methods, Middlewares: reg.Chain(tmpl)})
+		return nil
+	})
+	return routes
+}
+
+// RegistryHandlerOptions configures Registry.Handler.
+type RegistryHandlerOptions struct {
+	// Logger receives a record of every switch; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Handler is an admin endpoint for reg. GET lists the middlewares as JSON,
+// and POST switches a dynamic one with a JSON body such as
+// {"name": "maintenance", "enabled": true}, answering with its new state.
+// It does no authorization of its own and must be mounted behind
+// admin-only middleware.
+func (reg *Registry) Handler(opts RegistryHandlerOptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			writeJSON(w, http.StatusOK, reg.List())
+			return
+		case http.MethodPost:
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		var req struct {
+			Name    string `json:"name"`
+			Enabled *bool  `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" || req.Enabled == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": `body must give a "name" and "enabled"`})
+			return
+		}
+		sw := reg.lookup(req.Name)
+		if sw == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "no dynamic middleware named " + req.Name})
+			return
+		}
+		if *req.Enabled {
+			sw.Enable()
+		} else {
+			sw.Disable()
+		}
+		attrs := []slog.Attr{
+			slog.String("middleware", req.Name),
+			slog.Bool("enabled", *req.Enabled),
+		}
+		if id, ok := IdentityFromContext(r.Context()); ok {
+			attrs = append(attrs, slog.String("switched_by", id.Subject))
+		}
+		requestLogger(opts.Logger, r).LogAttrs(r.Context(), slog.LevelInfo, "middleware switched", attrs...)
+		writeJSON(w, http.StatusOK, MiddlewareInfo{Name: req.Name, Enabled: sw.Enabled(), Dynamic: true})
+	})
+}
+
+// RoutesHandler is an admin endpoint listing the chain of every route of
+// router as JSON, or as lines of text with ?format=text. Like Handler it
+// must be mounted behind admin-only middleware.
+func (reg *Registry) RoutesHandler(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routes := reg.Routes(router)
+		if r.URL.Query().Get("format") != "text" {
+			writeJSON(w, http.StatusOK, routes)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, route := range routes {
+			fmt.Fprintln(w, route)
+		}
+	})
+}
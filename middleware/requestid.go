@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+const requestIDKey contextKey = "requestID"
+
+// maxRequestIDLen bounds client-supplied IDs so they can't bloat log lines.
+const maxRequestIDLen = 128
+
+// RequestIDOptions configures the RequestID middleware.
+type RequestIDOptions struct {
+	// Header is read from the request and set on the response; defaults to
+	// X-Request-ID.
+	Header string
+	// Generator creates IDs for requests without a valid incoming one;
+	// defaults to NewUUID.
+	Generator func() string
+	// IgnoreIncoming always generates a fresh ID instead of trusting the
+	// client-supplied header.
+	IgnoreIncoming bool
+}
+
+// RequestID reuses the incoming request ID header or generates a new ID,
+// stores it in the request context and echoes it on the response so that all
+// log lines for one request can be correlated.
+func RequestID(opts RequestIDOptions) Middleware {
+	header := opts.Header
+	if header == "" {
+		header = "X-Request-ID"
+	}
+	generate := opts.Generator
+	if generate == nil {
+		generate = NewUUID
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := ""
+			if !opts.IgnoreIncoming {
+				id = r.Header.Get(header)
+			}
+			if !validRequestID(id) {
+				id = generate()
+			}
+			w.Header().Set(header, id)
+			ctx := context.WithValue(r.Context(), requestIDKey, id)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequestIDFromContext returns the request ID stored by RequestID, or "" if
+// there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// NewUUID returns a random (version 4) UUID string.
+func NewUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], b[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], b[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], b[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], b[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], b[10:])
+	return string(buf[:])
+}
+
+// validRequestID accepts non-empty IDs of printable ASCII without spaces.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
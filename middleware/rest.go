@@ -0,0 +1,16 @@
+package middleware
+
+import "net/http"
+
+// RESTHeaders marks every response as JSON.
+//
+// Deprecated: it mislabels every response that isn't JSON. Answer with
+// render.Respond, which negotiates the format and labels it.
+func RESTHeaders() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrRevocationExpired is returned by RevocationStore implementations for
+// revocations whose expiry has already passed, which would not be kept.
+var ErrRevocationExpired = errors.New("middleware: revocation expiry is in the past")
+
+// RevocationStore records revoked JWTs, either individually by "jti" or
+// wholesale per subject. Entries only need to be kept until expires, after
+// which the tokens they cover have expired on their own; for an expires in
+// the past the Revoke methods return ErrRevocationExpired.
+type RevocationStore interface {
+	// RevokeToken rejects the token with the given jti.
+	RevokeToken(ctx context.Context, jti string, expires time.Time) error
+	// RevokeSubject rejects every token for subject issued before
+	// issuedBefore, e.g. after a password change.
+	RevokeSubject(ctx context.Context, subject string, issuedBefore, expires time.Time) error
+	// IsRevoked reports whether a token is covered by either kind of entry.
+	// jti and subject may be empty when the token lacks those claims.
+	IsRevoked(ctx context.Context, jti, subject string, issuedAt time.Time) (bool, error)
+}
+
+// MemoryRevocationStore is a RevocationStore for a single instance. Call
+// Cleanup, or run RunCleanup in a goroutine, to drop expired entries.
+type MemoryRevocationStore struct {
+	mu       sync.RWMutex
+	tokens   map[string]time.Time
+	subjects map[string]subjectRevocation
+}
+
+type subjectRevocation struct {
+	issuedBefore time.Time
+	expires      time.Time
+}
+
+// NewMemoryRevocationStore returns an empty in-memory store.
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{
+		tokens:   make(map[string]time.Time),
+		subjects: make(map[string]subjectRevocation),
+	}
+}
+
+// RevokeToken implements RevocationStore.
+func (s *MemoryRevocationStore) RevokeToken(_ context.Context, jti string, expires time.Time) error {
+	if !expires.After(time.Now()) {
+		return ErrRevocationExpired
+	}
+	s.mu.Lock()
+	s.tokens[jti] = expires
+	s.mu.Unlock()
+	return nil
+}
+
+// RevokeSubject implements RevocationStore.
+func (s *MemoryRevocationStore) RevokeSubject(_ context.Context, subject string, issuedBefore, expires time.Time) error {
+	if !expires.After(time.Now()) {
+		return ErrRevocationExpired
+	}
+	s.mu.Lock()
+	s.subjects[subject] = subjectRevocation{issuedBefore: issuedBefore, expires: expires}
+	s.mu.Unlock()
+	return nil
+}
+
+// IsRevoked implements RevocationStore.
+func (s *MemoryRevocationStore) IsRevoked(_ context.Context, jti, subject string, issuedAt time.Time) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if jti != "" {
+		if _, ok := s.tokens[jti]; ok {
+			return true, nil
+		}
+	}
+	if subject != "" {
+		if rev, ok := s.subjects[subject]; ok && issuedAt.Before(rev.issuedBefore) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Cleanup removes entries past their expiry.
+func (s *MemoryRevocationStore) Cleanup() {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for jti, exp := range s.tokens {
+		if now.After(exp) {
+			delete(s.tokens, jti)
+		}
+	}
+	for sub, rev := range s.subjects {
+		if now.After(rev.expires) {
+			delete(s.subjects, sub)
+		}
+	}
+}
+
+// RunCleanup calls Cleanup every interval until ctx is done.
+func (s *MemoryRevocationStore) RunCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Cleanup()
+		}
+	}
+}
+
+// RevocationHandlerOptions configures RevocationHandler.
+type RevocationHandlerOptions struct {
+	// MaxTokenLifetime is how long entries are kept when a request doesn't
+	// say when the token expires; defaults to 24h. It should be at least
+	// the lifetime of the tokens being issued.
+	MaxTokenLifetime time.Duration
+	// Logger receives revocation records; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// RevocationHandler is an admin endpoint revoking tokens in store. It
+// accepts POST requests with a JSON body naming a "jti", a "subject" (all
+// of whose current tokens are revoked) or both, and an optional
+// "expires_at" in RFC 3339 format, which must be in the future, and
+// answers 204 No Content. It does no authorization of its own and must be
+// mounted behind admin-only middleware.
+func RevocationHandler(store RevocationStore, opts RevocationHandlerOptions) http.Handler {
+	lifetime := opts.MaxTokenLifetime
+	if lifetime <= 0 {
+		lifetime = 24 * time.Hour
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		var req struct {
+			JTI       string    `json:"jti"`
+			Subject   string    `json:"subject"`
+			ExpiresAt time.Time `json:"expires_at"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.JTI == "" && req.Subject == "") {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": `body must name a "jti" or "subject"`})
+			return
+		}
+		now := time.Now()
+		if req.ExpiresAt.IsZero() {
+			req.ExpiresAt = now.Add(lifetime)
+		}
+		var err error
+		if req.JTI != "" {
+			err = store.RevokeToken(r.Context(), req.JTI, req.ExpiresAt)
+		}
+		if err == nil && req.Subject != "" {
+			err = store.RevokeSubject(r.Context(), req.Subject, now, req.ExpiresAt)
+		}
+		logger := requestLogger(opts.Logger, r)
+		if errors.Is(err, ErrRevocationExpired) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": `"expires_at" must be in the future`})
+			return
+		}
+		if err != nil {
+			logger.LogAttrs(r.Context(), slog.LevelError, "revocation failed", slog.String("error", err.Error()))
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "revocation store unavailable"})
+			return
+		}
+		attrs := []slog.Attr{
+			slog.String("jti", req.JTI),
+			slog.String("subject", req.Subject),
+			slog.Time("expires_at", req.ExpiresAt),
+		}
+		if id, ok := IdentityFromContext(r.Context()); ok {
+			attrs = append(attrs, slog.String("revoked_by", id.Subject))
+		}
+		logger.LogAttrs(r.Context(), slog.LevelInfo, "token revoked", attrs...)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// routeTemplate returns the path template of the matched mux route (e.g.
+// "/users/{id}"), or "" when the request was not routed by mux. It only
+// works for middlewares installed with Router.Use, which run after matching.
+func routeTemplate(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+	tmpl, err := route.GetPathTemplate()
+	if err != nil {
+		return ""
+	}
+	return tmpl
+}
+
+// PerRoute runs the middleware that routes gives for the path template of
+// the request's mux route, and def for the requests of other routes, e.g.
+// to give one route a longer Timeout than the rest. def may be nil to leave
+// those requests alone. Like Route it only works for middleware added with
+// Router.Use.
+func PerRoute(def Middleware, routes map[string]Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		fallback := next
+		if def != nil {
+			fallback = def(next)
+		}
+		handlers := make(map[string]http.Handler, len(routes))
+		for tmpl, mw := range routes {
+			handlers[tmpl] = mw(next)
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if h, ok := handlers[routeTemplate(r)]; ok {
+				h.ServeHTTP(w, r)
+				return
+			}
+			fallback.ServeHTTP(w, r)
+		})
+	}
+}
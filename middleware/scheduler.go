@@ -0,0 +1,203 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// PriorityRule gives requests matching Match the priority class Priority.
+type PriorityRule struct {
+	Match    Matcher
+	Priority int
+}
+
+// Prioritize returns a classifier for the Priority option of Scheduler and
+// LoadShedder: a request gets the Priority of the first rule it matches,
+// and 0 if it matches none.
+func Prioritize(rules ...PriorityRule) func(r *http.Request) int {
+	return func(r *http.Request) int {
+		for _, rule := range rules {
+			if rule.Match(r) {
+				return rule.Priority
+			}
+		}
+		return 0
+	}
+}
+
+// SchedulerOptions configures the Scheduler middleware.
+type SchedulerOptions struct {
+	// Max is the number of requests allowed to execute at the same time.
+	Max int
+	// Queue is how many further requests may wait for a slot, across all
+	// classes; 0 rejects immediately once Max is reached.
+	Queue int
+	// QueueTimeout bounds how long a queued request waits; defaults to 1s.
+	QueueTimeout time.Duration
+	// Priority classifies a request from 0, served last, to Priorities-1,
+	// served first; defaults to 0 for every request. See Prioritize.
+	Priority func(r *http.Request) int
+	// Priorities is the number of classes Priority returns; defaults to 1.
+	Priorities int
+	// MaxDelay is how long a request may wait before it is served ahead of
+	// higher classes; defaults to half of QueueTimeout.
+	MaxDelay time.Duration
+	// Status is the status of rejected requests; defaults to 503 Service
+	// Unavailable.
+	Status int
+	// Sink receives the number of queued requests per class as the gauge
+	// "http.scheduler.queued", their wait as the timing
+	// "http.scheduler.wait" and rejections as the count
+	// "http.scheduler.rejected", all tagged with the priority; defaults to
+	// NopSink.
+	Sink MetricsSink
+}
+
+// Scheduler caps the number of concurrently executing requests like
+// ConcurrencyLimit, but queues waiters by priority class: a freed slot goes
+// to the longest waiting request of the highest class, so important traffic
+// keeps flowing while the server is busy. Lower classes are delayed, not
+// starved: a request that waited MaxDelay is served next regardless of
+// class. Requests still queued after QueueTimeout, or arriving to a full
+// queue, are rejected with Status.
+func Scheduler(opts SchedulerOptions) Middleware {
+	if opts.QueueTimeout <= 0 {
+		opts.QueueTimeout = time.Second
+	}
+	if opts.Priority == nil {
+		opts.Priority = func(*http.Request) int { return 0 }
+	}
+	if opts.Priorities <= 0 {
+		opts.Priorities = 1
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = opts.QueueTimeout / 2
+	}
+	if opts.Status == 0 {
+		opts.Status = http.StatusServiceUnavailable
+	}
+	if opts.Sink == nil {
+		opts.Sink = NopSink{}
+	}
+	s := &scheduler{
+		opts:   opts,
+		max:    max(1, opts.Max),
+		queues: make([][]*schedulerWaiter, opts.Priorities),
+		tags:   make([][]string, opts.Priorities),
+	}
+	for class := range s.tags {
+		s.tags[class] = []string{"priority:" + strconv.Itoa(class)}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			class := min(max(opts.Priority(r), 0), opts.Priorities-1)
+			if !s.acquire(r, class) {
+				opts.Sink.Count("http.scheduler.rejected", 1, s.tags[class])
+				w.Header().Set("Retry-After", retryAfterSeconds(opts.QueueTimeout))
+				http.Error(w, http.StatusText(opts.Status), opts.Status)
+				return
+			}
+			defer s.release()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type scheduler struct {
+	opts SchedulerOptions
+	max  int
+	tags [][]string
+
+	mu      sync.Mutex
+	running int
+	waiting int
+	queues  [][]*schedulerWaiter // per class, oldest first
+}
+
+type schedulerWaiter struct {
+	since time.Time
+	ready chan struct{} // closed once the waiter was handed a slot
+}
+
+func (s *scheduler) acquire(r *http.Request, class int) bool {
+	s.mu.Lock()
+	if s.running < s.max {
+		s.running++
+		s.mu.Unlock()
+		return true
+	}
+	if s.waiting >= s.opts.Queue {
+		s.mu.Unlock()
+		return false
+	}
+	w := &schedulerWaiter{since: time.Now(), ready: make(chan struct{})}
+	s.queues[class] = append(s.queues[class], w)
+	s.waiting++
+	s.opts.Sink.Gauge("http.scheduler.queued", float64(len(s.queues[class])), s.tags[class])
+	s.mu.Unlock()
+
+	timer := time.NewTimer(s.opts.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case <-w.ready:
+		s.opts.Sink.Timing("http.scheduler.wait", time.Since(w.since), s.tags[class])
+		return true
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+
+	s.mu.Lock()
+	i := slices.Index(s.queues[class], w)
+	if i >= 0 {
+		s.queues[class] = slices.Delete(s.queues[class], i, i+1)
+		s.waiting--
+		s.opts.Sink.Gauge("http.scheduler.queued", float64(len(s.queues[class])), s.tags[class])
+	}
+	s.mu.Unlock()
+	if i < 0 {
+		// Handed a slot while giving up: pass it on.
+		s.release()
+	}
+	return false
+}
+
+// release hands the slot to the next waiter, or frees it if there is none.
+func (s *scheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	class := s.next(time.Now())
+	if class < 0 {
+		s.running--
+		return
+	}
+	w := s.queues[class][0]
+	s.queues[class] = slices.Delete(s.queues[class], 0, 1)
+	s.waiting--
+	s.opts.Sink.Gauge("http.scheduler.queued", float64(len(s.queues[class])), s.tags[class])
+	close(w.ready)
+}
+
+// next returns the class to serve next: the one whose oldest waiter
+// exceeded MaxDelay by the most, or else the highest with a waiter, or -1
+// if nothing is queued. s.mu must be held.
+func (s *scheduler) next(now time.Time) int {
+	starved, oldest := -1, s.opts.MaxDelay
+	highest := -1
+	for class, q := range s.queues {
+		if len(q) == 0 {
+			continue
+		}
+		highest = class
+		if waited := now.Sub(q[0].since); waited > oldest {
+			starved, oldest = class, waited
+		}
+	}
+	if starved >= 0 {
+		return starved
+	}
+	return highest
+}
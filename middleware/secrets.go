@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrSecretNotFound is returned by a SecretsProvider for names it has no
+// value for.
+var ErrSecretNotFound = errors.New("middleware: secret not found")
+
+// SecretsProvider supplies secrets such as tokens and signing keys by name,
+// from wherever they are kept (see package secrets). Middlewares look their
+// secrets up for every request, so a rotated value applies right away and
+// providers should answer from memory.
+type SecretsProvider interface {
+	Secret(ctx context.Context, name string) ([]byte, error)
+}
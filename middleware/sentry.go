@@ -0,0 +1,316 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SentryOptions configures NewSentry.
+type SentryOptions struct {
+	// Environment and Release tag every event, e.g. "production" and the
+	// version deployed.
+	Environment string
+	Release     string
+	// ServerName identifies the instance; defaults to the hostname.
+	ServerName string
+	// RedactHeaders are request headers whose values are replaced; defaults
+	// to those of BodyLog.
+	RedactHeaders []string
+	// Client sends the events; defaults to a client with a 10s timeout.
+	Client *http.Client
+	// QueueSize bounds the events waiting to be sent; events beyond it are
+	// dropped. Defaults to 100.
+	QueueSize int
+	// Logger receives delivery failures; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Sentry is an ErrorReporter sending events to Sentry, or a service
+// speaking its protocol, from a background goroutine. Events carry the
+// request, the caller, the trace of Tracing and the breadcrumbs; panics are
+// reported as exceptions with their stack trace and 5xx responses as
+// messages grouped by route and status.
+type Sentry struct {
+	endpoint string
+	auth     string
+	dsn      string
+	app      string // path of the main module
+	opts     SentryOptions
+	redactor *redactor
+	queue    chan []byte
+	closed   chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+// NewSentry starts a reporter sending to the project of dsn, e.g.
+// "https://<key>@o1.ingest.sentry.io/<project>". Call Close to flush
+// pending events on shutdown.
+func NewSentry(dsn string, opts SentryOptions) (*Sentry, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("sentry: invalid DSN: %w", err)
+	}
+	dir, project := path.Split(u.Path)
+	if u.User == nil || u.User.Username() == "" || project == "" || u.Host == "" {
+		return nil, errors.New("sentry: DSN needs a key, a host and a project")
+	}
+	if opts.ServerName == "" {
+		opts.ServerName, _ = os.Hostname()
+	}
+	if len(opts.RedactHeaders) == 0 {
+		opts.RedactHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "X-Auth-Token"}
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 100
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	s := &Sentry{
+		endpoint: u.Scheme + "://" + u.Host + dir + "api/" + project + "/envelope/",
+		auth:     "Sentry sentry_version=7, sentry_client=middlware/1.0, sentry_key=" + u.User.Username(),
+		dsn:      u.Redacted(),
+		opts:     opts,
+		redactor: newRedactor(nil, opts.RedactHeaders),
+		queue:    make(chan []byte, opts.QueueSize),
+		closed:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		s.app = info.Main.Path
+	}
+	go s.run()
+	return s, nil
+}
+
+// ReportError implements ErrorReporter. It builds the event from r and
+// queues it.
+func (s *Sentry) ReportError(r *http.Request, e *ErrorReport) {
+	select {
+	case <-s.closed:
+		return
+	default:
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	event := s.event(r, e)
+	event["event_id"] = hex.EncodeToString(id)
+	payload, err := json.Marshal(event)
+	if err != nil {
+		s.opts.Logger.Error("sentry event encoding failed", "error", err)
+		return
+	}
+	header, _ := json.Marshal(map[string]any{
+		"event_id": event["event_id"],
+		"sent_at":  time.Now().UTC().Format(time.RFC3339Nano),
+		"dsn":      s.dsn,
+	})
+	item, _ := json.Marshal(map[string]any{"type": "event", "length": len(payload)})
+	envelope := slices.Concat(header, []byte("\n"), item, []byte("\n"), payload, []byte("\n"))
+	select {
+	case s.queue <- envelope:
+	default:
+		s.opts.Logger.Warn("sentry queue full, event dropped", "event_id", event["event_id"])
+	}
+}
+
+func (s *Sentry) event(r *http.Request, e *ErrorReport) map[string]any {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	route := e.Route
+	if route == "" {
+		route = "unmatched"
+	}
+	tags := map[string]string{"route": route, "status": fmt.Sprint(e.Status)}
+	if id := RequestIDFromContext(r.Context()); id != "" {
+		tags["request_id"] = id
+	}
+	event := map[string]any{
+		"timestamp":   e.Time.UTC().Format(time.RFC3339Nano),
+		"platform":    "go",
+		"level":       "error",
+		"server_name": s.opts.ServerName,
+		"transaction": r.Method + " " + route,
+		"tags":        tags,
+		"request": map[string]any{
+			"method":       r.Method,
+			"url":          scheme + "://" + r.Host + r.URL.Path,
+			"query_string": r.URL.RawQuery,
+			"headers":      s.redactor.headers(r.Header),
+			"env":          map[string]string{"REMOTE_ADDR": ClientIP(r)},
+		},
+	}
+	if s.opts.Environment != "" {
+		event["environment"] = s.opts.Environment
+	}
+	if s.opts.Release != "" {
+		event["release"] = s.opts.Release
+	}
+	user := map[string]any{"ip_address": ClientIP(r)}
+	if e.Identity != nil {
+		user["id"] = e.Identity.Subject
+		user["data"] = map[string]any{"method": e.Identity.Method, "roles": e.Identity.Roles}
+	}
+	event["user"] = user
+	if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+		event["contexts"] = map[string]any{"trace": map[string]string{
+			"trace_id": sc.TraceID().String(),
+			"span_id":  sc.SpanID().String(),
+		}}
+	}
+	if e.Panic != nil {
+		event["level"] = "fatal"
+		event["exception"] = map[string]any{"values": []any{map[string]any{
+			"type":       panicType(e.Panic),
+			"value":      fmt.Sprint(e.Panic),
+			"mechanism":  map[string]any{"type": "recovery", "handled": true},
+			"stacktrace": map[string]any{"frames": s.sentryFrames(e)},
+		}}}
+	} else {
+		event["message"] = map[string]string{"formatted": r.Method + " " + route + ": " + fmt.Sprint(e.Status) + " " + http.StatusText(e.Status)}
+	}
+	crumbs := make([]map[string]any, 0, len(e.Breadcrumbs))
+	for _, b := range e.Breadcrumbs {
+		crumb := map[string]any{
+			"timestamp": b.Time.UTC().Format(time.RFC3339Nano),
+			"category":  b.Category,
+			"level":     sentryLevel(b.Level),
+		}
+		if b.Category == "http" {
+			crumb["type"] = "http"
+		}
+		if b.Message != "" {
+			crumb["message"] = b.Message
+		}
+		if b.Data != nil {
+			crumb["data"] = b.Data
+		}
+		crumbs = append(crumbs, crumb)
+	}
+	event["breadcrumbs"] = map[string]any{"values": crumbs}
+	return event
+}
+
+// panicType names the exception: the type of a panic with an error, as for
+// a runtime error, and "panic" otherwise.
+func panicType(recovered any) string {
+	if _, ok := recovered.(error); ok {
+		return fmt.Sprintf("%T", recovered)
+	}
+	return "panic"
+}
+
+// sentryFrames converts the stack of e to Sentry's frames, which are
+// outermost first. Frames of the main module are in the app.
+func (s *Sentry) sentryFrames(e *ErrorReport) []map[string]any {
+	frames := make([]map[string]any, 0, len(e.Frames))
+	for _, f := range slices.Backward(e.Frames) {
+		pkg, function := splitFunction(f.Function)
+		frames = append(frames, map[string]any{
+			"function": function,
+			"module":   pkg,
+			"filename": path.Base(f.File),
+			"abs_path": f.File,
+			"lineno":   f.Line,
+			"in_app":   s.app == "" || pkg == s.app || strings.HasPrefix(pkg, s.app+"/"),
+		})
+	}
+	return frames
+}
+
+// splitFunction splits "example.com/pkg.(*T).Method" into the package path
+// and the function.
+func splitFunction(name string) (pkg, function string) {
+	slash := strings.LastIndex(name, "/")
+	if dot := strings.Index(name[slash+1:], "."); dot >= 0 {
+		return name[:slash+1+dot], name[slash+2+dot:]
+	}
+	return "", name
+}
+
+func sentryLevel(l slog.Level) string {
+	switch {
+	case l >= slog.LevelError:
+		return "error"
+	case l >= slog.LevelWarn:
+		return "warning"
+	case l >= slog.LevelInfo:
+		return "info"
+	}
+	return "debug"
+}
+
+// Close stops accepting events and waits until the queued ones were sent
+// or ctx is done.
+func (s *Sentry) Close(ctx context.Context) error {
+	s.once.Do(func() { close(s.closed) })
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Sentry) run() {
+	defer close(s.done)
+	for {
+		select {
+		case envelope := <-s.queue:
+			s.send(envelope)
+		case <-s.closed:
+			for {
+				select {
+				case envelope := <-s.queue:
+					s.send(envelope)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *Sentry) send(envelope []byte) {
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(envelope))
+	if err != nil {
+		s.opts.Logger.Error("sentry delivery failed", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", s.auth)
+	resp, err := s.opts.Client.Do(req)
+	if err != nil {
+		s.opts.Logger.Error("sentry delivery failed", "error", err)
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		s.opts.Logger.Error("sentry delivery failed", "status", resp.StatusCode)
+	}
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const serverTimingKey contextKey = "serverTiming"
+
+// ServerTimingMetric is one entry of the Server-Timing response header.
+type ServerTimingMetric struct {
+	Name     string
+	Desc     string
+	Duration time.Duration
+}
+
+type serverTiming struct {
+	mu      sync.Mutex
+	metrics []ServerTimingMetric
+}
+
+func (st *serverTiming) add(m ServerTimingMetric) {
+	st.mu.Lock()
+	st.metrics = append(st.metrics, m)
+	st.mu.Unlock()
+}
+
+// AddServerTiming records a named metric for the Server-Timing header of the
+// current request. It is a no-op unless Timing runs with ServerTiming
+// enabled, and metrics added after the response header is sent are dropped.
+func AddServerTiming(ctx context.Context, name, desc string, d time.Duration) {
+	if st, ok := ctx.Value(serverTimingKey).(*serverTiming); ok {
+		st.add(ServerTimingMetric{Name: name, Desc: desc, Duration: d})
+	}
+}
+
+// StartServerTiming starts timing a named sub-span and returns a function
+// that records it when called:
+//
+//	defer middleware.StartServerTiming(r.Context(), "db", "")()
+func StartServerTiming(ctx context.Context, name, desc string) func() {
+	start := time.Now()
+	return func() {
+		AddServerTiming(ctx, name, desc, time.Since(start))
+	}
+}
+
+// header renders the recorded metrics followed by the total duration, e.g.
+// `db;dur=12.3, app;dur=40.1`. totalPrefix is the total's name and
+// parameters up to the duration, as in "app;dur=".
+func (st *serverTiming) header(totalPrefix string, total time.Duration) string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	// Most headers fit, so only the string conversion allocates.
+	b := make([]byte, 0, 128)
+	for _, m := range st.metrics {
+		b = append(b, m.Name...)
+		if m.Desc != "" {
+			b = append(b, `;desc="`...)
+			b = append(b, strings.ReplaceAll(m.Desc, `"`, `'`)...)
+			b = append(b, '"')
+		}
+		b = append(b, ";dur="...)
+		b = appendMillis(b, m.Duration)
+		b = append(b, ", "...)
+	}
+	b = append(b, totalPrefix...)
+	return string(appendMillis(b, total))
+}
+
+// appendMillis appends d in milliseconds with microsecond precision.
+func appendMillis(b []byte, d time.Duration) []byte {
+	return strconv.AppendFloat(b, float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
@@ -0,0 +1,387 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Objective is a service level objective: the share of the requests it
+// covers that must be good.
+type Objective struct {
+	// Name identifies the objective in metrics, alerts and Status.
+	Name string
+	// Match selects the requests covered, e.g. with Route; nil covers all.
+	Match Matcher
+	// Target is the share of good requests, e.g. 0.999; the remaining
+	// 0.001 is the error budget. It must be below 1.
+	Target float64
+	// Latency, when set, makes a latency objective, whose good requests are
+	// those served within it. Otherwise requests are good unless answered
+	// with a 5xx.
+	Latency time.Duration
+}
+
+// BurnAlert fires while the error budget of an objective burns at least
+// Rate times as fast as it lasts for, over both the Long and the Short
+// window: the long window keeps short spikes from firing it, and the
+// short one lets it stop soon after the burn does.
+type BurnAlert struct {
+	Long  time.Duration
+	Short time.Duration
+	Rate  float64
+}
+
+// DefaultBurnAlerts fire on 2% of a 30-day budget spent within an hour, or
+// 5% within six hours.
+var DefaultBurnAlerts = []BurnAlert{
+	{Long: time.Hour, Short: 5 * time.Minute, Rate: 14.4},
+	{Long: 6 * time.Hour, Short: 30 * time.Minute, Rate: 6},
+}
+
+// BurnEvent reports a BurnAlert starting or stopping to fire.
+type BurnEvent struct {
+	Objective string
+	Alert     BurnAlert
+	// LongRate and ShortRate are the burn rates over the alert's windows.
+	LongRate  float64
+	ShortRate float64
+	Firing    bool
+	Time      time.Time
+}
+
+// SLOOptions configures NewSLO.
+type SLOOptions struct {
+	Objectives []Objective
+	// Alerts apply to every objective; defaults to DefaultBurnAlerts.
+	Alerts []BurnAlert
+	// Resolution is the width of the buckets requests are counted in, which
+	// windows are rounded to; defaults to 1m.
+	Resolution time.Duration
+	// MinRequests is the number of requests the short window of an alert
+	// needs before it may fire; defaults to 10.
+	MinRequests int
+	// EvaluateInterval is how often burn rates are computed, as requests
+	// arrive; defaults to 10s.
+	EvaluateInterval time.Duration
+	// Sink receives the gauge "http.slo.burn_rate" per objective and
+	// window; defaults to NopSink.
+	Sink MetricsSink
+	// Prefix is prepended to the metric name; defaults to "http.".
+	Prefix string
+	// OnBurn, if set, is called on its own goroutine whenever an alert
+	// starts or stops firing, e.g. with BurnWebhook.
+	OnBurn func(BurnEvent)
+	// Logger receives a record of every alert change; defaults to
+	// slog.Default().
+	Logger *slog.Logger
+}
+
+// SLO tracks the compliance of requests with objectives over rolling
+// windows and alerts when their error budgets burn too fast.
+type SLO struct {
+	opts       SLOOptions
+	windows    []time.Duration // of all alerts, shortest first
+	metric     string
+	objectives []*objectiveState
+}
+
+type sloBucket struct {
+	start time.Time
+	total int
+	bad   int
+}
+
+type objectiveState struct {
+	Objective
+
+	mu        sync.Mutex
+	buckets   []sloBucket
+	rates     map[time.Duration]float64
+	firing    []bool // by alert
+	evaluated time.Time
+}
+
+// NewSLO returns a tracker of opts.Objectives.
+func NewSLO(opts SLOOptions) *SLO {
+	if opts.Alerts == nil {
+		opts.Alerts = DefaultBurnAlerts
+	}
+	if opts.Resolution <= 0 {
+		opts.Resolution = time.Minute
+	}
+	if opts.MinRequests <= 0 {
+		opts.MinRequests = 10
+	}
+	if opts.EvaluateInterval <= 0 {
+		opts.EvaluateInterval = 10 * time.Second
+	}
+	if opts.Sink == nil {
+		opts.Sink = NopSink{}
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = "http."
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	s := &SLO{opts: opts, metric: opts.Prefix + "slo.burn_rate"}
+	for _, a := range opts.Alerts {
+		s.windows = append(s.windows, a.Long, a.Short)
+	}
+	slices.Sort(s.windows)
+	s.windows = slices.Compact(s.windows)
+	longest := opts.Resolution
+	if len(s.windows) > 0 {
+		longest = s.windows[len(s.windows)-1]
+	}
+	buckets := int(longest/opts.Resolution) + 1
+	for _, o := range opts.Objectives {
+		s.objectives = append(s.objectives, &objectiveState{
+			Objective: o,
+			buckets:   make([]sloBucket, buckets),
+			rates:     map[time.Duration]float64{},
+			firing:    make([]bool, len(opts.Alerts)),
+		})
+	}
+	return s
+}
+
+// Middleware counts every request against the objectives covering it.
+// Protocol upgrades, which last as long as the connection, are not
+// counted.
+func (s *SLO) Middleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isUpgrade(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			start := time.Now()
+			rec, owned := acquireResponseRecorder(w)
+			if owned {
+				defer releaseResponseRecorder(rec)
+			}
+			next.ServeHTTP(rec, r)
+			now := time.Now()
+			duration := now.Sub(start)
+			for _, o := range s.objectives {
+				if o.Match != nil && !o.Match(r) {
+					continue
+				}
+				good := rec.Status() < 500
+				if o.Latency > 0 {
+					good = duration <= o.Latency
+				}
+				s.record(o, now, good)
+			}
+		})
+	}
+}
+
+func (s *SLO) record(o *objectiveState, now time.Time, good bool) {
+	o.mu.Lock()
+	b := o.bucket(now, s.opts.Resolution)
+	b.total++
+	if !good {
+		b.bad++
+	}
+	var events []BurnEvent
+	if now.Sub(o.evaluated) >= s.opts.EvaluateInterval {
+		events = s.evaluate(o, now)
+	}
+	o.mu.Unlock()
+	for _, e := range events {
+		s.report(e)
+	}
+}
+
+// bucket returns the bucket for now, resetting it if it belongs to an
+// earlier cycle.
+func (o *objectiveState) bucket(now time.Time, width time.Duration) *sloBucket {
+	slot := now.Truncate(width)
+	b := &o.buckets[(slot.UnixNano()/int64(width))%int64(len(o.buckets))]
+	if !b.start.Equal(slot) {
+		*b = sloBucket{start: slot}
+	}
+	return b
+}
+
+// count returns the requests of the window ending at now.
+func (o *objectiveState) count(now time.Time, window time.Duration) (total, bad int) {
+	for _, b := range o.buckets {
+		if now.Sub(b.start) < window {
+			total += b.total
+			bad += b.bad
+		}
+	}
+	return total, bad
+}
+
+// burnRate is how many times faster than it lasts for the budget goes.
+func (o *objectiveState) burnRate(total, bad int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(bad) / float64(total) / (1 - o.Target)
+}
+
+// evaluate updates the burn rates of o, emits them and returns the alerts
+// that changed. o.mu must be held.
+func (s *SLO) evaluate(o *objectiveState, now time.Time) []BurnEvent {
+	o.evaluated = now
+	counts := map[time.Duration]int{}
+	for _, w := range s.windows {
+		total, bad := o.count(now, w)
+		counts[w] = total
+		o.rates[w] = o.burnRate(total, bad)
+		s.opts.Sink.Gauge(s.metric, o.rates[w], []string{"objective:" + o.Name, "window:" + w.String()})
+	}
+	var events []BurnEvent
+	for i, a := range s.opts.Alerts {
+		long, short := o.rates[a.Long], o.rates[a.Short]
+		firing := counts[a.Short] >= s.opts.MinRequests && long >= a.Rate && short >= a.Rate
+		if firing != o.firing[i] {
+			o.firing[i] = firing
+			events = append(events, BurnEvent{Objective: o.Name, Alert: a, LongRate: long, ShortRate: short, Firing: firing, Time: now})
+		}
+	}
+	return events
+}
+
+func (s *SLO) report(e BurnEvent) {
+	attrs := []any{
+		"objective", e.Objective,
+		"long_window", e.Alert.Long, "short_window", e.Alert.Short,
+		"threshold", e.Alert.Rate, "long_burn_rate", round(e.LongRate), "short_burn_rate", round(e.ShortRate),
+	}
+	if e.Firing {
+		s.opts.Logger.Warn("error budget burning too fast", attrs...)
+	} else {
+		s.opts.Logger.Info("error budget burn back to normal", attrs...)
+	}
+	if s.opts.OnBurn != nil {
+		go s.opts.OnBurn(e)
+	}
+}
+
+// round keeps burn rates readable in logs and JSON.
+func round(rate float64) float64 {
+	return math.Round(rate*1000) / 1000
+}
+
+// ObjectiveStatus is the compliance of an objective, as given by Status.
+type ObjectiveStatus struct {
+	Name    string      `json:"name"`
+	Target  float64     `json:"target"`
+	Latency string      `json:"latency,omitempty"`
+	Windows []SLOWindow `json:"windows"`
+	// Firing lists the firing alerts by their windows, e.g. "1h0m0s/5m0s".
+	Firing []string `json:"firing"`
+}
+
+// SLOWindow counts the requests of a rolling window.
+type SLOWindow struct {
+	Window   string  `json:"window"`
+	Requests int     `json:"requests"`
+	Bad      int     `json:"bad"`
+	BurnRate float64 `json:"burn_rate"`
+}
+
+// Status returns the compliance of every objective over the windows of the
+// alerts, as of now.
+func (s *SLO) Status() []ObjectiveStatus {
+	now := time.Now()
+	out := make([]ObjectiveStatus, 0, len(s.objectives))
+	for _, o := range s.objectives {
+		st := ObjectiveStatus{Name: o.Name, Target: o.Target, Windows: []SLOWindow{}, Firing: []string{}}
+		if o.Latency > 0 {
+			st.Latency = o.Latency.String()
+		}
+		o.mu.Lock()
+		for _, w := range s.windows {
+			total, bad := o.count(now, w)
+			st.Windows = append(st.Windows, SLOWindow{Window: w.String(), Requests: total, Bad: bad, BurnRate: round(o.burnRate(total, bad))})
+		}
+		for i, a := range s.opts.Alerts {
+			if o.firing[i] {
+				st.Firing = append(st.Firing, a.Long.String()+"/"+a.Short.String())
+			}
+		}
+		o.mu.Unlock()
+		out = append(out, st)
+	}
+	return out
+}
+
+// Handler answers GET with Status as JSON. Like Registry.Handler it must be
+// mounted behind admin-only middleware.
+func (s *SLO) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"objectives": s.Status()})
+	})
+}
+
+// BurnWebhookOptions configures BurnWebhook.
+type BurnWebhookOptions struct {
+	// Client posts the events; defaults to a client with a 10s timeout.
+	Client *http.Client
+	// Logger receives delivery failures; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// BurnWebhook returns an SLOOptions.OnBurn posting every event to url as
+// JSON, e.g.
+//
+//	{"objective": "/checkout availability", "firing": true,
+//	 "long_window": "1h0m0s", "short_window": "5m0s", "threshold": 14.4,
+//	 "long_burn_rate": 20.5, "short_burn_rate": 31, "time": "..."}
+//
+// Failed deliveries are logged, not retried.
+func BurnWebhook(url string, opts BurnWebhookOptions) func(BurnEvent) {
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	return func(e BurnEvent) {
+		body, _ := json.Marshal(map[string]any{
+			"objective":       e.Objective,
+			"firing":          e.Firing,
+			"long_window":     e.Alert.Long.String(),
+			"short_window":    e.Alert.Short.String(),
+			"threshold":       e.Alert.Rate,
+			"long_burn_rate":  round(e.LongRate),
+			"short_burn_rate": round(e.ShortRate),
+			"time":            e.Time,
+		})
+		if err := postJSON(opts.Client, url, body); err != nil {
+			opts.Logger.Error("SLO webhook failed", "url", url, "objective", e.Objective, "error", err)
+		}
+	}
+}
+
+func postJSON(client *http.Client, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// slowWatch follows a request timed by Timing against its slow threshold.
+type slowWatch struct {
+	threshold time.Duration
+	route     string
+	identity  *identitySlot
+	// label identifies the goroutines serving the request in dumps; dumped
+	// is closed once stacks were taken, or the dump was skipped.
+	label  string
+	dumped chan struct{}
+	stacks string
+}
+
+var (
+	// lastSlowDump is when a goroutine dump was last taken, in Unix
+	// nanoseconds; dumps stop the world, so there is at most one a second.
+	lastSlowDump atomic.Int64
+	slowSeq      atomic.Uint64
+)
+
+// watchSlow returns the threshold of r, nil when it has none, and r with a
+// context capturing the identity of the caller.
+func watchSlow(r *http.Request, opts *TimingOptions) (*http.Request, *slowWatch) {
+	route := routeTemplate(r)
+	threshold, ok := opts.SlowRoutes[route]
+	if !ok {
+		threshold = opts.SlowThreshold
+	}
+	if threshold <= 0 {
+		return r, nil
+	}
+	if route == "" {
+		route = "unmatched"
+	}
+	ctx, slot := captureIdentity(r.Context())
+	return r.WithContext(ctx), &slowWatch{threshold: threshold, route: route, identity: slot}
+}
+
+// serve runs next under pprof labels of the route and request and dumps
+// the goroutines carrying them if it is still running after the
+// threshold. Goroutines the handler starts inherit the labels.
+func (s *slowWatch) serve(next http.Handler, w http.ResponseWriter, r *http.Request) {
+	id := RequestIDFromContext(r.Context())
+	if id == "" {
+		id = "seq-" + strconv.FormatUint(slowSeq.Add(1), 10)
+	}
+	s.label = `"request_id":"` + id + `"`
+	s.dumped = make(chan struct{})
+	timer := time.AfterFunc(s.threshold, func() {
+		defer close(s.dumped)
+		now, last := time.Now().UnixNano(), lastSlowDump.Load()
+		if now-last < int64(time.Second) || !lastSlowDump.CompareAndSwap(last, now) {
+			return
+		}
+		s.stacks = goroutineStacks(s.label)
+	})
+	defer func() {
+		if timer.Stop() {
+			close(s.dumped)
+		}
+	}()
+	pprof.Do(r.Context(), pprof.Labels("request_id", id, "route", s.route), func(ctx context.Context) {
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// goroutineStacks returns the stacks of the goroutines whose pprof labels
+// contain label, in the format of the goroutine profile.
+func goroutineStacks(label string) string {
+	var buf bytes.Buffer
+	pprof.Lookup("goroutine").WriteTo(&buf, 1)
+	var stacks []string
+	for record := range strings.SplitSeq(buf.String(), "\n\n") {
+		if strings.Contains(record, label) {
+			stacks = append(stacks, record)
+		}
+	}
+	return strings.Join(stacks, "\n\n")
+}
+
+// attrs returns the attributes of the slow request record, waiting for the
+// dump being taken, if any.
+func (s *slowWatch) attrs() []slog.Attr {
+	attrs := []slog.Attr{
+		slog.String("route", s.route),
+		slog.Duration("threshold", s.threshold),
+	}
+	if id := s.identity.id; id != nil {
+		attrs = append(attrs, slog.String("subject", id.Subject), slog.String("auth_method", id.Method))
+	}
+	if s.dumped != nil {
+		<-s.dumped
+		if s.stacks != "" {
+			attrs = append(attrs, slog.String("goroutines", s.stacks))
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"bufio"
+	"io/fs"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// SPAOptions configures the SPA middleware.
+type SPAOptions struct {
+	// FS holds the application shell.
+	FS fs.FS
+	// Index names the shell within FS; defaults to index.html.
+	Index string
+	// Exclude lists path prefixes that keep their 404s, such as the API;
+	// defaults to /api/.
+	Exclude []string
+}
+
+// SPA hosts single-page applications using client-side routing: GET and
+// HEAD requests that the next handler answers with 404 are served the
+// index page instead, provided the client asked for HTML and the path is
+// not excluded. Scripts, images and API calls thus still get real 404s
+// while deep links like /settings/profile load the app. Typically it wraps
+// Static for the same files, e.g.
+//
+//	assets := os.DirFS("dist")
+//	router.PathPrefix("/").Handler(middleware.SPA(middleware.SPAOptions{FS: assets})(middleware.Static(assets, middleware.StaticOptions{})))
+func SPA(opts SPAOptions) Middleware {
+	index := opts.Index
+	if index == "" {
+		index = "index.html"
+	}
+	exclude := opts.Exclude
+	if exclude == nil {
+		exclude = []string{"/api/"}
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+			for _, p := range exclude {
+				if strings.HasPrefix(r.URL.Path, p) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			sw := &spaWriter{ResponseWriter: w, html: acceptsHTML(r)}
+			next.ServeHTTP(sw, r)
+			if !sw.fallback {
+				return
+			}
+			h := w.Header()
+			for _, k := range []string{"Content-Type", "Content-Length", "X-Content-Type-Options", "ETag", "Last-Modified"} {
+				h.Del(k)
+			}
+			h.Set("Cache-Control", "no-cache")
+			http.ServeFileFS(w, r, opts.FS, index)
+		})
+	}
+}
+
+// acceptsHTML reports whether r lists text/html in its Accept header, as
+// browsers do when navigating but not when fetching scripts or data.
+func acceptsHTML(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, params, err := mime.ParseMediaType(part); err == nil && mediaType == "text/html" && params["q"] != "0" {
+			return true
+		}
+	}
+	return false
+}
+
+// spaWriter swallows a 404 so the index page can be served instead.
+type spaWriter struct {
+	http.ResponseWriter
+	html bool
+
+	wroteHeader bool
+	fallback    bool
+}
+
+func (sw *spaWriter) WriteHeader(code int) {
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		sw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if sw.wroteHeader {
+		return
+	}
+	sw.wroteHeader = true
+	if code == http.StatusNotFound {
+		// The answer to this URL depends on Accept.
+		sw.Header().Add("Vary", "Accept")
+		if sw.html {
+			sw.fallback = true
+			return
+		}
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *spaWriter) Write(p []byte) (int, error) {
+	if !sw.wroteHeader {
+		sw.WriteHeader(http.StatusOK)
+	}
+	if sw.fallback {
+		return len(p), nil
+	}
+	return sw.ResponseWriter.Write(p)
+}
+
+func (sw *spaWriter) Flush() {
+	if sw.fallback {
+		return
+	}
+	http.NewResponseController(sw.ResponseWriter).Flush()
+}
+
+func (sw *spaWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(sw.ResponseWriter).Hijack()
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController.
+func (sw *spaWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
@@ -0,0 +1,287 @@
+package middleware
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event is a server-sent event.
+type Event struct {
+	// ID lets a reconnecting client resume after the event; SSEBroker
+	// numbers events that don't have one.
+	ID string
+	// Type is the event name a client listens for; empty means "message".
+	Type string
+	// Data is the payload; it may span several lines.
+	Data string
+	// Retry, if positive, changes the client's reconnection delay.
+	Retry time.Duration
+}
+
+// errNoStreaming is returned by NewEventStream for writers that can't flush.
+var errNoStreaming = errors.New("middleware: ResponseWriter does not support streaming")
+
+// EventStream sends server-sent events to one client.
+type EventStream struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+// NewEventStream starts an event stream on w: it sets the text/event-stream
+// headers, lifts the server's write deadline (where the writer allows it)
+// and sends the header. It fails, with nothing written, if w can't flush,
+// e.g. behind a middleware buffering the response.
+func NewEventStream(w http.ResponseWriter) (*EventStream, error) {
+	rc := http.NewResponseController(w)
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	// Keeps nginx and similar proxies from buffering the stream.
+	h.Set("X-Accel-Buffering", "no")
+	h.Del("Content-Length")
+	// ErrNotSupported leaves the server's write timeout in place, which
+	// ends the stream early; see WriteDeadline.
+	_ = rc.SetWriteDeadline(time.Time{})
+	if err := rc.Flush(); err != nil {
+		for _, k := range []string{"Content-Type", "Cache-Control", "X-Accel-Buffering"} {
+			h.Del(k)
+		}
+		return nil, errNoStreaming
+	}
+	return &EventStream{w: w, rc: rc}, nil
+}
+
+// Send writes e and flushes it to the client.
+func (s *EventStream) Send(e Event) error {
+	var b strings.Builder
+	if e.ID != "" {
+		b.WriteString("id: " + singleLine(e.ID) + "\n")
+	}
+	if e.Type != "" {
+		b.WriteString("event: " + singleLine(e.Type) + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(e.Data, "\r\n", "\n"), "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteByte('\n')
+	return s.write(b.String())
+}
+
+// Comment writes a comment line, which clients ignore; it keeps idle
+// connections from being closed by proxies.
+func (s *EventStream) Comment(text string) error {
+	return s.write(": " + singleLine(text) + "\n\n")
+}
+
+func (s *EventStream) write(text string) error {
+	if _, err := io.WriteString(s.w, text); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// singleLine drops line breaks, which would end an SSE field early.
+func singleLine(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// SSEOptions configures an SSEBroker.
+type SSEOptions struct {
+	// Topic picks the topic a request subscribes to; defaults to one topic
+	// for everyone. Per-user streams can use the Identity Subject.
+	Topic func(r *http.Request) string
+	// Heartbeat is the interval of keep-alive comments on idle streams;
+	// defaults to 15s.
+	Heartbeat time.Duration
+	// Buffer is how many events may queue for a client; defaults to 16.
+	// Clients falling further behind are disconnected and catch up from
+	// History when they reconnect.
+	Buffer int
+	// History is how many recent events per topic are kept for clients
+	// reconnecting with Last-Event-ID; defaults to 100.
+	History int
+	// Retry is the reconnection delay told to clients; defaults to theirs,
+	// usually a few seconds.
+	Retry time.Duration
+	// Logger receives records about dropped clients; defaults to
+	// slog.Default().
+	Logger *slog.Logger
+}
+
+// SSEBroker fans events out to server-sent event streams. It is the
+// handler of the stream endpoint: each GET request subscribes to a topic,
+// first receives the events it missed since its Last-Event-ID, then every
+// event published to the topic, with heartbeats in between. Close ends all
+// streams, so register it with http.Server.RegisterOnShutdown; otherwise
+// Shutdown waits for the streams until its drain timeout.
+//
+// Streams last indefinitely, so middleware bounding request duration or
+// counting in-flight requests, such as Timeout and ConcurrencyLimit,
+// should be left out of the route with Unless.
+type SSEBroker struct {
+	opts SSEOptions
+
+	mu      sync.Mutex
+	clients map[*sseClient]struct{}
+	history map[string][]Event
+	seq     uint64
+	closed  bool
+	done    chan struct{}
+}
+
+type sseClient struct {
+	topic   string
+	events  chan Event
+	dropped bool
+}
+
+// NewSSEBroker returns a broker with no clients.
+func NewSSEBroker(opts SSEOptions) *SSEBroker {
+	if opts.Topic == nil {
+		opts.Topic = func(*http.Request) string { return "" }
+	}
+	if opts.Heartbeat <= 0 {
+		opts.Heartbeat = 15 * time.Second
+	}
+	if opts.Buffer <= 0 {
+		opts.Buffer = 16
+	}
+	if opts.History <= 0 {
+		opts.History = 100
+	}
+	return &SSEBroker{
+		opts:    opts,
+		clients: make(map[*sseClient]struct{}),
+		history: make(map[string][]Event),
+		done:    make(chan struct{}),
+	}
+}
+
+// Publish sends e to the clients subscribed to topic and returns its ID,
+// assigning the next sequence number if e has none. It never blocks on slow
+// clients.
+func (b *SSEBroker) Publish(topic string, e Event) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	if e.ID == "" {
+		e.ID = strconv.FormatUint(b.seq, 10)
+	}
+	h := append(b.history[topic], e)
+	if len(h) > b.opts.History {
+		h = h[len(h)-b.opts.History:]
+	}
+	b.history[topic] = h
+	for c := range b.clients {
+		if c.topic != topic || c.dropped {
+			continue
+		}
+		select {
+		case c.events <- e:
+		default:
+			c.dropped = true
+			close(c.events)
+		}
+	}
+	return e.ID
+}
+
+// Clients returns the number of connected streams.
+func (b *SSEBroker) Clients() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.clients)
+}
+
+// Close ends every stream and makes new subscriptions fail with 503.
+func (b *SSEBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.closed = true
+		close(b.done)
+	}
+}
+
+func (b *SSEBroker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	c := &sseClient{topic: b.opts.Topic(r), events: make(chan Event, b.opts.Buffer)}
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	missed := b.since(c.topic, r.Header.Get("Last-Event-ID"))
+	b.clients[c] = struct{}{}
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.clients, c)
+		b.mu.Unlock()
+	}()
+
+	stream, err := NewEventStream(w)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if b.opts.Retry > 0 {
+		err = stream.write("retry: " + strconv.FormatInt(b.opts.Retry.Milliseconds(), 10) + "\n\n")
+	}
+	for _, e := range missed {
+		if err == nil {
+			err = stream.Send(e)
+		}
+	}
+
+	heartbeat := time.NewTicker(b.opts.Heartbeat)
+	defer heartbeat.Stop()
+	for err == nil {
+		select {
+		case e, ok := <-c.events:
+			if !ok {
+				requestLogger(b.opts.Logger, r).LogAttrs(r.Context(), slog.LevelWarn, "sse client too slow, disconnected",
+					slog.String("topic", c.topic),
+					slog.Int("buffer", b.opts.Buffer),
+				)
+				return
+			}
+			err = stream.Send(e)
+		case <-heartbeat.C:
+			err = stream.Comment("heartbeat")
+		case <-r.Context().Done():
+			return
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// since returns the events of topic after the one with ID lastID, or none
+// if lastID is empty or no longer in the history. b.mu must be held.
+func (b *SSEBroker) since(topic, lastID string) []Event {
+	if lastID == "" {
+		return nil
+	}
+	h := b.history[topic]
+	for i := len(h) - 1; i >= 0; i-- {
+		if h[i].ID == lastID {
+			return append([]Event(nil), h[i+1:]...)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,283 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// precompressedSuffixes maps content codings to the file suffix of their
+// precompressed variants.
+var precompressedSuffixes = map[string]string{
+	"br":   ".br",
+	"zstd": ".zst",
+	"gzip": ".gz",
+}
+
+// StaticOptions configures the Static handler.
+type StaticOptions struct {
+	// Index is served for directory requests; defaults to index.html.
+	Index string
+	// Browse lists directories without an index file. By default they are
+	// answered with 404.
+	Browse bool
+	// ShowDotfiles serves names starting with "." (other than
+	// /.well-known); by default they are hidden, so stray .env or .git
+	// files don't leak.
+	ShowDotfiles bool
+	// Precompressed lists the encodings whose variants ("app.js.br",
+	// ".zst", ".gz") are served in place of the file to clients accepting
+	// them; defaults to br, zstd and gzip. Set it to an empty non-nil slice
+	// to disable.
+	Precompressed []string
+	// Fingerprinted reports whether a file name embeds a content hash, so
+	// it can be cached forever; defaults to names with a dot- or
+	// dash-separated hex segment of at least 8 digits, like
+	// "app.3f9a1c2e.js".
+	Fingerprinted func(name string) bool
+	// MaxAge is the browser cache lifetime of files that aren't
+	// fingerprinted; defaults to 0, i.e. "no-cache", so they are
+	// revalidated with their ETag on every use.
+	MaxAge time.Duration
+}
+
+// Static serves the files of fsys. Content types follow the file
+// extension, Range and conditional requests are handled by
+// http.ServeContent, and each file gets an ETag. Fingerprinted files are
+// sent with "Cache-Control: public, max-age=31536000, immutable". Mount it
+// with http.StripPrefix, e.g.
+//
+//	router.PathPrefix("/static/").Handler(http.StripPrefix("/static", middleware.Static(os.DirFS("public"), middleware.StaticOptions{})))
+func Static(fsys fs.FS, opts StaticOptions) http.Handler {
+	index := opts.Index
+	if index == "" {
+		index = "index.html"
+	}
+	encodings := opts.Precompressed
+	if encodings == nil {
+		encodings = []string{"br", "zstd", "gzip"}
+	}
+	fingerprinted := opts.Fingerprinted
+	if fingerprinted == nil {
+		fingerprinted = hasHashSegment
+	}
+	revalidate := "no-cache"
+	if opts.MaxAge > 0 {
+		revalidate = "public, max-age=" + strconv.Itoa(int(opts.MaxAge.Seconds()))
+	}
+	// Files without a modification time, as in embed.FS, are tagged by a
+	// hash of their content computed once.
+	var hashes sync.Map
+
+	serveFile := func(w http.ResponseWriter, r *http.Request, name string, info fs.FileInfo) {
+		h := w.Header()
+		ctype := mime.TypeByExtension(path.Ext(name))
+		size, modTime := info.Size(), info.ModTime()
+		open, suffix := name, ""
+		// Variants need a known type: ServeContent would sniff the
+		// compressed bytes otherwise.
+		if ctype != "" && len(encodings) > 0 {
+			var available []string
+			variants := make(map[string]fs.FileInfo)
+			for _, enc := range encodings {
+				if vi, err := fs.Stat(fsys, name+precompressedSuffixes[enc]); err == nil && vi.Mode().IsRegular() {
+					available = append(available, enc)
+					variants[enc] = vi
+				}
+			}
+			if len(available) > 0 {
+				h.Add("Vary", "Accept-Encoding")
+				if enc := negotiateEncoding(r.Header.Get("Accept-Encoding"), available); enc != "" {
+					open, suffix = name+precompressedSuffixes[enc], "-"+enc
+					size, modTime = variants[enc].Size(), variants[enc].ModTime()
+					h.Set("Content-Encoding", enc)
+				}
+			}
+		}
+
+		f, err := fsys.Open(open)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		defer f.Close()
+		content, ok := f.(io.ReadSeeker)
+		if !ok {
+			data, err := io.ReadAll(f)
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			content = bytes.NewReader(data)
+		}
+
+		var tag string
+		if modTime.IsZero() {
+			if v, ok := hashes.Load(open); ok {
+				tag = v.(string)
+			} else {
+				sum := sha256.New()
+				if _, err := io.Copy(sum, content); err != nil {
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+				content.Seek(0, io.SeekStart)
+				tag = base64.RawURLEncoding.EncodeToString(sum.Sum(nil)[:12])
+				hashes.Store(open, tag)
+			}
+		} else {
+			tag = strconv.FormatInt(modTime.UnixNano(), 36) + "-" + strconv.FormatInt(size, 36) + suffix
+		}
+		h.Set("ETag", `"`+tag+`"`)
+
+		if ctype != "" {
+			h.Set("Content-Type", ctype)
+		}
+		if fingerprinted(path.Base(name)) {
+			h.Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			h.Set("Cache-Control", revalidate)
+		}
+		http.ServeContent(w, r, name, modTime, content)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		if name == "" {
+			name = "."
+		}
+		if !opts.ShowDotfiles && hasDotSegment(name) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		info, err := fs.Stat(fsys, name)
+		if err != nil {
+			code := http.StatusNotFound
+			if errors.Is(err, fs.ErrPermission) {
+				code = http.StatusForbidden
+			}
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+		if !info.IsDir() {
+			serveFile(w, r, name, info)
+			return
+		}
+
+		// Directories are addressed with a trailing slash, so relative
+		// links in their index resolve inside them. The Location is
+		// relative as the handler may be mounted under a stripped prefix.
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			target := path.Base(r.URL.Path) + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			w.Header().Set("Location", target)
+			w.WriteHeader(http.StatusMovedPermanently)
+			return
+		}
+		indexName := path.Join(name, index)
+		if ii, err := fs.Stat(fsys, indexName); err == nil && ii.Mode().IsRegular() {
+			serveFile(w, r, indexName, ii)
+			return
+		}
+		if !opts.Browse {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		entries, err := fs.ReadDir(fsys, name)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		writeListing(w, r, entries, opts.ShowDotfiles)
+	})
+}
+
+// writeListing renders a minimal HTML index of entries.
+func writeListing(w http.ResponseWriter, r *http.Request, entries []fs.DirEntry, dotfiles bool) {
+	var b strings.Builder
+	title := html.EscapeString(r.URL.Path)
+	fmt.Fprintf(&b, "<!doctype html>\n<meta charset=\"utf-8\">\n<title>%s</title>\n<h1>%s</h1>\n<ul>\n", title, title)
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		if a.IsDir() != b.IsDir() {
+			if a.IsDir() {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Name(), b.Name())
+	})
+	for _, e := range entries {
+		name := e.Name()
+		if !dotfiles && strings.HasPrefix(name, ".") {
+			continue
+		}
+		if e.IsDir() {
+			name += "/"
+		}
+		href := (&url.URL{Path: name}).String()
+		fmt.Fprintf(&b, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(href), html.EscapeString(name))
+	}
+	b.WriteString("</ul>\n")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	io.WriteString(w, b.String())
+}
+
+// hasDotSegment reports whether any element of the slash-separated name
+// starts with a dot, except the .well-known directory.
+func hasDotSegment(name string) bool {
+	for _, seg := range strings.Split(name, "/") {
+		if strings.HasPrefix(seg, ".") && seg != "." && seg != ".well-known" {
+			return true
+		}
+	}
+	return false
+}
+
+// hasHashSegment reports whether name contains a dot- or dash-separated
+// segment of at least 8 hex digits, at least one of them a decimal digit so
+// words like "deadbeef" don't count.
+func hasHashSegment(name string) bool {
+	segments := strings.FieldsFunc(name, func(r rune) bool { return r == '.' || r == '-' })
+	// The last segment is the extension.
+	for _, seg := range segments[:max(0, len(segments)-1)] {
+		if len(seg) < 8 {
+			continue
+		}
+		digit, hex := false, true
+		for _, c := range seg {
+			switch {
+			case c >= '0' && c <= '9':
+				digit = true
+			case c >= 'a' && c <= 'f', c >= 'A' && c <= 'F':
+			default:
+				hex = false
+			}
+		}
+		if hex && digit {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,329 @@
+package middleware
+
+import (
+	"cmp"
+	"html/template"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// StatsOptions configures NewStats.
+type StatsOptions struct {
+	// Window is the span the aggregates cover, counted in one-second
+	// buckets; defaults to 1m.
+	Window time.Duration
+	// TopRoutes is the number of busiest routes StatsSnapshot lists;
+	// defaults to 10.
+	TopRoutes int
+	// Refresh is how often the dashboard page reloads; defaults to 5s.
+	Refresh time.Duration
+	// Logger receives a record of every reset; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Stats keeps in-memory aggregates of the requests of the last
+// StatsOptions.Window in a ring of one-second buckets: counts by route, as
+// for Metrics, and by status class, and latency quantiles estimated with
+// t-digests. Handler serves them as a dashboard page or JSON: enough
+// observability for a small deployment without a metrics backend.
+type Stats struct {
+	opts   StatsOptions
+	active gauge
+
+	mu      sync.Mutex
+	start   time.Time // or last reset
+	buckets []statsBucket
+}
+
+type statsBucket struct {
+	second int64 // Unix time
+	total  routeBucket
+	routes map[string]*routeBucket
+}
+
+type routeBucket struct {
+	requests int
+	statuses [6]int  // by status class, 0 for unknown
+	latency  tdigest // in nanoseconds
+}
+
+func (b *routeBucket) add(o *routeBucket) {
+	b.requests += o.requests
+	for class, n := range o.statuses {
+		b.statuses[class] += n
+	}
+	b.latency.merge(&o.latency)
+}
+
+// NewStats returns empty aggregates.
+func NewStats(opts StatsOptions) *Stats {
+	if opts.Window < time.Second {
+		opts.Window = time.Minute
+	}
+	if opts.TopRoutes <= 0 {
+		opts.TopRoutes = 10
+	}
+	if opts.Refresh <= 0 {
+		opts.Refresh = 5 * time.Second
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	return &Stats{opts: opts, start: time.Now(), buckets: make([]statsBucket, int(opts.Window/time.Second))}
+}
+
+// Middleware counts every request once it is served. Protocol upgrades,
+// which last as long as the connection, count as active but their
+// latency is not recorded.
+func (s *Stats) Middleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.active.add(1)
+			defer s.active.add(-1)
+			if isUpgrade(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			start := time.Now()
+			rec, owned := acquireResponseRecorder(w)
+			if owned {
+				defer releaseResponseRecorder(rec)
+			}
+			next.ServeHTTP(rec, r)
+			status := rec.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			s.record(routeTemplate(r), status, time.Since(start))
+		})
+	}
+}
+
+func (s *Stats) record(route string, status int, d time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	class := status / 100
+	if class < 1 || class > 5 {
+		class = 0
+	}
+	now := time.Now().Unix()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &s.buckets[now%int64(len(s.buckets))]
+	if b.second != now {
+		*b = statsBucket{second: now, routes: map[string]*routeBucket{}}
+	}
+	rb := b.routes[route]
+	if rb == nil {
+		rb = &routeBucket{}
+		b.routes[route] = rb
+	}
+	for _, rb := range []*routeBucket{&b.total, rb} {
+		rb.requests++
+		rb.statuses[class]++
+		rb.latency.add(float64(d))
+	}
+}
+
+// Reset drops the aggregates, e.g. after a deploy; active requests are
+// still counted.
+func (s *Stats) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.start = time.Now()
+	clear(s.buckets)
+}
+
+// StatsSnapshot is the state of Stats at one time.
+type StatsSnapshot struct {
+	Time time.Time `json:"time"`
+	// Since is when counting started, or the last reset.
+	Since  time.Time `json:"since"`
+	Window string    `json:"window"`
+	// Active is the number of requests being served.
+	Active   int64          `json:"active"`
+	Requests int            `json:"requests"`
+	RPS      float64        `json:"rps"`
+	Latency  LatencySummary `json:"latency"`
+	// Statuses counts responses by class, e.g. "2xx".
+	Statuses map[string]int `json:"statuses"`
+	// Routes are the busiest routes, busiest first.
+	Routes []RouteStats `json:"routes"`
+}
+
+// LatencySummary gives estimated latency quantiles in milliseconds.
+type LatencySummary struct {
+	P50 float64 `json:"p50_ms"`
+	P95 float64 `json:"p95_ms"`
+	P99 float64 `json:"p99_ms"`
+}
+
+// RouteStats are the aggregates of one route.
+type RouteStats struct {
+	Route    string         `json:"route"`
+	Requests int            `json:"requests"`
+	RPS      float64        `json:"rps"`
+	Statuses map[string]int `json:"statuses"`
+	Latency  LatencySummary `json:"latency"`
+}
+
+// Snapshot returns the aggregates of the window ending now.
+func (s *Stats) Snapshot() StatsSnapshot {
+	now := time.Now()
+	var total routeBucket
+	routes := map[string]*routeBucket{}
+	oldest := now.Unix() - int64(len(s.buckets)) + 1
+	s.mu.Lock()
+	since := s.start
+	for i := range s.buckets {
+		b := &s.buckets[i]
+		if b.second < oldest {
+			continue
+		}
+		total.add(&b.total)
+		for route, rb := range b.routes {
+			sum := routes[route]
+			if sum == nil {
+				sum = &routeBucket{}
+				routes[route] = sum
+			}
+			sum.add(rb)
+		}
+	}
+	s.mu.Unlock()
+
+	// The current second is still filling, so rates are over the seconds
+	// elapsed, or since the start when that is shorter
+	seconds := min(s.opts.Window.Seconds(), math.Max(now.Sub(since).Seconds(), 1))
+	snap := StatsSnapshot{
+		Time:     now,
+		Since:    since,
+		Window:   s.opts.Window.String(),
+		Active:   s.active.value(),
+		Requests: total.requests,
+		RPS:      float64(total.requests) / seconds,
+		Latency:  summarize(&total.latency),
+		Statuses: statusCounts(total.statuses),
+		Routes:   []RouteStats{},
+	}
+	for route, sum := range routes {
+		snap.Routes = append(snap.Routes, RouteStats{
+			Route:    route,
+			Requests: sum.requests,
+			RPS:      float64(sum.requests) / seconds,
+			Statuses: statusCounts(sum.statuses),
+			Latency:  summarize(&sum.latency),
+		})
+	}
+	slices.SortFunc(snap.Routes, func(a, b RouteStats) int {
+		return cmp.Or(cmp.Compare(b.Requests, a.Requests), cmp.Compare(a.Route, b.Route))
+	})
+	if len(snap.Routes) > s.opts.TopRoutes {
+		snap.Routes = snap.Routes[:s.opts.TopRoutes]
+	}
+	return snap
+}
+
+func summarize(t *tdigest) LatencySummary {
+	ms := func(q float64) float64 { return math.Round(t.quantile(q)/float64(time.Millisecond)*1000) / 1000 }
+	return LatencySummary{P50: ms(0.5), P95: ms(0.95), P99: ms(0.99)}
+}
+
+func statusCounts(statuses [6]int) map[string]int {
+	out := map[string]int{}
+	for class, n := range statuses {
+		if n > 0 {
+			out[statusClassName(class)] = n
+		}
+	}
+	return out
+}
+
+func statusClassName(class int) string {
+	if class == 0 {
+		return "other"
+	}
+	return strconv.Itoa(class) + "xx"
+}
+
+// Handler serves Snapshot to GET, as a dashboard page reloading itself
+// every StatsOptions.Refresh for browsers and as JSON otherwise, and
+// resets the aggregates on DELETE. Like Registry.Handler it must be
+// mounted behind admin-only middleware.
+func (s *Stats) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodDelete:
+			s.Reset()
+			attrs := []slog.Attr{}
+			if id, ok := IdentityFromContext(r.Context()); ok {
+				attrs = append(attrs, slog.String("reset_by", id.Subject))
+			}
+			requestLogger(s.opts.Logger, r).LogAttrs(r.Context(), slog.LevelInfo, "stats reset", attrs...)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		default:
+			w.Header().Set("Allow", "GET, HEAD, DELETE")
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		w.Header().Set("Vary", "Accept")
+		w.Header().Set("Cache-Control", "no-store")
+		if !acceptsHTML(r) {
+			writeJSON(w, http.StatusOK, s.Snapshot())
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		statsPage.Execute(w, struct {
+			StatsSnapshot
+			Refresh int
+		}{s.Snapshot(), int(s.opts.Refresh.Seconds())})
+	})
+}
+
+var statsPage = template.Must(template.New("stats").Funcs(template.FuncMap{
+	"ms":   func(ms float64) string { return strconv.FormatFloat(ms, 'f', 1, 64) + " ms" },
+	"rate": func(f float64) string { return strconv.FormatFloat(f, 'f', 2, 64) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.Refresh}}">
+<title>Stats</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2em; color: #222; }
+.cards { display: flex; flex-wrap: wrap; gap: 1em; }
+.card { border: 1px solid #ddd; border-radius: 6px; padding: 0.8em 1.2em; min-width: 8em; }
+.card b { display: block; font-size: 1.6em; }
+table { border-collapse: collapse; margin-top: 1.5em; }
+th, td { padding: 0.3em 1em; border-bottom: 1px solid #eee; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+small { color: #777; }
+</style>
+</head>
+<body>
+<h1>Stats</h1>
+<small>Last {{.Window}}, counting since {{.Since.Format "15:04:05"}}, refreshed every {{.Refresh}}s at {{.Time.Format "15:04:05"}}</small>
+<div class="cards">
+<div class="card">Requests/s<b>{{rate .RPS}}</b></div>
+<div class="card">Active<b>{{.Active}}</b></div>
+<div class="card">p50<b>{{ms .Latency.P50}}</b></div>
+<div class="card">p95<b>{{ms .Latency.P95}}</b></div>
+<div class="card">p99<b>{{ms .Latency.P99}}</b></div>
+{{range $class, $n := .Statuses}}<div class="card">{{$class}}<b>{{$n}}</b></div>
+{{end}}</div>
+<table>
+<tr><th>Route</th><th>Requests</th><th>Requests/s</th><th>4xx</th><th>5xx</th><th>p50</th><th>p95</th><th>p99</th></tr>
+{{range .Routes}}<tr><td>{{.Route}}</td><td>{{.Requests}}</td><td>{{rate .RPS}}</td><td>{{index .Statuses "4xx"}}</td><td>{{index .Statuses "5xx"}}</td><td>{{ms .Latency.P50}}</td><td>{{ms .Latency.P95}}</td><td>{{ms .Latency.P99}}</td></tr>
+{{else}}<tr><td colspan="8">No requests yet</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
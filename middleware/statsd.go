@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatsDOptions configures a StatsD sink.
+type StatsDOptions struct {
+	// Namespace is prepended to every metric name, e.g. "myapp.".
+	Namespace string
+	// Tags are added to every metric, e.g. "env:prod".
+	Tags []string
+	// DogStatsD appends tags in the Datadog "|#k:v,k2:v2" format. Plain
+	// StatsD has no tag support, so tags are dropped when false.
+	DogStatsD bool
+	// SampleRate, between 0 and 1, is reported to the server for counters
+	// and timings; defaults to 1 (every event is sent).
+	SampleRate float64
+}
+
+// StatsD is a MetricsSink that sends metrics over UDP using the StatsD line
+// protocol. Sends are fire-and-forget: network errors are ignored so metrics
+// never slow down or fail requests.
+type StatsD struct {
+	conn net.Conn
+	opts StatsDOptions
+	rate string
+}
+
+// NewStatsD returns a sink sending to addr ("host:port").
+func NewStatsD(addr string, opts StatsDOptions) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &StatsD{conn: conn, opts: opts}
+	if opts.SampleRate > 0 && opts.SampleRate < 1 {
+		s.rate = "|@" + strconv.FormatFloat(opts.SampleRate, 'f', -1, 64)
+	}
+	return s, nil
+}
+
+func (s *StatsD) Count(name string, value int64, tags []string) {
+	s.send(name, strconv.FormatInt(value, 10), "c", s.rate, tags)
+}
+
+func (s *StatsD) Timing(name string, d time.Duration, tags []string) {
+	ms := strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+	s.send(name, ms, "ms", s.rate, tags)
+}
+
+func (s *StatsD) Gauge(name string, value float64, tags []string) {
+	s.send(name, strconv.FormatFloat(value, 'f', -1, 64), "g", "", tags)
+}
+
+// Close closes the underlying UDP socket.
+func (s *StatsD) Close() error {
+	return s.conn.Close()
+}
+
+func (s *StatsD) send(name, value, kind, rate string, tags []string) {
+	var b strings.Builder
+	b.WriteString(s.opts.Namespace)
+	b.WriteString(name)
+	b.WriteByte(':')
+	b.WriteString(value)
+	b.WriteByte('|')
+	b.WriteString(kind)
+	b.WriteString(rate)
+	if s.opts.DogStatsD && len(tags)+len(s.opts.Tags) > 0 {
+		b.WriteString("|#")
+		for i, t := range append(append([]string(nil), s.opts.Tags...), tags...) {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(sanitizeTag(t))
+		}
+	}
+	s.conn.Write([]byte(b.String()))
+}
+
+// sanitizeTag strips characters that would break the DogStatsD framing.
+func sanitizeTag(t string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '|', ',', '#', '\n':
+			return '_'
+		}
+		return r
+	}, t)
+}
@@ -0,0 +1,223 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// streamEvents is the number of lines the streaming handlers send.
+const streamEvents = 3
+
+// streamingHandler writes streamEvents lines, flushing each one and
+// waiting for the client to acknowledge it on ack before writing the next,
+// so it only finishes if every flush reaches the client.
+func streamingHandler(t *testing.T, ack <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for i := range streamEvents {
+			fmt.Fprintf(w, "event %d\n", i)
+			if err := http.NewResponseController(w).Flush(); err != nil {
+				t.Errorf("Flush: %v", err)
+				return
+			}
+			select {
+			case <-ack:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+}
+
+// readEvents reads the streamEvents lines of resp, acknowledging each.
+func readEvents(t *testing.T, resp *http.Response, ack chan<- struct{}) {
+	t.Helper()
+	br := bufio.NewReader(resp.Body)
+	for i := range streamEvents {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event %d: %v", i, err)
+		}
+		if want := fmt.Sprintf("event %d\n", i); line != want {
+			t.Fatalf("event %d = %q, want %q", i, line, want)
+		}
+		select {
+		case ack <- struct{}{}:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("handler stopped after event %d", i)
+		}
+	}
+	if rest, _ := io.ReadAll(br); len(rest) > 0 {
+		t.Fatalf("trailing data %q", rest)
+	}
+}
+
+// get requests url, giving up when the stream stalls.
+func get(t *testing.T, url string) *http.Response {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	req.Header.Set("Accept", "text/html")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestStreaming(t *testing.T) {
+	quiet := slog.New(slog.DiscardHandler)
+	spa := fstest.MapFS{"index.html": {Data: []byte("<!doctype html>")}}
+	tests := []struct {
+		name       string
+		mw         Middleware
+		compressed bool
+	}{
+		{"RequestID", RequestID(RequestIDOptions{}), false},
+		{"Recovery", Recovery(RecoveryOptions{Logger: quiet}), false},
+		{"Logging", Logging(LoggingOptions{Logger: quiet}), false},
+		{"Timing", Timing(TimingOptions{Logger: quiet, ServerTiming: true}), false},
+		{"BodyLog", BodyLog(BodyLogOptions{Logger: quiet}), false},
+		{"MaxBody", MaxBody(1 << 20), false},
+		{"Compress", Compress(CompressOptions{}), true},
+		{"ETag", ETag(ETagOptions{}), false},
+		{"Timeout", Timeout(time.Minute), false},
+		{"Cache", Cache(CacheOptions{}), false},
+		{"Coalesce", Coalesce(CoalesceOptions{}), false},
+		{"CacheControl", CacheControl(CachePolicy{CacheControl: "no-cache"}), false},
+		{"SPA", SPA(SPAOptions{FS: spa}), false},
+		{"Chain", Compose(
+			RequestID(RequestIDOptions{}),
+			Recovery(RecoveryOptions{Logger: quiet}),
+			Logging(LoggingOptions{Logger: quiet}),
+			Timing(TimingOptions{Logger: quiet, ServerTiming: true}),
+			MaxBody(1<<20),
+			Compress(CompressOptions{}),
+			ETag(ETagOptions{}),
+			Timeout(time.Minute),
+			Cache(CacheOptions{}),
+			Coalesce(CoalesceOptions{}),
+			CacheControl(CachePolicy{CacheControl: "no-cache"}),
+		), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ack := make(chan struct{})
+			srv := httptest.NewServer(tt.mw(streamingHandler(t, ack)))
+			defer srv.Close()
+
+			resp := get(t, srv.URL)
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d, want 200", resp.StatusCode)
+			}
+			if resp.Uncompressed != tt.compressed {
+				t.Errorf("compressed = %v, want %v", resp.Uncompressed, tt.compressed)
+			}
+			readEvents(t, resp, ack)
+		})
+	}
+}
+
+// TestStreamingNotShared checks that requests for a URL being streamed
+// don't wait for the stream to end, and that it isn't stored.
+func TestStreamingNotShared(t *testing.T) {
+	tests := []struct {
+		name string
+		mw   Middleware
+	}{
+		{"Cache", Cache(CacheOptions{FillTimeout: time.Minute})},
+		{"Coalesce", Coalesce(CoalesceOptions{})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			calls := 0
+			release := make(chan struct{})
+			h := tt.mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				calls++
+				mu.Unlock()
+				io.WriteString(w, "event 0\n")
+				http.NewResponseController(w).Flush()
+				select {
+				case <-release:
+				case <-r.Context().Done():
+					return
+				}
+				io.WriteString(w, "event 1\n")
+			}))
+			srv := httptest.NewServer(h)
+			defer srv.Close()
+
+			var bodies []*bufio.Reader
+			for i := range 2 {
+				br := bufio.NewReader(get(t, srv.URL).Body)
+				if line, err := br.ReadString('\n'); err != nil || line != "event 0\n" {
+					t.Fatalf("request %d: first event = %q, %v", i, line, err)
+				}
+				bodies = append(bodies, br)
+			}
+			close(release)
+			for i, br := range bodies {
+				if rest, err := io.ReadAll(br); err != nil || string(rest) != "event 1\n" {
+					t.Fatalf("request %d: rest = %q, %v", i, rest, err)
+				}
+			}
+
+			resp := get(t, srv.URL)
+			if body, _ := io.ReadAll(resp.Body); string(body) != "event 0\nevent 1\n" {
+				t.Fatalf("third body = %q", body)
+			}
+			if got := resp.Header.Get("X-Cache"); got == "HIT" {
+				t.Errorf("X-Cache = %s, want a miss", got)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if calls != 3 {
+				t.Errorf("handler ran %d times, want 3", calls)
+			}
+		})
+	}
+}
+
+// TestTimeoutStreamCutOff checks that a stream outliving the deadline is
+// ended rather than followed by a 504 page.
+func TestTimeoutStreamCutOff(t *testing.T) {
+	writeErr := make(chan error, 1)
+	h := Timeout(100 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "event 0\n")
+		http.NewResponseController(w).Flush()
+		<-r.Context().Done()
+		_, err := io.WriteString(w, "event 1\n")
+		writeErr <- err
+	}))
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp := get(t, srv.URL)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "event 0\n" {
+		t.Errorf("body = %q, want only the first event", body)
+	}
+	if strings.Contains(string(body), http.StatusText(http.StatusGatewayTimeout)) {
+		t.Error("504 written into the stream")
+	}
+	if err := <-writeErr; err != http.ErrHandlerTimeout {
+		t.Errorf("write after deadline: %v, want http.ErrHandlerTimeout", err)
+	}
+}
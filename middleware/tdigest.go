@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"math"
+	"slices"
+)
+
+// tdigest estimates quantiles of a stream in bounded memory, accurately in
+// the tails where latency quantiles are read (Dunning's merging t-digest
+// with the k1 scale function). The zero value is empty and ready to use.
+type tdigest struct {
+	centroids []centroid // sorted by mean
+	buffer    []centroid // not merged yet
+	count     float64
+	min, max  float64
+}
+
+type centroid struct {
+	mean  float64
+	count float64
+}
+
+const (
+	// tdigestCompression bounds the centroids to about half this many.
+	tdigestCompression = 200
+	tdigestBuffer      = 5 * tdigestCompression
+)
+
+func (t *tdigest) add(x float64) {
+	t.addCentroid(centroid{x, 1})
+}
+
+func (t *tdigest) addCentroid(c centroid) {
+	if t.count == 0 && len(t.buffer) == 0 {
+		t.min, t.max = c.mean, c.mean
+	}
+	t.min, t.max = min(t.min, c.mean), max(t.max, c.mean)
+	t.buffer = append(t.buffer, c)
+	if len(t.buffer) >= tdigestBuffer {
+		t.compress()
+	}
+}
+
+// merge adds the values of o.
+func (t *tdigest) merge(o *tdigest) {
+	if o.count == 0 && len(o.buffer) == 0 {
+		return
+	}
+	lo, hi := o.min, o.max
+	for _, c := range o.centroids {
+		t.addCentroid(c)
+	}
+	for _, c := range o.buffer {
+		t.addCentroid(c)
+	}
+	// Centroid means lie inside the range of the values they hold
+	t.min, t.max = min(t.min, lo), max(t.max, hi)
+}
+
+// compress merges the buffer into the centroids, combining neighbours as
+// long as each centroid spans at most one unit of k.
+func (t *tdigest) compress() {
+	if len(t.buffer) == 0 {
+		return
+	}
+	all := append(t.centroids, t.buffer...)
+	slices.SortFunc(all, func(a, b centroid) int {
+		switch {
+		case a.mean < b.mean:
+			return -1
+		case a.mean > b.mean:
+			return 1
+		}
+		return 0
+	})
+	var total float64
+	for _, c := range all {
+		total += c.count
+	}
+	out := make([]centroid, 0, tdigestCompression)
+	cur, soFar := all[0], 0.0
+	limit := total * kInverse(kScale(0)+1)
+	for _, c := range all[1:] {
+		if soFar+cur.count+c.count <= limit {
+			cur.mean += (c.mean - cur.mean) * c.count / (cur.count + c.count)
+			cur.count += c.count
+			continue
+		}
+		soFar += cur.count
+		out = append(out, cur)
+		limit = total * kInverse(kScale(soFar/total)+1)
+		cur = c
+	}
+	t.centroids = append(out, cur)
+	t.buffer = t.buffer[:0]
+	t.count = total
+}
+
+func kScale(q float64) float64 {
+	return tdigestCompression / (2 * math.Pi) * math.Asin(2*q-1)
+}
+
+func kInverse(k float64) float64 {
+	if k >= tdigestCompression/4 {
+		return 1
+	}
+	return (math.Sin(k*2*math.Pi/tdigestCompression) + 1) / 2
+}
+
+// quantile returns the estimated value at q, interpolating between the
+// centres of the centroids around it; 0 for an empty digest.
+func (t *tdigest) quantile(q float64) float64 {
+	t.compress()
+	if len(t.centroids) == 0 {
+		return 0
+	}
+	if len(t.centroids) == 1 {
+		return t.centroids[0].mean
+	}
+	rank := q * t.count
+	first := t.centroids[0]
+	if rank < first.count/2 {
+		return t.min + (first.mean-t.min)*rank/(first.count/2)
+	}
+	center := first.count / 2
+	for i := 1; i < len(t.centroids); i++ {
+		prev, c := t.centroids[i-1], t.centroids[i]
+		next := center + prev.count/2 + c.count/2
+		if rank <= next {
+			return prev.mean + (c.mean-prev.mean)*(rank-center)/(next-center)
+		}
+		center = next
+	}
+	last := t.centroids[len(t.centroids)-1]
+	if rest := t.count - center; rest > 0 {
+		return last.mean + (t.max-last.mean)*(rank-center)/rest
+	}
+	return t.max
+}
@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+)
+
+// Tenant is one customer of a multi-tenant deployment.
+type Tenant struct {
+	ID string
+	// Hosts select the tenant by Host header, exact or "*.example.com"
+	// for any subdomain as in AllowedHosts.
+	Hosts []string
+	// Config is what handlers of the tenant's requests get from
+	// ConfigFromContext; its Tenant field is set to ID.
+	Config Config
+}
+
+// TenancyOptions configures the Tenancy middleware.
+type TenancyOptions struct {
+	Tenants []Tenant
+	// Header carries the ID of the tenant for requests whose Host selects
+	// none; defaults to "X-Tenant-ID". Clients can name any tenant with
+	// it, so authorization has to check the caller belongs to the tenant.
+	Header string
+	// Default is the Config of requests for no tenant. If nil they get 404
+	// Not Found.
+	Default *Config
+	// Logger receives unknown-tenant records; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Tenancy resolves the tenant of every request, by Host and then by
+// Header, and stores its Config in the request context in place of
+// WithConfig. Requests naming an unknown tenant get 404 Not Found. When
+// Header is consulted responses vary on it, so put VaryHeaders on caches
+// behind Tenancy.
+//
+// The tenants can be replaced while serving with SetTenants, e.g. on a
+// configuration reload.
+type Tenancy struct {
+	opts    TenancyOptions
+	tenants atomic.Pointer[tenantIndex]
+}
+
+type tenantIndex struct {
+	byID    map[string]*Config
+	exact   map[string]*Config
+	pattern []tenantPattern
+}
+
+type tenantPattern struct {
+	pattern hostPattern
+	config  *Config
+}
+
+// NewTenancy returns a tenancy middleware applying opts.
+func NewTenancy(opts TenancyOptions) *Tenancy {
+	if opts.Header == "" {
+		opts.Header = "X-Tenant-ID"
+	}
+	t := &Tenancy{opts: opts}
+	t.SetTenants(opts.Tenants)
+	return t
+}
+
+// SetTenants replaces the tenants for the requests that follow. When two
+// tenants claim a host the first one gets it.
+func (t *Tenancy) SetTenants(tenants []Tenant) {
+	idx := &tenantIndex{byID: make(map[string]*Config), exact: make(map[string]*Config)}
+	for _, tenant := range tenants {
+		config := tenant.Config
+		config.Tenant = tenant.ID
+		idx.byID[tenant.ID] = &config
+		for _, h := range tenant.Hosts {
+			p := newHostPattern(h)
+			if p.suffix != "" {
+				idx.pattern = append(idx.pattern, tenantPattern{p, &config})
+			} else if _, ok := idx.exact[p.exact]; !ok {
+				idx.exact[p.exact] = &config
+			}
+		}
+	}
+	t.tenants.Store(idx)
+}
+
+// resolve returns the Config of r's tenant, whether r names one, and
+// whether the header was consulted.
+func (idx *tenantIndex) resolve(r *http.Request, header string) (config *Config, named, byHeader bool) {
+	host := requestHost(r)
+	if config, ok := idx.exact[host]; ok {
+		return config, true, false
+	}
+	for _, p := range idx.pattern {
+		if p.pattern.match(host) {
+			return p.config, true, false
+		}
+	}
+	id := r.Header.Get(header)
+	if id == "" {
+		return nil, false, true
+	}
+	return idx.byID[id], true, true
+}
+
+// Middleware returns the middleware applying t.
+func (t *Tenancy) Middleware() Middleware {
+	opts := t.opts
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			config, named, byHeader := t.tenants.Load().resolve(r, opts.Header)
+			if byHeader {
+				w.Header().Add("Vary", opts.Header)
+			}
+			if !named {
+				config = opts.Default
+			}
+			if config == nil {
+				if named {
+					requestLogger(opts.Logger, r).LogAttrs(r.Context(), slog.LevelWarn, "unknown tenant",
+						slog.String("tenant", r.Header.Get(opts.Header)),
+					)
+				}
+				writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown tenant"})
+				return
+			}
+			ctx := context.WithValue(r.Context(), configKey, config)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
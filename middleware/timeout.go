@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Timeout gives the rest of the chain a context that is cancelled after d
+// and responds with 504 Gateway Timeout if the handler has not finished by
+// then. Handlers keep running until they notice ctx.Done(); anything they
+// write after the deadline is discarded and Write returns
+// http.ErrHandlerTimeout.
+//
+// The response is buffered until the handler returns or flushes. A flush
+// commits it: what was buffered is sent and later writes go straight
+// through, so streaming handlers work, but once the deadline passes the
+// stream is cut off rather than answered with 504. Long-lived streams need
+// a d to match, or to be left out with Unless. Protocol upgrades such as
+// WebSocket handshakes are passed through without a deadline.
+func Timeout(d time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isUpgrade(r) {
+				// The connection outlives the request once hijacked.
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+
+			tw := &timeoutWriter{ctx: ctx, w: w, h: make(http.Header)}
+			done := make(chan struct{})
+			panicc := make(chan any, 1)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panicc <- p
+					}
+				}()
+				next.ServeHTTP(tw, r.WithContext(ctx))
+				close(done)
+			}()
+
+			select {
+			case p := <-panicc:
+				panic(p)
+			case <-done:
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				if !tw.streaming {
+					tw.writeBuffered()
+				}
+			case <-ctx.Done():
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				tw.timedOut = true
+				if r.Context().Err() != nil || tw.streaming {
+					// The client went away, or already has part of the
+					// response; there is nobody to answer.
+					return
+				}
+				http.Error(w, http.StatusText(http.StatusGatewayTimeout), http.StatusGatewayTimeout)
+			}
+		})
+	}
+}
+
+// timeoutWriter buffers the handler's response so it can be dropped if the
+// deadline passes first.
+type timeoutWriter struct {
+	mu        sync.Mutex
+	ctx       context.Context
+	w         http.ResponseWriter
+	h         http.Header
+	buf       bytes.Buffer
+	code      int
+	timedOut  bool
+	streaming bool
+}
+
+// writeBuffered sends the buffered response. tw.mu must be held.
+func (tw *timeoutWriter) writeBuffered() {
+	dst := tw.w.Header()
+	for k, v := range tw.h {
+		dst[k] = v
+	}
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	tw.w.WriteHeader(tw.code)
+	tw.w.Write(tw.buf.Bytes())
+	tw.buf.Reset()
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.h
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	// A stream ends at the deadline, even before Timeout gets to notice.
+	if tw.timedOut || tw.streaming && tw.ctx.Err() != nil {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	if tw.streaming {
+		return tw.w.Write(p)
+	}
+	return tw.buf.Write(p)
+}
+
+// Flush sends the response so far and switches to writing through.
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	if !tw.streaming {
+		tw.streaming = true
+		tw.writeBuffered()
+	}
+	http.NewResponseController(tw.w).Flush()
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.code != 0 {
+		return
+	}
+	if code >= 100 && code < 200 {
+		// Informational responses such as 103 Early Hints can't wait for
+		// the handler to finish; they go out at once with their Link
+		// headers.
+		if code != http.StatusSwitchingProtocols {
+			tw.w.Header()["Link"] = slices.Clone(tw.h["Link"])
+			tw.w.WriteHeader(code)
+		}
+		return
+	}
+	tw.code = code
+}
@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// TimingOptions configures the Timing middleware.
+type TimingOptions struct {
+	// Logger receives the log records; defaults to slog.Default().
+	Logger *slog.Logger
+	// ServerTiming emits a Server-Timing response header with the time spent
+	// until the response header was written, plus any sub-spans registered
+	// with AddServerTiming or StartServerTiming.
+	ServerTiming bool
+	// ServerTimingName names the total duration metric; defaults to "app".
+	ServerTimingName string
+	// ServerTimingBreakdown adds the time each middleware wrapped with
+	// Measure took until the response header was written to Server-Timing,
+	// with the description "middleware".
+	ServerTimingBreakdown bool
+	// Sample, when set, logs only the share of requests it returns, as for
+	// LoggingOptions. Server-Timing is sent either way.
+	Sample func(r *http.Request, status int) float64
+	// Apdex, when set, scores every timed request.
+	Apdex *Apdex
+	// SlowThreshold, when set, logs the requests taking longer as "slow
+	// request" at warning level instead, sampled or not, with their route
+	// and caller.
+	SlowThreshold time.Duration
+	// SlowRoutes override SlowThreshold by mux path template, e.g. a longer
+	// one for "/reports/{id}".
+	SlowRoutes map[string]time.Duration
+	// SlowDump serves requests with a threshold under pprof labels of their
+	// route and request ID, so CPU profiles can be broken down by them, and
+	// adds the stacks of the goroutines of a request still running past it
+	// to its record. Dumps stop the world briefly, so at most one is taken
+	// a second.
+	SlowDump bool
+}
+
+// Timing logs how long the rest of the chain took to serve the request,
+// along with the response status and size, and singles out slow requests.
+// The record includes the time each middleware wrapped with Measure took
+// itself, in a "middlewares" group; those outside Timing are only counted
+// until they passed the request on. Protocol upgrades, whose handlers run
+// for the life of the connection, are not timed.
+func Timing(opts TimingOptions) Middleware {
+	metricName := opts.ServerTimingName
+	if metricName == "" {
+		metricName = "app"
+	}
+	totalPrefix := metricName + ";dur="
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isUpgrade(r) {
+				// The handler returns when the connection closes, which
+				// says nothing about the handshake.
+				next.ServeHTTP(w, r)
+				return
+			}
+			start := time.Now()
+			rec, owned := acquireResponseRecorder(w)
+			if owned {
+				defer releaseResponseRecorder(rec)
+			}
+			if opts.ServerTiming {
+				st := &serverTiming{}
+				b := breakdownFromContext(r.Context())
+				rec.BeforeWriteHeader(func(int) {
+					if opts.ServerTimingBreakdown && b != nil {
+						for _, m := range b.metrics() {
+							st.add(m)
+						}
+					}
+					rec.Header().Add("Server-Timing", st.header(totalPrefix, time.Since(start)))
+				})
+				r = r.WithContext(context.WithValue(r.Context(), serverTimingKey, st))
+			}
+			r, slow := watchSlow(r, &opts)
+			if slow != nil && opts.SlowDump {
+				slow.serve(next, rec, r)
+			} else {
+				next.ServeHTTP(rec, r)
+			}
+			duration := time.Since(start)
+			if opts.Apdex != nil {
+				opts.Apdex.Record(r, rec.Status(), duration)
+			}
+			if slow != nil && duration > slow.threshold {
+				logRequest(opts.Logger, r, slog.LevelWarn, "slow request", append(timingAttrs(r, rec, duration), slow.attrs()...)...)
+				return
+			}
+			rate, ok := logSampled(opts.Sample, r, rec.Status())
+			if !ok {
+				return
+			}
+			attrs := timingAttrs(r, rec, duration)
+			if rate < 1 {
+				attrs = append(attrs, slog.Float64("sample_rate", rate))
+			}
+			logRequest(opts.Logger, r, slog.LevelInfo, "request completed", attrs...)
+		})
+	}
+}
+
+func timingAttrs(r *http.Request, rec *ResponseRecorder, duration time.Duration) []slog.Attr {
+	attrs := []slog.Attr{
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.Int("status", rec.Status()),
+		slog.Int64("bytes", rec.BytesWritten()),
+		slog.Duration("duration", duration),
+	}
+	if b := breakdownFromContext(r.Context()); b != nil {
+		attrs = append(attrs, b.attrs())
+	}
+	return attrs
+}
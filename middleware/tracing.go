@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "middlware/middleware"
+
+// TracingOptions configures the Tracing middleware.
+type TracingOptions struct {
+	// TracerProvider creates the tracer; defaults to otel.GetTracerProvider().
+	TracerProvider trace.TracerProvider
+	// Propagator extracts incoming and injects outgoing trace context;
+	// defaults to otel.GetTextMapPropagator().
+	Propagator propagation.TextMapPropagator
+	// PropagateResponse injects the server span's context into the response
+	// headers so clients can find the trace.
+	PropagateResponse bool
+}
+
+// Tracing starts an OpenTelemetry server span per request, continuing any
+// trace carried by W3C traceparent/tracestate headers. Spans are named after
+// the mux route template ("GET /users/{id}") and record the response status;
+// 5xx responses and panics mark the span as failed.
+func Tracing(opts TracingOptions) Middleware {
+	tp := opts.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	prop := opts.Propagator
+	if prop == nil {
+		prop = otel.GetTextMapPropagator()
+	}
+	tracer := tp.Tracer(tracerName, trace.WithSchemaURL(semconv.SchemaURL))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := prop.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+			route := routeTemplate(r)
+			name := r.Method
+			if route != "" {
+				name += " " + route
+			}
+			attrs := []trace.SpanStartOption{
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					semconv.HTTPRequestMethodKey.String(r.Method),
+					semconv.URLPath(r.URL.Path),
+					semconv.ServerAddress(r.Host),
+					semconv.ClientAddress(r.RemoteAddr),
+					semconv.UserAgentOriginal(r.UserAgent()),
+				),
+			}
+			if route != "" {
+				attrs = append(attrs, trace.WithAttributes(semconv.HTTPRoute(route)))
+			}
+			ctx, span := tracer.Start(ctx, name, attrs...)
+			defer span.End()
+
+			rec, owned := acquireResponseRecorder(w)
+			if owned {
+				defer releaseResponseRecorder(rec)
+			}
+			if opts.PropagateResponse {
+				prop.Inject(ctx, propagation.HeaderCarrier(rec.Header()))
+			}
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					span.RecordError(fmt.Errorf("panic: %v", recovered))
+					span.SetStatus(codes.Error, "panic")
+					panic(recovered)
+				}
+				status := rec.Status()
+				if status == 0 {
+					status = http.StatusOK
+				}
+				span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+				if status >= 500 {
+					span.SetStatus(codes.Error, http.StatusText(status))
+				}
+			}()
+			next.ServeHTTP(rec, r.WithContext(ctx))
+		})
+	}
+}
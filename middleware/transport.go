@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// TransportOptions configures Transport.
+type TransportOptions struct {
+	// Base sends the requests; defaults to http.DefaultTransport.
+	Base http.RoundTripper
+	// Header carries the request ID; defaults to X-Request-ID, as for
+	// RequestID.
+	Header string
+	// Propagator injects the trace context; defaults to
+	// otel.GetTextMapPropagator(), as for Tracing.
+	Propagator propagation.TextMapPropagator
+}
+
+// Transport returns an http.RoundTripper passing the correlation of the
+// request being served on to the requests a handler makes with its
+// context: the ID stored by RequestID and the trace context of the span
+// started by Tracing, so the services called log and trace under the same
+// request, e.g.
+//
+//	client := &http.Client{Transport: middleware.Transport(middleware.TransportOptions{})}
+//	req, _ := http.NewRequestWithContext(r.Context(), http.MethodGet, url, nil)
+//	resp, err := client.Do(req)
+//
+// Headers the outbound request already has are left alone. Every request
+// is also recorded as an "http" breadcrumb for the error report of the
+// request being served, if it fails.
+func Transport(opts TransportOptions) http.RoundTripper {
+	if opts.Base == nil {
+		opts.Base = http.DefaultTransport
+	}
+	if opts.Header == "" {
+		opts.Header = "X-Request-ID"
+	}
+	if opts.Propagator == nil {
+		opts.Propagator = otel.GetTextMapPropagator()
+	}
+	return &transport{opts}
+}
+
+type transport struct {
+	opts TransportOptions
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	carrier := propagation.MapCarrier{}
+	t.opts.Propagator.Inject(ctx, carrier)
+	if id := RequestIDFromContext(ctx); id != "" {
+		carrier[t.opts.Header] = id
+	}
+	out := req
+	if len(carrier) > 0 {
+		// A RoundTripper must not modify the request it is given.
+		out = req.Clone(ctx)
+		for key, value := range carrier {
+			if out.Header.Get(key) == "" {
+				out.Header.Set(key, value)
+			}
+		}
+	}
+	resp, err := t.opts.Base.RoundTrip(out)
+	// Credentials may be in the userinfo or the query
+	u := *req.URL
+	u.User, u.RawQuery = nil, ""
+	crumb := Breadcrumb{Category: "http", Level: slog.LevelInfo, Data: map[string]any{
+		"method": req.Method,
+		"url":    u.String(),
+	}}
+	if err != nil {
+		crumb.Level, crumb.Message = slog.LevelError, err.Error()
+	} else {
+		crumb.Data["status_code"] = resp.StatusCode
+		if resp.StatusCode >= 500 {
+			crumb.Level = slog.LevelWarn
+		}
+	}
+	AddBreadcrumb(ctx, crumb)
+	return resp, err
+}
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+)
+
+// ValidateBodyOptions configures the ValidateBody middleware.
+type ValidateBodyOptions struct {
+	// Schemas are the schemas of the request bodies by the path template of
+	// the mux route, e.g. "/items/{id}". Requests of other routes pass.
+	Schemas map[string]*JSONSchema
+	// MaxBody is the largest body validated, as it is read whole; defaults
+	// to 1 MiB. Larger bodies get 413 Request Entity Too Large.
+	MaxBody int64
+	// Logger receives rejection records; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// ValidateBody validates the JSON bodies of POST, PUT and PATCH requests
+// against the schema of their route before the handler runs. Bodies that
+// are missing or not declared as JSON get 415 Unsupported Media Type, those
+// that don't parse 400 Bad Request, and those violating the schema 422
+// Unprocessable Entity with every violation:
+//
+//	{"error": "request body invalid", "errors": [{"field": "/name", "message": "is required"}]}
+//
+// Like Route it only works for middleware added with Router.Use.
+func ValidateBody(opts ValidateBodyOptions) Middleware {
+	maxBody := opts.MaxBody
+	if maxBody <= 0 {
+		maxBody = 1 << 20
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost && r.Method != http.MethodPut && r.Method != http.MethodPatch {
+				next.ServeHTTP(w, r)
+				return
+			}
+			route := routeTemplate(r)
+			schema, ok := opts.Schemas[route]
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			reject := func(status int, reason string, body map[string]any, attrs ...slog.Attr) {
+				requestLogger(opts.Logger, r).LogAttrs(r.Context(), slog.LevelWarn, "request body rejected", append([]slog.Attr{
+					slog.String("reason", reason),
+					slog.String("route", route),
+				}, attrs...)...)
+				writeJSON(w, status, body)
+			}
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if r.Body == nil || r.Body == http.NoBody || !jsonMediaType(mediaType) {
+				w.Header().Set("Accept", "application/json")
+				reject(http.StatusUnsupportedMediaType, "not json", map[string]any{"error": "request body must be application/json"})
+				return
+			}
+			data, err := io.ReadAll(io.LimitReader(r.Body, maxBody+1))
+			var tooLarge *http.MaxBytesError
+			switch {
+			case errors.As(err, &tooLarge) || int64(len(data)) > maxBody:
+				// An enclosing MaxBody answers with its own limit
+				reject(http.StatusRequestEntityTooLarge, "too large", map[string]any{"error": "request body too large", "limit": maxBody})
+				return
+			case err != nil:
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			r.Body = readCloser{bytes.NewReader(data), r.Body}
+			v, err := decodeJSON(data)
+			if err != nil {
+				reject(http.StatusBadRequest, "malformed", map[string]any{"error": "request body is not valid JSON"}, slog.String("error", err.Error()))
+				return
+			}
+			if errs := schema.Validate(v); errs != nil {
+				fields := make([]string, len(errs))
+				for i, e := range errs {
+					fields[i] = e.Field
+				}
+				reject(http.StatusUnprocessableEntity, "invalid", map[string]any{"error": "request body invalid", "errors": errs}, slog.Any("fields", fields))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// WAFMode selects what happens when a rule matches.
+type WAFMode string
+
+const (
+	// WAFBlock rejects matching requests with 403 Forbidden.
+	WAFBlock WAFMode = "block"
+	// WAFLogOnly logs matches and lets the request through, for trying out
+	// new rules against real traffic.
+	WAFLogOnly WAFMode = "log"
+)
+
+// WAFRule matches Pattern against the request parts named in Targets:
+// "path", "query", "body", "headers" (all values) or "header:<Name>".
+type WAFRule struct {
+	ID          string   `yaml:"id"`
+	Description string   `yaml:"description"`
+	Targets     []string `yaml:"targets"`
+	Pattern     string   `yaml:"pattern"`
+	// Mode overrides the WAF's mode for this rule.
+	Mode WAFMode `yaml:"mode"`
+}
+
+// DefaultWAFRules catch the most obvious injection and traversal attempts.
+// They are deliberately coarse; tune them in log-only mode first.
+var DefaultWAFRules = []WAFRule{
+	{ID: "sqli-union", Description: "SQL injection: UNION SELECT", Targets: []string{"query", "body"},
+		Pattern: `(?i)\bunion\b[\s/*]+(all[\s/*]+)?select\b`},
+	{ID: "sqli-tautology", Description: "SQL injection: boolean tautology", Targets: []string{"query", "body"},
+		Pattern: `(?i)['"]\s*(or|and)\s+['"]?\w+['"]?\s*=\s*['"]?\w+`},
+	{ID: "sqli-comment", Description: "SQL injection: statement terminator and comment", Targets: []string{"query"},
+		Pattern: `(?i)['"]\s*;\s*(drop|delete|insert|update|shutdown)\b|['"]\s*--`},
+	{ID: "xss-script", Description: "XSS: script tag or javascript: URL", Targets: []string{"query", "body", "header:Referer"},
+		Pattern: `(?i)<\s*script\b|javascript\s*:|\bon(error|load|mouseover|focus)\s*=`},
+	{ID: "traversal", Description: "Path traversal", Targets: []string{"path", "query"},
+		Pattern: `(^|[/\\=])\.\.([/\\]|$)|(?i)/etc/passwd|\\windows\\win\.ini`},
+	{ID: "cmd-injection", Description: "Shell command injection", Targets: []string{"query"},
+		Pattern: "(?i)[;|`]\\s*(cat|wget|curl|nc|bash|sh|powershell)\\b|\\$\\(\\s*\\w+"},
+}
+
+// WAF is a compiled set of rules.
+type WAF struct {
+	rules []wafRule
+}
+
+type wafRule struct {
+	WAFRule
+	re *regexp.Regexp
+}
+
+// NewWAF compiles rules.
+func NewWAF(rules []WAFRule) (*WAF, error) {
+	w := &WAF{}
+	for _, rule := range rules {
+		if rule.ID == "" {
+			return nil, fmt.Errorf("waf: rule without id (pattern %q)", rule.Pattern)
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("waf: rule %s: %w", rule.ID, err)
+		}
+		for _, t := range rule.Targets {
+			switch {
+			case t == "path", t == "query", t == "body", t == "headers", strings.HasPrefix(t, "header:"):
+			default:
+				return nil, fmt.Errorf("waf: rule %s: unknown target %q", rule.ID, t)
+			}
+		}
+		switch rule.Mode {
+		case "", WAFBlock, WAFLogOnly:
+		default:
+			return nil, fmt.Errorf("waf: rule %s: unknown mode %q", rule.ID, rule.Mode)
+		}
+		w.rules = append(w.rules, wafRule{WAFRule: rule, re: re})
+	}
+	return w, nil
+}
+
+// LoadWAF reads rules from a YAML file of the form
+//
+//	rules:
+//	  - id: sqli-union
+//	    targets: [query, body]
+//	    pattern: '(?i)union\s+select'
+//	    mode: log
+func LoadWAF(path string) (*WAF, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Rules []WAFRule `yaml:"rules"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return NewWAF(file.Rules)
+}
+
+// WAFOptions configures WAF.Middleware.
+type WAFOptions struct {
+	// Mode applies to rules that don't set their own; defaults to WAFBlock.
+	Mode WAFMode
+	// MaxBody is how much of the request body is inspected; defaults to
+	// 64 KiB. The rest is passed to the handler unread.
+	MaxBody int64
+	// Logger receives match records; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Middleware inspects each request against the rules, logging every match
+// with its rule ID and rejecting the request with 403 Forbidden on the first
+// match of a blocking rule.
+func (f *WAF) Middleware(opts WAFOptions) Middleware {
+	mode := opts.Mode
+	if mode == "" {
+		mode = WAFBlock
+	}
+	maxBody := opts.MaxBody
+	if maxBody <= 0 {
+		maxBody = 64 << 10
+	}
+	needBody := false
+	for _, rule := range f.rules {
+		for _, t := range rule.Targets {
+			needBody = needBody || t == "body"
+		}
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var body []byte
+			if needBody && r.Body != nil && r.Body != http.NoBody {
+				var err error
+				body, err = io.ReadAll(io.LimitReader(r.Body, maxBody))
+				if err != nil {
+					http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+					return
+				}
+				r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+			}
+			for _, rule := range f.rules {
+				target, ok := rule.match(r, body)
+				if !ok {
+					continue
+				}
+				ruleMode := rule.Mode
+				if ruleMode == "" {
+					ruleMode = mode
+				}
+				requestLogger(opts.Logger, r).LogAttrs(r.Context(), slog.LevelWarn, "waf rule matched",
+					slog.String("rule_id", rule.ID),
+					slog.String("target", target),
+					slog.String("mode", string(ruleMode)),
+					slog.String("client_ip", ClientIP(r)),
+					slog.String("path", r.URL.Path),
+				)
+				if ruleMode == WAFBlock {
+					http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// match returns the first of the rule's targets the pattern matches.
+func (rule *wafRule) match(r *http.Request, body []byte) (string, bool) {
+	for _, t := range rule.Targets {
+		switch {
+		case t == "path":
+			if rule.re.MatchString(r.URL.Path) {
+				return t, true
+			}
+		case t == "query":
+			if rule.re.MatchString(unescape(r.URL.RawQuery)) {
+				return t, true
+			}
+		case t == "body":
+			// Form bodies are URL-encoded, so match the decoded form too.
+			if rule.re.Match(body) || rule.re.MatchString(unescape(string(body))) {
+				return t, true
+			}
+		case t == "headers":
+			for name, values := range r.Header {
+				for _, v := range values {
+					if rule.re.MatchString(v) {
+						return "header:" + name, true
+					}
+				}
+			}
+		default:
+			name := strings.TrimPrefix(t, "header:")
+			for _, v := range r.Header.Values(name) {
+				if rule.re.MatchString(v) {
+					return t, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// unescape decodes percent and plus escapes, keeping the input as-is when it
+// is malformed rather than letting a bad escape hide a payload.
+func unescape(s string) string {
+	if u, err := url.QueryUnescape(s); err == nil {
+		return u
+	}
+	return s
+}
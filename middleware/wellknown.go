@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SecurityTxt is the content of an RFC 9116 security.txt file.
+type SecurityTxt struct {
+	// Contact lists how to report vulnerabilities, e.g.
+	// "mailto:security@example.com". At least one is required.
+	Contact []string
+	// Expires is when the file should be considered stale; defaults to one
+	// year after the middleware was created.
+	Expires            time.Time
+	Encryption         []string
+	Acknowledgments    []string
+	PreferredLanguages []string
+	Canonical          []string
+	Policy             []string
+	Hiring             []string
+}
+
+func (s *SecurityTxt) render(now time.Time) []byte {
+	var buf bytes.Buffer
+	field := func(name string, values []string) {
+		for _, v := range values {
+			buf.WriteString(name + ": " + v + "\n")
+		}
+	}
+	field("Contact", s.Contact)
+	expires := s.Expires
+	if expires.IsZero() {
+		expires = now.AddDate(1, 0, 0)
+	}
+	field("Expires", []string{expires.UTC().Format(time.RFC3339)})
+	field("Encryption", s.Encryption)
+	field("Acknowledgments", s.Acknowledgments)
+	if len(s.PreferredLanguages) > 0 {
+		field("Preferred-Languages", []string{strings.Join(s.PreferredLanguages, ", ")})
+	}
+	field("Canonical", s.Canonical)
+	field("Policy", s.Policy)
+	field("Hiring", s.Hiring)
+	return buf.Bytes()
+}
+
+// WellKnownFile is a static document served by WellKnown.
+type WellKnownFile struct {
+	// ContentType defaults to the type registered for the path's
+	// extension, or text/plain.
+	ContentType string
+	Body        string
+}
+
+// WellKnownOptions configures the WellKnown middleware.
+type WellKnownOptions struct {
+	// SecurityTxt, when set, is served at /.well-known/security.txt and,
+	// for older scanners, /security.txt.
+	SecurityTxt *SecurityTxt
+	// RobotsTxt, when set, is served at /robots.txt.
+	RobotsTxt string
+	// Files maps further paths to documents, e.g.
+	// "/.well-known/apple-app-site-association".
+	Files map[string]WellKnownFile
+	// MaxAge is sent as Cache-Control max-age; defaults to one day.
+	MaxAge time.Duration
+}
+
+// WellKnown answers GET and HEAD requests for the configured documents
+// itself and passes everything else on. Install it in front of
+// authentication so crawlers and scanners get these files without
+// credentials.
+func WellKnown(opts WellKnownOptions) Middleware {
+	type doc struct {
+		contentType string
+		body        []byte
+	}
+	docs := make(map[string]doc)
+	for p, f := range opts.Files {
+		ct := f.ContentType
+		if ct == "" {
+			if ct = mime.TypeByExtension(path.Ext(p)); ct == "" {
+				ct = "text/plain; charset=utf-8"
+			}
+		}
+		docs[p] = doc{ct, []byte(f.Body)}
+	}
+	if opts.RobotsTxt != "" {
+		docs["/robots.txt"] = doc{"text/plain; charset=utf-8", []byte(opts.RobotsTxt)}
+	}
+	if opts.SecurityTxt != nil {
+		d := doc{"text/plain; charset=utf-8", opts.SecurityTxt.render(time.Now())}
+		docs["/.well-known/security.txt"] = d
+		docs["/security.txt"] = d
+	}
+	maxAge := opts.MaxAge
+	if maxAge <= 0 {
+		maxAge = 24 * time.Hour
+	}
+	cacheControl := "public, max-age=" + strconv.Itoa(int(maxAge.Seconds()))
+	modified := time.Now()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d, ok := docs[r.URL.Path]
+			if !ok || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", d.contentType)
+			w.Header().Set("Cache-Control", cacheControl)
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			http.ServeContent(w, r, "", modified, bytes.NewReader(d.body))
+		})
+	}
+}
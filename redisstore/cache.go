@@ -0,0 +1,78 @@
+package redisstore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"middlware/middleware"
+)
+
+// CacheStore is a middleware.CacheStore keeping responses in their binary
+// encoding, with Redis expiring each key once it may no longer be served
+// stale. Fill locks are SET NX keys, so one replica regenerates an expired
+// response while the others wait for it or serve the stale copy. They hold
+// the claimant's token, so Unlock only deletes its own.
+type CacheStore struct {
+	client Client
+	prefix string
+}
+
+var _ middleware.CacheStore = (*CacheStore)(nil)
+
+// NewCacheStore returns a store using client. Keys are prefixed with
+// prefix, or "middlware:cache:" when empty.
+func NewCacheStore(client Client, prefix string) *CacheStore {
+	if prefix == "" {
+		prefix = defaultPrefix + "cache:"
+	}
+	return &CacheStore{client: client, prefix: prefix}
+}
+
+func (s *CacheStore) Get(ctx context.Context, key string) (*middleware.CachedResponse, error) {
+	data, err := s.client.Get(ctx, s.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	resp := new(middleware.CachedResponse)
+	if err := resp.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	if time.Now().After(resp.KeepUntil()) {
+		return nil, nil
+	}
+	return resp, nil
+}
+
+func (s *CacheStore) Set(ctx context.Context, key string, resp *middleware.CachedResponse) error {
+	ttl := time.Until(resp.KeepUntil())
+	if ttl <= 0 {
+		return nil
+	}
+	data, err := resp.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, s.prefix+key, data, ttl).Err()
+}
+
+func (s *CacheStore) Lock(ctx context.Context, key, token string, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, s.prefix+"lock:"+key, token, ttl).Result()
+}
+
+// unlockScript deletes KEYS[1] if it still holds the token ARGV[1].
+var unlockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+  return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+func (s *CacheStore) Unlock(ctx context.Context, key, token string) error {
+	return unlockScript.Run(ctx, s.client, []string{s.prefix + "lock:" + key}, token).Err()
+}
@@ -0,0 +1,30 @@
+package redisstore
+
+import (
+	"context"
+	"time"
+
+	"middlware/middleware"
+)
+
+// NonceStore is a middleware.NonceStore using SET NX, so a nonce is accepted
+// by exactly one replica.
+type NonceStore struct {
+	client Client
+	prefix string
+}
+
+var _ middleware.NonceStore = (*NonceStore)(nil)
+
+// NewNonceStore returns a store using client. Keys are prefixed with
+// prefix, or "middlware:nonce:" when empty.
+func NewNonceStore(client Client, prefix string) *NonceStore {
+	if prefix == "" {
+		prefix = defaultPrefix + "nonce:"
+	}
+	return &NonceStore{client: client, prefix: prefix}
+}
+
+func (s *NonceStore) Add(ctx context.Context, nonce string, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, s.prefix+nonce, 1, ttl).Result()
+}
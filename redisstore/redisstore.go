@@ -0,0 +1,12 @@
+// Package redisstore implements the pluggable stores of package middleware
+// on top of Redis, so state is shared across replicas.
+package redisstore
+
+import "github.com/redis/go-redis/v9"
+
+// defaultPrefix namespaces every key written by this package.
+const defaultPrefix = "middlware:"
+
+// Client is the subset of go-redis clients used by the stores; *redis.Client,
+// *redis.ClusterClient and *redis.Ring all satisfy it.
+type Client = redis.UniversalClient
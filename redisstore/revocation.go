@@ -0,0 +1,61 @@
+package redisstore
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"middlware/middleware"
+)
+
+// RevocationStore is a middleware.RevocationStore whose entries are Redis
+// keys expiring with the tokens they cover, so no cleanup is needed.
+type RevocationStore struct {
+	client Client
+	prefix string
+}
+
+var _ middleware.RevocationStore = (*RevocationStore)(nil)
+
+// NewRevocationStore returns a store using client. Keys are prefixed with
+// prefix, or "middlware:revoked:" when empty.
+func NewRevocationStore(client Client, prefix string) *RevocationStore {
+	if prefix == "" {
+		prefix = defaultPrefix + "revoked:"
+	}
+	return &RevocationStore{client: client, prefix: prefix}
+}
+
+func (s *RevocationStore) RevokeToken(ctx context.Context, jti string, expires time.Time) error {
+	ttl := time.Until(expires)
+	if ttl <= 0 {
+		return middleware.ErrRevocationExpired
+	}
+	return s.client.Set(ctx, s.prefix+"jti:"+jti, 1, ttl).Err()
+}
+
+func (s *RevocationStore) RevokeSubject(ctx context.Context, subject string, issuedBefore, expires time.Time) error {
+	ttl := time.Until(expires)
+	if ttl <= 0 {
+		return middleware.ErrRevocationExpired
+	}
+	return s.client.Set(ctx, s.prefix+"sub:"+subject, issuedBefore.UnixNano(), ttl).Err()
+}
+
+func (s *RevocationStore) IsRevoked(ctx context.Context, jti, subject string, issuedAt time.Time) (bool, error) {
+	vals, err := s.client.MGet(ctx, s.prefix+"jti:"+jti, s.prefix+"sub:"+subject).Result()
+	if err != nil {
+		return false, err
+	}
+	if jti != "" && vals[0] != nil {
+		return true, nil
+	}
+	if subject != "" && vals[1] != nil {
+		before, err := strconv.ParseInt(vals[1].(string), 10, 64)
+		if err != nil {
+			return false, err
+		}
+		return issuedAt.IsZero() || issuedAt.UnixNano() < before, nil
+	}
+	return false, nil
+}
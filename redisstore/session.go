@@ -0,0 +1,57 @@
+package redisstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"middlware/session"
+)
+
+// SessionStore is a session.Store keeping JSON records in Redis keys that
+// expire with the session.
+type SessionStore struct {
+	client Client
+	prefix string
+}
+
+var _ session.Store = (*SessionStore)(nil)
+
+// NewSessionStore returns a store using client. Keys are prefixed with
+// prefix, or "middlware:session:" when empty.
+func NewSessionStore(client Client, prefix string) *SessionStore {
+	if prefix == "" {
+		prefix = defaultPrefix + "session:"
+	}
+	return &SessionStore{client: client, prefix: prefix}
+}
+
+func (s *SessionStore) Load(ctx context.Context, id string) (*session.Record, error) {
+	data, err := s.client.Get(ctx, s.prefix+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, session.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rec session.Record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (s *SessionStore) Save(ctx context.Context, id string, rec *session.Record, ttl time.Duration) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, s.prefix+id, data, ttl).Err()
+}
+
+func (s *SessionStore) Delete(ctx context.Context, id string) error {
+	return s.client.Del(ctx, s.prefix+id).Err()
+}
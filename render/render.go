@@ -0,0 +1,208 @@
+// Package render writes handler results in the format the client asks for
+// with its Accept header: JSON, XML or MessagePack, or any Encoder added.
+// Handlers call Respond with a value instead of encoding it themselves:
+//
+//	render.Respond(w, r, item)
+//	render.RespondStatus(w, r, http.StatusCreated, item)
+package render
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"middlware/middleware"
+)
+
+// Encoder writes values in one media type.
+type Encoder struct {
+	// ContentType is sent with the responses it encodes, e.g.
+	// "application/json; charset=utf-8".
+	ContentType string
+	// Aliases are further media types clients ask for it by, e.g.
+	// "text/xml".
+	Aliases []string
+	Encode  func(w io.Writer, v any) error
+}
+
+var (
+	// JSON encodes values with encoding/json.
+	JSON = Encoder{ContentType: "application/json; charset=utf-8", Encode: func(w io.Writer, v any) error {
+		return json.NewEncoder(w).Encode(v)
+	}}
+	// XML encodes values with encoding/xml, which can't encode maps.
+	XML = Encoder{ContentType: "application/xml; charset=utf-8", Aliases: []string{"text/xml"}, Encode: func(w io.Writer, v any) error {
+		if _, err := io.WriteString(w, xml.Header); err != nil {
+			return err
+		}
+		return xml.NewEncoder(w).Encode(v)
+	}}
+	// MessagePack encodes the JSON form of values, so json struct tags
+	// apply, in MessagePack.
+	MessagePack = Encoder{ContentType: "application/msgpack", Aliases: []string{"application/x-msgpack", "application/vnd.msgpack"}, Encode: encodeMessagePack}
+
+	// DefaultEncoders are used without Middleware; the first one is
+	// chosen for clients accepting anything.
+	DefaultEncoders = []Encoder{JSON, XML, MessagePack}
+)
+
+// Options configures Middleware.
+type Options struct {
+	// Encoders are the formats offered, the first being chosen for clients
+	// accepting anything; defaults to DefaultEncoders.
+	Encoders []Encoder
+	// Logger receives encoding failures; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+type contextKey struct{}
+
+// negotiation is the outcome of the Accept header of a request.
+type negotiation struct {
+	opts    Options
+	encoder *Encoder // nil when none is acceptable
+}
+
+// Middleware chooses the encoder of every request from its Accept header,
+// once, for Respond. Without it Respond chooses among DefaultEncoders.
+// Requests are served whatever they accept, as handlers that don't use
+// Respond, e.g. of HTML pages or event streams, answer with types of their
+// own.
+func Middleware(opts Options) middleware.Middleware {
+	if len(opts.Encoders) == 0 {
+		opts.Encoders = DefaultEncoders
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := &negotiation{opts: opts, encoder: Negotiate(r.Header.Get("Accept"), opts.Encoders)}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, n)))
+		})
+	}
+}
+
+func negotiated(r *http.Request) *negotiation {
+	if n, ok := r.Context().Value(contextKey{}).(*negotiation); ok {
+		return n
+	}
+	return &negotiation{opts: Options{Encoders: DefaultEncoders, Logger: slog.Default()}, encoder: Negotiate(r.Header.Get("Accept"), DefaultEncoders)}
+}
+
+// Respond writes v with status 200 OK in the format r accepts.
+func Respond(w http.ResponseWriter, r *http.Request, v any) {
+	RespondStatus(w, r, http.StatusOK, v)
+}
+
+// RespondStatus writes v with status in the format r accepts, or answers
+// with a 406 Not Acceptable problem, listing the formats offered, when it
+// accepts none. The value is encoded before anything is written, so a
+// value the format can't encode gets a 500 Internal Server Error problem
+// instead.
+func RespondStatus(w http.ResponseWriter, r *http.Request, status int, v any) {
+	n := negotiated(r)
+	w.Header().Add("Vary", "Accept")
+	if n.encoder == nil {
+		types := make([]string, len(n.opts.Encoders))
+		for i, e := range n.opts.Encoders {
+			types[i] = mediaType(e.ContentType)
+		}
+		middleware.WriteProblem(w, r, &middleware.Error{
+			Status:     http.StatusNotAcceptable,
+			Detail:     "available as " + strings.Join(types, ", "),
+			Extensions: map[string]any{"available": types},
+		})
+		return
+	}
+	var buf bytes.Buffer
+	if err := n.encoder.Encode(&buf, v); err != nil {
+		n.opts.Logger.LogAttrs(r.Context(), slog.LevelError, "response encoding failed",
+			slog.String("request_id", middleware.RequestIDFromContext(r.Context())),
+			slog.String("content_type", n.encoder.ContentType),
+			slog.String("error", err.Error()),
+		)
+		middleware.WriteProblem(w, r, &middleware.Error{Status: http.StatusInternalServerError})
+		return
+	}
+	w.Header().Set("Content-Type", n.encoder.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(buf.Bytes())
+	}
+}
+
+// Negotiate returns the encoder the client prefers according to the
+// q-values of its Accept header, ties going to the order of encoders, or
+// nil if it accepts none. A media range counts for an encoder unless a more
+// specific one matches it too, so "*/*;q=0.1, application/json" prefers
+// JSON. A client without Accept header gets the first encoder.
+func Negotiate(accept string, encoders []Encoder) *Encoder {
+	if strings.TrimSpace(accept) == "" {
+		if len(encoders) == 0 {
+			return nil
+		}
+		return &encoders[0]
+	}
+	type mediaRange struct {
+		typ, subtype string
+		q            float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		typ, subtype, _ := strings.Cut(mt, "/")
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		ranges = append(ranges, mediaRange{typ, subtype, q})
+	}
+	var best *Encoder
+	bestQ := 0.0
+	for i := range encoders {
+		e := &encoders[i]
+		q, specificity := 0.0, -1
+		for _, mt := range append([]string{mediaType(e.ContentType)}, e.Aliases...) {
+			typ, subtype, _ := strings.Cut(mt, "/")
+			for _, rg := range ranges {
+				s := -1
+				switch {
+				case rg.typ == typ && rg.subtype == subtype:
+					s = 2
+				case rg.typ == typ && rg.subtype == "*":
+					s = 1
+				case rg.typ == "*" && rg.subtype == "*":
+					s = 0
+				}
+				if s > specificity || s == specificity && s >= 0 && rg.q > q {
+					q, specificity = rg.q, s
+				}
+			}
+		}
+		if q > bestQ {
+			best, bestQ = e, q
+		}
+	}
+	return best
+}
+
+// mediaType returns the media type of a Content-Type value, without its
+// parameters.
+func mediaType(contentType string) string {
+	mt, _, _ := strings.Cut(contentType, ";")
+	return strings.ToLower(strings.TrimSpace(mt))
+}
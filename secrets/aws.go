@@ -0,0 +1,178 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"middlware/middleware"
+)
+
+// AWSOptions configures NewAWS.
+type AWSOptions struct {
+	// Region defaults to AWS_REGION.
+	Region string
+	// AccessKeyID, SecretAccessKey and SessionToken default to
+	// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Endpoint defaults to https://secretsmanager.<region>.amazonaws.com.
+	Endpoint string
+	// Client defaults to a client with a 10s timeout.
+	Client *http.Client
+}
+
+// AWS reads secrets from AWS Secrets Manager. A name is a secret ID (its
+// name or ARN) or, for secrets holding a JSON object, the ID, "#" and the
+// key of a string field, e.g. "prod/myapp#jwt_secret". The current version
+// is read, so rotations performed by Secrets Manager are picked up. Every
+// lookup is a request to AWS, so wrap it in a Cache.
+type AWS struct {
+	opts AWSOptions
+}
+
+var _ middleware.SecretsProvider = (*AWS)(nil)
+
+// NewAWS returns a provider applying opts.
+func NewAWS(opts AWSOptions) *AWS {
+	env := func(v *string, name string) {
+		if *v == "" {
+			*v = os.Getenv(name)
+		}
+	}
+	env(&opts.Region, "AWS_REGION")
+	env(&opts.AccessKeyID, "AWS_ACCESS_KEY_ID")
+	env(&opts.SecretAccessKey, "AWS_SECRET_ACCESS_KEY")
+	env(&opts.SessionToken, "AWS_SESSION_TOKEN")
+	if opts.Endpoint == "" {
+		opts.Endpoint = "https://secretsmanager." + opts.Region + ".amazonaws.com"
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &AWS{opts: opts}
+}
+
+// Secret returns the value of the secret name by GetSecretValue.
+func (a *AWS) Secret(ctx context.Context, name string) ([]byte, error) {
+	id, key, hasKey := strings.Cut(name, "#")
+	body, err := json.Marshal(map[string]string{"SecretId": id})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.opts.Endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	if a.opts.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", a.opts.SessionToken)
+	}
+	signV4(req, body, a.opts.AccessKeyID, a.opts.SecretAccessKey, a.opts.Region, "secretsmanager", time.Now())
+	resp, err := a.opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var out struct {
+		SecretString *string `json:"SecretString"`
+		SecretBinary []byte  `json:"SecretBinary"`
+		Type         string  `json:"__type"`
+		Message      string  `json:"message"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&out); err != nil {
+		return nil, fmt.Errorf("aws %s: %s: %w", id, resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if strings.HasSuffix(out.Type, "ResourceNotFoundException") {
+			return nil, fmt.Errorf("aws %s: %w", id, middleware.ErrSecretNotFound)
+		}
+		return nil, fmt.Errorf("aws %s: %s: %s %s", id, resp.Status, out.Type, out.Message)
+	}
+	value := out.SecretBinary
+	if out.SecretString != nil {
+		value = []byte(*out.SecretString)
+	}
+	if !hasKey {
+		return value, nil
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(value, &fields); err != nil {
+		return nil, fmt.Errorf("aws %s: %w", id, err)
+	}
+	field, ok := fields[key].(string)
+	if !ok {
+		return nil, fmt.Errorf("aws %s: %w", name, middleware.ErrSecretNotFound)
+	}
+	return []byte(field), nil
+}
+
+// signV4 adds Signature Version 4 authentication to req, whose body is
+// body, signing the Host, Content-Type and X-Amz-* headers.
+func signV4(req *http.Request, body []byte, accessKeyID, secretAccessKey, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	mac := func(key []byte, data string) []byte {
+		m := hmac.New(sha256.New, key)
+		m.Write([]byte(data))
+		return m.Sum(nil)
+	}
+	key := mac([]byte("AWS4"+secretAccessKey), date)
+	key = mac(key, region)
+	key = mac(key, service)
+	key = mac(key, "aws4_request")
+	signature := hex.EncodeToString(mac(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
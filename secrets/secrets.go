@@ -0,0 +1,164 @@
+// Package secrets provides middleware.SecretsProvider implementations backed
+// by environment variables, a directory of files, HashiCorp Vault and AWS
+// Secrets Manager, and a Cache that keeps secrets in memory and refreshes
+// them periodically so rotated values are picked up without a restart.
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"middlware/middleware"
+)
+
+// Env reads secrets from environment variables named Prefix and the
+// upper-cased secret name, with characters other than letters and digits
+// replaced by "_", so "jwt_secret" is JWT_SECRET. Empty variables count as
+// unset.
+type Env struct {
+	Prefix string
+}
+
+var _ middleware.SecretsProvider = Env{}
+
+// Secret returns the value of the variable for name.
+func (e Env) Secret(_ context.Context, name string) ([]byte, error) {
+	key := e.Prefix + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+	value := os.Getenv(key)
+	if value == "" {
+		return nil, fmt.Errorf("%s: %w", key, middleware.ErrSecretNotFound)
+	}
+	return []byte(value), nil
+}
+
+// Dir reads each secret from the file of its name in the directory, as
+// mounted by Kubernetes and Docker secrets. A trailing newline is dropped.
+type Dir string
+
+var _ middleware.SecretsProvider = Dir("")
+
+// Secret returns the contents of the file name.
+func (d Dir) Secret(_ context.Context, name string) ([]byte, error) {
+	if !filepath.IsLocal(name) || strings.ContainsAny(name, `/\`) {
+		return nil, fmt.Errorf("secrets: invalid secret name %q", name)
+	}
+	data, err := os.ReadFile(filepath.Join(string(d), name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", name, middleware.ErrSecretNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSuffix(data, []byte("\n"))
+	return bytes.TrimSuffix(data, []byte("\r")), nil
+}
+
+// CacheOptions configures NewCache.
+type CacheOptions struct {
+	// RefreshInterval is how often the secrets looked up so far are
+	// fetched again; defaults to 5 minutes.
+	RefreshInterval time.Duration
+	// Logger receives refresh failures and rotations; defaults to
+	// slog.Default().
+	Logger *slog.Logger
+}
+
+// Cache answers from memory for another provider, such as Vault, which is
+// only asked for secrets not seen before and, in the background, for new
+// values of the others. When a refresh fails the previous value stays in
+// use, so an outage of the provider only delays rotations.
+type Cache struct {
+	provider middleware.SecretsProvider
+	opts     CacheOptions
+
+	mu     sync.RWMutex
+	values map[string][]byte
+}
+
+var _ middleware.SecretsProvider = (*Cache)(nil)
+
+// NewCache returns a cache of provider refreshing it every RefreshInterval
+// until ctx is done.
+func NewCache(ctx context.Context, provider middleware.SecretsProvider, opts CacheOptions) *Cache {
+	if opts.RefreshInterval <= 0 {
+		opts.RefreshInterval = 5 * time.Minute
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	c := &Cache{provider: provider, opts: opts, values: make(map[string][]byte)}
+	go c.run(ctx)
+	return c
+}
+
+// Secret returns the cached value of name, fetching it on first use.
+// Failed lookups are not cached.
+func (c *Cache) Secret(ctx context.Context, name string) ([]byte, error) {
+	c.mu.RLock()
+	value, ok := c.values[name]
+	c.mu.RUnlock()
+	if ok {
+		return value, nil
+	}
+	value, err := c.provider.Secret(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.values[name] = value
+	c.mu.Unlock()
+	return value, nil
+}
+
+func (c *Cache) run(ctx context.Context) {
+	ticker := time.NewTicker(c.opts.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.Refresh(ctx)
+		}
+	}
+}
+
+// Refresh fetches every cached secret again.
+func (c *Cache) Refresh(ctx context.Context) {
+	c.mu.RLock()
+	names := make([]string, 0, len(c.values))
+	for name := range c.values {
+		names = append(names, name)
+	}
+	c.mu.RUnlock()
+	for _, name := range names {
+		value, err := c.provider.Secret(ctx, name)
+		if err != nil {
+			c.opts.Logger.ErrorContext(ctx, "secret refresh failed, keeping previous value", "secret", name, "error", err)
+			continue
+		}
+		c.mu.Lock()
+		rotated := !bytes.Equal(c.values[name], value)
+		c.values[name] = value
+		c.mu.Unlock()
+		if rotated {
+			c.opts.Logger.InfoContext(ctx, "secret rotated", "secret", name)
+		}
+	}
+}
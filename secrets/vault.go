@@ -0,0 +1,86 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"middlware/middleware"
+)
+
+// VaultOptions configures NewVault.
+type VaultOptions struct {
+	// Address of the server, e.g. https://vault.example.com:8200.
+	Address string
+	// Token authenticates to Vault.
+	Token string
+	// Mount is where the KV version 2 engine is mounted; defaults to
+	// "secret".
+	Mount string
+	// Path is the secret whose keys are the secret names, e.g. "myapp".
+	Path string
+	// Client defaults to a client with a 10s timeout.
+	Client *http.Client
+}
+
+// Vault reads secrets from the keys of one secret of a HashiCorp Vault KV
+// version 2 engine, in its latest version. Every lookup is a request to
+// Vault, so wrap it in a Cache.
+type Vault struct {
+	opts VaultOptions
+	url  string
+}
+
+var _ middleware.SecretsProvider = (*Vault)(nil)
+
+// NewVault returns a provider applying opts.
+func NewVault(opts VaultOptions) *Vault {
+	if opts.Mount == "" {
+		opts.Mount = "secret"
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Vault{
+		opts: opts,
+		url:  strings.TrimSuffix(opts.Address, "/") + "/v1/" + url.PathEscape(opts.Mount) + "/data/" + strings.Trim(opts.Path, "/"),
+	}
+}
+
+// Secret returns the value of the key name.
+func (v *Vault) Secret(ctx context.Context, name string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.opts.Token)
+	resp, err := v.opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("vault %s: %w", v.opts.Path, middleware.ErrSecretNotFound)
+	default:
+		return nil, fmt.Errorf("vault %s: unexpected status %s", v.opts.Path, resp.Status)
+	}
+	var body struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("vault %s: %w", v.opts.Path, err)
+	}
+	value, ok := body.Data.Data[name].(string)
+	if !ok {
+		return nil, fmt.Errorf("vault %s#%s: %w", v.opts.Path, name, middleware.ErrSecretNotFound)
+	}
+	return []byte(value), nil
+}
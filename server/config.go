@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// Config holds the connection-level settings of a server built by New.
+// Zero durations select the defaults below; negative ones disable the
+// timeout entirely, which should only be done behind a proxy that enforces
+// its own.
+type Config struct {
+	// Addr is the listen address; defaults to ":8080".
+	Addr string
+	// ReadHeaderTimeout bounds how long a client may take to send request
+	// headers, the main defence against slowloris; defaults to 5s.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout bounds reading the whole request including the body;
+	// defaults to 30s.
+	ReadTimeout time.Duration
+	// WriteTimeout bounds the time from the end of the request headers to
+	// the end of the response; defaults to 30s. Handlers that stream can
+	// move their own deadline with middleware.WriteDeadline.
+	WriteTimeout time.Duration
+	// IdleTimeout bounds how long a keep-alive connection may sit idle
+	// between requests; defaults to 120s.
+	IdleTimeout time.Duration
+	// MaxHeaderBytes caps the size of request headers; defaults to 1 MiB.
+	MaxHeaderBytes int
+	// DisableKeepAlives closes every connection after its first response,
+	// e.g. to spread clients over freshly scaled replicas.
+	DisableKeepAlives bool
+	// TCPKeepAlive is how long a connection must be silent before TCP
+	// keep-alive probes check that the peer is still there; defaults to
+	// 15s. Negative disables the probes.
+	TCPKeepAlive time.Duration
+	// TCPKeepAliveInterval is the time between unanswered probes before
+	// the connection is dropped; defaults to 15s.
+	TCPKeepAliveInterval time.Duration
+	// HTTP2 tunes HTTP/2, which is negotiated on TLS connections.
+	HTTP2 HTTP2Config
+	// TLS, when set, makes the server serve HTTPS; run it with
+	// ListenAndServeTLS and empty file names.
+	TLS *TLSConfig
+	// Logger receives the server's internal errors, such as TLS handshake
+	// failures; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// HTTP2Config holds the HTTP/2 settings of Config. Zero values select the
+// defaults.
+type HTTP2Config struct {
+	// MaxConcurrentStreams caps the requests a client may have in flight
+	// on one connection; defaults to 250.
+	MaxConcurrentStreams uint32
+	// IdleTimeout bounds how long a connection may stay open without
+	// streams; defaults to Config.IdleTimeout, negative disables it.
+	IdleTimeout time.Duration
+}
+
+// New returns an *http.Server for handler with every timeout set, so slow
+// clients cannot hold connections open indefinitely. It fails only if the
+// TLS configuration can't be loaded.
+func New(handler http.Handler, cfg Config) (*http.Server, error) {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	addr := cfg.Addr
+	if addr == "" {
+		addr = ":8080"
+	}
+	maxHeaderBytes := cfg.MaxHeaderBytes
+	if maxHeaderBytes <= 0 {
+		maxHeaderBytes = 1 << 20
+	}
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: timeoutOr(cfg.ReadHeaderTimeout, 5*time.Second),
+		ReadTimeout:       timeoutOr(cfg.ReadTimeout, 30*time.Second),
+		WriteTimeout:      timeoutOr(cfg.WriteTimeout, 30*time.Second),
+		IdleTimeout:       timeoutOr(cfg.IdleTimeout, 120*time.Second),
+		MaxHeaderBytes:    maxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+	}
+	if cfg.DisableKeepAlives {
+		srv.SetKeepAlivesEnabled(false)
+	}
+	if cfg.TCPKeepAlive != 0 || cfg.TCPKeepAliveInterval != 0 {
+		keepAlive := net.KeepAliveConfig{
+			Enable:   cfg.TCPKeepAlive >= 0,
+			Idle:     cfg.TCPKeepAlive,
+			Interval: cfg.TCPKeepAliveInterval,
+		}
+		// The listener enables the defaults on every accepted connection;
+		// this replaces them before the first request is read.
+		srv.ConnState = func(c net.Conn, state http.ConnState) {
+			if tc, ok := c.(*net.TCPConn); ok && state == http.StateNew {
+				tc.SetKeepAliveConfig(keepAlive)
+			}
+		}
+	}
+	if cfg.TLS != nil {
+		tlsConfig, refresh, err := cfg.TLS.build(logger)
+		if err != nil {
+			return nil, err
+		}
+		srv.TLSConfig = tlsConfig
+		maxStreams := cfg.HTTP2.MaxConcurrentStreams
+		if maxStreams == 0 {
+			maxStreams = 250
+		}
+		h2 := &http2.Server{MaxConcurrentStreams: maxStreams, IdleTimeout: cfg.HTTP2.IdleTimeout}
+		if h2.IdleTimeout < 0 {
+			// http2 disables the timer for any negative value but takes
+			// zero from srv.IdleTimeout.
+			h2.IdleTimeout = -1
+		}
+		if err := http2.ConfigureServer(srv, h2); err != nil {
+			return nil, err
+		}
+		if refresh != nil {
+			ctx, cancel := context.WithCancel(context.Background())
+			srv.RegisterOnShutdown(cancel)
+			go refresh(ctx)
+		}
+	}
+	return srv, nil
+}
+
+// timeoutOr maps the Config convention onto http.Server's, where zero
+// means no timeout.
+func timeoutOr(d, def time.Duration) time.Duration {
+	switch {
+	case d < 0:
+		return 0
+	case d == 0:
+		return def
+	}
+	return d
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// NewHTTP3 returns an HTTP/3 server listening on the UDP port of srv's
+// address with the same handler, TLS configuration and idle timeout, and
+// makes srv advertise it with an Alt-Svc header so browsers switch to QUIC
+// on their next requests. srv must have been built by New from cfg with
+// TLS configured. Serve it through Options.HTTP3; a deployment that can't
+// pass UDP traffic simply doesn't create one.
+func NewHTTP3(srv *http.Server, cfg Config) (*http3.Server, error) {
+	if srv.TLSConfig == nil {
+		return nil, errors.New("server: HTTP/3 needs TLS")
+	}
+	quicConfig := &quic.Config{}
+	if srv.ReadHeaderTimeout > 0 {
+		// The closest QUIC analogue of a header timeout: clients must
+		// complete the handshake in time.
+		quicConfig.HandshakeIdleTimeout = srv.ReadHeaderTimeout
+	}
+	handler := srv.Handler
+	h3 := &http3.Server{
+		Addr:           srv.Addr,
+		Handler:        handler,
+		TLSConfig:      http3.ConfigureTLSConfig(srv.TLSConfig),
+		QUICConfig:     quicConfig,
+		IdleTimeout:    srv.IdleTimeout,
+		MaxHeaderBytes: srv.MaxHeaderBytes,
+		Logger:         cfg.Logger,
+	}
+	srv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Until the UDP listener is up there is no port to announce.
+		h3.SetQUICHeaders(w.Header())
+		handler.ServeHTTP(w, r)
+	})
+	return h3, nil
+}
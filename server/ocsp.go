@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// stapler serves a certificate with an OCSP response attached, refreshing
+// the response in the background before it goes stale.
+type stapler struct {
+	base   tls.Certificate
+	issuer *x509.Certificate
+	cert   atomic.Pointer[tls.Certificate]
+	client *http.Client
+	logger *slog.Logger
+}
+
+// newStapler returns nil when cert can't be stapled because it names no
+// OCSP responder or its chain lacks the issuer.
+func newStapler(cert tls.Certificate, logger *slog.Logger) *stapler {
+	if cert.Leaf == nil || len(cert.Leaf.OCSPServer) == 0 || len(cert.Certificate) < 2 {
+		return nil
+	}
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		return nil
+	}
+	s := &stapler{
+		base:   cert,
+		issuer: issuer,
+		client: &http.Client{Timeout: 10 * time.Second},
+		logger: logger,
+	}
+	s.cert.Store(&cert)
+	return s
+}
+
+func (s *stapler) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.cert.Load(), nil
+}
+
+// run refreshes the staple halfway through each response's validity until
+// ctx is done, retrying every five minutes after failures.
+func (s *stapler) run(ctx context.Context) {
+	var nextUpdate time.Time
+	for {
+		wait := 5 * time.Minute
+		resp, err := s.fetch(ctx)
+		switch {
+		case err != nil:
+			s.logger.Warn("ocsp staple refresh failed", "responder", s.base.Leaf.OCSPServer[0], "error", err)
+			// A stale staple is worse than none: clients may reject it.
+			if !nextUpdate.IsZero() && time.Now().After(nextUpdate) {
+				base := s.base
+				s.cert.Store(&base)
+				nextUpdate = time.Time{}
+			}
+		default:
+			cert := s.base
+			cert.OCSPStaple = resp.Raw
+			s.cert.Store(&cert)
+			nextUpdate = resp.NextUpdate
+			if !resp.NextUpdate.IsZero() {
+				wait = max(time.Until(resp.ThisUpdate.Add(resp.NextUpdate.Sub(resp.ThisUpdate)/2)), time.Minute)
+			} else {
+				wait = 12 * time.Hour
+			}
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (s *stapler) fetch(ctx context.Context) (*ocsp.Response, error) {
+	leaf := s.base.Leaf
+	body, err := ocsp.CreateRequest(leaf, s.issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, leaf.OCSPServer[0], bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	req.Header.Set("Accept", "application/ocsp-response")
+	httpResp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", httpResp.Status)
+	}
+	raw, err := io.ReadAll(io.LimitReader(httpResp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ocsp.ParseResponseForCert(raw, leaf, s.issuer)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status != ocsp.Good {
+		return nil, errors.New("certificate status is not good")
+	}
+	return resp, nil
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// NewRedirect returns a plain-HTTP server on addr (e.g. ":80") that
+// answers every request with 301 Moved Permanently to the same path and
+// query on the HTTPS server configured by cfg, which must already have
+// been passed to New. With autocert, ACME HTTP-01 challenges under
+// /.well-known/acme-challenge/ are answered instead of redirected. Serve it
+// through Options.Companions.
+func NewRedirect(addr string, cfg Config) *http.Server {
+	_, port, _ := net.SplitHostPort(cfg.Addr)
+	if port == "443" {
+		port = ""
+	}
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if host == "" || strings.ContainsAny(host, "/\\@") {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		if port != "" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+	if cfg.TLS != nil && cfg.TLS.manager != nil {
+		handler = cfg.TLS.manager.HTTPHandler(handler)
+	}
+	// The server only redirects, so the defaults with the TLS options
+	// stripped suit it.
+	redirectCfg := cfg
+	redirectCfg.Addr, redirectCfg.TLS = addr, nil
+	srv, _ := New(handler, redirectCfg)
+	return srv
+}
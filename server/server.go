@@ -0,0 +1,138 @@
+// Package server runs an *http.Server with signal-driven graceful shutdown.
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+
+	"middlware/health"
+)
+
+// Options configures ListenAndServe.
+type Options struct {
+	// DrainTimeout bounds how long in-flight requests may take to finish
+	// after shutdown starts; defaults to 30s.
+	DrainTimeout time.Duration
+	// DrainDelay is how long readiness reports "draining" before the
+	// listener closes, giving load balancers time to notice; defaults to 0.
+	DrainDelay time.Duration
+	// Health, if set, is flipped to draining when shutdown starts.
+	Health *health.Registry
+	// Signals trigger shutdown; defaults to SIGINT and SIGTERM.
+	Signals []os.Signal
+	// Companions are plain-HTTP servers, such as the one from NewRedirect,
+	// run alongside the main server and shut down with it.
+	Companions []*http.Server
+	// HTTP3, such as the server from NewHTTP3, is run alongside the main
+	// server on UDP and shut down with it.
+	HTTP3 *http3.Server
+	// Logger receives lifecycle log records; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// ListenAndServe serves srv until ctx is cancelled or one of the configured
+// signals arrives, then shuts down gracefully: readiness is failed first,
+// the listener stops accepting connections and in-flight requests get up to
+// DrainTimeout to complete. It returns nil after a clean shutdown.
+func ListenAndServe(ctx context.Context, srv *http.Server, opts Options) error {
+	return run(ctx, srv, opts, srv.ListenAndServe)
+}
+
+func run(ctx context.Context, srv *http.Server, opts Options, serve func() error) error {
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	signals := opts.Signals
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	drainTimeout := opts.DrainTimeout
+	if drainTimeout <= 0 {
+		drainTimeout = 30 * time.Second
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, signals...)
+	defer stop()
+
+	errc := make(chan error, 2+len(opts.Companions))
+	go func() {
+		logger.Info("starting server", "addr", srv.Addr)
+		errc <- serve()
+	}()
+	for _, c := range opts.Companions {
+		go func() {
+			logger.Info("starting companion server", "addr", c.Addr)
+			if err := c.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				errc <- err
+			}
+		}()
+	}
+	if h3 := opts.HTTP3; h3 != nil {
+		go func() {
+			logger.Info("starting HTTP/3 server", "addr", h3.Addr)
+			if err := h3.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				errc <- err
+			}
+		}()
+	}
+
+	select {
+	case err := <-errc:
+		// A listener failed before any shutdown was requested.
+		srv.Close()
+		for _, c := range opts.Companions {
+			c.Close()
+		}
+		if opts.HTTP3 != nil {
+			opts.HTTP3.Close()
+		}
+		return err
+	case <-ctx.Done():
+	}
+	stop()
+
+	logger.Info("shutting down", "drain_timeout", drainTimeout)
+	if opts.Health != nil {
+		opts.Health.SetDraining(true)
+	}
+	if opts.DrainDelay > 0 {
+		time.Sleep(opts.DrainDelay)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	for _, c := range opts.Companions {
+		if err := c.Shutdown(shutdownCtx); err != nil {
+			c.Close()
+		}
+	}
+	// Both protocols drain at once; Shutdown closes the remaining HTTP/3
+	// connections itself when the context expires.
+	h3Done := make(chan struct{})
+	go func() {
+		defer close(h3Done)
+		if opts.HTTP3 != nil {
+			opts.HTTP3.Shutdown(shutdownCtx)
+		}
+	}()
+	err := srv.Shutdown(shutdownCtx)
+	<-h3Done
+	if err != nil {
+		srv.Close()
+		return err
+	}
+	if err := <-errc; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	logger.Info("server stopped")
+	return nil
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLSConfig configures HTTPS for servers built by New. Set either CertFile
+// and KeyFile, or AutocertDomains to obtain certificates from Let's Encrypt.
+type TLSConfig struct {
+	// CertFile and KeyFile are PEM files; CertFile should hold the full
+	// chain so the OCSP responder's issuer is known.
+	CertFile string
+	KeyFile  string
+	// AutocertDomains are the host names certificates may be requested
+	// for. Requests for other names are refused, so nobody can make the
+	// server exhaust its ACME rate limits.
+	AutocertDomains []string
+	// AutocertCacheDir stores obtained certificates across restarts;
+	// defaults to "middlware-autocert" in the user cache directory.
+	AutocertCacheDir string
+	// AutocertEmail is given to the CA for expiry and problem notices.
+	AutocertEmail string
+	// NextProtos lists the ALPN protocols offered, in preference order;
+	// defaults to "h2" and "http/1.1".
+	NextProtos []string
+	// ClientCAFile enables mutual TLS as in MutualTLSConfig.
+	ClientCAFile      string
+	RequireClientCert bool
+	// DisableOCSPStapling turns off fetching and stapling OCSP responses
+	// for CertFile. It has no effect on certificates without a responder.
+	DisableOCSPStapling bool
+
+	// manager is set by New when autocert is used, for NewRedirect.
+	manager *autocert.Manager
+}
+
+// build returns the server's tls.Config and a background task keeping it
+// fresh, or a nil task when there is none.
+func (c *TLSConfig) build(logger *slog.Logger) (*tls.Config, func(context.Context), error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.ClientCAFile != "" {
+		var err error
+		if cfg, err = MutualTLSConfig(c.ClientCAFile, c.RequireClientCert); err != nil {
+			return nil, nil, err
+		}
+	}
+	cfg.NextProtos = c.NextProtos
+	if len(cfg.NextProtos) == 0 {
+		cfg.NextProtos = []string{"h2", "http/1.1"}
+	}
+
+	switch {
+	case len(c.AutocertDomains) > 0:
+		dir := c.AutocertCacheDir
+		if dir == "" {
+			cache, err := os.UserCacheDir()
+			if err != nil {
+				return nil, nil, err
+			}
+			dir = filepath.Join(cache, "middlware-autocert")
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(c.AutocertDomains...),
+			Cache:      autocert.DirCache(dir),
+			Email:      c.AutocertEmail,
+		}
+		c.manager = m
+		cfg.GetCertificate = m.GetCertificate
+		// The TLS-ALPN-01 challenge is answered on the HTTPS port itself.
+		cfg.NextProtos = append(cfg.NextProtos, acme.ALPNProto)
+		return cfg, nil, nil
+	case c.CertFile != "":
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !c.DisableOCSPStapling {
+			if s := newStapler(cert, logger); s != nil {
+				cfg.GetCertificate = s.getCertificate
+				return cfg, s.run, nil
+			}
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+		return cfg, nil, nil
+	}
+	return nil, nil, errors.New("server: TLS needs CertFile and KeyFile or AutocertDomains")
+}
+
+// MutualTLSConfig returns a TLS config that verifies client certificates
+// against the PEM-encoded CAs in caFile. With require false a client may
+// still connect without a certificate, leaving the decision to per-route
+// middleware such as middleware.ClientCert.
+func MutualTLSConfig(caFile string, require bool) (*tls.Config, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("server: no certificates found in " + caFile)
+	}
+	clientAuth := tls.VerifyClientCertIfGiven
+	if require {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ClientAuth: clientAuth,
+		ClientCAs:  pool,
+	}, nil
+}
+
+// ListenAndServeTLS is like ListenAndServe but serves HTTPS using the given
+// certificate files (which may be empty if srv.TLSConfig already provides
+// certificates).
+func ListenAndServeTLS(ctx context.Context, srv *http.Server, certFile, keyFile string, opts Options) error {
+	return run(ctx, srv, opts, func() error {
+		return srv.ListenAndServeTLS(certFile, keyFile)
+	})
+}
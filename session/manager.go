@@ -0,0 +1,332 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"middlware/middleware"
+)
+
+// maxCookieSize is the largest cookie value browsers reliably accept.
+const maxCookieSize = 4000
+
+// touchInterval limits how often an unchanged session's idle timer is
+// refreshed in the store.
+const touchInterval = time.Minute
+
+// Options configures a Manager.
+type Options struct {
+	// Secret derives the cookie encryption key; at least 32 random bytes.
+	Secret []byte
+	// Secrets, when set, supplies Secret as the secret SecretName instead,
+	// looked up for every request. When it rotates, cookies sealed with
+	// the previous value are still read, and replaced as sessions are
+	// saved.
+	Secrets    middleware.SecretsProvider
+	SecretName string
+	// Store keeps session data server-side; the cookie then only carries
+	// the encrypted session ID. When nil, the whole record is encrypted into
+	// the cookie, which limits sessions to about 4 KB.
+	Store Store
+	// CookieName defaults to "session".
+	CookieName string
+	// CookiePath defaults to "/".
+	CookiePath   string
+	CookieDomain string
+	// InsecureCookie drops the Secure attribute, for local HTTP testing.
+	InsecureCookie bool
+	// SameSite defaults to http.SameSiteLaxMode.
+	SameSite http.SameSite
+	// IdleTimeout expires sessions without activity; defaults to 30m.
+	IdleTimeout time.Duration
+	// AbsoluteTimeout expires sessions regardless of activity; defaults to
+	// 24h.
+	AbsoluteTimeout time.Duration
+	// Logger receives store errors; defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Manager loads and saves sessions around each request.
+type Manager struct {
+	opts Options
+
+	mu   sync.Mutex // serializes key changes
+	keys atomic.Pointer[cookieKeys]
+}
+
+// cookieKeys are the ciphers derived from the current secret and the one
+// before it, if any.
+type cookieKeys struct {
+	secret   []byte
+	current  cipher.AEAD
+	previous cipher.AEAD
+}
+
+// cookiePayload is what gets encrypted into the cookie.
+type cookiePayload struct {
+	ID     string  `json:"id,omitempty"`
+	Record *Record `json:"rec,omitempty"`
+}
+
+// New returns a Manager.
+func New(opts Options) (*Manager, error) {
+	if opts.Secrets != nil {
+		secret, err := opts.Secrets.Secret(context.Background(), opts.SecretName)
+		if err != nil {
+			return nil, fmt.Errorf("session: %s: %w", opts.SecretName, err)
+		}
+		opts.Secret = secret
+	}
+	if opts.CookieName == "" {
+		opts.CookieName = "session"
+	}
+	if opts.CookiePath == "" {
+		opts.CookiePath = "/"
+	}
+	if opts.SameSite == 0 {
+		opts.SameSite = http.SameSiteLaxMode
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = 30 * time.Minute
+	}
+	if opts.AbsoluteTimeout <= 0 {
+		opts.AbsoluteTimeout = 24 * time.Hour
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	m := &Manager{opts: opts}
+	if _, err := m.rotate(opts.Secret); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// cookieKeys returns the keys for the current secret, deriving new ones
+// when the secret has changed.
+func (m *Manager) cookieKeys(ctx context.Context) (*cookieKeys, error) {
+	keys := m.keys.Load()
+	if m.opts.Secrets == nil {
+		return keys, nil
+	}
+	secret, err := m.opts.Secrets.Secret(ctx, m.opts.SecretName)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(secret, keys.secret) {
+		return keys, nil
+	}
+	return m.rotate(secret)
+}
+
+// rotate makes secret the current one.
+func (m *Manager) rotate(secret []byte) (*cookieKeys, error) {
+	if len(secret) < 32 {
+		return nil, errors.New("session: secret must be at least 32 bytes")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old := m.keys.Load()
+	if old != nil && bytes.Equal(secret, old.secret) {
+		// Another request got here first
+		return old, nil
+	}
+	key := sha256.Sum256(append([]byte("session-encryption:"), secret...))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	keys := &cookieKeys{secret: bytes.Clone(secret), current: aead}
+	if old != nil {
+		keys.previous = old.current
+	}
+	m.keys.Store(keys)
+	return keys, nil
+}
+
+// Middleware loads the session (creating a fresh one when missing or
+// expired), exposes it through FromContext and saves it just before the
+// response header is written.
+func (m *Manager) Middleware() middleware.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s := m.load(r)
+			rec := middleware.NewResponseRecorder(w)
+			rec.BeforeWriteHeader(func(int) { m.save(rec, r, s) })
+			rec.Header().Add("Vary", "Cookie")
+
+			next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), contextKey{}, s)))
+			if !rec.Written() && !rec.Hijacked() {
+				m.save(rec, r, s)
+			}
+		})
+	}
+}
+
+func (m *Manager) load(r *http.Request) *Session {
+	now := time.Now()
+	fresh := func() *Session {
+		s := &Session{isNew: true, rec: Record{Created: now, LastSeen: now}}
+		if m.opts.Store != nil {
+			s.id = newID()
+		}
+		return s
+	}
+
+	c, err := r.Cookie(m.opts.CookieName)
+	if err != nil {
+		return fresh()
+	}
+	var p cookiePayload
+	if err := m.decrypt(r.Context(), c.Value, &p); err != nil {
+		if !errors.Is(err, errMalformedCookie) {
+			m.opts.Logger.ErrorContext(r.Context(), "session cookie unreadable", "error", err)
+		}
+		return fresh()
+	}
+	rec := p.Record
+	if m.opts.Store != nil {
+		if p.ID == "" {
+			return fresh()
+		}
+		rec, err = m.opts.Store.Load(r.Context(), p.ID)
+		if err != nil {
+			if !errors.Is(err, ErrNotFound) {
+				m.opts.Logger.ErrorContext(r.Context(), "session load failed", "error", err)
+			}
+			return fresh()
+		}
+	}
+	if rec == nil || now.Sub(rec.LastSeen) > m.opts.IdleTimeout || now.Sub(rec.Created) > m.opts.AbsoluteTimeout {
+		if m.opts.Store != nil && p.ID != "" {
+			m.opts.Store.Delete(r.Context(), p.ID)
+		}
+		return fresh()
+	}
+	return &Session{id: p.ID, rec: *rec}
+}
+
+// save persists s and sets or clears the cookie. It runs at most once per
+// request.
+func (m *Manager) save(w http.ResponseWriter, r *http.Request, s *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ctx := r.Context()
+	now := time.Now()
+
+	if s.destroyed {
+		if m.opts.Store != nil && s.id != "" && !s.isNew {
+			m.opts.Store.Delete(ctx, s.id)
+		}
+		m.setCookie(w, "", -1)
+		return
+	}
+	if s.oldID != "" {
+		m.opts.Store.Delete(ctx, s.oldID)
+	}
+	// New sessions nobody wrote to aren't worth a cookie.
+	if s.isNew && !s.dirty {
+		return
+	}
+	if !s.dirty && now.Sub(s.rec.LastSeen) < touchInterval {
+		return
+	}
+	s.rec.LastSeen = now
+
+	// The store may forget the record at whichever expiry comes first.
+	remaining := m.opts.AbsoluteTimeout - now.Sub(s.rec.Created)
+	ttl := max(min(m.opts.IdleTimeout, remaining), time.Second)
+	payload := cookiePayload{ID: s.id}
+	if m.opts.Store != nil {
+		if err := m.opts.Store.Save(ctx, s.id, &s.rec, ttl); err != nil {
+			m.opts.Logger.ErrorContext(ctx, "session save failed", "error", err)
+			return
+		}
+	} else {
+		payload.Record = &s.rec
+	}
+	value, err := m.encrypt(ctx, payload)
+	if err != nil {
+		m.opts.Logger.ErrorContext(ctx, "session encode failed", "error", err)
+		return
+	}
+	if len(value) > maxCookieSize {
+		m.opts.Logger.ErrorContext(ctx, "session cookie too large, use a server-side store", "bytes", len(value))
+		return
+	}
+	m.setCookie(w, value, int(max(remaining, time.Second).Seconds()))
+	s.dirty = false
+	s.isNew = false
+	s.oldID = ""
+}
+
+func (m *Manager) setCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.opts.CookieName,
+		Value:    value,
+		Path:     m.opts.CookiePath,
+		Domain:   m.opts.CookieDomain,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   !m.opts.InsecureCookie,
+		SameSite: m.opts.SameSite,
+	})
+}
+
+// errMalformedCookie is returned by decrypt for cookies it did not seal.
+var errMalformedCookie = errors.New("session: malformed cookie")
+
+// encrypt seals v with AES-GCM, authenticating the cookie name as well.
+func (m *Manager) encrypt(ctx context.Context, v any) (string, error) {
+	keys, err := m.cookieKeys(ctx)
+	if err != nil {
+		return "", err
+	}
+	plain, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	aead := keys.current
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	rand.Read(nonce)
+	sealed := aead.Seal(nonce, nonce, plain, []byte(m.opts.CookieName))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt opens value with the current key, or else the previous one.
+func (m *Manager) decrypt(ctx context.Context, value string, v any) error {
+	keys, err := m.cookieKeys(ctx)
+	if err != nil {
+		return err
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(sealed) < keys.current.NonceSize() {
+		return errMalformedCookie
+	}
+	n := keys.current.NonceSize()
+	plain, err := keys.current.Open(nil, sealed[:n], sealed[n:], []byte(m.opts.CookieName))
+	if err != nil && keys.previous != nil {
+		plain, err = keys.previous.Open(nil, sealed[:n], sealed[n:], []byte(m.opts.CookieName))
+	}
+	if err != nil {
+		return errMalformedCookie
+	}
+	return json.Unmarshal(plain, v)
+}
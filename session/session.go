@@ -0,0 +1,130 @@
+// Package session provides cookie-based HTTP sessions with pluggable
+// server-side stores and idle/absolute expiry.
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by a Store for unknown or expired sessions.
+var ErrNotFound = errors.New("session: not found")
+
+// Record is the persisted form of a session. Values must survive a JSON
+// round trip: after loading, numbers come back as float64 and structs as
+// map[string]any.
+type Record struct {
+	Values   map[string]any `json:"values"`
+	Created  time.Time      `json:"created"`
+	LastSeen time.Time      `json:"last_seen"`
+}
+
+// Store persists session records by ID. Save's ttl is the time after which
+// the store may forget the record.
+type Store interface {
+	Load(ctx context.Context, id string) (*Record, error)
+	Save(ctx context.Context, id string, rec *Record, ttl time.Duration) error
+	Delete(ctx context.Context, id string) error
+}
+
+// Session is the per-request view of a session. It is safe for concurrent
+// use by the handler's goroutines.
+type Session struct {
+	mu        sync.Mutex
+	id        string
+	oldID     string
+	rec       Record
+	isNew     bool
+	dirty     bool
+	destroyed bool
+}
+
+// ID returns the session ID; it is empty in cookie-only mode.
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+// IsNew reports whether the session was created by this request.
+func (s *Session) IsNew() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isNew
+}
+
+// Get returns the value stored under key, or nil.
+func (s *Session) Get(key string) any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rec.Values[key]
+}
+
+// GetString returns the string stored under key, or "".
+func (s *Session) GetString(key string) string {
+	v, _ := s.Get(key).(string)
+	return v
+}
+
+// Set stores v under key.
+func (s *Session) Set(key string, v any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rec.Values == nil {
+		s.rec.Values = make(map[string]any)
+	}
+	s.rec.Values[key] = v
+	s.dirty = true
+}
+
+// Delete removes key.
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.rec.Values, key)
+	s.dirty = true
+}
+
+// Renew assigns a new session ID while keeping the values. Call it after
+// login or privilege changes to prevent session fixation.
+func (s *Session) Renew() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.id != "" {
+		if s.oldID == "" && !s.isNew {
+			s.oldID = s.id
+		}
+		s.id = newID()
+	}
+	s.rec.Created = time.Now()
+	s.dirty = true
+}
+
+// Destroy clears the session and expires its cookie.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.destroyed = true
+	s.rec.Values = nil
+	s.dirty = true
+}
+
+type contextKey struct{}
+
+// FromContext returns the session of r, as loaded by Manager.Middleware. It
+// returns nil if the middleware did not run.
+func FromContext(r *http.Request) *Session {
+	s, _ := r.Context().Value(contextKey{}).(*Session)
+	return s
+}
+
+func newID() string {
+	var b [32]byte
+	rand.Read(b[:])
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
@@ -0,0 +1,165 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps sessions in process memory. Sessions are lost on
+// restart and not shared between replicas.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	sweep   time.Time
+}
+
+type memoryEntry struct {
+	data    []byte
+	expires time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]memoryEntry)}
+}
+
+func (s *MemoryStore) Load(_ context.Context, id string) (*Record, error) {
+	s.mu.Lock()
+	e, ok := s.entries[id]
+	s.mu.Unlock()
+	if !ok || time.Now().After(e.expires) {
+		return nil, ErrNotFound
+	}
+	// Records are stored encoded so callers never share maps.
+	var rec Record
+	if err := json.Unmarshal(e.data, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (s *MemoryStore) Save(_ context.Context, id string, rec *Record, ttl time.Duration) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.After(s.sweep) {
+		for k, e := range s.entries {
+			if now.After(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+		s.sweep = now.Add(time.Minute)
+	}
+	s.entries[id] = memoryEntry{data: data, expires: now.Add(ttl)}
+	return nil
+}
+
+func (s *MemoryStore) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	delete(s.entries, id)
+	s.mu.Unlock()
+	return nil
+}
+
+// FileStore keeps one JSON file per session in a directory. Expired files
+// are removed on access and by Cleanup.
+type FileStore struct {
+	dir string
+}
+
+type fileRecord struct {
+	Record
+	Expires time.Time `json:"expires"`
+}
+
+// NewFileStore returns a store writing to dir, creating it if needed.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// path maps a session ID to a file. IDs are base64url, so they can't
+// contain path separators.
+func (s *FileStore) path(id string) string {
+	return filepath.Join(s.dir, "sess_"+filepath.Base(id)+".json")
+}
+
+func (s *FileStore) Load(_ context.Context, id string) (*Record, error) {
+	data, err := os.ReadFile(s.path(id))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var fr fileRecord
+	if err := json.Unmarshal(data, &fr); err != nil {
+		return nil, err
+	}
+	if time.Now().After(fr.Expires) {
+		os.Remove(s.path(id))
+		return nil, ErrNotFound
+	}
+	return &fr.Record, nil
+}
+
+func (s *FileStore) Save(_ context.Context, id string, rec *Record, ttl time.Duration) error {
+	data, err := json.Marshal(fileRecord{Record: *rec, Expires: time.Now().Add(ttl)})
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file and rename so readers never see partial
+	// content.
+	tmp, err := os.CreateTemp(s.dir, "tmp_*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(id))
+}
+
+func (s *FileStore) Delete(_ context.Context, id string) error {
+	err := os.Remove(s.path(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Cleanup removes expired session files; run it periodically.
+func (s *FileStore) Cleanup() error {
+	matches, err := filepath.Glob(filepath.Join(s.dir, "sess_*.json"))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, path := range matches {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var fr fileRecord
+		if json.Unmarshal(data, &fr) != nil || now.After(fr.Expires) {
+			os.Remove(path)
+		}
+	}
+	return nil
+}